@@ -0,0 +1,843 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Audit events form a hash chain: every row stores the SHA-256 of its own
+// content together with the hash of the previous row, so editing, inserting
+// or deleting a row in the middle breaks every later link. Events are
+// appended under an advisory lock to keep the chain linear across
+// instances.
+//
+// The hash function is public, so someone with write access could rewrite
+// the whole tail consistently. Checkpoints close that gap: when
+// OFFICE_AUDIT_CHECKPOINT_INTERVAL is set, the head of the chain is
+// periodically signed with the office JWT signing key and stored in
+// audit_log_checkpoints. A rewritten chain no longer matches the signed
+// hashes, and a truncated tail leaves a checkpoint pointing at a missing
+// event. Only keys pinned outside the database are trusted for checkpoints:
+// the keys configured in env, and RSA keys whose JWK thumbprint is listed in
+// OFFICE_AUDIT_CHECKPOINT_KEY_PINS. Rotated keys and the public keys kept in
+// audit_checkpoint_keys live in the database the checkpoints protect, so
+// they only verify a checkpoint when pinned; recording them keeps pinned
+// checkpoints verifiable after rotation deletes the key itself. A
+// checkpoint no trusted key verifies makes the chain invalid.
+//
+// Personal data erasure rewrites the content of an employee's events but
+// keeps their hashes and marks them redacted_at. Verification still checks
+// the links of a redacted event but not its original content hash. Instead,
+// the erasure signs a checkpoint of its erase_personal_data event in the
+// same transaction, and the checkpoint claims carry the hash of every
+// redacted event's new content. A redacted event must match the hash signed
+// by the latest erasure that rewrote it, and every erase_personal_data event
+// must have its erasure checkpoint. Both live in signed claims, so marking
+// an event redacted or appending an erase event by hand does not verify.
+const (
+	auditChainLockID          = 7_340_022
+	auditChainVerifyBatch     = 1000
+	auditCheckpointTokenType  = "audit checkpoint"
+	auditCheckpointJWTType    = "audit_checkpoint"
+	adminAuditLogsVerifyPath  = "/api/admin/logs/verify"
+	auditCheckpointMinPeriod  = time.Minute
+	auditChainBreakMissing    = "missing_hash"
+	auditChainBreakPrevious   = "prev_hash_mismatch"
+	auditChainBreakContent    = "hash_mismatch"
+	auditChainBreakRedaction  = "redaction_not_recorded"
+	auditChainBreakErasure    = "erasure_not_signed"
+	auditCheckpointBreakEvent = "event_missing"
+	auditCheckpointBreakHash  = "hash_mismatch"
+	auditCheckpointBreakSig   = "invalid_signature"
+)
+
+type auditChainEvent struct {
+	ID              int64
+	PrevHash        string
+	Hash            string
+	ActionType      string
+	EntityType      string
+	EntityID        int64
+	EntityName      string
+	ActorEmployeeID string
+	ActorName       string
+	DetailsJSON     []byte
+	CreatedAt       time.Time
+	Redacted        bool
+}
+
+// canonicalAuditDetails re-encodes details so that the hash does not depend
+// on how JSONB reorders keys or spaces the stored document.
+func canonicalAuditDetails(raw []byte) []byte {
+	if len(raw) == 0 {
+		return []byte("{}")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return raw
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return raw
+	}
+	return out
+}
+
+func auditEventHash(e auditChainEvent) string {
+	payload, _ := json.Marshal([]any{
+		e.ID,
+		e.PrevHash,
+		e.ActionType,
+		e.EntityType,
+		e.EntityID,
+		e.EntityName,
+		e.ActorEmployeeID,
+		e.ActorName,
+		json.RawMessage(canonicalAuditDetails(e.DetailsJSON)),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func ensureAuditChainStorage(db *sql.DB) error {
+	if err := ensureColumn(db, "audit_log_events", "prev_hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "audit_log_events", "hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "audit_log_events", "redacted_at", "TIMESTAMPTZ"); err != nil {
+		return err
+	}
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS audit_log_checkpoints (
+			id BIGSERIAL PRIMARY KEY,
+			last_event_id BIGINT NOT NULL,
+			last_hash TEXT NOT NULL,
+			token TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_checkpoints_event_idx ON audit_log_checkpoints (last_event_id DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return backfillAuditChain(context.Background(), db)
+}
+
+// backfillAuditChain hashes the events written before the chain existed.
+// It runs only while no event has a hash yet: afterwards an unhashed row is
+// a tampering sign that verification must report, not something to adopt.
+func backfillAuditChain(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return err
+	}
+	var chained bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM audit_log_events WHERE hash <> '')`).Scan(&chained); err != nil {
+		return err
+	}
+	if chained {
+		return nil
+	}
+	events, err := loadAuditChainEvents(ctx, tx, 0, 0)
+	if err != nil {
+		return err
+	}
+	prevHash := ""
+	for _, e := range events {
+		e.PrevHash = prevHash
+		e.Hash = auditEventHash(e)
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE audit_log_events SET prev_hash = $2, hash = $3 WHERE id = $1`,
+			e.ID, e.PrevHash, e.Hash,
+		); err != nil {
+			return err
+		}
+		prevHash = e.Hash
+	}
+	if len(events) > 0 {
+		log.Printf("audit chain: hashed %d existing events", len(events))
+	}
+	return tx.Commit()
+}
+
+type auditChainQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadAuditChainEvents returns events with id > afterID in id order; limit 0
+// loads all of them.
+func loadAuditChainEvents(ctx context.Context, q auditChainQueryer, afterID int64, limit int) ([]auditChainEvent, error) {
+	query := `SELECT id, prev_hash, hash, action_type, entity_type, entity_id, entity_name,
+	                 actor_employee_id, actor_name, details_json::text, created_at,
+	                 redacted_at IS NOT NULL
+	            FROM audit_log_events
+	           WHERE id > $1
+	           ORDER BY id`
+	args := []any{afterID}
+	if limit > 0 {
+		query += ` LIMIT $2`
+		args = append(args, limit)
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditChainEvents(rows)
+}
+
+// scanAuditChainEvents reads rows selected with the column list used by
+// loadAuditChainEvents and closes them.
+func scanAuditChainEvents(rows *sql.Rows) ([]auditChainEvent, error) {
+	defer rows.Close()
+	var events []auditChainEvent
+	for rows.Next() {
+		var e auditChainEvent
+		var details string
+		if err := rows.Scan(&e.ID, &e.PrevHash, &e.Hash, &e.ActionType, &e.EntityType, &e.EntityID, &e.EntityName,
+			&e.ActorEmployeeID, &e.ActorName, &details, &e.CreatedAt, &e.Redacted); err != nil {
+			return nil, err
+		}
+		e.DetailsJSON = []byte(details)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// appendAuditEvent links e to the current head of the chain and stores it,
+// queueing it for delivery to sinks in the same transaction.
+func appendAuditEvent(ctx context.Context, db *sql.DB, e auditChainEvent, sinks []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return err
+	}
+	if _, err := appendAuditEventTx(ctx, tx, e, sinks); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendAuditEventTx is appendAuditEvent for callers that already hold
+// auditChainLockID in tx. It returns the new event ID. A zero e.CreatedAt
+// means now.
+func appendAuditEventTx(ctx context.Context, tx *sql.Tx, e auditChainEvent, sinks []string) (int64, error) {
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(
+			(SELECT hash FROM audit_log_events ORDER BY id DESC LIMIT 1),
+			(SELECT last_hash FROM audit_log_archives ORDER BY last_event_id DESC LIMIT 1),
+			''
+		)`,
+	).Scan(&e.PrevHash); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRowContext(ctx,
+		`SELECT nextval(pg_get_serial_sequence('audit_log_events', 'id'))`,
+	).Scan(&e.ID); err != nil {
+		return 0, err
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	// Postgres keeps microseconds; hash exactly what will be read back.
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+	e.DetailsJSON = canonicalAuditDetails(e.DetailsJSON)
+	e.Hash = auditEventHash(e)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_log_events (
+			id,
+			action_type,
+			entity_type,
+			entity_id,
+			entity_name,
+			actor_employee_id,
+			actor_name,
+			details_json,
+			created_at,
+			prev_hash,
+			hash
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9, $10, $11)`,
+		e.ID,
+		e.ActionType,
+		e.EntityType,
+		e.EntityID,
+		e.EntityName,
+		e.ActorEmployeeID,
+		e.ActorName,
+		string(e.DetailsJSON),
+		e.CreatedAt,
+		e.PrevHash,
+		e.Hash,
+	); err != nil {
+		return 0, err
+	}
+	if len(sinks) > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_sink_queue (sink, event_id) SELECT unnest($1::text[]), $2`,
+			sinks, e.ID,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return e.ID, nil
+}
+
+type auditChainBreak struct {
+	EventID  int64  `json:"event_id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+type auditCheckpointBreak struct {
+	CheckpointID int64  `json:"checkpoint_id"`
+	EventID      int64  `json:"event_id"`
+	Reason       string `json:"reason"`
+}
+
+type auditChainReport struct {
+	Valid                   bool                  `json:"valid"`
+	ArchivedThroughEventID  int64                 `json:"archived_through_event_id"`
+	EventsChecked           int64                 `json:"events_checked"`
+	FirstEventID            int64                 `json:"first_event_id"`
+	LastEventID             int64                 `json:"last_event_id"`
+	HeadHash                string                `json:"head_hash"`
+	RedactedEvents          int64                 `json:"redacted_events"`
+	BrokenLink              *auditChainBreak      `json:"broken_link,omitempty"`
+	CheckpointsChecked      int                   `json:"checkpoints_checked"`
+	CheckpointsUnverifiable int                   `json:"checkpoints_unverifiable"`
+	CheckpointsArchived     int                   `json:"checkpoints_archived"`
+	LatestCheckpointEventID int64                 `json:"latest_checkpoint_event_id"`
+	BrokenCheckpoint        *auditCheckpointBreak `json:"broken_checkpoint,omitempty"`
+}
+
+// checkAuditChainLink verifies e against the hash of the event before it and
+// returns the reason it is broken, if any.
+func checkAuditChainLink(e auditChainEvent, prevHash string) *auditChainBreak {
+	if e.Hash == "" {
+		return &auditChainBreak{EventID: e.ID, Reason: auditChainBreakMissing}
+	}
+	if e.PrevHash != prevHash {
+		return &auditChainBreak{EventID: e.ID, Reason: auditChainBreakPrevious, Expected: prevHash, Actual: e.PrevHash}
+	}
+	if e.Redacted {
+		return nil
+	}
+	if computed := auditEventHash(e); computed != e.Hash {
+		return &auditChainBreak{EventID: e.ID, Reason: auditChainBreakContent, Expected: computed, Actual: e.Hash}
+	}
+	return nil
+}
+
+// auditCheckpointClaims is the signed checkpoint. Erasure checkpoints set
+// Erasure and map each event they redacted to its new content hash.
+type auditCheckpointClaims struct {
+	Type        string           `json:"typ"`
+	LastEventID int64            `json:"last_event_id"`
+	LastHash    string           `json:"last_hash"`
+	IssuedAt    int64            `json:"iat"`
+	Erasure     bool             `json:"erasure,omitempty"`
+	Redactions  map[int64]string `json:"redactions,omitempty"`
+}
+
+// auditRedactedEvent is a redacted event as found by verification: Hash is
+// computed from its current content.
+type auditRedactedEvent struct {
+	ID   int64
+	Hash string
+}
+
+// firstUnsignedRedaction returns the first redacted event or erasure event
+// that no verified erasure checkpoint vouches for. signed maps event IDs to
+// the content hash of their latest redaction; erasures are the
+// erase_personal_data events and signedErasures those with an erasure
+// checkpoint.
+func firstUnsignedRedaction(redacted []auditRedactedEvent, signed map[int64]string, erasures []int64, signedErasures map[int64]bool) *auditChainBreak {
+	var first *auditChainBreak
+	for _, e := range redacted {
+		want, ok := signed[e.ID]
+		switch {
+		case !ok:
+			first = &auditChainBreak{EventID: e.ID, Reason: auditChainBreakRedaction}
+		case want != e.Hash:
+			first = &auditChainBreak{EventID: e.ID, Reason: auditChainBreakContent, Expected: want, Actual: e.Hash}
+		default:
+			continue
+		}
+		break
+	}
+	for _, id := range erasures {
+		if signedErasures[id] {
+			continue
+		}
+		if first == nil || id < first.EventID {
+			first = &auditChainBreak{EventID: id, Reason: auditChainBreakErasure}
+		}
+		break
+	}
+	return first
+}
+
+// verifyAuditChain walks the chain from the last archived event and then
+// checks every checkpoint against it. Signatures are checked with the keys
+// auditCheckpointVerifier trusts. keys may be nil.
+func verifyAuditChain(ctx context.Context, db *sql.DB, keys *officeTokenKeyManager, pins auditCheckpointKeyPins) (auditChainReport, error) {
+	report := auditChainReport{}
+	hashes := make(map[int64]string)
+
+	anchor, err := loadAuditArchiveAnchor(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	report.ArchivedThroughEventID = anchor.LastEventID
+
+	recordedKeys, err := loadAuditCheckpointKeys(ctx, db)
+	if err != nil {
+		return report, err
+	}
+
+	var checkpointIDs []int64
+	rows, err := db.QueryContext(ctx, `SELECT last_event_id FROM audit_log_checkpoints`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return report, err
+		}
+		checkpointIDs = append(checkpointIDs, id)
+		hashes[id] = ""
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	if _, ok := hashes[anchor.LastEventID]; ok {
+		hashes[anchor.LastEventID] = anchor.LastHash
+	}
+
+	var (
+		redacted []auditRedactedEvent
+		erasures []int64
+	)
+	afterID := anchor.LastEventID
+	prevHash := anchor.LastHash
+	for {
+		events, err := loadAuditChainEvents(ctx, db, afterID, auditChainVerifyBatch)
+		if err != nil {
+			return report, err
+		}
+		for _, e := range events {
+			if report.BrokenLink == nil {
+				report.BrokenLink = checkAuditChainLink(e, prevHash)
+			}
+			if e.Redacted {
+				report.RedactedEvents++
+				redacted = append(redacted, auditRedactedEvent{ID: e.ID, Hash: auditEventHash(e)})
+			}
+			if e.ActionType == auditActionErasePersonalData {
+				erasures = append(erasures, e.ID)
+			}
+			if report.EventsChecked == 0 {
+				report.FirstEventID = e.ID
+			}
+			report.EventsChecked++
+			report.LastEventID = e.ID
+			if _, ok := hashes[e.ID]; ok {
+				hashes[e.ID] = e.Hash
+			}
+			prevHash = e.Hash
+			afterID = e.ID
+		}
+		if len(events) < auditChainVerifyBatch {
+			break
+		}
+	}
+	report.HeadHash = prevHash
+
+	cpRows, err := db.QueryContext(ctx, `SELECT id, last_event_id, last_hash, token FROM audit_log_checkpoints ORDER BY id`)
+	if err != nil {
+		return report, err
+	}
+	defer cpRows.Close()
+	signedRedactions := make(map[int64]string)
+	signedErasures := make(map[int64]bool)
+	for cpRows.Next() {
+		var (
+			id, eventID     int64
+			lastHash, token string
+		)
+		if err := cpRows.Scan(&id, &eventID, &lastHash, &token); err != nil {
+			return report, err
+		}
+		report.CheckpointsChecked++
+		if eventID > report.LatestCheckpointEventID {
+			report.LatestCheckpointEventID = eventID
+		}
+		if report.BrokenCheckpoint != nil {
+			continue
+		}
+		verifier := auditCheckpointVerifier(keys, recordedKeys, pins, token)
+		if verifier == nil {
+			// The key is unknown or not pinned outside the database. Its
+			// claims cannot be trusted, so the chain is not valid.
+			report.CheckpointsUnverifiable++
+			continue
+		}
+		var claims auditCheckpointClaims
+		if err := verifier.verifyJWT(token, auditCheckpointTokenType, &claims); err != nil || claims.Type != auditCheckpointJWTType {
+			report.BrokenCheckpoint = &auditCheckpointBreak{CheckpointID: id, EventID: eventID, Reason: auditCheckpointBreakSig}
+			continue
+		}
+		if claims.LastEventID != eventID || claims.LastHash != lastHash {
+			report.BrokenCheckpoint = &auditCheckpointBreak{CheckpointID: id, EventID: eventID, Reason: auditCheckpointBreakSig}
+			continue
+		}
+		if claims.Erasure {
+			// Checkpoints are read in id order, so a later erasure of the
+			// same event overrides the hash.
+			signedErasures[eventID] = true
+			for redactedID, hash := range claims.Redactions {
+				signedRedactions[redactedID] = hash
+			}
+		}
+		if eventID < anchor.LastEventID {
+			// The event is in an archive file; only the signature is
+			// checked here.
+			report.CheckpointsArchived++
+			continue
+		}
+		switch actual := hashes[eventID]; {
+		case actual == "":
+			report.BrokenCheckpoint = &auditCheckpointBreak{CheckpointID: id, EventID: eventID, Reason: auditCheckpointBreakEvent}
+		case actual != lastHash:
+			report.BrokenCheckpoint = &auditCheckpointBreak{CheckpointID: id, EventID: eventID, Reason: auditCheckpointBreakHash}
+		}
+	}
+	if err := cpRows.Err(); err != nil {
+		return report, err
+	}
+	if report.BrokenLink == nil {
+		report.BrokenLink = firstUnsignedRedaction(redacted, signedRedactions, erasures, signedErasures)
+	}
+	report.Valid = report.BrokenLink == nil && report.BrokenCheckpoint == nil && report.CheckpointsUnverifiable == 0
+	return report, nil
+}
+
+// auditCheckpointKeyPins holds the JWK thumbprints (RFC 7638, SHA-256,
+// base64url) of the RSA keys trusted to have signed checkpoints.
+type auditCheckpointKeyPins map[string]bool
+
+// auditCheckpointKeyPinsFromEnv reads OFFICE_AUDIT_CHECKPOINT_KEY_PINS, a
+// comma or space separated list of JWK thumbprints.
+func auditCheckpointKeyPinsFromEnv() auditCheckpointKeyPins {
+	pins := make(auditCheckpointKeyPins)
+	for _, pin := range strings.FieldsFunc(os.Getenv("OFFICE_AUDIT_CHECKPOINT_KEY_PINS"), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		pins[pin] = true
+	}
+	return pins
+}
+
+// rsaJWKThumbprint returns the RFC 7638 SHA-256 thumbprint of key.
+func rsaJWKThumbprint(key *rsa.PublicKey) string {
+	// Required members only, in lexicographic order, without whitespace.
+	canonical := `{"e":"` + base64URLEncode(big.NewInt(int64(key.E)).Bytes()) +
+		`","kty":"RSA","n":"` + base64URLEncode(key.N.Bytes()) + `"}`
+	sum := sha256.Sum256([]byte(canonical))
+	return base64URLEncode(sum[:])
+}
+
+// pinned reports whether verifier is an RSA key listed in pins.
+func (pins auditCheckpointKeyPins) pinned(verifier *officeJWTVerificationKey) bool {
+	return verifier != nil && verifier.alg == jwtAlgRS256 && verifier.rsaPublic != nil &&
+		pins[rsaJWKThumbprint(verifier.rsaPublic)]
+}
+
+// auditCheckpointVerifier returns the key token was signed with if that key
+// is trusted: configured in env, or a rotated or recorded key that is
+// pinned. It returns nil otherwise.
+func auditCheckpointVerifier(keys *officeTokenKeyManager, recorded map[string]*officeJWTVerificationKey, pins auditCheckpointKeyPins, token string) *officeJWTVerificationKey {
+	headerPart, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil
+	}
+	raw, err := base64URLDecode(headerPart)
+	if err != nil {
+		return nil
+	}
+	var header jwtHeaderData
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil
+	}
+	var candidates []*officeJWTVerificationKey
+	if keys != nil {
+		if header.Kid == "" && header.Alg == jwtAlgHS256 && keys.legacyHS256VerifierNoKID != nil {
+			return keys.legacyHS256VerifierNoKID
+		}
+		if verifier := keys.verificationKeysByKID[header.Kid]; verifier != nil {
+			if verifier.alg != header.Alg {
+				return nil
+			}
+			return verifier
+		}
+		keys.mu.RLock()
+		candidates = append(candidates, keys.rotatedVerificationKeys[header.Kid])
+		keys.mu.RUnlock()
+	}
+	candidates = append(candidates, recorded[header.Kid])
+	for _, verifier := range candidates {
+		if verifier != nil && verifier.alg == header.Alg && pins.pinned(verifier) {
+			return verifier
+		}
+	}
+	return nil
+}
+
+// loadAuditCheckpointKeys returns the recorded checkpoint keys by kid.
+func loadAuditCheckpointKeys(ctx context.Context, db *sql.DB) (map[string]*officeJWTVerificationKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT jwk FROM audit_checkpoint_keys`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var raw []json.RawMessage
+	for rows.Next() {
+		var jwk []byte
+		if err := rows.Scan(&jwk); err != nil {
+			return nil, err
+		}
+		raw = append(raw, jwk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return parseAuditCheckpointKeys(raw), nil
+}
+
+// parseAuditCheckpointKeys converts recorded JWKs into verification keys.
+// Checkpoints are only ever signed with RS256, so other keys are dropped.
+func parseAuditCheckpointKeys(raw []json.RawMessage) map[string]*officeJWTVerificationKey {
+	recorded := make(map[string]*officeJWTVerificationKey, len(raw))
+	for kid, key := range parseJWKS(raw) {
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			recorded[kid] = &officeJWTVerificationKey{kid: kid, alg: jwtAlgRS256, rsaPublic: rsaKey}
+		}
+	}
+	return recorded
+}
+
+// recordAuditCheckpointKeys stores every published public key that is not
+// recorded yet. Recording all of them, not only the current signing key,
+// also covers checkpoints written before keys were recorded, as long as
+// their key is still published. HS256 secrets are never stored.
+func recordAuditCheckpointKeys(ctx context.Context, tx *sql.Tx, keys *officeTokenKeyManager) error {
+	for _, jwk := range keys.publicJWKs() {
+		raw, err := json.Marshal(jwk)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_checkpoint_keys (kid, jwk) VALUES ($1, $2) ON CONFLICT (kid) DO NOTHING`,
+			jwk["kid"], raw,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateAuditCheckpointKeysUp(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS audit_checkpoint_keys (
+		kid TEXT PRIMARY KEY,
+		jwk JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+func migrateAuditCheckpointKeysDown(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS audit_checkpoint_keys`)
+	return err
+}
+
+var errAuditCheckpointNoSigningKey = errors.New("office JWT signing key is not configured")
+
+// writeAuditCheckpoint signs the current head of the chain unless it is
+// already covered by the latest checkpoint.
+func (a *app) writeAuditCheckpoint(ctx context.Context) error {
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanSign() {
+		return errAuditCheckpointNoSigningKey
+	}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return err
+	}
+	var (
+		headID   int64
+		headHash string
+	)
+	err = tx.QueryRowContext(ctx, `SELECT id, hash FROM audit_log_events ORDER BY id DESC LIMIT 1`).Scan(&headID, &headHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var covered bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM audit_log_checkpoints WHERE last_event_id >= $1)`, headID,
+	).Scan(&covered); err != nil {
+		return err
+	}
+	if covered {
+		return nil
+	}
+	if err := a.insertAuditCheckpointTx(ctx, tx, auditCheckpointClaims{LastEventID: headID, LastHash: headHash}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertAuditCheckpointTx signs claims and stores the checkpoint. The caller
+// holds auditChainLockID in tx and fills in the event and hash.
+func (a *app) insertAuditCheckpointTx(ctx context.Context, tx *sql.Tx, claims auditCheckpointClaims) error {
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanSign() {
+		return errAuditCheckpointNoSigningKey
+	}
+	claims.Type = auditCheckpointJWTType
+	claims.IssuedAt = time.Now().Unix()
+	token, err := a.officeTokenKeys.signJWT(claims, auditCheckpointTokenType)
+	if err != nil {
+		return err
+	}
+	if err := recordAuditCheckpointKeys(ctx, tx, a.officeTokenKeys); err != nil {
+		return err
+	}
+	if auditCheckpointVerifier(a.officeTokenKeys, nil, a.auditCheckpointKeyPins, token) == nil {
+		signing := a.officeTokenKeys.currentSigningKey()
+		thumbprint := ""
+		if signing.rsaPrivate != nil {
+			thumbprint = rsaJWKThumbprint(&signing.rsaPrivate.PublicKey)
+		}
+		log.Printf("WARNING: audit checkpoint signing key %q (thumbprint %q) is not pinned in OFFICE_AUDIT_CHECKPOINT_KEY_PINS; verification reports its checkpoints as unverifiable", signing.kid, thumbprint)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO audit_log_checkpoints (last_event_id, last_hash, token) VALUES ($1, $2, $3)`,
+		claims.LastEventID, claims.LastHash, token,
+	)
+	return err
+}
+
+// auditCheckpointIntervalFromEnv returns 0 when checkpoints are disabled.
+func auditCheckpointIntervalFromEnv() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("OFFICE_AUDIT_CHECKPOINT_INTERVAL"))
+	if raw == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		log.Printf("WARNING: invalid OFFICE_AUDIT_CHECKPOINT_INTERVAL %q; audit checkpoints are disabled", raw)
+		return 0
+	}
+	if interval < auditCheckpointMinPeriod {
+		interval = auditCheckpointMinPeriod
+	}
+	return interval
+}
+
+func (a *app) runAuditCheckpointLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := a.writeAuditCheckpoint(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("audit checkpoint failed: %v", err)
+		}
+	}
+}
+
+// handleAdminAuditLogsVerify walks the audit chain and reports the first
+// broken link or checkpoint.
+func (a *app) handleAdminAuditLogsVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+	report, err := verifyAuditChain(r.Context(), a.db, a.officeTokenKeys, a.auditCheckpointKeyPins)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+func formatAuditChainReport(report auditChainReport) string {
+	var b strings.Builder
+	if report.ArchivedThroughEventID > 0 {
+		fmt.Fprintf(&b, "archived through event: %d\n", report.ArchivedThroughEventID)
+	}
+	fmt.Fprintf(&b, "events checked: %d (ids %d..%d)\n", report.EventsChecked, report.FirstEventID, report.LastEventID)
+	fmt.Fprintf(&b, "head hash: %s\n", report.HeadHash)
+	if report.BrokenLink != nil {
+		fmt.Fprintf(&b, "BROKEN LINK at event %d: %s", report.BrokenLink.EventID, report.BrokenLink.Reason)
+		if report.BrokenLink.Expected != "" || report.BrokenLink.Actual != "" {
+			fmt.Fprintf(&b, " (expected %q, stored %q)", report.BrokenLink.Expected, report.BrokenLink.Actual)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "checkpoints checked: %d (unverifiable: %d, archived: %d, latest covers event %d)\n",
+		report.CheckpointsChecked, report.CheckpointsUnverifiable, report.CheckpointsArchived, report.LatestCheckpointEventID)
+	if report.CheckpointsUnverifiable > 0 {
+		fmt.Fprintf(&b, "UNVERIFIABLE CHECKPOINTS: %d signed by an unknown or unpinned key\n", report.CheckpointsUnverifiable)
+	}
+	if report.BrokenCheckpoint != nil {
+		fmt.Fprintf(&b, "BROKEN CHECKPOINT %d for event %d: %s\n",
+			report.BrokenCheckpoint.CheckpointID, report.BrokenCheckpoint.EventID, report.BrokenCheckpoint.Reason)
+	}
+	if report.Valid {
+		b.WriteString("audit chain is intact\n")
+	}
+	return b.String()
+}
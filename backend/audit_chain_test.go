@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func buildAuditChain(n int) []auditChainEvent {
+	events := make([]auditChainEvent, 0, n)
+	prev := ""
+	start := time.Date(2026, 3, 1, 9, 0, 0, 123456000, time.UTC)
+	for i := 0; i < n; i++ {
+		e := auditChainEvent{
+			ID:              int64(i + 1),
+			PrevHash:        prev,
+			ActionType:      auditActionUpdate,
+			EntityType:      auditEntityDesk,
+			EntityID:        int64(10 + i),
+			EntityName:      "Стол",
+			ActorEmployeeID: "42",
+			DetailsJSON:     []byte(`{"b": 1, "a": {"y": "2", "x": 1.50}}`),
+			CreatedAt:       start.Add(time.Duration(i) * time.Minute),
+		}
+		e.Hash = auditEventHash(e)
+		prev = e.Hash
+		events = append(events, e)
+	}
+	return events
+}
+
+func firstAuditChainBreak(events []auditChainEvent) *auditChainBreak {
+	prev := ""
+	for _, e := range events {
+		if b := checkAuditChainLink(e, prev); b != nil {
+			return b
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	t.Parallel()
+
+	if b := firstAuditChainBreak(buildAuditChain(4)); b != nil {
+		t.Fatalf("intact chain reported broken: %+v", b)
+	}
+
+	edited := buildAuditChain(4)
+	edited[1].EntityName = "Другой стол"
+	if b := firstAuditChainBreak(edited); b == nil || b.EventID != 2 || b.Reason != auditChainBreakContent {
+		t.Errorf("edited event: got %+v; want hash mismatch at event 2", b)
+	}
+
+	deleted := buildAuditChain(4)
+	deleted = append(deleted[:2], deleted[3:]...)
+	if b := firstAuditChainBreak(deleted); b == nil || b.EventID != 4 || b.Reason != auditChainBreakPrevious {
+		t.Errorf("deleted event: got %+v; want prev hash mismatch at event 4", b)
+	}
+
+	inserted := buildAuditChain(3)
+	inserted = append(inserted, auditChainEvent{ID: 4, ActionType: auditActionDelete, EntityType: auditEntityDesk})
+	if b := firstAuditChainBreak(inserted); b == nil || b.EventID != 4 || b.Reason != auditChainBreakMissing {
+		t.Errorf("unhashed event: got %+v; want missing hash at event 4", b)
+	}
+}
+
+func TestAuditEventHashIgnoresJSONBFormatting(t *testing.T) {
+	t.Parallel()
+
+	e := buildAuditChain(1)[0]
+	// JSONB returns keys sorted by length and with its own spacing.
+	stored := e
+	stored.DetailsJSON = []byte(`{"a": {"x": 1.50, "y": "2"}, "b": 1}`)
+	if auditEventHash(stored) != e.Hash {
+		t.Error("hash changed after JSONB round trip")
+	}
+	stored.DetailsJSON = []byte(`{"a": {"x": 1.5, "y": "2"}, "b": 1}`)
+	if auditEventHash(stored) == e.Hash {
+		t.Error("hash did not change when a detail value changed")
+	}
+}
+
+func TestAuditCheckpointSignature(t *testing.T) {
+	t.Parallel()
+
+	keys := newLegacyHS256KeyManager([]byte("0123456789abcdef0123456789abcdef"))
+	token, err := keys.signJWT(auditCheckpointClaims{Type: auditCheckpointJWTType, LastEventID: 7, LastHash: "abc"}, auditCheckpointTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := auditCheckpointVerifier(keys, nil, nil, token)
+	if verifier == nil {
+		t.Fatal("signing key is not found for verification")
+	}
+	var claims auditCheckpointClaims
+	if err := verifier.verifyJWT(token, auditCheckpointTokenType, &claims); err != nil || claims.LastEventID != 7 || claims.LastHash != "abc" {
+		t.Fatalf("verifyJWT = %+v, %v", claims, err)
+	}
+	other := newLegacyHS256KeyManager([]byte("fedcba9876543210fedcba9876543210"))
+	if err := other.verifyJWT(token, auditCheckpointTokenType, &claims); err == nil {
+		t.Error("checkpoint verified with a different key")
+	}
+	if auditCheckpointVerifier(nil, nil, nil, token) != nil {
+		t.Error("nil key manager reported a verifier")
+	}
+}
+
+func TestAuditCheckpointRecordedKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	keys := newLegacyHS256KeyManager([]byte("0123456789abcdef0123456789abcdef"))
+	var records []officeJWTKeyRecord
+	for _, kid := range []string{"signing", "forged"} {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		records = append(records, officeJWTKeyRecord{kid: kid, activatesAt: now.Add(-time.Hour), privateKey: key})
+	}
+	store := &officeJWTKeyStore{keys: keys}
+	store.apply(records[:1], now)
+	token, err := keys.signJWT(auditCheckpointClaims{Type: auditCheckpointJWTType, LastEventID: 7, LastHash: "abc"}, auditCheckpointTokenType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []json.RawMessage
+	for _, jwk := range keys.publicJWKs() {
+		encoded, _ := json.Marshal(jwk)
+		raw = append(raw, encoded)
+	}
+	recorded := parseAuditCheckpointKeys(raw)
+	pins := auditCheckpointKeyPins{rsaJWKThumbprint(&records[0].privateKey.PublicKey): true}
+
+	// Rotated keys come from the database and need a pin.
+	if auditCheckpointVerifier(keys, recorded, nil, token) != nil {
+		t.Fatal("unpinned rotated key reported as a verifier")
+	}
+	if auditCheckpointVerifier(keys, nil, pins, token) == nil {
+		t.Fatal("pinned rotated key is not found for verification")
+	}
+
+	// The key is rotated out and deleted.
+	keys.setRotatedKeys(nil, nil)
+	if auditCheckpointVerifier(keys, nil, pins, token) != nil {
+		t.Fatal("deleted key still reported as a verifier")
+	}
+	if auditCheckpointVerifier(keys, recorded, nil, token) != nil {
+		t.Fatal("unpinned recorded key reported as a verifier")
+	}
+	verifier := auditCheckpointVerifier(keys, recorded, pins, token)
+	if verifier == nil {
+		t.Fatal("pinned recorded key is not found for verification")
+	}
+	var claims auditCheckpointClaims
+	if err := verifier.verifyJWT(token, auditCheckpointTokenType, &claims); err != nil || claims.LastEventID != 7 {
+		t.Fatalf("verifyJWT with recorded key = %+v, %v", claims, err)
+	}
+
+	// A different key recorded under the same kid is not pinned.
+	forged, _ := json.Marshal(rsaPublicJWK("signing", &records[1].privateKey.PublicKey))
+	if auditCheckpointVerifier(keys, parseAuditCheckpointKeys([]json.RawMessage{forged}), pins, token) != nil {
+		t.Fatal("forged recorded key reported as a verifier")
+	}
+}
+
+func TestRSAJWKThumbprint(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7638, section 3.1.
+	n, err := base64URLDecode("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	if got, want := rsaJWKThumbprint(key), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Fatalf("rsaJWKThumbprint() = %q, want %q", got, want)
+	}
+}
+
+func TestAuditArchiveFileKeepsChain(t *testing.T) {
+	t.Parallel()
+
+	events := buildAuditChain(3)
+	path := filepath.Join(t.TempDir(), fmt.Sprintf(auditArchiveFileNameTemplate, 1, 3))
+	checksum, err := writeAuditArchiveFile(path, events)
+	if err != nil {
+		t.Fatalf("writeAuditArchiveFile: %v", err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(raw); hex.EncodeToString(sum[:]) != checksum {
+		t.Errorf("checksum = %s; want SHA-256 of the file", checksum)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(gz)
+	prev := ""
+	for i := 0; dec.More(); i++ {
+		var rec auditArchiveRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		createdAt, err := time.Parse(time.RFC3339Nano, rec.CreatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := auditChainEvent{
+			ID: rec.ID, PrevHash: rec.PrevHash, Hash: rec.Hash,
+			ActionType: rec.ActionType, EntityType: rec.EntityType, EntityID: rec.EntityID, EntityName: rec.EntityName,
+			ActorEmployeeID: rec.ActorEmployeeID, ActorName: rec.ActorName, DetailsJSON: rec.Details, CreatedAt: createdAt,
+		}
+		if b := checkAuditChainLink(e, prev); b != nil {
+			t.Fatalf("archived event %d breaks the chain: %+v", rec.ID, b)
+		}
+		prev = e.Hash
+	}
+	if prev != events[len(events)-1].Hash {
+		t.Errorf("archive head = %s; want %s", prev, events[len(events)-1].Hash)
+	}
+}
+
+func TestResponsibilityChangeAuditEvent(t *testing.T) {
+	change := responsibilityChange{
+		EntityType:           auditEntityFloor,
+		EntityID:             7,
+		EntityName:           "3 этаж",
+		PreviousEmployeeID:   "10",
+		PreviousEmployeeName: "Иванов",
+		NewEmployeeID:        "20",
+		ActorEmployeeID:      "1",
+	}
+	changedAt := time.Date(2024, 2, 3, 4, 5, 6, 0, time.FixedZone("MSK", 3*60*60))
+	e := change.auditEvent(migratedResponsibilityDetails(changedAt))
+	// The copy is stamped when it is written, not with the original time,
+	// so the chain stays in time order.
+	if !e.CreatedAt.IsZero() {
+		t.Fatalf("migrated event created_at = %v, want the time of the copy", e.CreatedAt)
+	}
+	if e.ActionType != auditActionChangeResponsible || e.EntityType != auditEntityFloor || e.EntityID != 7 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	var details map[string]string
+	if err := json.Unmarshal(e.DetailsJSON, &details); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"previous_employee_id":   "10",
+		"previous_employee_name": "Иванов",
+		"new_employee_id":        "20",
+		"new_employee_name":      "",
+		"migrated_from":          "responsibility_audit_log",
+		"original_created_at":    "2024-02-03T01:05:06Z",
+	}
+	for key, value := range want {
+		if got, ok := details[key]; !ok || got != value {
+			t.Fatalf("details[%q] = %q, want %q", key, got, value)
+		}
+	}
+}
+
+// TestAuditCheckpointSurvivesKeyDeletion writes a checkpoint against the
+// database in OFFICE_TEST_DATABASE_URL and verifies it after its pinned
+// signing key is gone from the key manager.
+func TestAuditCheckpointSurvivesKeyDeletion(t *testing.T) {
+	db := openTestDatabase(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	kid := fmt.Sprintf("audit-test-%d", now.UnixNano())
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	// The legacy secret verifies the erasure checkpoints other tests leave.
+	keys := newLegacyHS256KeyManager([]byte(testAuditCheckpointSecret))
+	store := &officeJWTKeyStore{keys: keys}
+	store.apply([]officeJWTKeyRecord{{kid: kid, activatesAt: now.Add(-time.Hour), privateKey: key}}, now)
+	pins := auditCheckpointKeyPins{rsaJWKThumbprint(&key.PublicKey): true}
+	a := &app{db: db, officeTokenKeys: keys, auditCheckpointKeyPins: pins}
+
+	a.logAuditEvent(ctx, auditLogWriteInput{ActionType: auditActionUpdate, EntityType: auditEntityDesk, EntityID: 1, EntityName: "checkpoint test"})
+	if err := a.writeAuditCheckpoint(ctx); err != nil {
+		t.Fatalf("write checkpoint: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec(`DELETE FROM audit_log_checkpoints WHERE token LIKE $1`, base64URLEncode([]byte(`{"alg":"RS256","typ":"JWT","kid":"`+kid+`"}`))+".%")
+		_, _ = db.Exec(`DELETE FROM audit_checkpoint_keys WHERE kid = $1`, kid)
+	})
+
+	keys.setRotatedKeys(nil, nil)
+	report, err := verifyAuditChain(ctx, db, keys, pins)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !report.Valid || report.CheckpointsUnverifiable != 0 {
+		t.Fatalf("checkpoint of a deleted key with a recorded JWK: %+v", report)
+	}
+	report, err = verifyAuditChain(ctx, db, keys, nil)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if report.Valid || report.CheckpointsUnverifiable == 0 {
+		t.Fatalf("checkpoint of an unpinned recorded key accepted: %+v", report)
+	}
+
+	if _, err := db.Exec(`DELETE FROM audit_checkpoint_keys WHERE kid = $1`, kid); err != nil {
+		t.Fatal(err)
+	}
+	report, err = verifyAuditChain(ctx, db, keys, pins)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if report.Valid || report.CheckpointsUnverifiable == 0 {
+		t.Fatalf("checkpoint of an unknown key accepted: %+v", report)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminAuditLogsExportPath     = "/api/admin/logs/export"
+	auditExportFormatCSV         = "csv"
+	auditExportFormatNDJSON      = "ndjson"
+	auditExportFlushEvery        = 500
+	auditExportFileTimeLayout    = "20060102-150405"
+	auditExportCSVContentType    = "text/csv; charset=utf-8"
+	auditExportNDJSONContentType = "application/x-ndjson"
+)
+
+var auditExportCSVHeader = []string{
+	"id",
+	"created_at",
+	"action_type",
+	"entity_type",
+	"entity_id",
+	"entity_name",
+	"actor_employee_id",
+	"actor_name",
+	"details",
+}
+
+// handleAdminAuditLogsExport streams every event matching the list filters
+// in chronological order. Rows are written as they are read, so the export
+// is not capped like the paginated list.
+func (a *app) handleAdminAuditLogsExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = auditExportFormatCSV
+	}
+	if format != auditExportFormatCSV && format != auditExportFormatNDJSON {
+		respondError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	filter, err := parseAuditLogFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where, args := filter.where(nil)
+	query := auditLogSelectColumns
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY created_at, id"
+	rows, err := a.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		log.Printf("audit log: export query failed: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer rows.Close()
+
+	// A large export outlives the server WriteTimeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	fileName := fmt.Sprintf("audit-log-%s.%s", time.Now().UTC().Format(auditExportFileTimeLayout), format)
+	if format == auditExportFormatCSV {
+		w.Header().Set("Content-Type", auditExportCSVContentType)
+	} else {
+		w.Header().Set("Content-Type", auditExportNDJSONContentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	buf := bufio.NewWriter(w)
+	var (
+		csvWriter *csv.Writer
+		encoder   *json.Encoder
+	)
+	if format == auditExportFormatCSV {
+		// The BOM lets spreadsheet software detect UTF-8 for Cyrillic names.
+		buf.WriteString("\ufeff")
+		csvWriter = csv.NewWriter(buf)
+		_ = csvWriter.Write(auditExportCSVHeader)
+	} else {
+		encoder = json.NewEncoder(buf)
+	}
+
+	written := 0
+	for rows.Next() {
+		item, err := scanAuditLogItem(rows)
+		if err != nil {
+			log.Printf("audit log: export scan failed: %v", err)
+			return
+		}
+		if csvWriter != nil {
+			err = csvWriter.Write(auditExportCSVRecord(item))
+		} else {
+			err = encoder.Encode(item)
+		}
+		if err != nil {
+			log.Printf("audit log: export write failed: %v", err)
+			return
+		}
+		written++
+		if written%auditExportFlushEvery == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			if err := buf.Flush(); err != nil {
+				log.Printf("audit log: export write failed: %v", err)
+				return
+			}
+			_ = rc.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("audit log: export rows failed: %v", err)
+		return
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	if err := buf.Flush(); err != nil {
+		log.Printf("audit log: export write failed: %v", err)
+	}
+}
+
+func auditExportCSVRecord(item auditLogItem) []string {
+	details, err := json.Marshal(item.Details)
+	if err != nil {
+		details = []byte("{}")
+	}
+	return []string{
+		strconv.FormatInt(item.ID, 10),
+		item.CreatedAt.UTC().Format(time.RFC3339Nano),
+		csvSafeCell(item.ActionType),
+		csvSafeCell(item.EntityType),
+		strconv.FormatInt(item.EntityID, 10),
+		csvSafeCell(item.EntityName),
+		csvSafeCell(item.ActorEmployeeID),
+		csvSafeCell(item.ActorName),
+		csvSafeCell(string(details)),
+	}
+}
+
+// csvSafeCell neutralises values that spreadsheet software would evaluate as
+// formulas. Entity and actor names come from user input.
+func csvSafeCell(value string) string {
+	if value == "" {
+		return value
+	}
+	switch value[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + value
+	}
+	return value
+}
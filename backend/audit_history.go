@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const adminEntityHistoryPath = "/api/admin/entity-history"
+
+// responsibleEntityTables maps the entity types that have a responsible
+// employee to their tables.
+var responsibleEntityTables = map[string]string{
+	auditEntityBuilding:  "office_buildings",
+	auditEntityFloor:     "floors",
+	auditEntityCoworking: "coworkings",
+	auditEntityZone:      "zones",
+	auditEntityResource:  "resources",
+}
+
+type entityHistoryResponse struct {
+	EntityType            string         `json:"entity_type"`
+	EntityID              int64          `json:"entity_id"`
+	EntityName            string         `json:"entity_name"`
+	EntityPath            string         `json:"entity_path"`
+	Exists                bool           `json:"exists"`
+	ResponsibleEmployeeID string         `json:"responsible_employee_id"`
+	ResponsibleName       string         `json:"responsible_name"`
+	Items                 []auditLogItem `json:"items"`
+	NextCursor            string         `json:"next_cursor"`
+}
+
+// handleAdminEntityHistory returns one timeline for a building, floor,
+// coworking, zone or resource: every audit event about it, responsibility
+// changes included, newest first with the same cursor as the audit log.
+func (a *app) handleAdminEntityHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+	query := r.URL.Query()
+	entityType := strings.TrimSpace(query.Get("entity_type"))
+	if _, ok := responsibleEntityTables[entityType]; !ok {
+		respondError(w, http.StatusBadRequest, "entity_type must be building, floor, coworking, zone or resource")
+		return
+	}
+	entityID, err := parseAuditLogsID(query.Get("entity_id"))
+	if err != nil || entityID == 0 {
+		respondError(w, http.StatusBadRequest, "entity_id is required")
+		return
+	}
+	limit := parseAuditLogsInt(query.Get("limit"), 100)
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	var cursor *auditLogCursor
+	if raw := strings.TrimSpace(query.Get("cursor")); raw != "" {
+		decoded, err := decodeAuditLogCursor(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cursor = &decoded
+	}
+
+	resp := entityHistoryResponse{EntityType: entityType, EntityID: entityID}
+	if err := a.loadEntityHistoryHeader(r.Context(), &resp); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	filter := auditLogFilter{EntityType: entityType, EntityID: entityID}
+	resp.Items, resp.NextCursor, err = a.queryAuditLogPage(r.Context(), filter, cursor, limit, 0)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if resp.EntityName == "" && len(resp.Items) > 0 {
+		// Purged entities are only known from their events.
+		resp.EntityName = resp.Items[0].EntityName
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+func (a *app) loadEntityHistoryHeader(ctx context.Context, resp *entityHistoryResponse) error {
+	table := responsibleEntityTables[resp.EntityType]
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(t.name, ''),
+		        COALESCE(t.responsible_employee_id, ''),
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "t.responsible_employee_id")+`
+		   FROM `+table+` t
+		  WHERE t.id = $1`,
+		resp.EntityID,
+	).Scan(&resp.EntityName, &resp.ResponsibleEmployeeID, &resp.ResponsibleName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Exists = true
+	resp.EntityPath = a.resolveEntityPath(ctx, resp.EntityType, resp.EntityID, nil)
+	return nil
+}
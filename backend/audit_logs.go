@@ -3,23 +3,28 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 const (
-	auditActionCreate = "create"
-	auditActionUpdate = "update"
-	auditActionDelete = "delete"
-	auditActionBook   = "book"
-	auditActionCancel = "cancel"
+	auditActionCreate  = "create"
+	auditActionUpdate  = "update"
+	auditActionDelete  = "delete"
+	auditActionBook    = "book"
+	auditActionCancel  = "cancel"
+	auditActionRestore = "restore"
+	auditActionPurge   = "purge"
 )
 
 const (
@@ -67,7 +72,8 @@ func ensureAuditLogsStorage(db *sql.DB) error {
 			details_json JSONB NOT NULL DEFAULT '{}'::jsonb,
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`,
-		`CREATE INDEX IF NOT EXISTS audit_log_events_created_idx ON audit_log_events (created_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_events_created_id_idx ON audit_log_events (created_at DESC, id DESC);`,
+		`DROP INDEX IF EXISTS audit_log_events_created_idx;`,
 		`CREATE INDEX IF NOT EXISTS audit_log_events_entity_idx ON audit_log_events (entity_type, entity_id, created_at DESC);`,
 		`CREATE INDEX IF NOT EXISTS audit_log_events_action_idx ON audit_log_events (action_type, created_at DESC);`,
 		`CREATE INDEX IF NOT EXISTS audit_log_events_actor_idx ON audit_log_events (actor_employee_id, created_at DESC);`,
@@ -77,6 +83,31 @@ func ensureAuditLogsStorage(db *sql.DB) error {
 			return err
 		}
 	}
+	return ensureAuditSearchStorage(db)
+}
+
+// ensureAuditSearchStorage adds a full-text vector over the text columns and
+// the string and numeric values of details_json, plus expression indexes for
+// the location filters.
+func ensureAuditSearchStorage(db *sql.DB) error {
+	if err := ensureColumn(db, "audit_log_events", "search_vector", `tsvector GENERATED ALWAYS AS (
+		to_tsvector('simple',
+			entity_name || ' ' || entity_type || ' ' || action_type || ' ' || entity_id::text || ' ' ||
+			actor_name || ' ' || actor_employee_id)
+		|| jsonb_to_tsvector('simple', details_json, '["string", "numeric"]')
+	) STORED`); err != nil {
+		return err
+	}
+	stmts := []string{
+		`CREATE INDEX IF NOT EXISTS audit_log_events_search_idx ON audit_log_events USING GIN (search_vector);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_events_building_idx ON audit_log_events ((details_json->>'building_id'), created_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_events_zone_idx ON audit_log_events ((details_json->>'zone_id'), created_at DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -93,10 +124,7 @@ func (a *app) logAuditEventFromRequest(
 	}
 	resolvedDetails := a.enrichAuditLogDetails(r.Context(), entityType, entityID, details)
 
-	actorEmployeeID := ""
-	if employeeID, err := extractEmployeeIDFromRequest(r, a.db); err == nil {
-		actorEmployeeID = strings.TrimSpace(employeeID)
-	}
+	actorEmployeeID := a.requestActorEmployeeID(r)
 
 	actorName := ""
 	if actorEmployeeID != "" {
@@ -107,6 +135,10 @@ func (a *app) logAuditEventFromRequest(
 			actorName = strings.TrimSpace(name)
 		}
 	}
+	if principal := serviceAccountFromContext(r.Context()); principal != nil && actorName == "" {
+		actorName = "Сервисный аккаунт «" + principal.Name + "»"
+	}
+	actorEmployeeID, actorName = impersonatedAuditActor(r.Context(), resolvedDetails, actorEmployeeID, actorName)
 
 	a.logAuditEvent(r.Context(), auditLogWriteInput{
 		ActionType:      actionType,
@@ -119,6 +151,16 @@ func (a *app) logAuditEventFromRequest(
 	})
 }
 
+// requestActorEmployeeID returns the employee ID of the caller, or "" when it
+// cannot be resolved.
+func (a *app) requestActorEmployeeID(r *http.Request) string {
+	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(employeeID)
+}
+
 func (a *app) logAuditEvent(ctx context.Context, input auditLogWriteInput) {
 	if a == nil || a.db == nil {
 		return
@@ -139,84 +181,256 @@ func (a *app) logAuditEvent(ctx context.Context, input auditLogWriteInput) {
 		}
 	}
 
-	if _, err := a.db.ExecContext(
-		ctx,
-		`INSERT INTO audit_log_events (
-			action_type,
-			entity_type,
-			entity_id,
-			entity_name,
-			actor_employee_id,
-			actor_name,
-			details_json
-		) VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb)`,
-		actionType,
-		entityType,
-		input.EntityID,
-		strings.TrimSpace(input.EntityName),
-		strings.TrimSpace(input.ActorEmployeeID),
-		strings.TrimSpace(input.ActorName),
-		string(detailsJSON),
-	); err != nil {
+	if err := appendAuditEvent(ctx, a.db, auditChainEvent{
+		ActionType:      actionType,
+		EntityType:      entityType,
+		EntityID:        input.EntityID,
+		EntityName:      strings.TrimSpace(input.EntityName),
+		ActorEmployeeID: strings.TrimSpace(input.ActorEmployeeID),
+		ActorName:       strings.TrimSpace(input.ActorName),
+		DetailsJSON:     detailsJSON,
+	}, a.auditSinks.names()); err != nil {
 		log.Printf("audit log: failed to store event (%s %s %d): %v", actionType, entityType, input.EntityID, err)
+		return
 	}
+	a.auditSinks.notify()
 }
 
-func (a *app) handleAdminAuditLogs(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
-	role, err := resolveRoleFromRequest(r, a.db)
-	if err != nil {
-		respondRoleResolutionError(w, err)
-		return
+// auditLogFilter holds the filters shared by the audit log list, export and
+// stats.
+type auditLogFilter struct {
+	EntityType      string
+	EntityID        int64
+	ActionType      string
+	ActorEmployeeID string
+	Search          string
+	ZoneID          int
+	BuildingID      int64
+	From            time.Time
+	To              time.Time
+}
+
+// parseAuditLogFilter reads the filter query parameters. from/to accept
+// RFC 3339 timestamps or YYYY-MM-DD dates; a bare "to" date includes the whole
+// day.
+func parseAuditLogFilter(query url.Values) (auditLogFilter, error) {
+	filter := auditLogFilter{
+		EntityType:      strings.TrimSpace(query.Get("entity_type")),
+		ActionType:      strings.TrimSpace(query.Get("action_type")),
+		ActorEmployeeID: strings.TrimSpace(query.Get("actor")),
+		Search:          strings.TrimSpace(query.Get("search")),
+		ZoneID:          parseAuditLogsInt(query.Get("zone_id"), 0),
+	}
+	var err error
+	if filter.EntityID, err = parseAuditLogsID(query.Get("entity_id")); err != nil {
+		return filter, fmt.Errorf("invalid entity_id: %w", err)
+	}
+	if filter.BuildingID, err = parseAuditLogsID(query.Get("building_id")); err != nil {
+		return filter, fmt.Errorf("invalid building_id: %w", err)
+	}
+	if filter.From, err = parseAuditLogTime(query.Get("from"), false); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseAuditLogTime(query.Get("to"), true); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	return filter, nil
+}
+
+func parseAuditLogsID(raw string) (int64, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return 0, nil
 	}
-	if role != roleAdmin {
-		respondError(w, http.StatusForbidden, "Недостаточно прав")
-		return
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("expected a positive integer")
 	}
+	return id, nil
+}
 
-	entityType := strings.TrimSpace(r.URL.Query().Get("entity_type"))
-	actionType := strings.TrimSpace(r.URL.Query().Get("action_type"))
-	search := strings.TrimSpace(r.URL.Query().Get("search"))
+// auditSearchNameSQL is the text the substring search looks in, and the
+// expression of its trigram index.
+const auditSearchNameSQL = `lower(entity_name || ' ' || actor_name)`
 
-	limit := parseAuditLogsInt(r.URL.Query().Get("limit"), 100)
-	if limit > 500 {
-		limit = 500
+// auditSearchWords splits free text into lower-case words of letters and
+// digits. They hold no tsquery operators or LIKE wildcards.
+func auditSearchWords(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// auditSearchTSQuery turns free text into a prefix query that matches events
+// containing every word, so "Иван стол" finds "Иванов" and "Стол 12".
+func auditSearchTSQuery(search string) string {
+	words := auditSearchWords(search)
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// ensureAuditSearchTrigramIndex backs the substring search on entity and
+// actor names with a pg_trgm index. The extension may need a superuser, so
+// without it search still works, just without the index, and the index is
+// retried on the next start.
+func ensureAuditSearchTrigramIndex(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(
+		`SELECT to_regclass('audit_log_events_name_trgm_idx') IS NOT NULL`,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if _, err := db.Exec(`CREATE EXTENSION IF NOT EXISTS pg_trgm`); err != nil {
+		log.Printf("WARNING: pg_trgm is not available, audit log substring search runs without an index: %v", err)
+		return nil
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS audit_log_events_name_trgm_idx
+		ON audit_log_events USING GIN (` + auditSearchNameSQL + ` gin_trgm_ops)`)
+	return err
+}
+
+// auditBuildingSubtreeSQL matches events recorded with the building in their
+// details and events about the building or anything currently inside it.
+// textArg and idArg are the placeholders holding the building ID as text and
+// as a number.
+func auditBuildingSubtreeSQL(textArg, idArg int) string {
+	return fmt.Sprintf(`(
+		details_json->>'building_id' = $%[1]d OR
+		(entity_type = '%[3]s' AND entity_id = $%[2]d) OR
+		(entity_type = '%[4]s' AND entity_id IN (SELECT id FROM floors WHERE building_id = $%[2]d)) OR
+		(entity_type = '%[5]s' AND entity_id IN (
+			SELECT c.id FROM coworkings c JOIN floors f ON f.id = c.floor_id WHERE f.building_id = $%[2]d)) OR
+		(entity_type = '%[6]s' AND entity_id IN (
+			SELECT w.id FROM workplaces w
+			  JOIN coworkings c ON c.id = w.coworking_id
+			  JOIN floors f ON f.id = c.floor_id
+			 WHERE f.building_id = $%[2]d)) OR
+		(entity_type = '%[7]s' AND entity_id IN (
+			SELECT m.id FROM meeting_rooms m JOIN floors f ON f.id = m.floor_id WHERE f.building_id = $%[2]d)) OR
+		(entity_type = '%[8]s' AND entity_id IN (
+			SELECT z.id FROM zones z JOIN floors f ON f.id = z.floor_id WHERE f.building_id = $%[2]d)) OR
+		(entity_type = '%[9]s' AND entity_id IN (
+			SELECT r.id FROM resources r JOIN floors f ON f.id = r.floor_id WHERE f.building_id = $%[2]d))
+	)`, textArg, idArg,
+		auditEntityBuilding, auditEntityFloor, auditEntityCoworking, auditEntityDesk,
+		auditEntityMeetingRoom, auditEntityZone, auditEntityResource,
+	)
+}
+
+func parseAuditLogTime(raw string, endOfDay bool) (time.Time, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return time.Time{}, nil
 	}
-	offset := parseAuditLogsInt(r.URL.Query().Get("offset"), 0)
-	if offset < 0 {
-		offset = 0
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC 3339 timestamp or YYYY-MM-DD")
 	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
 
-	var (
-		whereParts []string
-		args       []any
-	)
-	if entityType != "" && entityType != "all" {
-		args = append(args, entityType)
+// where appends the filter conditions to args and returns them joined with
+// AND, or "" when nothing is filtered.
+func (f auditLogFilter) where(args []any) (string, []any) {
+	var whereParts []string
+	if f.EntityType != "" && f.EntityType != "all" {
+		args = append(args, f.EntityType)
 		whereParts = append(whereParts, fmt.Sprintf("entity_type = $%d", len(args)))
 	}
-	if actionType != "" && actionType != "all" {
-		args = append(args, actionType)
+	if f.EntityID > 0 {
+		args = append(args, f.EntityID)
+		whereParts = append(whereParts, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+	if f.ActionType != "" && f.ActionType != "all" {
+		args = append(args, f.ActionType)
 		whereParts = append(whereParts, fmt.Sprintf("action_type = $%d", len(args)))
 	}
-	if search != "" {
-		needle := "%" + strings.ToLower(search) + "%"
-		args = append(args, needle)
-		whereParts = append(whereParts, fmt.Sprintf(`(
-			LOWER(COALESCE(entity_name, '')) LIKE $%d OR
-			LOWER(COALESCE(entity_type, '')) LIKE $%d OR
-			LOWER(COALESCE(action_type, '')) LIKE $%d OR
-			CAST(entity_id AS TEXT) LIKE $%d OR
-			LOWER(COALESCE(actor_name, '')) LIKE $%d OR
-			LOWER(COALESCE(actor_employee_id, '')) LIKE $%d OR
-			LOWER(COALESCE(details_json::text, '')) LIKE $%d
-		)`, len(args), len(args), len(args), len(args), len(args), len(args), len(args)))
-	}
-
-	query := `SELECT id,
+	if f.ActorEmployeeID != "" {
+		args = append(args, f.ActorEmployeeID)
+		whereParts = append(whereParts, fmt.Sprintf("actor_employee_id = $%d", len(args)))
+	}
+	if f.ZoneID > 0 {
+		args = append(args, strconv.Itoa(f.ZoneID))
+		whereParts = append(whereParts, fmt.Sprintf(
+			"(details_json->>'zone_id' = $%d OR (entity_type = '%s' AND CAST(entity_id AS TEXT) = $%d))",
+			len(args), auditEntityZone, len(args),
+		))
+	}
+	if f.BuildingID > 0 {
+		args = append(args, strconv.FormatInt(f.BuildingID, 10), f.BuildingID)
+		whereParts = append(whereParts, auditBuildingSubtreeSQL(len(args)-1, len(args)))
+	}
+	if tsQuery := auditSearchTSQuery(f.Search); tsQuery != "" {
+		// Word prefixes come from the full-text index. Parts of a word, like
+		// "ворк" in "Коворкинг", are only found in entity and actor names.
+		args = append(args, tsQuery)
+		search := fmt.Sprintf("search_vector @@ to_tsquery('simple', $%d)", len(args))
+		var substrings []string
+		for _, word := range auditSearchWords(f.Search) {
+			args = append(args, "%"+word+"%")
+			substrings = append(substrings, fmt.Sprintf("%s LIKE $%d", auditSearchNameSQL, len(args)))
+		}
+		whereParts = append(whereParts, "("+search+" OR ("+strings.Join(substrings, " AND ")+"))")
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		whereParts = append(whereParts, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		whereParts = append(whereParts, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	return strings.Join(whereParts, " AND "), args
+}
+
+// auditLogCursor points at the last item of a page. Pages are ordered by
+// (created_at, id) descending, so the next page starts strictly below it and
+// deep pages cost the same as the first one.
+type auditLogCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+func (c auditLogCursor) encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeAuditLogCursor(value string) (auditLogCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return auditLogCursor{}, errors.New("invalid cursor")
+	}
+	createdAtRaw, idRaw, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return auditLogCursor{}, errors.New("invalid cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtRaw)
+	if err != nil {
+		return auditLogCursor{}, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(idRaw, 10, 64)
+	if err != nil || id <= 0 {
+		return auditLogCursor{}, errors.New("invalid cursor")
+	}
+	return auditLogCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+const auditLogSelectColumns = `SELECT id,
 	                 action_type,
 	                 entity_type,
 	                 entity_id,
@@ -226,59 +440,128 @@ func (a *app) handleAdminAuditLogs(w http.ResponseWriter, r *http.Request) {
 	                 COALESCE(details_json, '{}'::jsonb),
 	                 created_at
 	            FROM audit_log_events`
-	if len(whereParts) > 0 {
-		query += " WHERE " + strings.Join(whereParts, " AND ")
+
+func scanAuditLogItem(rows rowScanner) (auditLogItem, error) {
+	var (
+		item       auditLogItem
+		detailsRaw []byte
+	)
+	if err := rows.Scan(
+		&item.ID,
+		&item.ActionType,
+		&item.EntityType,
+		&item.EntityID,
+		&item.EntityName,
+		&item.ActorEmployeeID,
+		&item.ActorName,
+		&detailsRaw,
+		&item.CreatedAt,
+	); err != nil {
+		return item, err
+	}
+	item.Details = map[string]any{}
+	if len(detailsRaw) > 0 {
+		if err := json.Unmarshal(detailsRaw, &item.Details); err != nil {
+			item.Details = map[string]any{}
+		}
+	}
+	return item, nil
+}
+
+// queryAuditLogPage returns one page of events, newest first, and the cursor
+// of the next page ("" on the last one).
+func (a *app) queryAuditLogPage(ctx context.Context, filter auditLogFilter, cursor *auditLogCursor, limit, offset int) ([]auditLogItem, string, error) {
+	where, args := filter.where(nil)
+	if cursor != nil {
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		keyset := fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+		if where != "" {
+			where += " AND " + keyset
+		} else {
+			where = keyset
+		}
+	}
+	query := auditLogSelectColumns
+	if where != "" {
+		query += " WHERE " + where
 	}
 	args = append(args, limit, offset)
 	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
 
-	rows, err := a.db.QueryContext(r.Context(), query, args...)
+	rows, err := a.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("audit log: list query failed: %v", err)
-		respondError(w, http.StatusInternalServerError, "internal error")
-		return
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	items := make([]auditLogItem, 0)
 	for rows.Next() {
-		var (
-			item       auditLogItem
-			detailsRaw []byte
-		)
-		if err := rows.Scan(
-			&item.ID,
-			&item.ActionType,
-			&item.EntityType,
-			&item.EntityID,
-			&item.EntityName,
-			&item.ActorEmployeeID,
-			&item.ActorName,
-			&detailsRaw,
-			&item.CreatedAt,
-		); err != nil {
-			log.Printf("audit log: list scan failed: %v", err)
-			respondError(w, http.StatusInternalServerError, "internal error")
-			return
-		}
-		item.Details = map[string]any{}
-		if len(detailsRaw) > 0 {
-			if err := json.Unmarshal(detailsRaw, &item.Details); err != nil {
-				item.Details = map[string]any{}
-			}
+		item, err := scanAuditLogItem(rows)
+		if err != nil {
+			return nil, "", err
 		}
 		items = append(items, item)
 	}
 	if err := rows.Err(); err != nil {
-		log.Printf("audit log: rows failed: %v", err)
-		respondError(w, http.StatusInternalServerError, "internal error")
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(items) == limit {
+		last := items[len(items)-1]
+		nextCursor = auditLogCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return items, nextCursor, nil
+}
+
+func (a *app) handleAdminAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+
+	filter, err := parseAuditLogFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	limit := parseAuditLogsInt(r.URL.Query().Get("limit"), 100)
+	if limit > 500 {
+		limit = 500
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+	offset := parseAuditLogsInt(r.URL.Query().Get("offset"), 0)
+	if offset < 0 {
+		offset = 0
+	}
+	var cursor *auditLogCursor
+	if raw := strings.TrimSpace(r.URL.Query().Get("cursor")); raw != "" {
+		decoded, err := decodeAuditLogCursor(raw)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cursor = &decoded
+		offset = 0
+	}
+
+	items, nextCursor, err := a.queryAuditLogPage(r.Context(), filter, cursor, limit, offset)
+	if err != nil {
+		log.Printf("audit log: list query failed: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
 	respondJSON(w, http.StatusOK, map[string]any{
-		"items":  items,
-		"limit":  limit,
-		"offset": offset,
+		"items":       items,
+		"limit":       limit,
+		"offset":      offset,
+		"next_cursor": nextCursor,
 	})
 }
 
@@ -545,10 +828,22 @@ func (a *app) resolveEntityPath(ctx context.Context, entityType string, entityID
 		if entityID > 0 {
 			return a.queryFloorPath(ctx, entityID)
 		}
+	case auditEntityZone:
+		if path := joinAuditPath(
+			readAuditString(details, "building_name"),
+			floorLabel(readAuditString(details, "floor_name"), readAuditInt(details, "floor_level")),
+			readAuditString(details, "zone_name", "entity_name"),
+		); hasAuditPathDepth(path, 3) {
+			return path
+		}
+		if entityID > 0 {
+			return a.queryZonePath(ctx, entityID)
+		}
 	case auditEntityCoworking:
 		if path := joinAuditPath(
 			readAuditString(details, "building_name"),
 			floorLabel(readAuditString(details, "floor_name"), readAuditInt(details, "floor_level")),
+			readAuditString(details, "zone_name"),
 			readAuditString(details, "subdivision_level_1", "before_subdivision_1", "after_subdivision_1"),
 			readAuditString(details, "subdivision_level_2", "before_subdivision_2", "after_subdivision_2"),
 			readAuditString(details, "space_name", "coworking_name", "entity_name"),
@@ -562,6 +857,7 @@ func (a *app) resolveEntityPath(ctx context.Context, entityType string, entityID
 		if path := joinAuditPath(
 			readAuditString(details, "building_name"),
 			floorLabel(readAuditString(details, "floor_name"), readAuditInt(details, "floor_level")),
+			readAuditString(details, "zone_name"),
 			readAuditString(details, "meeting_room_name", "space_name", "entity_name"),
 		); hasAuditPathDepth(path, 3) {
 			return path
@@ -573,6 +869,7 @@ func (a *app) resolveEntityPath(ctx context.Context, entityType string, entityID
 		if path := joinAuditPath(
 			readAuditString(details, "building_name"),
 			floorLabel(readAuditString(details, "floor_name"), readAuditInt(details, "floor_level")),
+			readAuditString(details, "zone_name"),
 			readAuditString(details, "subdivision_level_1", "before_subdivision_1", "after_subdivision_1"),
 			readAuditString(details, "subdivision_level_2", "before_subdivision_2", "after_subdivision_2"),
 			readAuditString(details, "coworking_name", "space_name"),
@@ -583,6 +880,18 @@ func (a *app) resolveEntityPath(ctx context.Context, entityType string, entityID
 		if entityID > 0 {
 			return a.queryDeskPath(ctx, entityID)
 		}
+	case auditEntityResource, auditEntityResourceBooking:
+		if path := joinAuditPath(
+			readAuditString(details, "building_name"),
+			floorLabel(readAuditString(details, "floor_name"), readAuditInt(details, "floor_level")),
+			readAuditString(details, "zone_name"),
+			readAuditString(details, "resource_name", "entity_name"),
+		); hasAuditPathDepth(path, 3) {
+			return path
+		}
+		if entityID > 0 {
+			return a.queryResourcePath(ctx, entityID)
+		}
 	case auditEntityMeetingBooking:
 		if path := joinAuditPath(
 			readAuditString(details, "building_name"),
@@ -675,17 +984,26 @@ func hasAuditPathDepth(path string, minParts int) bool {
 	return count >= minParts
 }
 
+// floorLabel returns the display label of a floor. A custom name ("B2",
+// "Мезонин") is shown as is; numeric names and unnamed floors are spelled out,
+// with underground levels labelled separately from the level number.
 func floorLabel(name string, level int) string {
 	trimmed := strings.TrimSpace(name)
 	if trimmed != "" {
 		if strings.HasPrefix(strings.ToLower(trimmed), "этаж ") {
 			return trimmed
 		}
-		if _, err := strconv.Atoi(trimmed); err == nil {
+		if number, err := strconv.Atoi(trimmed); err == nil {
+			if number < 0 {
+				return fmt.Sprintf("Подземный этаж %d", -number)
+			}
 			return "Этаж " + trimmed
 		}
 		return trimmed
 	}
+	if level < 0 {
+		return fmt.Sprintf("Подземный этаж %d", -level)
+	}
 	if level != 0 {
 		return fmt.Sprintf("Этаж %d", level)
 	}
@@ -731,6 +1049,63 @@ func (a *app) queryFloorPath(ctx context.Context, floorID int64) string {
 	return joinAuditPath(buildingName, floorLabel(floorName, level))
 }
 
+func (a *app) queryZonePath(ctx context.Context, zoneID int64) string {
+	if a == nil || a.db == nil || zoneID <= 0 {
+		return ""
+	}
+	var (
+		buildingName string
+		floorName    string
+		level        int
+		zoneName     string
+	)
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(b.name, ''),
+		        COALESCE(f.name, ''),
+		        COALESCE(f.level, 0),
+		        COALESCE(z.name, '')
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		  WHERE z.id = $1`,
+		zoneID,
+	).Scan(&buildingName, &floorName, &level, &zoneName)
+	if err != nil {
+		return ""
+	}
+	return joinAuditPath(buildingName, floorLabel(floorName, level), zoneName)
+}
+
+func (a *app) queryResourcePath(ctx context.Context, resourceID int64) string {
+	if a == nil || a.db == nil || resourceID <= 0 {
+		return ""
+	}
+	var (
+		buildingName string
+		floorName    string
+		level        int
+		zoneName     string
+		resourceName string
+	)
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(b.name, ''),
+		        COALESCE(f.name, ''),
+		        COALESCE(f.level, 0),
+		        COALESCE(z.name, ''),
+		        COALESCE(r.name, '')
+		   FROM resources r
+		   JOIN floors f ON f.id = r.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = r.zone_id
+		  WHERE r.id = $1`,
+		resourceID,
+	).Scan(&buildingName, &floorName, &level, &zoneName, &resourceName)
+	if err != nil {
+		return ""
+	}
+	return joinAuditPath(buildingName, floorLabel(floorName, level), zoneName, resourceName)
+}
+
 func (a *app) queryCoworkingPath(ctx context.Context, coworkingID int64) string {
 	if a == nil || a.db == nil || coworkingID <= 0 {
 		return ""
@@ -739,6 +1114,7 @@ func (a *app) queryCoworkingPath(ctx context.Context, coworkingID int64) string
 		buildingName string
 		floorName    string
 		level        int
+		zoneName     string
 		coworking    string
 		subdivision1 string
 		subdivision2 string
@@ -747,21 +1123,24 @@ func (a *app) queryCoworkingPath(ctx context.Context, coworkingID int64) string
 		`SELECT COALESCE(b.name, ''),
 		        COALESCE(f.name, ''),
 		        COALESCE(f.level, 0),
+		        COALESCE(z.name, ''),
 		        COALESCE(c.name, ''),
 		        COALESCE(c.subdivision_level_1, ''),
 		        COALESCE(c.subdivision_level_2, '')
 		   FROM coworkings c
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE c.id = $1`,
 		coworkingID,
-	).Scan(&buildingName, &floorName, &level, &coworking, &subdivision1, &subdivision2)
+	).Scan(&buildingName, &floorName, &level, &zoneName, &coworking, &subdivision1, &subdivision2)
 	if err != nil {
 		return ""
 	}
 	return joinAuditPath(
 		buildingName,
 		floorLabel(floorName, level),
+		zoneName,
 		subdivision1,
 		subdivision2,
 		coworking,
@@ -776,23 +1155,26 @@ func (a *app) queryMeetingRoomPath(ctx context.Context, meetingRoomID int64) str
 		buildingName string
 		floorName    string
 		level        int
+		zoneName     string
 		meetingName  string
 	)
 	err := a.db.QueryRowContext(ctx,
 		`SELECT COALESCE(b.name, ''),
 		        COALESCE(f.name, ''),
 		        COALESCE(f.level, 0),
+		        COALESCE(z.name, ''),
 		        COALESCE(m.name, '')
 		   FROM meeting_rooms m
 		   JOIN floors f ON f.id = m.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = m.zone_id
 		  WHERE m.id = $1`,
 		meetingRoomID,
-	).Scan(&buildingName, &floorName, &level, &meetingName)
+	).Scan(&buildingName, &floorName, &level, &zoneName, &meetingName)
 	if err != nil {
 		return ""
 	}
-	return joinAuditPath(buildingName, floorLabel(floorName, level), meetingName)
+	return joinAuditPath(buildingName, floorLabel(floorName, level), zoneName, meetingName)
 }
 
 func (a *app) queryDeskPath(ctx context.Context, deskID int64) string {
@@ -803,6 +1185,7 @@ func (a *app) queryDeskPath(ctx context.Context, deskID int64) string {
 		buildingName string
 		floorName    string
 		level        int
+		zoneName     string
 		coworking    string
 		subdivision1 string
 		subdivision2 string
@@ -812,6 +1195,7 @@ func (a *app) queryDeskPath(ctx context.Context, deskID int64) string {
 		`SELECT COALESCE(b.name, ''),
 		        COALESCE(f.name, ''),
 		        COALESCE(f.level, 0),
+		        COALESCE(z.name, ''),
 		        COALESCE(c.name, ''),
 		        COALESCE(c.subdivision_level_1, ''),
 		        COALESCE(c.subdivision_level_2, ''),
@@ -820,15 +1204,17 @@ func (a *app) queryDeskPath(ctx context.Context, deskID int64) string {
 		   JOIN coworkings c ON c.id = w.coworking_id
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE w.id = $1`,
 		deskID,
-	).Scan(&buildingName, &floorName, &level, &coworking, &subdivision1, &subdivision2, &deskLabel)
+	).Scan(&buildingName, &floorName, &level, &zoneName, &coworking, &subdivision1, &subdivision2, &deskLabel)
 	if err != nil {
 		return ""
 	}
 	return joinAuditPath(
 		buildingName,
 		floorLabel(floorName, level),
+		zoneName,
 		subdivision1,
 		subdivision2,
 		coworking,
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLogFilterWhere(t *testing.T) {
+	t.Parallel()
+
+	filter, err := parseAuditLogFilter(url.Values{
+		"entity_type": {"desk"},
+		"action_type": {"all"},
+		"from":        {"2026-03-01"},
+		"to":          {"2026-03-31"},
+	})
+	if err != nil {
+		t.Fatalf("parseAuditLogFilter: %v", err)
+	}
+	where, args := filter.where([]any{"prefix"})
+	if want := "entity_type = $2 AND created_at >= $3 AND created_at < $4"; where != want {
+		t.Errorf("where = %q; want %q", where, want)
+	}
+	wantArgs := []any{
+		"prefix",
+		"desk",
+		time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(args, wantArgs) {
+		t.Errorf("args = %#v; want %#v", args, wantArgs)
+	}
+
+	for _, bad := range []url.Values{
+		{"from": {"yesterday"}},
+		{"from": {"2026-03-02"}, "to": {"2026-03-01"}},
+	} {
+		if _, err := parseAuditLogFilter(bad); err == nil {
+			t.Errorf("parseAuditLogFilter(%v) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestAuditLogCursorRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cursor := auditLogCursor{CreatedAt: time.Date(2026, 3, 1, 9, 30, 0, 123456000, time.UTC), ID: 4242}
+	decoded, err := decodeAuditLogCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("decodeAuditLogCursor: %v", err)
+	}
+	if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.ID != cursor.ID {
+		t.Errorf("decoded = %+v; want %+v", decoded, cursor)
+	}
+	for _, bad := range []string{"", "not base64!", "MjAyNi0wMy0wMQ", auditLogCursor{CreatedAt: cursor.CreatedAt}.encode()} {
+		if _, err := decodeAuditLogCursor(bad); err == nil {
+			t.Errorf("decodeAuditLogCursor(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestAuditExportCSVRecordEscapesFormulas(t *testing.T) {
+	t.Parallel()
+
+	record := auditExportCSVRecord(auditLogItem{
+		ID:         7,
+		EntityName: "=HYPERLINK(\"http://evil\")",
+		ActorName:  "Иванов",
+		Details:    map[string]any{},
+		CreatedAt:  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if record[5] != "'=HYPERLINK(\"http://evil\")" || record[7] != "Иванов" {
+		t.Errorf("record = %q", record)
+	}
+}
+
+func TestAuditLogFilterSearchAndBuilding(t *testing.T) {
+	t.Parallel()
+
+	if got, want := auditSearchTSQuery(`  Иван, стол-12 (desk_booking) '&|!  `), "иван:* & стол:* & 12:* & desk:* & booking:*"; got != want {
+		t.Errorf("auditSearchTSQuery = %q; want %q", got, want)
+	}
+	if got := auditSearchTSQuery(" &|!:* "); got != "" {
+		t.Errorf("auditSearchTSQuery(punctuation) = %q; want empty", got)
+	}
+
+	filter, err := parseAuditLogFilter(url.Values{
+		"actor":       {"1001"},
+		"entity_id":   {"7"},
+		"building_id": {"3"},
+		"search":      {"Иван"},
+	})
+	if err != nil {
+		t.Fatalf("parseAuditLogFilter: %v", err)
+	}
+	where, args := filter.where(nil)
+	if !reflect.DeepEqual(args, []any{int64(7), "1001", "3", int64(3), "иван:*", "%иван%"}) {
+		t.Errorf("args = %#v", args)
+	}
+	for _, fragment := range []string{
+		"entity_id = $1 AND actor_employee_id = $2 AND (",
+		"details_json->>'building_id' = $3 OR",
+		"(entity_type = 'building' AND entity_id = $4)",
+		"(search_vector @@ to_tsquery('simple', $5) OR (lower(entity_name || ' ' || actor_name) LIKE $6))",
+	} {
+		if !strings.Contains(where, fragment) {
+			t.Errorf("where does not contain %q:\n%s", fragment, where)
+		}
+	}
+
+	// Every word must also occur in the names when matched as a substring.
+	filter, _ = parseAuditLogFilter(url.Values{"search": {"ворк 12"}})
+	where, args = filter.where(nil)
+	if !reflect.DeepEqual(args, []any{"ворк:* & 12:*", "%ворк%", "%12%"}) || !strings.Contains(where, "LIKE $2 AND lower(entity_name || ' ' || actor_name) LIKE $3") {
+		t.Errorf("substring search: %s %#v", where, args)
+	}
+
+	if _, err := parseAuditLogFilter(url.Values{"building_id": {"-1"}}); err == nil {
+		t.Error("negative building_id accepted")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// When OFFICE_AUDIT_RETENTION_DAYS is set, events older than the retention
+// period are moved out of audit_log_events into gzip-compressed NDJSON files
+// in OFFICE_AUDIT_ARCHIVE_DIR. Each file holds a contiguous id range with the
+// chain hashes, so it can be verified offline. The file is synced to disk
+// before its rows are deleted, and audit_log_archives records the last
+// archived hash: it is the anchor the remaining chain continues from.
+// Events still pending in the audit_sink_queue of a configured sink are kept
+// until delivered, so retention never drops an event before the SIEM has it.
+// Queues of sinks no longer configured do not hold retention back.
+//
+// Archives stay on the local disk on purpose. Uploaded files are served
+// publicly, so the object storage is not a place for personal data.
+// Personal data erasure does not rewrite them: an archive keeps the names
+// and identifiers of erased employees until the file is deleted.
+const (
+	auditArchiveDirName          = "backend/audit_archive"
+	auditRetentionInterval       = time.Hour
+	auditRetentionBatch          = 5000
+	auditRetentionMinDays        = 7
+	auditRetentionMaxDays        = 3650
+	auditArchiveFileNameTemplate = "audit-%012d-%012d.ndjson.gz"
+)
+
+var errAuditChainBrokenBeforeArchive = errors.New("audit chain is broken; run `api audit verify` before archiving")
+
+// auditArchiveAnchor is the last archived event; zero when nothing has been
+// archived yet.
+type auditArchiveAnchor struct {
+	LastEventID int64
+	LastHash    string
+}
+
+type auditArchiveRecord struct {
+	ID              int64           `json:"id"`
+	PrevHash        string          `json:"prev_hash"`
+	Hash            string          `json:"hash"`
+	ActionType      string          `json:"action_type"`
+	EntityType      string          `json:"entity_type"`
+	EntityID        int64           `json:"entity_id"`
+	EntityName      string          `json:"entity_name"`
+	ActorEmployeeID string          `json:"actor_employee_id"`
+	ActorName       string          `json:"actor_name"`
+	Details         json.RawMessage `json:"details"`
+	CreatedAt       string          `json:"created_at"`
+	Redacted        bool            `json:"redacted,omitempty"`
+}
+
+// newAuditArchiveRecord is the external form of an event, shared by archive
+// files and audit sinks.
+func newAuditArchiveRecord(e auditChainEvent) auditArchiveRecord {
+	return auditArchiveRecord{
+		ID:              e.ID,
+		PrevHash:        e.PrevHash,
+		Hash:            e.Hash,
+		ActionType:      e.ActionType,
+		EntityType:      e.EntityType,
+		EntityID:        e.EntityID,
+		EntityName:      e.EntityName,
+		ActorEmployeeID: e.ActorEmployeeID,
+		ActorName:       e.ActorName,
+		Details:         json.RawMessage(canonicalAuditDetails(e.DetailsJSON)),
+		CreatedAt:       e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		Redacted:        e.Redacted,
+	}
+}
+
+func ensureAuditArchiveStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS audit_log_archives (
+			id BIGSERIAL PRIMARY KEY,
+			file_name TEXT NOT NULL,
+			first_event_id BIGINT NOT NULL,
+			last_event_id BIGINT NOT NULL,
+			last_hash TEXT NOT NULL,
+			events INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS audit_log_archives_last_event_idx ON audit_log_archives (last_event_id DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadAuditArchiveAnchor(ctx context.Context, q rowQueryer) (auditArchiveAnchor, error) {
+	var anchor auditArchiveAnchor
+	err := q.QueryRowContext(ctx,
+		`SELECT last_event_id, last_hash FROM audit_log_archives ORDER BY last_event_id DESC LIMIT 1`,
+	).Scan(&anchor.LastEventID, &anchor.LastHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return auditArchiveAnchor{}, nil
+	}
+	return anchor, err
+}
+
+// auditRetentionFromEnv returns 0 when retention is disabled.
+func auditRetentionFromEnv() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("OFFICE_AUDIT_RETENTION_DAYS"))
+	if raw == "" || raw == "0" {
+		return 0
+	}
+	return parseEnvDurationDays("OFFICE_AUDIT_RETENTION_DAYS", 0, auditRetentionMinDays, auditRetentionMaxDays)
+}
+
+func auditArchiveDirFromEnv() (string, error) {
+	dir := strings.TrimSpace(os.Getenv("OFFICE_AUDIT_ARCHIVE_DIR"))
+	if dir == "" {
+		dir = auditArchiveDirName
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(abs, 0o700); err != nil {
+		return "", err
+	}
+	return abs, nil
+}
+
+// archiveExpiredAuditEvents archives and deletes events created before
+// cutoff, one batch per transaction, and returns how many were archived.
+// Events still pending for one of sinks are kept.
+func archiveExpiredAuditEvents(ctx context.Context, db *sql.DB, dir string, cutoff time.Time, sinks []string) (int, error) {
+	total := 0
+	for {
+		archived, err := archiveAuditBatch(ctx, db, dir, cutoff, sinks)
+		total += archived
+		if err != nil || archived < auditRetentionBatch {
+			return total, err
+		}
+	}
+}
+
+func archiveAuditBatch(ctx context.Context, db *sql.DB, dir string, cutoff time.Time, sinks []string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return 0, err
+	}
+	anchor, err := loadAuditArchiveAnchor(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	events, err := loadAuditChainEvents(ctx, tx, anchor.LastEventID, auditRetentionBatch)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		undeliveredSink string
+		undeliveredID   int64
+	)
+	if len(sinks) > 0 {
+		err = tx.QueryRowContext(ctx,
+			`SELECT sink, event_id FROM audit_sink_queue
+			  WHERE status = $1 AND sink = ANY($2::text[])
+			  ORDER BY event_id LIMIT 1`,
+			auditSinkStatusPending, sinks,
+		).Scan(&undeliveredSink, &undeliveredID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	expired, held, err := countExpiredAuditEvents(events, anchor.LastHash, cutoff, undeliveredID)
+	if err != nil {
+		return 0, err
+	}
+	if held {
+		log.Printf("audit retention: waiting for sink %s to deliver event %d", undeliveredSink, undeliveredID)
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	events = events[:expired]
+	first, last := events[0], events[len(events)-1]
+
+	fileName := fmt.Sprintf(auditArchiveFileNameTemplate, first.ID, last.ID)
+	checksum, err := writeAuditArchiveFile(filepath.Join(dir, fileName), events)
+	if err != nil {
+		return 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = os.Remove(filepath.Join(dir, fileName))
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM audit_log_events WHERE id > $1 AND id <= $2`, anchor.LastEventID, last.ID,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_log_archives (file_name, first_event_id, last_event_id, last_hash, events, sha256)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		fileName, first.ID, last.ID, last.Hash, len(events), checksum,
+	); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	committed = true
+	return len(events), nil
+}
+
+// countExpiredAuditEvents returns how many leading events can be archived.
+// Only the oldest contiguous run can go, otherwise the remaining chain would
+// have a hole, and it stops before undeliveredFrom (when positive), the
+// oldest event a sink has not received yet. held reports that an expired
+// event was kept for a sink.
+func countExpiredAuditEvents(events []auditChainEvent, prevHash string, cutoff time.Time, undeliveredFrom int64) (expired int, held bool, err error) {
+	for _, e := range events {
+		if !e.CreatedAt.Before(cutoff) {
+			break
+		}
+		if undeliveredFrom > 0 && e.ID >= undeliveredFrom {
+			return expired, true, nil
+		}
+		if brk := checkAuditChainLink(e, prevHash); brk != nil {
+			// Deleting a tampered range would destroy the evidence.
+			return 0, false, fmt.Errorf("%w (event %d: %s)", errAuditChainBrokenBeforeArchive, brk.EventID, brk.Reason)
+		}
+		prevHash = e.Hash
+		expired++
+	}
+	return expired, false, nil
+}
+
+// writeAuditArchiveFile writes events as gzip-compressed NDJSON, syncs the
+// file and returns the SHA-256 of the compressed bytes.
+func writeAuditArchiveFile(path string, events []auditChainEvent) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".audit-archive-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, hasher))
+	enc := json.NewEncoder(gz)
+	for _, e := range events {
+		if err := enc.Encode(newAuditArchiveRecord(e)); err != nil {
+			return "", err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func (a *app) runAuditRetentionLoop(ctx context.Context, retention time.Duration, dir string) {
+	ticker := time.NewTicker(auditRetentionInterval)
+	defer ticker.Stop()
+	for {
+		archived, err := archiveExpiredAuditEvents(ctx, a.db, dir, time.Now().Add(-retention), a.auditSinks.names())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("audit retention failed: %v", err)
+		}
+		if archived > 0 {
+			log.Printf("audit retention: archived %d event(s) to %s", archived, dir)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
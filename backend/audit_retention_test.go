@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCountExpiredAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	// buildAuditChain spaces events a minute apart from 09:00.
+	events := buildAuditChain(5)
+	cutoff := events[3].CreatedAt
+	tampered := buildAuditChain(5)
+	tampered[1].EntityName = "Подмена"
+
+	tests := []struct {
+		name            string
+		events          []auditChainEvent
+		undeliveredFrom int64
+		wantExpired     int
+		wantHeld        bool
+		wantErr         error
+	}{
+		{name: "all expired before cutoff", events: events, wantExpired: 3},
+		{name: "undelivered event holds the rest", events: events, undeliveredFrom: 2, wantExpired: 1, wantHeld: true},
+		{name: "oldest event undelivered", events: events, undeliveredFrom: 1, wantExpired: 0, wantHeld: true},
+		{name: "undelivered event not expired yet", events: events, undeliveredFrom: 4, wantExpired: 3},
+		{name: "broken chain", events: tampered, wantErr: errAuditChainBrokenBeforeArchive},
+		{name: "broken chain after undelivered event", events: tampered, undeliveredFrom: 2, wantExpired: 1, wantHeld: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			expired, held, err := countExpiredAuditEvents(tt.events, "", cutoff, tt.undeliveredFrom)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if expired != tt.wantExpired || held != tt.wantHeld {
+				t.Fatalf("countExpiredAuditEvents() = %d, %v, want %d, %v", expired, held, tt.wantExpired, tt.wantHeld)
+			}
+		})
+	}
+	if _, _, err := countExpiredAuditEvents(events, "", cutoff.Add(-time.Hour), 0); err != nil {
+		t.Fatalf("nothing expired: %v", err)
+	}
+}
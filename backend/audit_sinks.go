@@ -0,0 +1,803 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audit events can be forwarded to external collectors (SIEM). Every stored
+// event is queued in audit_sink_queue for each configured sink in the same
+// transaction that appends it to the chain, so nothing is lost on restart and
+// the request that produced the event only pays for one local INSERT.
+//
+// A background worker delivers each sink's queue in event order. An
+// advisory lock per sink keeps a single replica delivering it at a time.
+// Transient failures (network errors, 5xx) pause the sink with exponential
+// backoff and keep the event at the head of the queue. Permanent failures
+// (malformed request, 4xx) mark only that event as failed; failed events can
+// be re-queued from the admin endpoint.
+const (
+	auditSinkTypeSyslog        = "syslog"
+	auditSinkTypeWebhook       = "webhook"
+	auditSinkLockID            = 7_340_023
+	auditSinkBatch             = 50
+	auditSinkPollInterval      = 5 * time.Second
+	auditSinkDeliveryTimeout   = 10 * time.Second
+	auditSinkMinBackoff        = 5 * time.Second
+	auditSinkMaxBackoff        = 15 * time.Minute
+	auditSinkStatusPending     = "pending"
+	auditSinkStatusFailed      = "failed"
+	auditSinkMaxErrorLength    = 500
+	auditSinkSignatureHeader   = "X-Office-Signature"
+	auditSinkEventIDHeader     = "X-Office-Event-ID"
+	auditSyslogDefaultFacility = 13 // log audit
+	auditSyslogSeverityNotice  = 5
+	auditSyslogDefaultAppName  = "office"
+	auditSyslogDefaultSDID     = "audit@32473"
+	auditSyslogMaxUDPMessage   = 65000
+	adminAuditSinksPath        = "/api/admin/audit-sinks"
+	adminAuditSinksRetryPath   = "/api/admin/audit-sinks/retry"
+)
+
+// errAuditSinkPermanent marks delivery errors that retrying will not fix.
+var errAuditSinkPermanent = errors.New("permanent delivery failure")
+
+type auditSink interface {
+	// Deliver sends one event. payload is the JSON form of record.
+	Deliver(ctx context.Context, record auditArchiveRecord, payload []byte) error
+	Close() error
+	Name() string
+	Type() string
+	// Target describes the destination without credentials.
+	Target() string
+}
+
+type auditSinkConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// syslog
+	Network      string `json:"network"`
+	Address      string `json:"address"`
+	TLS          bool   `json:"tls"`
+	CAFile       string `json:"ca_file"`
+	ServerName   string `json:"server_name"`
+	Facility     *int   `json:"facility"`
+	AppName      string `json:"app_name"`
+	StructuredID string `json:"sd_id"`
+	Hostname     string `json:"hostname"`
+
+	// webhook
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// auditSinkSet is the configured sinks plus a wake-up signal for the
+// delivery worker. A nil set means forwarding is disabled.
+type auditSinkSet struct {
+	sinks []auditSink
+	wake  chan struct{}
+}
+
+func (s *auditSinkSet) names() []string {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.sinks))
+	for _, sink := range s.sinks {
+		names = append(names, sink.Name())
+	}
+	return names
+}
+
+func (s *auditSinkSet) notify() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *auditSinkSet) find(name string) auditSink {
+	if s == nil {
+		return nil
+	}
+	for _, sink := range s.sinks {
+		if sink.Name() == name {
+			return sink
+		}
+	}
+	return nil
+}
+
+func ensureAuditSinkStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS audit_sink_queue (
+			id BIGSERIAL PRIMARY KEY,
+			sink TEXT NOT NULL,
+			event_id BIGINT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			UNIQUE (sink, event_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS audit_sink_queue_pending_idx ON audit_sink_queue (sink, status, event_id);`,
+		`CREATE TABLE IF NOT EXISTS audit_sink_status (
+			sink TEXT PRIMARY KEY,
+			delivered BIGINT NOT NULL DEFAULT 0,
+			consecutive_failures INTEGER NOT NULL DEFAULT 0,
+			last_success_at TIMESTAMPTZ,
+			last_failure_at TIMESTAMPTZ,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_attempt_at TIMESTAMPTZ
+		);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAuditSinksFromEnv reads OFFICE_AUDIT_SINKS_JSON, a JSON array of sink
+// configs. It returns nil when no sinks are configured.
+func loadAuditSinksFromEnv() (*auditSinkSet, error) {
+	raw := strings.TrimSpace(os.Getenv("OFFICE_AUDIT_SINKS_JSON"))
+	if raw == "" {
+		return nil, nil
+	}
+	var configs []auditSinkConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("parse OFFICE_AUDIT_SINKS_JSON: %w", err)
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	set := &auditSinkSet{wake: make(chan struct{}, 1)}
+	seen := make(map[string]bool, len(configs))
+	for i, cfg := range configs {
+		cfg.Name = strings.TrimSpace(cfg.Name)
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("audit sink #%d: name is required", i+1)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("audit sink %q is configured twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+		sink, err := newAuditSink(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("audit sink %q: %w", cfg.Name, err)
+		}
+		set.sinks = append(set.sinks, sink)
+	}
+	return set, nil
+}
+
+func newAuditSink(cfg auditSinkConfig) (auditSink, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case auditSinkTypeSyslog:
+		return newSyslogAuditSink(cfg)
+	case auditSinkTypeWebhook:
+		return newWebhookAuditSink(cfg, nil)
+	default:
+		return nil, fmt.Errorf("unknown type %q", cfg.Type)
+	}
+}
+
+// --- syslog ---
+
+// syslogAuditSink writes RFC 5424 messages. TCP and TLS streams use
+// octet-counting framing (RFC 6587, RFC 5425); UDP sends one message per
+// datagram. DTLS is not supported, so TLS requires TCP.
+type syslogAuditSink struct {
+	name      string
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	facility  int
+	appName   string
+	sdID      string
+	hostname  string
+	conn      net.Conn
+}
+
+func newSyslogAuditSink(cfg auditSinkConfig) (*syslogAuditSink, error) {
+	network := strings.ToLower(strings.TrimSpace(cfg.Network))
+	if network == "" {
+		network = "tcp"
+	}
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("network must be tcp or udp, got %q", cfg.Network)
+	}
+	address := strings.TrimSpace(cfg.Address)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("address must be host:port: %w", err)
+	}
+	sink := &syslogAuditSink{
+		name:     cfg.Name,
+		network:  network,
+		address:  address,
+		facility: auditSyslogDefaultFacility,
+		appName:  syslogHeaderField(cfg.AppName, auditSyslogDefaultAppName, 48),
+		sdID:     syslogHeaderField(cfg.StructuredID, auditSyslogDefaultSDID, 32),
+		hostname: syslogHeaderField(cfg.Hostname, "", 255),
+	}
+	if strings.ContainsAny(sink.sdID, `="]`) {
+		return nil, fmt.Errorf("sd_id %q must not contain '=', '\"' or ']'", sink.sdID)
+	}
+	if cfg.Facility != nil {
+		if *cfg.Facility < 0 || *cfg.Facility > 23 {
+			return nil, fmt.Errorf("facility must be 0..23, got %d", *cfg.Facility)
+		}
+		sink.facility = *cfg.Facility
+	}
+	if sink.hostname == "" {
+		hostname, _ := os.Hostname()
+		sink.hostname = syslogHeaderField(hostname, "-", 255)
+	}
+	if cfg.TLS {
+		if network != "tcp" {
+			return nil, errors.New("tls requires network tcp")
+		}
+		sink.tlsConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: host,
+		}
+		if serverName := strings.TrimSpace(cfg.ServerName); serverName != "" {
+			sink.tlsConfig.ServerName = serverName
+		}
+		if caFile := strings.TrimSpace(cfg.CAFile); caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("read ca_file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("ca_file contains no certificates")
+			}
+			sink.tlsConfig.RootCAs = pool
+		}
+	}
+	return sink, nil
+}
+
+func (s *syslogAuditSink) Name() string { return s.name }
+func (s *syslogAuditSink) Type() string { return auditSinkTypeSyslog }
+
+func (s *syslogAuditSink) Target() string {
+	scheme := s.network
+	if s.tlsConfig != nil {
+		scheme = "tls"
+	}
+	return scheme + "://" + s.address
+}
+
+func (s *syslogAuditSink) Deliver(ctx context.Context, record auditArchiveRecord, payload []byte) error {
+	msg := formatSyslogAuditMessage(s.facility, s.hostname, s.appName, s.sdID, record, payload)
+	if s.network == "udp" && len(msg) > auditSyslogMaxUDPMessage {
+		return fmt.Errorf("%w: message of %d bytes does not fit in a datagram", errAuditSinkPermanent, len(msg))
+	}
+	if s.conn == nil {
+		conn, err := s.dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(auditSinkDeliveryTimeout)
+	}
+	_ = s.conn.SetWriteDeadline(deadline)
+	frame := msg
+	if s.network == "tcp" {
+		frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		// The stream may be half-written; start over on a new connection.
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *syslogAuditSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: auditSinkDeliveryTimeout}
+	if s.tlsConfig != nil {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}
+		return tlsDialer.DialContext(ctx, "tcp", s.address)
+	}
+	return dialer.DialContext(ctx, s.network, s.address)
+}
+
+func (s *syslogAuditSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// formatSyslogAuditMessage renders an RFC 5424 message: the event identity
+// goes into structured data and the full JSON record into MSG.
+func formatSyslogAuditMessage(facility int, hostname, appName, sdID string, record auditArchiveRecord, payload []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s [%s",
+		facility*8+auditSyslogSeverityNotice,
+		record.CreatedAt,
+		hostname,
+		appName,
+		os.Getpid(),
+		syslogHeaderField(record.ActionType, "-", 32),
+		sdID,
+	)
+	for _, param := range [][2]string{
+		{"event_id", strconv.FormatInt(record.ID, 10)},
+		{"entity_type", record.EntityType},
+		{"entity_id", strconv.FormatInt(record.EntityID, 10)},
+		{"actor", record.ActorEmployeeID},
+		{"hash", record.Hash},
+	} {
+		fmt.Fprintf(&b, ` %s="%s"`, param[0], syslogEscapeParam(param[1]))
+	}
+	b.WriteString("] \ufeff")
+	b.Write(payload)
+	return b.Bytes()
+}
+
+// syslogHeaderField keeps the printable US-ASCII a header field allows.
+func syslogHeaderField(value, fallback string, maxLen int) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+		if b.Len() == maxLen {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return fallback
+	}
+	return b.String()
+}
+
+func syslogEscapeParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// --- webhook ---
+
+// webhookAuditSink POSTs each event as JSON. The body is signed with
+// HMAC-SHA256 over "<timestamp>.<body>" and sent as
+// "X-Office-Signature: t=<timestamp>,v1=<hex>", so the receiver can check
+// both origin and freshness.
+type webhookAuditSink struct {
+	name   string
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func newWebhookAuditSink(cfg auditSinkConfig, client *http.Client) (*webhookAuditSink, error) {
+	target, err := url.Parse(strings.TrimSpace(cfg.URL))
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return nil, errors.New("url must be an absolute http(s) URL")
+	}
+	if target.User != nil {
+		return nil, errors.New("url must not contain credentials; use secret")
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: auditSinkDeliveryTimeout}
+	}
+	return &webhookAuditSink{name: cfg.Name, url: target.String(), secret: []byte(cfg.Secret), client: client}, nil
+}
+
+func (s *webhookAuditSink) Name() string   { return s.name }
+func (s *webhookAuditSink) Type() string   { return auditSinkTypeWebhook }
+func (s *webhookAuditSink) Target() string { return s.url }
+func (s *webhookAuditSink) Close() error   { return nil }
+
+func (s *webhookAuditSink) Deliver(ctx context.Context, record auditArchiveRecord, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errAuditSinkPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auditSinkEventIDHeader, strconv.FormatInt(record.ID, 10))
+	req.Header.Set(auditSinkSignatureHeader, signAuditWebhook(s.secret, time.Now(), payload))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("%w: webhook responded %d", errAuditSinkPermanent, resp.StatusCode)
+	}
+}
+
+func signAuditWebhook(secret []byte, now time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// --- delivery ---
+
+func auditSinkBackoff(failures int) time.Duration {
+	backoff := auditSinkMinBackoff
+	for i := 1; i < failures && backoff < auditSinkMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > auditSinkMaxBackoff {
+		backoff = auditSinkMaxBackoff
+	}
+	return backoff
+}
+
+func truncateAuditSinkError(err error) string {
+	msg := err.Error()
+	if len(msg) > auditSinkMaxErrorLength {
+		msg = msg[:auditSinkMaxErrorLength]
+	}
+	return strings.ToValidUTF8(msg, "")
+}
+
+// deliverAuditSinkBatch sends up to auditSinkBatch queued events to sink and
+// reports how many left the queue.
+func deliverAuditSinkBatch(ctx context.Context, db *sql.DB, sink auditSink) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var locked bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_xact_lock($1, hashtext($2))`, auditSinkLockID, sink.Name(),
+	).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	var paused bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM audit_sink_status WHERE sink = $1 AND next_attempt_at > now())`, sink.Name(),
+	).Scan(&paused); err != nil {
+		return 0, err
+	}
+	if paused {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, event_id FROM audit_sink_queue
+		  WHERE sink = $1 AND status = $2
+		  ORDER BY event_id
+		  LIMIT $3`,
+		sink.Name(), auditSinkStatusPending, auditSinkBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var queueIDs, eventIDs []int64
+	for rows.Next() {
+		var queueID, eventID int64
+		if err := rows.Scan(&queueID, &eventID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		queueIDs = append(queueIDs, queueID)
+		eventIDs = append(eventIDs, eventID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(queueIDs) == 0 {
+		return 0, nil
+	}
+	eventRows, err := tx.QueryContext(ctx,
+		`SELECT id, prev_hash, hash, action_type, entity_type, entity_id, entity_name,
+		        actor_employee_id, actor_name, details_json::text, created_at,
+		        redacted_at IS NOT NULL
+		   FROM audit_log_events
+		  WHERE id = ANY($1)`,
+		eventIDs,
+	)
+	if err != nil {
+		return 0, err
+	}
+	loaded, err := scanAuditChainEvents(eventRows)
+	if err != nil {
+		return 0, err
+	}
+	events := make(map[int64]auditChainEvent, len(loaded))
+	for _, e := range loaded {
+		events[e.ID] = e
+	}
+
+	done := 0
+	delivered := 0
+	var deliveryErr error
+	for i, queueID := range queueIDs {
+		e, ok := events[eventIDs[i]]
+		if !ok {
+			// Retention waits for pending events of configured sinks, so
+			// this is a failed event re-queued after it was archived, or the
+			// sink was left out of the configuration while it was archived.
+			log.Printf("audit sink %s: event %d is no longer in the database, skipping", sink.Name(), eventIDs[i])
+			if _, err := tx.ExecContext(ctx, `DELETE FROM audit_sink_queue WHERE id = $1`, queueID); err != nil {
+				return done, err
+			}
+			done++
+			continue
+		}
+		record := newAuditArchiveRecord(e)
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return done, err
+		}
+		deliverCtx, cancel := context.WithTimeout(ctx, auditSinkDeliveryTimeout)
+		err = sink.Deliver(deliverCtx, record, payload)
+		cancel()
+		if err == nil {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM audit_sink_queue WHERE id = $1`, queueID); err != nil {
+				return done, err
+			}
+			done++
+			delivered++
+			continue
+		}
+		status := auditSinkStatusPending
+		if errors.Is(err, errAuditSinkPermanent) {
+			status = auditSinkStatusFailed
+			done++
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE audit_sink_queue SET status = $2, attempts = attempts + 1, last_error = $3 WHERE id = $1`,
+			queueID, status, truncateAuditSinkError(err),
+		); err != nil {
+			return done, err
+		}
+		if status == auditSinkStatusPending {
+			deliveryErr = err
+			break
+		}
+		log.Printf("audit sink %s: event %d rejected: %v", sink.Name(), e.ID, err)
+	}
+
+	if delivered > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_sink_status (sink, delivered, consecutive_failures, last_success_at, next_attempt_at)
+			 VALUES ($1, $2, 0, now(), NULL)
+			 ON CONFLICT (sink) DO UPDATE
+			    SET delivered = audit_sink_status.delivered + EXCLUDED.delivered,
+			        consecutive_failures = 0,
+			        last_success_at = EXCLUDED.last_success_at,
+			        next_attempt_at = NULL`,
+			sink.Name(), delivered,
+		); err != nil {
+			return done, err
+		}
+	}
+	if deliveryErr != nil {
+		var failures int
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE((SELECT consecutive_failures FROM audit_sink_status WHERE sink = $1), 0) + 1`, sink.Name(),
+		).Scan(&failures); err != nil {
+			return done, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_sink_status (sink, consecutive_failures, last_failure_at, last_error, next_attempt_at)
+			 VALUES ($1, $2, now(), $3, $4)
+			 ON CONFLICT (sink) DO UPDATE
+			    SET consecutive_failures = EXCLUDED.consecutive_failures,
+			        last_failure_at = EXCLUDED.last_failure_at,
+			        last_error = EXCLUDED.last_error,
+			        next_attempt_at = EXCLUDED.next_attempt_at`,
+			sink.Name(), failures, truncateAuditSinkError(deliveryErr), time.Now().Add(auditSinkBackoff(failures)),
+		); err != nil {
+			return done, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return done, deliveryErr
+}
+
+// runAuditSinkLoop delivers queued events until ctx is cancelled. It wakes up
+// right after new events are stored and polls for retries in between.
+func (a *app) runAuditSinkLoop(ctx context.Context) {
+	set := a.auditSinks
+	defer func() {
+		for _, sink := range set.sinks {
+			_ = sink.Close()
+		}
+	}()
+	ticker := time.NewTicker(auditSinkPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, sink := range set.sinks {
+			for ctx.Err() == nil {
+				done, err := deliverAuditSinkBatch(ctx, a.db, sink)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("audit sink %s: delivery failed: %v", sink.Name(), err)
+					}
+					break
+				}
+				if done < auditSinkBatch {
+					break
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-set.wake:
+		}
+	}
+}
+
+type auditSinkHealth struct {
+	Name                string     `json:"name"`
+	Type                string     `json:"type"`
+	Target              string     `json:"target"`
+	Healthy             bool       `json:"healthy"`
+	Pending             int64      `json:"pending"`
+	Failed              int64      `json:"failed"`
+	OldestPendingAt     *time.Time `json:"oldest_pending_at,omitempty"`
+	Delivered           int64      `json:"delivered"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	NextAttemptAt       *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+func loadAuditSinkHealth(ctx context.Context, db *sql.DB, sink auditSink) (auditSinkHealth, error) {
+	health := auditSinkHealth{Name: sink.Name(), Type: sink.Type(), Target: sink.Target()}
+	var (
+		oldestPending, lastSuccess, lastFailure, nextAttempt sql.NullTime
+	)
+	if err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FILTER (WHERE status = $2),
+		        COUNT(*) FILTER (WHERE status = $3),
+		        MIN(created_at) FILTER (WHERE status = $2)
+		   FROM audit_sink_queue
+		  WHERE sink = $1`,
+		sink.Name(), auditSinkStatusPending, auditSinkStatusFailed,
+	).Scan(&health.Pending, &health.Failed, &oldestPending); err != nil {
+		return health, err
+	}
+	err := db.QueryRowContext(ctx,
+		`SELECT delivered, consecutive_failures, last_success_at, last_failure_at, last_error, next_attempt_at
+		   FROM audit_sink_status
+		  WHERE sink = $1`,
+		sink.Name(),
+	).Scan(&health.Delivered, &health.ConsecutiveFailures, &lastSuccess, &lastFailure, &health.LastError, &nextAttempt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return health, err
+	}
+	health.OldestPendingAt = nullTimePtr(oldestPending)
+	health.LastSuccessAt = nullTimePtr(lastSuccess)
+	health.LastFailureAt = nullTimePtr(lastFailure)
+	health.NextAttemptAt = nullTimePtr(nextAttempt)
+	health.Healthy = health.ConsecutiveFailures == 0
+	return health, nil
+}
+
+func nullTimePtr(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := value.Time
+	return &t
+}
+
+// handleAdminAuditSinks reports queue depth and delivery state per sink.
+func (a *app) handleAdminAuditSinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+	items := make([]auditSinkHealth, 0)
+	if a.auditSinks != nil {
+		for _, sink := range a.auditSinks.sinks {
+			health, err := loadAuditSinkHealth(r.Context(), a.db, sink)
+			if err != nil {
+				log.Printf("internal error: %v", err)
+				respondError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			items = append(items, health)
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// handleAdminAuditSinksRetry re-queues the failed events of a sink and lifts
+// its backoff. Reading the audit log is not enough: this changes delivery.
+func (a *app) handleAdminAuditSinksRetry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermissionFresh(w, r, a.db, permissionManageAuditSinks) {
+		return
+	}
+	var payload struct {
+		Sink string `json:"sink"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	sink := a.auditSinks.find(strings.TrimSpace(payload.Sink))
+	if sink == nil {
+		respondError(w, http.StatusNotFound, "audit sink not found")
+		return
+	}
+	res, err := a.db.ExecContext(r.Context(),
+		`UPDATE audit_sink_queue SET status = $2 WHERE sink = $1 AND status = $3`,
+		sink.Name(), auditSinkStatusPending, auditSinkStatusFailed,
+	)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	requeued, _ := res.RowsAffected()
+	if _, err := a.db.ExecContext(r.Context(),
+		`UPDATE audit_sink_status SET next_attempt_at = NULL WHERE sink = $1`, sink.Name(),
+	); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.auditSinks.notify()
+	respondJSON(w, http.StatusOK, map[string]any{"sink": sink.Name(), "requeued": requeued})
+}
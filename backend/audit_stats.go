@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminAuditLogsStatsPath    = "/api/admin/logs/stats"
+	auditStatsDefaultWindow    = 30 * 24 * time.Hour
+	auditStatsMaxWindow        = 366 * 24 * time.Hour
+	auditStatsDefaultTopLimit  = 10
+	auditStatsMaxTopLimit      = 100
+	auditStatsDayQueryTemplate = "to_char(created_at AT TIME ZONE $%d, 'YYYY-MM-DD')"
+)
+
+type auditStatsActor struct {
+	ActorEmployeeID string `json:"actor_employee_id"`
+	ActorName       string `json:"actor_name"`
+	Events          int64  `json:"events"`
+}
+
+type auditStatsEntity struct {
+	EntityType string `json:"entity_type"`
+	EntityID   int64  `json:"entity_id"`
+	EntityName string `json:"entity_name"`
+	Events     int64  `json:"events"`
+}
+
+type auditStatsDay struct {
+	Day        string `json:"day"`
+	ActionType string `json:"action_type"`
+	Events     int64  `json:"events"`
+}
+
+type auditStatsResponse struct {
+	From          time.Time          `json:"from"`
+	To            time.Time          `json:"to"`
+	Timezone      string             `json:"timezone"`
+	Total         int64              `json:"total"`
+	TopActors     []auditStatsActor  `json:"top_actors"`
+	TopEntities   []auditStatsEntity `json:"top_entities"`
+	ActionsPerDay []auditStatsDay    `json:"actions_per_day"`
+}
+
+// handleAdminAuditLogsStats aggregates the events matching the list filters
+// for the admin dashboard. Without from/to it covers the last 30 days; days
+// are counted in tz (the default building timezone unless given).
+func (a *app) handleAdminAuditLogsStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionViewAuditLogs) {
+		return
+	}
+	query := r.URL.Query()
+	filter, err := parseAuditLogFilter(query)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now().UTC()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-auditStatsDefaultWindow)
+	}
+	if !filter.From.Before(filter.To) {
+		respondError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	if filter.To.Sub(filter.From) > auditStatsMaxWindow {
+		respondError(w, http.StatusBadRequest, "date range must not exceed 366 days")
+		return
+	}
+	timezone := strings.TrimSpace(query.Get("tz"))
+	if timezone == "" {
+		timezone = defaultBuildingTimezone
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid tz")
+		return
+	}
+	limit := parseAuditLogsInt(query.Get("limit"), auditStatsDefaultTopLimit)
+	if limit <= 0 {
+		limit = auditStatsDefaultTopLimit
+	}
+	if limit > auditStatsMaxTopLimit {
+		limit = auditStatsMaxTopLimit
+	}
+
+	stats, err := a.queryAuditStats(r.Context(), filter, timezone, limit)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
+
+func (a *app) queryAuditStats(ctx context.Context, filter auditLogFilter, timezone string, limit int) (auditStatsResponse, error) {
+	stats := auditStatsResponse{
+		From:          filter.From,
+		To:            filter.To,
+		Timezone:      timezone,
+		TopActors:     []auditStatsActor{},
+		TopEntities:   []auditStatsEntity{},
+		ActionsPerDay: []auditStatsDay{},
+	}
+	where, args := filter.where(nil)
+	where = " WHERE " + where
+
+	if err := a.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log_events`+where, args...).Scan(&stats.Total); err != nil {
+		return stats, err
+	}
+
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT actor_employee_id,
+		        MAX(actor_name),
+		        COUNT(*)
+		   FROM audit_log_events`+where+`
+		  GROUP BY actor_employee_id
+		  ORDER BY COUNT(*) DESC, actor_employee_id
+		  LIMIT `+strconv.Itoa(limit),
+		args...,
+	)
+	if err != nil {
+		return stats, err
+	}
+	for rows.Next() {
+		var item auditStatsActor
+		if err := rows.Scan(&item.ActorEmployeeID, &item.ActorName, &item.Events); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		stats.TopActors = append(stats.TopActors, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	rows, err = a.db.QueryContext(ctx,
+		`SELECT entity_type,
+		        entity_id,
+		        MAX(entity_name),
+		        COUNT(*)
+		   FROM audit_log_events`+where+`
+		  GROUP BY entity_type, entity_id
+		  ORDER BY COUNT(*) DESC, entity_type, entity_id
+		  LIMIT `+strconv.Itoa(limit),
+		args...,
+	)
+	if err != nil {
+		return stats, err
+	}
+	for rows.Next() {
+		var item auditStatsEntity
+		if err := rows.Scan(&item.EntityType, &item.EntityID, &item.EntityName, &item.Events); err != nil {
+			rows.Close()
+			return stats, err
+		}
+		stats.TopEntities = append(stats.TopEntities, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	dayArgs := append(append([]any{}, args...), timezone)
+	day := fmt.Sprintf(auditStatsDayQueryTemplate, len(dayArgs))
+	rows, err = a.db.QueryContext(ctx,
+		`SELECT `+day+`, action_type, COUNT(*)
+		   FROM audit_log_events`+where+`
+		  GROUP BY 1, action_type
+		  ORDER BY 1, action_type`,
+		dayArgs...,
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item auditStatsDay
+		if err := rows.Scan(&item.Day, &item.ActionType, &item.Events); err != nil {
+			return stats, err
+		}
+		stats.ActionsPerDay = append(stats.ActionsPerDay, item)
+	}
+	return stats, rows.Err()
+}
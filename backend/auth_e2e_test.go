@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockIdentityRefusesProduction(t *testing.T) {
+	t.Setenv("OFFICE_DEV_MOCK_IDP", "true")
+	t.Setenv("IDENTITY_PROVIDER", "")
+
+	t.Setenv("OFFICE_ENV", "")
+	if _, err := loadIdentityProviderFromEnv(nil, nil, nil); !errors.Is(err, errMockIdentityInProduction) {
+		t.Fatalf("expected mock to be refused without OFFICE_ENV, got %v", err)
+	}
+	t.Setenv("OFFICE_ENV", "production")
+	if _, err := loadIdentityProviderFromEnv(nil, nil, nil); !errors.Is(err, errMockIdentityInProduction) {
+		t.Fatalf("expected mock to be refused in production, got %v", err)
+	}
+
+	t.Setenv("OFFICE_ENV", "development")
+	provider, err := loadIdentityProviderFromEnv(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("expected mock in development, got %v", err)
+	}
+	if wb, ok := provider.(*wbIdentityProvider); !ok || wb.authBaseURL != mockIdentityBaseURL {
+		t.Fatalf("expected WB provider backed by the mock, got %#v", provider)
+	}
+
+	t.Setenv("IDENTITY_PROVIDER", "oidc")
+	if _, err := loadIdentityProviderFromEnv(nil, nil, nil); err == nil {
+		t.Fatal("expected mock to be refused together with IDENTITY_PROVIDER=oidc")
+	}
+}
+
+func TestMockIdentityServesWBContracts(t *testing.T) {
+	t.Parallel()
+
+	provider, mock, err := newMockIdentityProvider(defaultMockIdentityFixtures())
+	if err != nil {
+		t.Fatalf("newMockIdentityProvider: %v", err)
+	}
+	ctx := context.Background()
+	post := func(path string, body any) map[string]any {
+		t.Helper()
+		raw, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, provider.authURL(path), bytes.NewReader(raw))
+		resp, err := provider.do(req, false)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		return out
+	}
+
+	if out := post("/v2/code/wb-captcha", map[string]any{"phone_number": "70000000009"}); out["result"] == float64(0) {
+		t.Fatalf("expected unknown phone to be rejected, got %v", out)
+	}
+	out := post("/v2/code/wb-captcha", map[string]any{"phone_number": "+7 (999) 000-00-01", "save_push": true})
+	payload, _ := out["payload"].(map[string]any)
+	sticker, _ := payload["sticker"].(string)
+	if out["result"] != float64(0) || sticker == "" {
+		t.Fatalf("unexpected request-code response %v", out)
+	}
+
+	if out := post("/v2/auth", map[string]any{"code": 1, "sticker": sticker}); out["result"] == float64(0) {
+		t.Fatalf("expected wrong code to be rejected, got %v", out)
+	}
+	out = post("/v2/auth", map[string]any{"code": mock.fixtures.Code, "sticker": sticker})
+	payload, _ = out["payload"].(map[string]any)
+	token, _ := payload["access_token"].(string)
+	if out["result"] != float64(0) || token == "" {
+		t.Fatalf("unexpected confirm response %v", out)
+	}
+	if out := post("/v2/auth", map[string]any{"code": mock.fixtures.Code, "sticker": sticker}); out["result"] == float64(0) {
+		t.Fatal("expected a sticker to be single-use")
+	}
+
+	user, err := provider.VerifyToken(ctx, token, nil)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if user.EmployeeID != "DEV-0001" || user.ProfileID != "1001" || user.WbUserID != "9001" {
+		t.Fatalf("unexpected identity %+v", user)
+	}
+	if band, err := provider.fetchWBBand(token, user.ProfileID, ""); err != nil || band != "Office" {
+		t.Fatalf("fetchWBBand = %q, %v", band, err)
+	}
+	if _, status, _ := provider.fetchWBBandRaw(token, "1002", "", nil); status != http.StatusForbidden {
+		t.Fatalf("expected foreign profile to be forbidden, got %d", status)
+	}
+	if _, err := provider.VerifyToken(ctx, "mock-unknown", nil); err == nil {
+		t.Fatal("expected unknown token to be rejected")
+	}
+}
+
+// openTestDatabase connects to the scratch Postgres database in
+// OFFICE_TEST_DATABASE_URL and migrates it, or skips the test when the
+// variable is not set. CI provides one; locally any empty database works.
+func openTestDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := strings.TrimSpace(os.Getenv("OFFICE_TEST_DATABASE_URL"))
+	if dsn == "" {
+		t.Skip("OFFICE_TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// TestAuthFlowEndToEnd drives login, refresh and logout through the real
+// handler chain against the mock identity provider. It needs a scratch
+// Postgres database in OFFICE_TEST_DATABASE_URL.
+func TestAuthFlowEndToEnd(t *testing.T) {
+	db := openTestDatabase(t)
+
+	identity, mock, err := newMockIdentityProvider(defaultMockIdentityFixtures())
+	if err != nil {
+		t.Fatalf("newMockIdentityProvider: %v", err)
+	}
+	a := &app{
+		db:                 db,
+		identity:           identity,
+		officeTokenKeys:    newLegacyHS256KeyManager([]byte("e2e-office-jwt-secret-0123456789")),
+		refreshTokenPepper: []byte("e2e-refresh-pepper"),
+		authCookieSameSite: http.SameSiteLaxMode,
+	}
+	srv := httptest.NewServer(a.routes(t.TempDir(), time.Second))
+	t.Cleanup(srv.Close)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	call := func(method, path string, body any, header http.Header) (int, map[string]any) {
+		t.Helper()
+		var reader *bytes.Reader
+		if body != nil {
+			raw, _ := json.Marshal(body)
+			reader = bytes.NewReader(raw)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		req, _ := http.NewRequest(method, srv.URL+path, reader)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", srv.URL)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		for _, c := range jar.Cookies(req.URL) {
+			if c.Name == csrfTokenCookieName {
+				req.Header.Set("X-CSRF-Token", c.Value)
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	// Login: request code, confirm it, exchange the provider token.
+	status, out := call(http.MethodPost, "/api/v2/auth/code/wb-captcha", map[string]any{"phone_number": "79990000001", "save_push": true}, nil)
+	payload, _ := out["payload"].(map[string]any)
+	if status != http.StatusOK || out["result"] != float64(0) {
+		t.Fatalf("request code: %d %v", status, out)
+	}
+	status, out = call(http.MethodPost, "/api/v2/auth/confirm", map[string]any{"code": mock.fixtures.Code, "sticker": payload["sticker"]}, nil)
+	payload, _ = out["payload"].(map[string]any)
+	token, _ := payload["access_token"].(string)
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("confirm: %d %v", status, out)
+	}
+	status, out = call(http.MethodPost, "/api/auth/office-token", nil, http.Header{"Authorization": {"Bearer " + token}})
+	if status != http.StatusOK {
+		t.Fatalf("office-token: %d %v", status, out)
+	}
+	if status, out = call(http.MethodGet, "/api/auth/session", nil, nil); status != http.StatusOK {
+		t.Fatalf("session after login: %d %v", status, out)
+	}
+	session, _ := out["session"].(map[string]any)
+	if session["employee_id"] != "DEV-0001" {
+		t.Fatalf("unexpected session %v", out)
+	}
+	if status, _ := call(http.MethodPost, "/api/auth/office-token", nil, http.Header{"Authorization": {"Bearer mock-forged"}}); status != http.StatusUnauthorized {
+		t.Fatalf("expected forged provider token to be rejected, got %d", status)
+	}
+
+	// Refresh rotates the token pair; replaying the old refresh token
+	// revokes the whole family.
+	refreshURL, _ := url.Parse(srv.URL + "/api/auth/refresh")
+	var oldRefresh string
+	for _, c := range jar.Cookies(refreshURL) {
+		if c.Name == refreshTokenCookieName {
+			oldRefresh = c.Value
+		}
+	}
+	if oldRefresh == "" {
+		t.Fatal("expected refresh cookie after login")
+	}
+	if status, out = call(http.MethodPost, "/api/auth/refresh", nil, nil); status != http.StatusOK {
+		t.Fatalf("refresh: %d %v", status, out)
+	}
+	if status, _ = call(http.MethodGet, "/api/auth/session", nil, nil); status != http.StatusOK {
+		t.Fatalf("session after refresh: %d", status)
+	}
+	replayReq, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/auth/refresh", strings.NewReader(`{"office_refresh_token":"`+oldRefresh+`"}`))
+	replayReq.Header.Set("Content-Type", "application/json")
+	replayResp, err := http.DefaultClient.Do(replayReq)
+	if err != nil {
+		t.Fatalf("replay refresh: %v", err)
+	}
+	replayResp.Body.Close()
+	if replayResp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected replayed refresh token to be rejected, got %d", replayResp.StatusCode)
+	}
+
+	// Log in again, then log out: the refresh token must stop working.
+	status, out = call(http.MethodPost, "/api/auth/office-token", nil, http.Header{"Authorization": {"Bearer " + token}})
+	if status != http.StatusOK {
+		t.Fatalf("second office-token: %d %v", status, out)
+	}
+	if status, out = call(http.MethodPost, "/api/auth/logout", nil, nil); status != http.StatusOK {
+		t.Fatalf("logout: %d %v", status, out)
+	}
+	if status, _ = call(http.MethodGet, "/api/auth/session", nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected no session after logout, got %d", status)
+	}
+	if status, _ = call(http.MethodPost, "/api/auth/refresh", map[string]any{}, nil); status == http.StatusOK {
+		t.Fatal("expected refresh to fail after logout")
+	}
+}
@@ -140,8 +140,11 @@ type sessionResponse struct {
 	UserName         string                 `json:"user_name,omitempty"`
 	Role             int                    `json:"role"`
 	Responsibilities *TokenResponsibilities `json:"responsibilities,omitempty"`
+	Permissions      []rolePermissionGrant  `json:"permissions"`
 	AccessExp        int64                  `json:"access_exp"`
 	RefreshExp       int64                  `json:"refresh_exp,omitempty"`
+	Impersonated     bool                   `json:"impersonated"`
+	Impersonation    *sessionImpersonation  `json:"impersonation,omitempty"`
 }
 
 // isSecureContext returns true when the request arrived over TLS (direct) or
@@ -195,27 +198,16 @@ func (a *app) cookieDomainForRequest(r *http.Request) string {
 
 // setTokenCookies writes both access and refresh tokens as HttpOnly cookies.
 func (a *app) setTokenCookies(w http.ResponseWriter, r *http.Request, accessToken, refreshToken string, accessExp, refreshExp int64) {
-	secure := isSecureContext(r)
-	cookieDomain := a.cookieDomainForRequest(r)
 	now := time.Now().UTC().Unix()
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     accessTokenCookieName,
-		Value:    accessToken,
-		Domain:   cookieDomain,
-		Path:     "/api/",
-		HttpOnly: true,
-		Secure:   secure,
-		SameSite: a.authCookieSameSite,
-		MaxAge:   int(accessExp - now),
-	})
+	a.setAccessTokenCookie(w, r, accessToken, accessExp)
 	http.SetCookie(w, &http.Cookie{
 		Name:     refreshTokenCookieName,
 		Value:    refreshToken,
-		Domain:   cookieDomain,
+		Domain:   a.cookieDomainForRequest(r),
 		Path:     "/api/auth/",
 		HttpOnly: true,
-		Secure:   secure,
+		Secure:   isSecureContext(r),
 		SameSite: a.authCookieSameSite,
 		MaxAge:   int(refreshExp - now),
 	})
@@ -224,6 +216,21 @@ func (a *app) setTokenCookies(w http.ResponseWriter, r *http.Request, accessToke
 	a.setCSRFCookie(w, r, int(refreshExp-now))
 }
 
+// setAccessTokenCookie writes the access token alone, leaving the refresh
+// cookie untouched.
+func (a *app) setAccessTokenCookie(w http.ResponseWriter, r *http.Request, accessToken string, accessExp int64) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookieName,
+		Value:    accessToken,
+		Domain:   a.cookieDomainForRequest(r),
+		Path:     "/api/",
+		HttpOnly: true,
+		Secure:   isSecureContext(r),
+		SameSite: a.authCookieSameSite,
+		MaxAge:   int(accessExp - time.Now().UTC().Unix()),
+	})
+}
+
 func newCSRFToken() (string, error) {
 	buf := make([]byte, 32)
 	if _, err := rand.Read(buf); err != nil {
@@ -315,6 +322,10 @@ func (a *app) clearTokenCookies(w http.ResponseWriter, r *http.Request) {
 
 // handleAuthUserInfo проксирует запрос к team.wb.ru/api/v1/user/info
 func (a *app) handleAuthUserInfo(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.requireWBIdentity(w)
+	if !ok {
+		return
+	}
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		log.Printf("handleAuthUserInfo: Authorization header is missing")
@@ -336,21 +347,12 @@ func (a *app) handleAuthUserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodGet, "https://team.wb.ru/api/v1/user/info", nil)
+	req, err := http.NewRequest(http.MethodGet, provider.teamURL("/api/v1/user/info"), nil)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create request")
 		return
 	}
-
-	req.Header.Set("accept", "application/json, text/plain, */*")
-	req.Header.Set("authorization", "Bearer "+token)
-	req.Header.Set("cache-control", "no-cache")
-	req.Header.Set("pragma", "no-cache")
-	req.Header.Set("referer", "https://team.wb.ru/account")
-	req.Header.Set("sec-fetch-dest", "empty")
-	req.Header.Set("sec-fetch-mode", "cors")
-	req.Header.Set("sec-fetch-site", "same-origin")
-	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
+	provider.setTeamHeaders(req, token)
 
 	cookie, source := collectCookies(r)
 	if cookie != "" {
@@ -360,7 +362,7 @@ func (a *app) handleAuthUserInfo(w http.ResponseWriter, r *http.Request) {
 		log.Printf("handleAuthUserInfo: No cookies found")
 	}
 
-	resp, err := a.doUpstreamRequest(req, true)
+	resp, err := provider.do(req, true)
 	if err != nil {
 		log.Printf("handleAuthUserInfo: Error making request to team.wb.ru: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to make request")
@@ -396,7 +398,7 @@ func (a *app) handleAuthUserInfo(w http.ResponseWriter, r *http.Request) {
 				wbBand = cached
 			} else if profileID != 0 {
 				profileIDStr := fmt.Sprintf("%d", profileID)
-				band, err := a.fetchWBBandFromTeam(token, profileIDStr, cookie)
+				band, err := provider.fetchWBBand(token, profileIDStr, cookie)
 				if err == nil && band != "" {
 					wbBand = band
 					if err := a.upsertUserWBBand(wbUserID, userName, band); err != nil {
@@ -444,6 +446,10 @@ func (a *app) handleAuthUserInfo(w http.ResponseWriter, r *http.Request) {
 // handleAuthUserWbBand проксирует запрос к team.wb.ru/api/v1/user/profile/{id}/wbband
 // и сохраняет wb_band в таблицу users по ключу пользователя.
 func (a *app) handleAuthUserWbBand(w http.ResponseWriter, r *http.Request) {
+	provider, ok := a.requireWBIdentity(w)
+	if !ok {
+		return
+	}
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		log.Printf("handleAuthUserWbBand: Authorization header is missing")
@@ -467,7 +473,7 @@ func (a *app) handleAuthUserWbBand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, status, err := a.fetchWBBandRaw(token, profileID, "", r)
+	body, status, err := provider.fetchWBBandRaw(token, profileID, "", r)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to make request")
 		return
@@ -502,8 +508,8 @@ func (a *app) handleAuthUserWbBand(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(body)
 }
 
-func (a *app) fetchWBBandFromTeam(token, profileID, cookie string) (string, error) {
-	body, _, err := a.fetchWBBandRaw(token, profileID, cookie, nil)
+func (p *wbIdentityProvider) fetchWBBand(token, profileID, cookie string) (string, error) {
+	body, _, err := p.fetchWBBandRaw(token, profileID, cookie, nil)
 	if err != nil {
 		return "", err
 	}
@@ -519,26 +525,17 @@ func (a *app) fetchWBBandFromTeam(token, profileID, cookie string) (string, erro
 	return strings.TrimSpace(parsed.Data.WBBand), nil
 }
 
-func (a *app) fetchWBBandRaw(token, profileID, cookie string, sourceReq *http.Request) ([]byte, int, error) {
+func (p *wbIdentityProvider) fetchWBBandRaw(token, profileID, cookie string, sourceReq *http.Request) ([]byte, int, error) {
 	// Validate profileID is strictly numeric to prevent SSRF via path traversal.
 	if !profileIDPattern.MatchString(profileID) {
 		return nil, http.StatusBadRequest, fmt.Errorf("invalid profile ID: %q", profileID)
 	}
-	url := fmt.Sprintf("https://team.wb.ru/api/v1/user/profile/%s/wbband", profileID)
+	url := p.teamURL(fmt.Sprintf("/api/v1/user/profile/%s/wbband", profileID))
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
 	}
-
-	req.Header.Set("accept", "application/json, text/plain, */*")
-	req.Header.Set("authorization", "Bearer "+token)
-	req.Header.Set("cache-control", "no-cache")
-	req.Header.Set("pragma", "no-cache")
-	req.Header.Set("referer", "https://team.wb.ru/account")
-	req.Header.Set("sec-fetch-dest", "empty")
-	req.Header.Set("sec-fetch-mode", "cors")
-	req.Header.Set("sec-fetch-site", "same-origin")
-	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
+	p.setTeamHeaders(req, token)
 
 	if sourceReq != nil {
 		cookie, source := collectCookies(sourceReq)
@@ -552,7 +549,7 @@ func (a *app) fetchWBBandRaw(token, profileID, cookie string, sourceReq *http.Re
 		req.Header.Set("Cookie", cookie)
 	}
 
-	resp, err := a.doUpstreamRequest(req, true)
+	resp, err := p.do(req, true)
 	if err != nil {
 		return nil, http.StatusInternalServerError, err
 	}
@@ -623,8 +620,11 @@ func firstNonEmptyString(values ...string) string {
 // handleAuthRequestCode проксирует запрос к auth-hrtech.wb.ru/v2/code/wb-captcha
 // Route: POST /api/v2/auth/code/wb-captcha
 func (a *app) handleAuthRequestCode(w http.ResponseWriter, r *http.Request) {
-	if a.authRateLimiter != nil && !a.authRateLimiter.allow(clientIP(r)) {
-		respondError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+	provider, ok := a.requireWBIdentity(w)
+	if !ok {
+		return
+	}
+	if !a.allowAuthAttempt(w, r) {
 		return
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
@@ -635,7 +635,7 @@ func (a *app) handleAuthRequestCode(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	req, err := http.NewRequest(http.MethodPost, "https://auth-hrtech.wb.ru/v2/code/wb-captcha", strings.NewReader(string(body)))
+	req, err := http.NewRequest(http.MethodPost, provider.authURL("/v2/code/wb-captcha"), strings.NewReader(string(body)))
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create request")
 		return
@@ -647,12 +647,9 @@ func (a *app) handleAuthRequestCode(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Origin", "https://team.wb.ru")
-	req.Header.Set("Referer", "https://team.wb.ru/")
+	provider.setAuthHeaders(req)
 
-	resp, err := a.doUpstreamRequest(req, false)
+	resp, err := provider.do(req, false)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to make request")
 		return
@@ -677,8 +674,11 @@ func (a *app) handleAuthRequestCode(w http.ResponseWriter, r *http.Request) {
 // handleAuthConfirmCode проксирует запрос к auth-hrtech.wb.ru/v2/auth
 // Route: POST /api/v2/auth/confirm
 func (a *app) handleAuthConfirmCode(w http.ResponseWriter, r *http.Request) {
-	if a.authRateLimiter != nil && !a.authRateLimiter.allow(clientIP(r)) {
-		respondError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+	provider, ok := a.requireWBIdentity(w)
+	if !ok {
+		return
+	}
+	if !a.allowAuthAttempt(w, r) {
 		return
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
@@ -694,7 +694,7 @@ func (a *app) handleAuthConfirmCode(w http.ResponseWriter, r *http.Request) {
 		cookie = r.Header.Get("Cookie")
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "https://auth-hrtech.wb.ru/v2/auth", strings.NewReader(string(body)))
+	req, err := http.NewRequest(http.MethodPost, provider.authURL("/v2/auth"), strings.NewReader(string(body)))
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create request")
 		return
@@ -706,16 +706,13 @@ func (a *app) handleAuthConfirmCode(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Origin", "https://team.wb.ru")
-	req.Header.Set("Referer", "https://team.wb.ru/")
+	provider.setAuthHeaders(req)
 
 	if cookie != "" {
 		req.Header.Set("Cookie", cookie)
 	}
 
-	resp, err := a.doUpstreamRequest(req, false)
+	resp, err := provider.do(req, false)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to make request")
 		return
@@ -737,89 +734,6 @@ func (a *app) handleAuthConfirmCode(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(respBody)
 }
 
-// verifiedUserInfo holds identity fields returned by team.wb.ru after
-// successful token validation.  All fields are populated from the upstream
-// response, NOT from locally-decoded JWT claims.
-type verifiedUserInfo struct {
-	WbUserID   string
-	UserName   string
-	EmployeeID string
-}
-
-// verifyExternalToken validates the Authorization bearer token by calling
-// team.wb.ru/api/v1/user/info.  If team.wb.ru responds with valid user data,
-// we trust the identity.  If it responds with an error or the request fails,
-// we reject the token.
-//
-// This is the ONLY way to confirm that the bearer token is legitimate — we
-// cannot verify its signature locally because the signing key belongs to
-// the external auth service.
-func (a *app) verifyExternalToken(token string, originalReq *http.Request) (*verifiedUserInfo, error) {
-	req, err := http.NewRequest(http.MethodGet, "https://team.wb.ru/api/v1/user/info", nil)
-	if err != nil {
-		return nil, fmt.Errorf("verifyExternalToken: create request: %w", err)
-	}
-
-	req.Header.Set("accept", "application/json, text/plain, */*")
-	req.Header.Set("authorization", "Bearer "+token)
-	req.Header.Set("cache-control", "no-cache")
-	req.Header.Set("pragma", "no-cache")
-	req.Header.Set("referer", "https://team.wb.ru/account")
-	req.Header.Set("sec-fetch-dest", "empty")
-	req.Header.Set("sec-fetch-mode", "cors")
-	req.Header.Set("sec-fetch-site", "same-origin")
-	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
-
-	if originalReq != nil {
-		cookie, _ := collectCookies(originalReq)
-		if cookie != "" {
-			req.Header.Set("Cookie", cookie)
-		}
-	}
-
-	resp, err := a.doUpstreamRequest(req, true)
-	if err != nil {
-		return nil, fmt.Errorf("verifyExternalToken: upstream request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("verifyExternalToken: upstream returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("verifyExternalToken: read response: %w", err)
-	}
-
-	var result struct {
-		Status int `json:"status"`
-		Data   struct {
-			WbUserID   any    `json:"wbUserID"`
-			EmployeeID any    `json:"employeeID"`
-			FullName   string `json:"fullName"`
-			Name       string `json:"name"`
-		} `json:"data"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("verifyExternalToken: parse response: %w", err)
-	}
-
-	wbUserID := normalizeIDString(result.Data.WbUserID)
-	employeeID := normalizeIDString(result.Data.EmployeeID)
-	userName := firstNonEmptyString(result.Data.FullName, result.Data.Name)
-
-	if wbUserID == "" && employeeID == "" {
-		return nil, fmt.Errorf("verifyExternalToken: upstream returned empty identity")
-	}
-
-	return &verifiedUserInfo{
-		WbUserID:   wbUserID,
-		UserName:   userName,
-		EmployeeID: employeeID,
-	}, nil
-}
-
 func extractBearerToken(authHeader string) string {
 	token := authHeader
 	if strings.HasPrefix(authHeader, "Bearer ") {
@@ -831,10 +745,10 @@ func extractBearerToken(authHeader string) string {
 }
 
 // handleAuthOfficeToken issues both access and refresh tokens.
-// The endpoint requires a valid Authorization bearer token (the caller must
-// already be authenticated via team.wb.ru). The token is validated by calling
-// the upstream team.wb.ru/api/v1/user/info endpoint — we never trust JWT
-// claims without server-side verification.
+// The endpoint requires a valid Authorization bearer token issued by the
+// configured identity provider. The provider verifies the token server-side
+// (the WB provider calls team.wb.ru/api/v1/user/info, the OIDC provider
+// checks the signature against the JWKS) — we never trust unverified claims.
 //
 // POST /api/auth/office-token
 // Response: { "office_access_token": "<jwt>", "office_refresh_token": "<jwt>" }
@@ -847,10 +761,13 @@ func (a *app) handleAuthOfficeToken(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusServiceUnavailable, "Office token signing is not configured")
 		return
 	}
+	if a.identity == nil {
+		respondError(w, http.StatusServiceUnavailable, "identity provider is not configured")
+		return
+	}
 
 	// Rate-limit office-token issuance the same way we rate-limit login endpoints.
-	if a.authRateLimiter != nil && !a.authRateLimiter.allow(clientIP(r)) {
-		respondError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+	if !a.allowAuthAttempt(w, r) {
 		return
 	}
 
@@ -865,33 +782,74 @@ func (a *app) handleAuthOfficeToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ── CRITICAL: Verify the external token by calling team.wb.ru ──
-	// We MUST NOT trust locally-parsed JWT claims because the external
-	// token's signing key is unknown to us.  Instead we call team.wb.ru
-	// which validates the token and returns the real user identity.
-	verifiedUser, err := a.verifyExternalToken(token, r)
+	// ── CRITICAL: Verify the external token with the identity provider ──
+	// We MUST NOT trust locally-parsed JWT claims; the provider validates
+	// the token and returns the real user identity.
+	verifiedUser, err := a.identity.VerifyToken(r.Context(), token, r)
 	if err != nil {
 		log.Printf("handleAuthOfficeToken: external token verification failed: %v", err)
 		respondError(w, http.StatusUnauthorized, "Authorization token is invalid or expired")
 		return
 	}
 
-	employeeID := strings.TrimSpace(verifiedUser.EmployeeID)
+	employeeID, err := a.resolveOfficeEmployeeID(r.Context(), verifiedUser)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	session, err := a.issueOfficeSession(w, r, employeeID, verifiedUser.UserName)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"session": session})
+}
+
+var (
+	errOfficeIdentityMissing  = errors.New("Unable to identify user from token")
+	errOfficeEmployeeMissing  = errors.New("employee_id is required for office token")
+	errOfficeEmployeeInactive = errors.New("Employee account is deactivated")
+)
+
+// resolveOfficeEmployeeID returns the employee a verified identity belongs
+// to: the employee id reported by the provider or, failing that, the one
+// stored for the provider subject. Employees deactivated through SCIM are
+// refused.
+func (a *app) resolveOfficeEmployeeID(ctx context.Context, user *verifiedUserInfo) (string, error) {
+	employeeID := strings.TrimSpace(user.EmployeeID)
+	if isServiceAccountPrincipal(employeeID) {
+		return "", errOfficeIdentityMissing
+	}
 	if employeeID == "" {
-		wbUserID := strings.TrimSpace(verifiedUser.WbUserID)
+		wbUserID := strings.TrimSpace(user.WbUserID)
 		if wbUserID == "" {
-			respondError(w, http.StatusUnauthorized, "Unable to identify user from token")
-			return
+			return "", errOfficeIdentityMissing
 		}
-		dbEmployeeID, err := getEmployeeIDByWbUserID(r.Context(), a.db, wbUserID)
-		if err == nil && dbEmployeeID != "" {
-			employeeID = dbEmployeeID
-		} else {
-			respondError(w, http.StatusUnauthorized, "employee_id is required for office token")
-			return
+		dbEmployeeID, err := getEmployeeIDByWbUserID(ctx, a.db, wbUserID)
+		if err != nil || dbEmployeeID == "" {
+			return "", errOfficeEmployeeMissing
 		}
+		employeeID = dbEmployeeID
+	}
+	deactivated, err := isEmployeeDeactivated(ctx, a.db, employeeID)
+	if err != nil {
+		log.Printf("resolveOfficeEmployeeID: failed to check status of %s: %v", employeeID, err)
+		return "", errOfficeEmployeeMissing
+	}
+	if deactivated {
+		return "", errOfficeEmployeeInactive
 	}
+	return employeeID, nil
+}
 
+// issueOfficeSession signs a new access/refresh token pair for employeeID,
+// stores the refresh token and sets both as cookies. Returned errors carry a
+// message that is safe to show to the client.
+func (a *app) issueOfficeSession(w http.ResponseWriter, r *http.Request, employeeID, userName string) (sessionResponse, error) {
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanSign() {
+		return sessionResponse{}, errors.New("Office token signing is not configured")
+	}
 	roleID, err := getUserRoleByWbUserID(r.Context(), a.db, employeeID)
 	if err != nil {
 		// On transient DB error, try the previous access token cookie to
@@ -902,27 +860,25 @@ func (a *app) handleAuthOfficeToken(w http.ResponseWriter, r *http.Request) {
 				roleID = oldAT.Role
 			}
 		}
-		log.Printf("handleAuthOfficeToken: DB role lookup failed for %s: %v; using fallback role %d", employeeID, err, roleID)
+		log.Printf("issueOfficeSession: DB role lookup failed for %s: %v; using fallback role %d", employeeID, err, roleID)
 	}
 
 	// Create access token
 	accessClaims := OfficeAccessTokenClaims{
 		EmployeeID:       employeeID,
-		UserName:         strings.TrimSpace(verifiedUser.UserName),
+		UserName:         strings.TrimSpace(userName),
 		Role:             roleID,
 		Responsibilities: a.loadResponsibilitiesForToken(employeeID),
 	}
 	accessToken, err := SignOfficeAccessTokenWithKeyManager(accessClaims, a.officeTokenKeys)
 	if err != nil {
-		log.Printf("handleAuthOfficeToken: failed to sign access token: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to issue office access token")
-		return
+		log.Printf("issueOfficeSession: failed to sign access token: %v", err)
+		return sessionResponse{}, errors.New("Failed to issue office access token")
 	}
 	parsedAccessClaims, err := VerifyOfficeAccessTokenWithKeyManager(accessToken, a.officeTokenKeys)
 	if err != nil {
-		log.Printf("handleAuthOfficeToken: failed to parse access token: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to process access token")
-		return
+		log.Printf("issueOfficeSession: failed to parse access token: %v", err)
+		return sessionResponse{}, errors.New("Failed to process access token")
 	}
 
 	// Create refresh token (new token family).
@@ -932,17 +888,15 @@ func (a *app) handleAuthOfficeToken(w http.ResponseWriter, r *http.Request) {
 	}
 	refreshToken, err := SignOfficeRefreshTokenWithKeyManager(refreshClaims, a.officeTokenKeys)
 	if err != nil {
-		log.Printf("handleAuthOfficeToken: failed to sign refresh token: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to issue office refresh token")
-		return
+		log.Printf("issueOfficeSession: failed to sign refresh token: %v", err)
+		return sessionResponse{}, errors.New("Failed to issue office refresh token")
 	}
 
 	// Parse the refresh token to get token_id, family_id and expiration.
 	parsedRefreshClaims, err := VerifyOfficeRefreshTokenWithKeyManager(refreshToken, a.officeTokenKeys)
 	if err != nil {
-		log.Printf("handleAuthOfficeToken: failed to parse refresh token: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to process refresh token")
-		return
+		log.Printf("issueOfficeSession: failed to parse refresh token: %v", err)
+		return sessionResponse{}, errors.New("Failed to process refresh token")
 	}
 
 	// Hash token_id with application pepper — never store raw token_id in DB.
@@ -962,25 +916,23 @@ func (a *app) handleAuthOfficeToken(w http.ResponseWriter, r *http.Request) {
 		truncateUA(r.UserAgent()),
 		parsedRefreshClaims.Exp,
 	); err != nil {
-		log.Printf("handleAuthOfficeToken: failed to store refresh token: %v", err)
-		respondError(w, http.StatusInternalServerError, "Failed to store refresh token")
-		return
+		log.Printf("issueOfficeSession: failed to store refresh token: %v", err)
+		return sessionResponse{}, errors.New("Failed to store refresh token")
 	}
 
 	// Set tokens as HttpOnly cookies (XSS-safe: JS cannot read them).
 	a.setTokenCookies(w, r, accessToken, refreshToken,
 		parsedAccessClaims.Exp, parsedRefreshClaims.Exp)
 
-	respondJSON(w, http.StatusOK, map[string]any{
-		"session": sessionResponse{
-			EmployeeID:       parsedAccessClaims.EmployeeID,
-			UserName:         parsedAccessClaims.UserName,
-			Role:             parsedAccessClaims.Role,
-			Responsibilities: parsedAccessClaims.Responsibilities,
-			AccessExp:        parsedAccessClaims.Exp,
-			RefreshExp:       parsedRefreshClaims.Exp,
-		},
-	})
+	return sessionResponse{
+		EmployeeID:       parsedAccessClaims.EmployeeID,
+		UserName:         parsedAccessClaims.UserName,
+		Role:             parsedAccessClaims.Role,
+		Responsibilities: parsedAccessClaims.Responsibilities,
+		Permissions:      roleDefinitions.grantsFor(parsedAccessClaims.Role),
+		AccessExp:        parsedAccessClaims.Exp,
+		RefreshExp:       parsedRefreshClaims.Exp,
+	}, nil
 }
 
 // handleAuthRefreshToken exchanges a valid refresh token for a new token pair
@@ -1096,14 +1048,19 @@ func (a *app) handleAuthRefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	// 4a. (consumed atomically in Step 2 above)
 
+	// Refreshing during impersonation returns the admin to their own
+	// session, since the refresh token is theirs.
+	a.endImpersonationFromRequest(r, "refresh")
+
 	// 4b. Resolve current role & user name from DB.
 	roleID, err := getUserRoleByWbUserID(r.Context(), a.db, refreshClaims.EmployeeID)
 	if err != nil {
 		// Transient DB error — fall back to the role from the previous access
 		// token (if available) to avoid silently downgrading admin → employee.
+		// An impersonation token carries someone else's role and is skipped.
 		roleID = roleEmployee
 		if ac, cookieErr := r.Cookie(accessTokenCookieName); cookieErr == nil && ac.Value != "" {
-			if oldAT, verifyErr := VerifyOfficeAccessTokenWithKeyManager(ac.Value, a.officeTokenKeys); verifyErr == nil && isValidRole(oldAT.Role) {
+			if oldAT, verifyErr := VerifyOfficeAccessTokenWithKeyManager(ac.Value, a.officeTokenKeys); verifyErr == nil && oldAT.Impersonation == nil && oldAT.EmployeeID == refreshClaims.EmployeeID && isValidRole(oldAT.Role) {
 				roleID = oldAT.Role
 			}
 		}
@@ -1179,6 +1136,7 @@ func (a *app) handleAuthRefreshToken(w http.ResponseWriter, r *http.Request) {
 			UserName:         parsedAccessClaims.UserName,
 			Role:             parsedAccessClaims.Role,
 			Responsibilities: parsedAccessClaims.Responsibilities,
+			Permissions:      roleDefinitions.grantsFor(parsedAccessClaims.Role),
 			AccessExp:        parsedAccessClaims.Exp,
 			RefreshExp:       parsedNewRefresh.Exp,
 		},
@@ -1242,6 +1200,18 @@ func (a *app) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusUnauthorized, "Session expired")
 		return
 	}
+	if atClaims.Impersonation != nil {
+		// A stopped or expired impersonation reads as an expired session so
+		// that the client refreshes back to the admin's own token.
+		open, err := a.impersonationSessionOpen(r.Context(), atClaims)
+		if err != nil {
+			log.Printf("handleAuthSession: impersonation lookup failed for %d: %v", atClaims.Impersonation.ID, err)
+		}
+		if !open {
+			respondError(w, http.StatusUnauthorized, "Session expired")
+			return
+		}
+	}
 	roleID, err := getUserRoleByWbUserID(r.Context(), a.db, atClaims.EmployeeID)
 	if err != nil {
 		// Fallback to the role baked into the cryptographically verified JWT
@@ -1279,8 +1249,11 @@ func (a *app) handleAuthSession(w http.ResponseWriter, r *http.Request) {
 			UserName:         atClaims.UserName,
 			Role:             roleID,
 			Responsibilities: atClaims.Responsibilities,
+			Permissions:      roleDefinitions.grantsFor(roleID),
 			AccessExp:        atClaims.Exp,
 			RefreshExp:       refreshExp,
+			Impersonated:     atClaims.Impersonation != nil,
+			Impersonation:    sessionImpersonationFromClaims(atClaims),
 		},
 	})
 }
@@ -1307,6 +1280,7 @@ func (a *app) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	a.endImpersonationFromRequest(r, "logout")
 	a.clearTokenCookies(w, r)
 	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
@@ -461,6 +461,7 @@ func (a *app) handleListMyBookings(w http.ResponseWriter, r *http.Request) {
 		   JOIN office_buildings ob ON ob.id = f.building_id
 		   LEFT JOIN users u ON u.employee_id = b.applier_employee_id
 		  WHERE b.applier_employee_id = $1 AND b.date >= CURRENT_DATE::text AND b.cancelled_at IS NULL
+		    AND s.deleted_at IS NULL
 		  ORDER BY b.date DESC, b.created_at DESC`,
 		employeeID,
 	)
@@ -560,7 +561,7 @@ func (a *app) handleListSpaceBookings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !a.ensureCanManageCoworking(w, r, spaceIDValue) {
+	if !a.ensureCanManageCoworkingBookings(w, r, spaceIDValue) {
 		return
 	}
 
@@ -631,7 +632,7 @@ func (a *app) handleCancelAllSpaceBookings(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if !a.ensureCanManageCoworking(w, r, spaceIDValue) {
+	if !a.ensureCanManageCoworkingBookings(w, r, spaceIDValue) {
 		return
 	}
 
@@ -824,7 +825,11 @@ func (a *app) handleCreateMultipleBookings(w http.ResponseWriter, r *http.Reques
 }
 
 func (a *app) ensureWorkplaceExists(workplaceID int64) error {
-	row := a.db.QueryRow(`SELECT id FROM workplaces WHERE id = $1`, workplaceID)
+	row := a.db.QueryRow(
+		`SELECT w.id FROM workplaces w JOIN coworkings c ON c.id = w.coworking_id
+		  WHERE w.id = $1 AND c.deleted_at IS NULL`,
+		workplaceID,
+	)
 	var id int64
 	if err := row.Scan(&id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -840,7 +845,7 @@ func (a *app) canManageCoworkingByWorkplaceID(r *http.Request, workplaceID int64
 	if err != nil {
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageBookings) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -850,24 +855,27 @@ func (a *app) canManageCoworkingByWorkplaceID(r *http.Request, workplaceID int64
 	eid := strings.TrimSpace(employeeID)
 
 	// Single query instead of 7 separate N+1 queries:
-	var buildingResp, floorResp, coworkingResp string
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
 		        COALESCE(TRIM(f.responsible_employee_id), ''),
-		        COALESCE(TRIM(c.responsible_employee_id), '')
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(c.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f", "c")+`
 		   FROM workplaces w
 		   JOIN coworkings c ON c.id = w.coworking_id
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE w.id = $1`,
-		workplaceID,
-	).Scan(&buildingResp, &floorResp, &coworkingResp)
+		workplaceID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &coworkingResp, &delegatedResp)
 	if err != nil {
 		return false
 	}
-	return (buildingResp != "" && buildingResp == eid) ||
-		(floorResp != "" && floorResp == eid) ||
-		(coworkingResp != "" && coworkingResp == eid)
+	return canRequestActInBuilding(r, role, permissionManageBookings, eid, buildingID, buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp)
 }
 
 func (a *app) resolveBookingTargetLabel(ctx context.Context, employeeID string) string {
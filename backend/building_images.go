@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	buildingImageJPEGQuality = 85
+	buildingImageWebPQuality = 80
+	// Decoded images are held in memory as RGBA; cap the pixel count so a small
+	// but highly compressed upload cannot exhaust memory.
+	maxBuildingImagePixels = 40_000_000
+	webpConvertTimeout     = 20 * time.Second
+)
+
+// buildingImageVariantSpec describes one generated rendition of a building
+// image. MaxSide bounds the longest edge; images are never upscaled.
+type buildingImageVariantSpec struct {
+	Name    string
+	MaxSide int
+}
+
+var buildingImageVariantSpecs = []buildingImageVariantSpec{
+	{Name: "thumb", MaxSide: 320},
+	{Name: "medium", MaxSide: 960},
+	{Name: "large", MaxSide: 1920},
+}
+
+// buildingImagePrimaryVariant is the rendition stored in image_url so that
+// clients unaware of variants keep working with a sanitized file.
+const buildingImagePrimaryVariant = "large"
+
+var errUnsupportedImageType = errors.New("unsupported image type")
+var errImageTooLarge = errors.New("image dimensions are too large")
+
+type buildingImage struct {
+	URL      string
+	Variants map[string]string
+}
+
+// webpTools holds paths to the libwebp command line utilities. Go's standard
+// library can neither encode nor decode WebP, so conversion is delegated to
+// cwebp/dwebp when they are installed; without them variants are written as
+// JPEG (or PNG for images with transparency).
+type webpTools struct {
+	cwebp string
+	dwebp string
+}
+
+func loadWebPToolsFromEnv() webpTools {
+	if value := strings.TrimSpace(os.Getenv("OFFICE_IMAGE_WEBP")); value != "" && !isTrueEnv("OFFICE_IMAGE_WEBP") {
+		return webpTools{}
+	}
+	return webpTools{
+		cwebp: resolveToolPath("OFFICE_CWEBP_PATH", "cwebp"),
+		dwebp: resolveToolPath("OFFICE_DWEBP_PATH", "dwebp"),
+	}
+}
+
+func resolveToolPath(envName, binary string) string {
+	if configured := strings.TrimSpace(os.Getenv(envName)); configured != "" {
+		return configured
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func (t webpTools) CanEncode() bool {
+	return t.cwebp != ""
+}
+
+func (t webpTools) CanDecode() bool {
+	return t.dwebp != ""
+}
+
+func (a *app) saveBuildingImage(buildingID int64, file multipart.File, header *multipart.FileHeader) (buildingImage, error) {
+	defer file.Close()
+	if header.Size == 0 {
+		return buildingImage{}, errors.New("empty image file")
+	}
+	if header.Size > maxBuildingImageSize {
+		return buildingImage{}, errors.New("image file is too large")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBuildingImageSize+1))
+	if err != nil {
+		return buildingImage{}, err
+	}
+	if len(data) > maxBuildingImageSize {
+		return buildingImage{}, errors.New("image file is too large")
+	}
+	contentType := http.DetectContentType(data)
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if ext == "" {
+		ext = extensionFromContentType(contentType)
+	}
+	if !isAllowedImageType(ext, contentType) {
+		return buildingImage{}, errUnsupportedImageType
+	}
+
+	src, err := a.decodeBuildingImage(data, contentType)
+	if err != nil {
+		return buildingImage{}, err
+	}
+	opaque := src.Opaque()
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageOperationTimeout)
+	defer cancel()
+
+	prefix := fmt.Sprintf("building-%d-%d", buildingID, time.Now().UnixNano())
+	result := buildingImage{Variants: make(map[string]string, len(buildingImageVariantSpecs))}
+	written := make([]string, 0, len(buildingImageVariantSpecs))
+	for _, spec := range buildingImageVariantSpecs {
+		variant := resizeToFit(src, spec.MaxSide)
+		encoded, variantExt, encodeErr := a.encodeBuildingImage(variant, opaque)
+		if encodeErr != nil {
+			a.removeObjects(ctx, written)
+			return buildingImage{}, encodeErr
+		}
+		key := buildingUploadDirName + "/" + fmt.Sprintf("%s-%s%s", prefix, spec.Name, variantExt)
+		if putErr := a.storage.Put(ctx, key, encoded, contentTypeForKey(key)); putErr != nil {
+			a.removeObjects(ctx, written)
+			return buildingImage{}, putErr
+		}
+		written = append(written, key)
+		result.Variants[spec.Name] = uploadURLForKey(key)
+	}
+	result.URL = result.Variants[buildingImagePrimaryVariant]
+	return result, nil
+}
+
+// removeBuildingImageFiles deletes the primary image and every variant of b.
+func (a *app) removeBuildingImageFiles(b building) error {
+	seen := make(map[string]struct{}, len(b.ImageVariants)+1)
+	urls := make([]string, 0, len(b.ImageVariants)+1)
+	for _, url := range append([]string{b.ImageURL}, variantURLs(b.ImageVariants)...) {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		if _, ok := seen[url]; ok {
+			continue
+		}
+		seen[url] = struct{}{}
+		urls = append(urls, url)
+	}
+	var firstErr error
+	for _, url := range urls {
+		if err := a.removeUploadedFile(url); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func variantURLs(variants map[string]string) []string {
+	urls := make([]string, 0, len(variants))
+	for _, spec := range buildingImageVariantSpecs {
+		if url, ok := variants[spec.Name]; ok {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+func (a *app) removeObjects(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := a.storage.Delete(ctx, key); err != nil {
+			log.Printf("remove object %s: %v", key, err)
+		}
+	}
+}
+
+func encodeImageVariants(variants map[string]string) string {
+	if len(variants) == 0 {
+		return "{}"
+	}
+	payload, err := json.Marshal(variants)
+	if err != nil {
+		return "{}"
+	}
+	return string(payload)
+}
+
+func decodeImageVariants(payload string) map[string]string {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil
+	}
+	var variants map[string]string
+	if err := json.Unmarshal([]byte(payload), &variants); err != nil || len(variants) == 0 {
+		return nil
+	}
+	return variants
+}
+
+// decodeBuildingImage decodes the upload into an RGBA canvas with the EXIF
+// orientation applied, so that dropping the metadata on re-encode does not
+// leave phone photos rotated. Only the first frame of animated GIFs is kept.
+func (a *app) decodeBuildingImage(data []byte, contentType string) (*image.RGBA, error) {
+	if contentType == "image/webp" {
+		if !a.webpTools.CanDecode() {
+			return nil, errors.New("webp images are not supported on this server")
+		}
+		converted, err := runWebPTool(a.webpTools.dwebp, data, ".webp", ".png", "-quiet", "-png")
+		if err != nil {
+			log.Printf("dwebp failed: %v", err)
+			return nil, errUnsupportedImageType
+		}
+		data = converted
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedImageType
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errUnsupportedImageType
+	}
+	if int64(config.Width)*int64(config.Height) > maxBuildingImagePixels {
+		return nil, errImageTooLarge
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errUnsupportedImageType
+	}
+
+	bounds := decoded.Bounds()
+	canvas := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(canvas, canvas.Bounds(), decoded, bounds.Min, draw.Src)
+	if contentType == "image/jpeg" {
+		canvas = applyEXIFOrientation(canvas, jpegEXIFOrientation(data))
+	}
+	return canvas, nil
+}
+
+func (a *app) encodeBuildingImage(img *image.RGBA, opaque bool) ([]byte, string, error) {
+	var buf bytes.Buffer
+	ext := ".jpg"
+	if opaque {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: buildingImageJPEGQuality}); err != nil {
+			return nil, "", err
+		}
+	} else {
+		ext = ".png"
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := encoder.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+	}
+	if !a.webpTools.CanEncode() {
+		return buf.Bytes(), ext, nil
+	}
+	converted, err := runWebPTool(a.webpTools.cwebp, buf.Bytes(), ext, ".webp", "-quiet", "-q", fmt.Sprint(buildingImageWebPQuality), "-metadata", "none")
+	if err != nil {
+		// WebP is an optimization; keep the sanitized JPEG/PNG instead of
+		// failing the upload.
+		log.Printf("cwebp failed, keeping %s: %v", ext, err)
+		return buf.Bytes(), ext, nil
+	}
+	return converted, ".webp", nil
+}
+
+// runWebPTool feeds input to a libwebp utility through temporary files and
+// returns the produced output. Both cwebp and dwebp accept "<in> ... -o <out>".
+func runWebPTool(tool string, input []byte, inExt, outExt string, args ...string) ([]byte, error) {
+	workDir, err := os.MkdirTemp("", "office-image-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	inPath := filepath.Join(workDir, "input"+inExt)
+	outPath := filepath.Join(workDir, "output"+outExt)
+	if err := os.WriteFile(inPath, input, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webpConvertTimeout)
+	defer cancel()
+	cmdArgs := append(append([]string{}, args...), inPath, "-o", outPath)
+	output, err := exec.CommandContext(ctx, tool, cmdArgs...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", filepath.Base(tool), err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(outPath)
+}
+
+// resizeToFit scales src down so that its longest side is at most maxSide,
+// averaging every covered source pixel (box filter). Smaller images are
+// returned unchanged.
+func resizeToFit(src *image.RGBA, maxSide int) *image.RGBA {
+	srcW, srcH := src.Rect.Dx(), src.Rect.Dy()
+	if maxSide <= 0 || (srcW <= maxSide && srcH <= maxSide) {
+		return src
+	}
+	dstW, dstH := maxSide, maxSide
+	if srcW >= srcH {
+		dstH = int(int64(srcH) * int64(maxSide) / int64(srcW))
+	} else {
+		dstW = int(int64(srcW) * int64(maxSide) / int64(srcH))
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for dy := 0; dy < dstH; dy++ {
+		y0 := dy * srcH / dstH
+		y1 := (dy + 1) * srcH / dstH
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for dx := 0; dx < dstW; dx++ {
+			x0 := dx * srcW / dstW
+			x1 := (dx + 1) * srcW / dstW
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, alpha, count uint64
+			for y := y0; y < y1; y++ {
+				offset := src.PixOffset(src.Rect.Min.X+x0, src.Rect.Min.Y+y)
+				for x := x0; x < x1; x++ {
+					r += uint64(src.Pix[offset])
+					g += uint64(src.Pix[offset+1])
+					b += uint64(src.Pix[offset+2])
+					alpha += uint64(src.Pix[offset+3])
+					offset += 4
+					count++
+				}
+			}
+			out := dst.PixOffset(dx, dy)
+			dst.Pix[out] = uint8(r / count)
+			dst.Pix[out+1] = uint8(g / count)
+			dst.Pix[out+2] = uint8(b / count)
+			dst.Pix[out+3] = uint8(alpha / count)
+		}
+	}
+	return dst
+}
+
+// jpegEXIFOrientation returns the EXIF orientation tag (1..8) of a JPEG, or 1
+// when it is absent or unreadable.
+func jpegEXIFOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		segmentLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if segmentLen < 2 || pos+2+segmentLen > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+segmentLen]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + segmentLen
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset < 8 || ifdOffset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:entry+2]) != 0x0112 {
+			continue
+		}
+		value := int(order.Uint16(tiff[entry+8 : entry+10]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+	return 1
+}
+
+// applyEXIFOrientation transforms img so that it displays upright for the
+// given EXIF orientation value.
+func applyEXIFOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var nx, ny int
+			switch orientation {
+			case 2:
+				nx, ny = w-1-x, y
+			case 3:
+				nx, ny = w-1-x, h-1-y
+			case 4:
+				nx, ny = x, h-1-y
+			case 5:
+				nx, ny = y, x
+			case 6:
+				nx, ny = h-1-y, x
+			case 7:
+				nx, ny = h-1-y, w-1-x
+			case 8:
+				nx, ny = y, w-1-x
+			}
+			src := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			out := dst.PixOffset(nx, ny)
+			copy(dst.Pix[out:out+4], img.Pix[src:src+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizeToFit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		width, height int
+		maxSide       int
+		wantW, wantH  int
+	}{
+		{name: "landscape is bounded by width", width: 4000, height: 3000, maxSide: 320, wantW: 320, wantH: 240},
+		{name: "portrait is bounded by height", width: 1000, height: 2000, maxSide: 960, wantW: 480, wantH: 960},
+		{name: "small image is not upscaled", width: 200, height: 100, maxSide: 320, wantW: 200, wantH: 100},
+		{name: "extreme aspect keeps one pixel", width: 5000, height: 2, maxSide: 320, wantW: 320, wantH: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			got := resizeToFit(src, tt.maxSide)
+			if got.Rect.Dx() != tt.wantW || got.Rect.Dy() != tt.wantH {
+				t.Fatalf("resizeToFit() = %dx%d, want %dx%d", got.Rect.Dx(), got.Rect.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestResizeToFitAveragesPixels(t *testing.T) {
+	t.Parallel()
+
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{R: 200, A: 255})
+	src.Set(1, 0, color.RGBA{R: 0, A: 255})
+	src.Set(0, 1, color.RGBA{R: 200, A: 255})
+	src.Set(1, 1, color.RGBA{R: 0, A: 255})
+
+	got := resizeToFit(src, 1)
+	if r := got.RGBAAt(0, 0).R; r != 100 {
+		t.Fatalf("averaged red = %d, want 100", r)
+	}
+}
+
+func TestJPEGEXIFOrientation(t *testing.T) {
+	t.Parallel()
+
+	tiff := make([]byte, 8+2+12+4)
+	copy(tiff, "MM")
+	binary.BigEndian.PutUint16(tiff[2:4], 42)
+	binary.BigEndian.PutUint32(tiff[4:8], 8)
+	binary.BigEndian.PutUint16(tiff[8:10], 1)
+	binary.BigEndian.PutUint16(tiff[10:12], 0x0112)
+	binary.BigEndian.PutUint16(tiff[12:14], 3)
+	binary.BigEndian.PutUint32(tiff[14:18], 1)
+	binary.BigEndian.PutUint16(tiff[18:20], 6)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(segment)+2))
+	data = append(data, segment...)
+	data = append(data, 0xFF, 0xD9)
+
+	if got := jpegEXIFOrientation(data); got != 6 {
+		t.Fatalf("jpegEXIFOrientation() = %d, want 6", got)
+	}
+	if got := jpegEXIFOrientation([]byte{0xFF, 0xD8, 0xFF, 0xD9}); got != 1 {
+		t.Fatalf("jpegEXIFOrientation() without EXIF = %d, want 1", got)
+	}
+}
+
+func TestApplyEXIFOrientationRotates(t *testing.T) {
+	t.Parallel()
+
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	marker := color.RGBA{R: 255, A: 255}
+	src.SetRGBA(0, 0, marker)
+
+	got := applyEXIFOrientation(src, 6)
+	if got.Rect.Dx() != 2 || got.Rect.Dy() != 3 {
+		t.Fatalf("rotated size = %dx%d, want 2x3", got.Rect.Dx(), got.Rect.Dy())
+	}
+	if got.RGBAAt(1, 0) != marker {
+		t.Fatalf("top-left pixel should move to top-right after 90° clockwise rotation")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runCLI handles maintenance subcommands (e.g. "api storage migrate"). It
+// returns the process exit code.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "storage":
+		return runStorageCommand(args[1:], stdout, stderr)
+	case "audit":
+		return runAuditCommand(args[1:], stdout, stderr)
+	case "migrate":
+		return runMigrateCommand(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		printCLIUsage(stdout)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printCLIUsage(stderr)
+		return 2
+	}
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: api [command]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Without a command the HTTP server is started.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "commands:")
+	fmt.Fprintln(w, "  storage migrate   copy uploaded files from a local directory into the configured storage")
+	fmt.Fprintln(w, "  audit verify      verify the audit log hash chain and its signed checkpoints")
+	fmt.Fprintln(w, "  audit checkpoint  sign the current head of the audit log chain")
+	fmt.Fprintln(w, "  audit archive     archive audit events older than OFFICE_AUDIT_RETENTION_DAYS now")
+	fmt.Fprintln(w, "  migrate up        apply pending schema migrations")
+	fmt.Fprintln(w, "  migrate status    list schema migrations and whether they are applied")
+	fmt.Fprintln(w, "  migrate down      revert the latest schema migration")
+}
+
+func runStorageCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintln(stderr, "usage: api storage migrate [-from-dir DIR] [-delete-source] [-dry-run]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("storage migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	fromDir := flags.String("from-dir", uploadDirName, "local upload directory to read files from")
+	deleteSource := flags.Bool("delete-source", false, "remove files from the local directory after they are copied")
+	dryRun := flags.Bool("dry-run", false, "only print what would be copied")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	sourceDir, err := filepath.Abs(*fromDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "resolve source dir: %v\n", err)
+		return 1
+	}
+	if stat, err := os.Stat(sourceDir); err != nil || !stat.IsDir() {
+		fmt.Fprintf(stderr, "source dir %s does not exist\n", sourceDir)
+		return 1
+	}
+	src, err := newLocalObjectStorage(sourceDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "open source dir: %v\n", err)
+		return 1
+	}
+
+	defaultDir, err := filepath.Abs(uploadDirName)
+	if err != nil {
+		fmt.Fprintf(stderr, "resolve upload dir: %v\n", err)
+		return 1
+	}
+	dst, err := loadObjectStorageFromEnv(defaultDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "configure upload storage: %v\n", err)
+		return 1
+	}
+	if strings.EqualFold(dst.Name(), src.Name()) {
+		fmt.Fprintf(stderr, "source and destination are the same (%s); set OFFICE_STORAGE_BACKEND=s3\n", dst.Name())
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "migrating %s -> %s\n", src.Name(), dst.Name())
+	copied, skipped, err := migrateObjectStorage(context.Background(), src, dst, *deleteSource, *dryRun, stdout)
+	fmt.Fprintf(stdout, "copied=%d skipped=%d\n", copied, skipped)
+	if err != nil {
+		fmt.Fprintf(stderr, "storage migrate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runAuditCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "checkpoint" && args[0] != "archive") {
+		fmt.Fprintln(stderr, "usage: api audit verify [-json] | api audit checkpoint | api audit archive")
+		return 2
+	}
+	flags := flag.NewFlagSet("audit "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", postgresDSN())
+	if err != nil {
+		fmt.Fprintf(stderr, "open db: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	if args[0] == "archive" {
+		retention := auditRetentionFromEnv()
+		if retention <= 0 {
+			fmt.Fprintln(stderr, "audit archive: OFFICE_AUDIT_RETENTION_DAYS is not set")
+			return 1
+		}
+		dir, err := auditArchiveDirFromEnv()
+		if err != nil {
+			fmt.Fprintf(stderr, "audit archive: %v\n", err)
+			return 1
+		}
+		sinks, err := loadAuditSinksFromEnv()
+		if err != nil {
+			fmt.Fprintf(stderr, "audit archive: %v\n", err)
+			return 1
+		}
+		archived, err := archiveExpiredAuditEvents(ctx, db, dir, time.Now().Add(-retention), sinks.names())
+		fmt.Fprintf(stdout, "archived=%d dir=%s\n", archived, dir)
+		if err != nil {
+			fmt.Fprintf(stderr, "audit archive: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	keys, err := loadOfficeTokenKeyManagerFromEnv()
+	if err != nil {
+		fmt.Fprintf(stderr, "load office jwt keys: %v\n", err)
+		return 1
+	}
+	keyStore, err := loadOfficeJWTKeyStoreFromEnv(db, keys)
+	if err != nil {
+		fmt.Fprintf(stderr, "configure office jwt key storage: %v\n", err)
+		return 1
+	}
+	if keyStore != nil {
+		if err := keyStore.load(ctx); err != nil {
+			fmt.Fprintf(stderr, "load office jwt keys from db: %v\n", err)
+			return 1
+		}
+	}
+
+	if args[0] == "checkpoint" {
+		a := &app{db: db, officeTokenKeys: keys, auditCheckpointKeyPins: auditCheckpointKeyPinsFromEnv()}
+		if err := a.writeAuditCheckpoint(ctx); err != nil {
+			fmt.Fprintf(stderr, "audit checkpoint: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "audit chain head is checkpointed")
+		return 0
+	}
+
+	report, err := verifyAuditChain(ctx, db, keys, auditCheckpointKeyPinsFromEnv())
+	if err != nil {
+		fmt.Fprintf(stderr, "audit verify: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		fmt.Fprint(stdout, formatAuditChainReport(report))
+	}
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+func runMigrateCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "status" && args[0] != "down") {
+		fmt.Fprintln(stderr, "usage: api migrate up [-to VERSION] [-dry-run] | api migrate status [-json] | api migrate down [-to VERSION] [-dry-run]")
+		return 2
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	asJSON := flags.Bool("json", false, "print the status as JSON")
+	target := flags.Int64("to", -1, "up: last version to apply; down: version to keep (default: revert only the latest)")
+	dryRun := flags.Bool("dry-run", false, "only print what would be done")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	ctx := context.Background()
+	db, err := sql.Open("pgx", postgresDSN())
+	if err != nil {
+		fmt.Fprintf(stderr, "open db: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	if args[0] == "status" || *dryRun {
+		applied, err := loadAppliedSchemaMigrations(ctx, db)
+		if err != nil {
+			fmt.Fprintf(stderr, "migrate %s: %v\n", args[0], err)
+			return 1
+		}
+		if args[0] == "status" {
+			statuses := schemaMigrationStatuses(schemaMigrations, applied)
+			if *asJSON {
+				enc := json.NewEncoder(stdout)
+				enc.SetIndent("", "  ")
+				_ = enc.Encode(statuses)
+			} else {
+				fmt.Fprint(stdout, formatSchemaMigrationStatuses(statuses))
+			}
+			return 0
+		}
+		plan := planSchemaMigrationsUp(schemaMigrations, applied, *target)
+		if args[0] == "down" {
+			if plan, err = planSchemaMigrationsDown(schemaMigrations, applied, *target); err != nil {
+				fmt.Fprintf(stderr, "migrate down: %v\n", err)
+				return 1
+			}
+		}
+		for _, m := range plan {
+			fmt.Fprintf(stdout, "would %s %d %s\n", args[0], m.Version, m.Name)
+		}
+		fmt.Fprintf(stdout, "migrations=%d\n", len(plan))
+		return 0
+	}
+
+	var done []schemaMigration
+	if args[0] == "up" {
+		done, err = migrateSchemaUp(ctx, db, schemaMigrations, *target)
+	} else {
+		done, err = migrateSchemaDown(ctx, db, schemaMigrations, *target)
+	}
+	for _, m := range done {
+		fmt.Fprintf(stdout, "%s %d %s\n", args[0], m.Version, m.Name)
+	}
+	fmt.Fprintf(stdout, "migrations=%d\n", len(done))
+	if err != nil {
+		fmt.Fprintf(stderr, "migrate %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func formatSchemaMigrationStatuses(statuses []schemaMigrationStatus) string {
+	var b strings.Builder
+	var current int64
+	pending := 0
+	for _, s := range statuses {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(&b, "%6d  %-8s  %-25s  %s\n", s.Version, s.State, appliedAt, s.Name)
+		switch s.State {
+		case schemaMigrationPending:
+			pending++
+		default:
+			if s.Version > current {
+				current = s.Version
+			}
+		}
+	}
+	fmt.Fprintf(&b, "schema version: %d, pending: %d\n", current, pending)
+	return b.String()
+}
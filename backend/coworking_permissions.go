@@ -72,7 +72,7 @@ func (a *app) ensureCanManageBuilding(w http.ResponseWriter, r *http.Request, bu
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -86,11 +86,14 @@ func (a *app) ensureCanManageBuilding(w http.ResponseWriter, r *http.Request, bu
 		respondError(w, http.StatusForbidden, "Недостаточно прав")
 		return false
 	}
-	var responsibleID string
+	var responsibleID, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(responsible_employee_id), '') FROM office_buildings WHERE id = $1`,
-		buildingID,
-	).Scan(&responsibleID)
+		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b")+`
+		   FROM office_buildings b
+		  WHERE b.id = $1`,
+		buildingID, eid,
+	).Scan(&responsibleID, &delegatedResp)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondError(w, http.StatusNotFound, "building not found")
@@ -100,11 +103,11 @@ func (a *app) ensureCanManageBuilding(w http.ResponseWriter, r *http.Request, bu
 		}
 		return false
 	}
-	if responsibleID == "" || responsibleID != eid {
-		respondError(w, http.StatusForbidden, "Недостаточно прав")
-		return false
+	if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, responsibleID, delegatedResp) {
+		return true
 	}
-	return true
+	respondError(w, http.StatusForbidden, "Недостаточно прав")
+	return false
 }
 
 func (a *app) ensureCanManageBuildingByFloor(w http.ResponseWriter, r *http.Request, floorID int64) bool {
@@ -155,7 +158,7 @@ func (a *app) ensureCanManageFloor(w http.ResponseWriter, r *http.Request, floor
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -170,15 +173,18 @@ func (a *app) ensureCanManageFloor(w http.ResponseWriter, r *http.Request, floor
 		return false
 	}
 	// Single query: floor → building with all responsible IDs.
-	var buildingResp, floorResp string
+	var buildingID int64
+	var buildingResp, floorResp, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
-		        COALESCE(TRIM(f.responsible_employee_id), '')
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
+		        COALESCE(TRIM(f.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f")+`
 		   FROM floors f
 		   JOIN office_buildings b ON b.id = f.building_id
 		  WHERE f.id = $1`,
-		floorID,
-	).Scan(&buildingResp, &floorResp)
+		floorID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &delegatedResp)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondError(w, http.StatusNotFound, "floor not found")
@@ -188,14 +194,86 @@ func (a *app) ensureCanManageFloor(w http.ResponseWriter, r *http.Request, floor
 		}
 		return false
 	}
-	if (buildingResp != "" && buildingResp == eid) ||
-		(floorResp != "" && floorResp == eid) {
+	if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, delegatedResp) {
 		return true
 	}
 	respondError(w, http.StatusForbidden, "Недостаточно прав")
 	return false
 }
 
+// ensureCanManageZone allows the building, floor and zone responsibles.
+func (a *app) ensureCanManageZone(w http.ResponseWriter, r *http.Request, zoneID int64) bool {
+	role, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return false
+	}
+	if hasRequestPermission(r, role, permissionManageLayout) {
+		return true
+	}
+	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	eid := strings.TrimSpace(employeeID)
+	if eid == "" {
+		respondError(w, http.StatusForbidden, "Недостаточно прав")
+		return false
+	}
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, delegatedResp string
+	err = a.db.QueryRowContext(r.Context(),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
+		        COALESCE(TRIM(f.responsible_employee_id), ''),
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f")+`
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		  WHERE z.id = $1`,
+		zoneID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &delegatedResp)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "zone not found")
+		} else {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return false
+	}
+	if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, zoneResp, delegatedResp) {
+		return true
+	}
+	respondError(w, http.StatusForbidden, "Недостаточно прав")
+	return false
+}
+
+// canActInBuilding reports whether the caller may act on an object in
+// buildingID: through a role permission (global or scoped to the building)
+// or by being responsible for one of the levels above the object. Deputies
+// are passed in as the delegatedResponsibleSQL column.
+func canActInBuilding(role int, required permission, eid string, buildingID int64, responsibleIDs ...string) bool {
+	return hasPermissionInBuilding(role, required, buildingID) || isResponsibleEmployee(eid, responsibleIDs...)
+}
+
+// isResponsibleEmployee reports whether eid is one of the responsible
+// employees along a building → floor → zone → space chain.
+func isResponsibleEmployee(eid string, responsibleIDs ...string) bool {
+	if eid == "" {
+		return false
+	}
+	for _, id := range responsibleIDs {
+		if id != "" && id == eid {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *app) getCoworkingIDByDeskID(deskID int64) (int64, error) {
 	var coworkingID int64
 	row := a.db.QueryRow(
@@ -261,7 +339,7 @@ func (a *app) ensureCanManageSpace(w http.ResponseWriter, r *http.Request, space
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -276,21 +354,24 @@ func (a *app) ensureCanManageSpace(w http.ResponseWriter, r *http.Request, space
 		return false
 	}
 	// Try coworking first (single query with full hierarchy).
-	var buildingResp, floorResp, coworkingResp string
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
 		        COALESCE(TRIM(f.responsible_employee_id), ''),
-		        COALESCE(TRIM(c.responsible_employee_id), '')
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(c.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f", "c")+`
 		   FROM coworkings c
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE c.id = $1`,
-		spaceID,
-	).Scan(&buildingResp, &floorResp, &coworkingResp)
+		spaceID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &coworkingResp, &delegatedResp)
 	if err == nil {
-		if (buildingResp != "" && buildingResp == eid) ||
-			(floorResp != "" && floorResp == eid) ||
-			(coworkingResp != "" && coworkingResp == eid) {
+		if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp) {
 			return true
 		}
 		respondError(w, http.StatusForbidden, "Недостаточно прав")
@@ -303,14 +384,18 @@ func (a *app) ensureCanManageSpace(w http.ResponseWriter, r *http.Request, space
 	}
 	// Maybe it's a meeting room.
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
-		        COALESCE(TRIM(f.responsible_employee_id), '')
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
+		        COALESCE(TRIM(f.responsible_employee_id), ''),
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f")+`
 		   FROM meeting_rooms mr
 		   JOIN floors f ON f.id = mr.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = mr.zone_id
 		  WHERE mr.id = $1`,
-		spaceID,
-	).Scan(&buildingResp, &floorResp)
+		spaceID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &delegatedResp)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondError(w, http.StatusNotFound, "space not found")
@@ -320,7 +405,7 @@ func (a *app) ensureCanManageSpace(w http.ResponseWriter, r *http.Request, space
 		}
 		return false
 	}
-	if (buildingResp != "" && buildingResp == eid) || (floorResp != "" && floorResp == eid) {
+	if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, zoneResp, delegatedResp) {
 		return true
 	}
 	respondError(w, http.StatusForbidden, "Недостаточно прав")
@@ -328,12 +413,22 @@ func (a *app) ensureCanManageSpace(w http.ResponseWriter, r *http.Request, space
 }
 
 func (a *app) ensureCanManageCoworking(w http.ResponseWriter, r *http.Request, coworkingID int64) bool {
+	return a.ensureCoworkingPermission(w, r, coworkingID, permissionManageLayout)
+}
+
+// ensureCanManageCoworkingBookings guards listing and cancelling other
+// employees' bookings of a coworking.
+func (a *app) ensureCanManageCoworkingBookings(w http.ResponseWriter, r *http.Request, coworkingID int64) bool {
+	return a.ensureCoworkingPermission(w, r, coworkingID, permissionManageBookings)
+}
+
+func (a *app) ensureCoworkingPermission(w http.ResponseWriter, r *http.Request, coworkingID int64, required permission) bool {
 	role, err := resolveRoleFromRequest(r, a.db)
 	if err != nil {
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, required) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -348,17 +443,22 @@ func (a *app) ensureCanManageCoworking(w http.ResponseWriter, r *http.Request, c
 		return false
 	}
 	// Single query: resolve hierarchy and all responsible employee IDs.
-	var buildingResp, floorResp, coworkingResp string
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
 		        COALESCE(TRIM(f.responsible_employee_id), ''),
-		        COALESCE(TRIM(c.responsible_employee_id), '')
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(c.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f", "c")+`
 		   FROM coworkings c
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE c.id = $1`,
-		coworkingID,
-	).Scan(&buildingResp, &floorResp, &coworkingResp)
+		coworkingID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &coworkingResp, &delegatedResp)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondError(w, http.StatusNotFound, "space not found")
@@ -368,9 +468,7 @@ func (a *app) ensureCanManageCoworking(w http.ResponseWriter, r *http.Request, c
 		}
 		return false
 	}
-	if (buildingResp != "" && buildingResp == eid) ||
-		(floorResp != "" && floorResp == eid) ||
-		(coworkingResp != "" && coworkingResp == eid) {
+	if canRequestActInBuilding(r, role, required, eid, buildingID, buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp) {
 		return true
 	}
 	respondError(w, http.StatusForbidden, "Недостаточно прав")
@@ -386,7 +484,7 @@ func (a *app) ensureCanManageCoworkings(w http.ResponseWriter, r *http.Request,
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -403,20 +501,26 @@ func (a *app) ensureCanManageCoworkings(w http.ResponseWriter, r *http.Request,
 
 	// Single batch query instead of N+1.
 	placeholders := make([]string, len(coworkingIDs))
-	args := make([]any, len(coworkingIDs))
+	args := make([]any, len(coworkingIDs), len(coworkingIDs)+1)
 	for i, id := range coworkingIDs {
 		placeholders[i] = fmt.Sprintf("$%d", i+1)
 		args[i] = id
 	}
+	args = append(args, eid)
 	query := fmt.Sprintf(
 		`SELECT c.id,
+		        b.id,
 		        COALESCE(TRIM(b.responsible_employee_id), ''),
 		        COALESCE(TRIM(f.responsible_employee_id), ''),
-		        COALESCE(TRIM(c.responsible_employee_id), '')
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(c.responsible_employee_id), ''),
+		        %s
 		   FROM coworkings c
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE c.id IN (%s)`,
+		delegatedResponsibleSQL(fmt.Sprintf("$%d", len(args)), "b", "f", "c"),
 		strings.Join(placeholders, ","),
 	)
 	rows, err := a.db.QueryContext(r.Context(), query, args...)
@@ -429,17 +533,14 @@ func (a *app) ensureCanManageCoworkings(w http.ResponseWriter, r *http.Request,
 
 	manageable := make(map[int64]bool, len(coworkingIDs))
 	for rows.Next() {
-		var cID int64
-		var buildingResp, floorResp, coworkingResp string
-		if err := rows.Scan(&cID, &buildingResp, &floorResp, &coworkingResp); err != nil {
+		var cID, buildingID int64
+		var buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp string
+		if err := rows.Scan(&cID, &buildingID, &buildingResp, &floorResp, &zoneResp, &coworkingResp, &delegatedResp); err != nil {
 			log.Printf("internal error: %v", err)
 			respondError(w, http.StatusInternalServerError, "internal error")
 			return false
 		}
-		canManage := (buildingResp != "" && buildingResp == eid) ||
-			(floorResp != "" && floorResp == eid) ||
-			(coworkingResp != "" && coworkingResp == eid)
-		manageable[cID] = canManage
+		manageable[cID] = canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp)
 	}
 	if err := rows.Err(); err != nil {
 		log.Printf("internal error: %v", err)
@@ -466,7 +567,7 @@ func (a *app) ensureCanManageDesk(w http.ResponseWriter, r *http.Request, deskID
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
@@ -480,19 +581,24 @@ func (a *app) ensureCanManageDesk(w http.ResponseWriter, r *http.Request, deskID
 		respondError(w, http.StatusForbidden, "Недостаточно прав")
 		return false
 	}
-	// Single query: desk → coworking → floor → building with all responsible IDs.
-	var buildingResp, floorResp, coworkingResp string
+	// Single query: desk → coworking → zone → floor → building with all responsible IDs.
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp string
 	err = a.db.QueryRowContext(r.Context(),
-		`SELECT COALESCE(TRIM(b.responsible_employee_id), ''),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
 		        COALESCE(TRIM(f.responsible_employee_id), ''),
-		        COALESCE(TRIM(c.responsible_employee_id), '')
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(c.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f", "c")+`
 		   FROM workplaces w
 		   JOIN coworkings c ON c.id = w.coworking_id
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = c.zone_id
 		  WHERE w.id = $1`,
-		deskID,
-	).Scan(&buildingResp, &floorResp, &coworkingResp)
+		deskID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &coworkingResp, &delegatedResp)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			respondError(w, http.StatusNotFound, "desk not found")
@@ -502,9 +608,7 @@ func (a *app) ensureCanManageDesk(w http.ResponseWriter, r *http.Request, deskID
 		}
 		return false
 	}
-	if (buildingResp != "" && buildingResp == eid) ||
-		(floorResp != "" && floorResp == eid) ||
-		(coworkingResp != "" && coworkingResp == eid) {
+	if canRequestActInBuilding(r, role, permissionManageLayout, eid, buildingID, buildingResp, floorResp, zoneResp, coworkingResp, delegatedResp) {
 		return true
 	}
 	respondError(w, http.StatusForbidden, "Недостаточно прав")
@@ -520,7 +624,7 @@ func (a *app) ensureCanManageDesks(w http.ResponseWriter, r *http.Request, deskI
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role != roleEmployee {
+	if hasRequestPermission(r, role, permissionManageLayout) {
 		return true
 	}
 	coworkingIDs, err := a.getCoworkingIDsByDeskIDs(deskIDs)
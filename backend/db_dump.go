@@ -36,7 +36,7 @@ func (a *app) handleDatabaseDumpExport(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	if !ensureNotEmployeeRoleFresh(w, r, a.db) {
+	if !ensurePermissionFresh(w, r, a.db, permissionManageBackups) {
 		return
 	}
 
@@ -99,7 +99,7 @@ func (a *app) handleDatabaseDumpImport(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
-	if !ensureNotEmployeeRoleFresh(w, r, a.db) {
+	if !ensurePermissionFresh(w, r, a.db, permissionManageBackups) {
 		return
 	}
 	if err := r.ParseMultipartForm(maxDBDumpUploadSize); err != nil {
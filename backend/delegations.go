@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A delegation lets a deputy act as the responsible employee of a building,
+// floor or coworking for a limited period, e.g. during a vacation. It is
+// granted by the current responsible or by a role with manage_layout in the
+// building. It ends at ends_at, when it is revoked, or as soon as the
+// delegator is no longer responsible for the object. While it is active the
+// permission checks treat the deputy like the responsible, and the deputy's
+// office token lists the object in responsibilities.
+const (
+	delegationsPath = "/api/delegations"
+
+	auditActionDelegate = "delegate"
+
+	delegationMaxPeriod = 90 * 24 * time.Hour
+	delegationListLimit = 200
+)
+
+const (
+	delegationStatusScheduled = "scheduled"
+	delegationStatusActive    = "active"
+	delegationStatusExpired   = "expired"
+	delegationStatusRevoked   = "revoked"
+	// delegationStatusLapsed means the delegator has stopped being
+	// responsible for the object, so the delegation no longer grants
+	// anything.
+	delegationStatusLapsed = "lapsed"
+)
+
+var (
+	errDelegationPeriodInvalid  = errors.New("ends_at must be after starts_at and in the future")
+	errDelegationPeriodTooLong  = fmt.Errorf("a delegation cannot be longer than %d days", int(delegationMaxPeriod/(24*time.Hour)))
+	errDelegationDeputyInvalid  = errors.New("deputy_employee_id must be an active employee other than the responsible")
+	errDelegationEntityNotFound = errors.New("entity not found")
+)
+
+// delegationLevelTypes maps the table aliases used by the permission checks
+// to the entity types a delegation can be granted on.
+var delegationLevelTypes = map[string]string{
+	"b": auditEntityBuilding,
+	"f": auditEntityFloor,
+	"c": auditEntityCoworking,
+}
+
+// delegationTargetQueries resolve the building, name and current
+// responsible of a delegatable entity.
+var delegationTargetQueries = map[string]string{
+	auditEntityBuilding: `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), '')
+	   FROM office_buildings b
+	  WHERE b.id = $1 AND b.deleted_at IS NULL`,
+	auditEntityFloor: `SELECT f.building_id, f.name, COALESCE(TRIM(f.responsible_employee_id), '')
+	   FROM floors f
+	  WHERE f.id = $1 AND f.deleted_at IS NULL`,
+	auditEntityCoworking: `SELECT f.building_id, c.name, COALESCE(TRIM(c.responsible_employee_id), '')
+	   FROM coworkings c
+	   JOIN floors f ON f.id = c.floor_id
+	  WHERE c.id = $1 AND c.deleted_at IS NULL`,
+}
+
+// delegationCurrentResponsibleSQL is the current responsible of the
+// delegation's entity; NULL once the entity is deleted.
+const delegationCurrentResponsibleSQL = `CASE d.entity_type
+		WHEN 'building' THEN (SELECT COALESCE(TRIM(responsible_employee_id), '') FROM office_buildings WHERE id = d.entity_id AND deleted_at IS NULL)
+		WHEN 'floor' THEN (SELECT COALESCE(TRIM(responsible_employee_id), '') FROM floors WHERE id = d.entity_id AND deleted_at IS NULL)
+		WHEN 'coworking' THEN (SELECT COALESCE(TRIM(responsible_employee_id), '') FROM coworkings WHERE id = d.entity_id AND deleted_at IS NULL)
+	END`
+
+type delegation struct {
+	ID                  int64      `json:"id"`
+	EntityType          string     `json:"entity_type"`
+	EntityID            int64      `json:"entity_id"`
+	EntityName          string     `json:"entity_name"`
+	DelegatorEmployeeID string     `json:"delegator_employee_id"`
+	DelegatorName       string     `json:"delegator_name"`
+	DeputyEmployeeID    string     `json:"deputy_employee_id"`
+	DeputyName          string     `json:"deputy_name"`
+	StartsAt            time.Time  `json:"starts_at"`
+	EndsAt              time.Time  `json:"ends_at"`
+	Reason              string     `json:"reason"`
+	CreatedByEmployeeID string     `json:"created_by_employee_id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	RevokedAt           *time.Time `json:"revoked_at,omitempty"`
+	RevokedByEmployeeID string     `json:"revoked_by_employee_id,omitempty"`
+	Status              string     `json:"status"`
+}
+
+type delegationRequest struct {
+	EntityType       string     `json:"entity_type"`
+	EntityID         int64      `json:"entity_id"`
+	DeputyEmployeeID string     `json:"deputy_employee_id"`
+	StartsAt         *time.Time `json:"starts_at"`
+	EndsAt           time.Time  `json:"ends_at"`
+	Reason           string     `json:"reason"`
+}
+
+type delegationTarget struct {
+	BuildingID    int64
+	Name          string
+	ResponsibleID string
+}
+
+func ensureDelegationStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS responsibility_delegations (
+			id BIGSERIAL PRIMARY KEY,
+			entity_type TEXT NOT NULL,
+			entity_id BIGINT NOT NULL,
+			delegator_employee_id TEXT NOT NULL DEFAULT '',
+			deputy_employee_id TEXT NOT NULL,
+			starts_at TIMESTAMPTZ NOT NULL,
+			ends_at TIMESTAMPTZ NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_by_employee_id TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			revoked_at TIMESTAMPTZ,
+			revoked_by_employee_id TEXT NOT NULL DEFAULT '',
+			CHECK (ends_at > starts_at)
+		);`,
+		`CREATE INDEX IF NOT EXISTS responsibility_delegations_deputy_idx ON responsibility_delegations (deputy_employee_id, ends_at) WHERE revoked_at IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS responsibility_delegations_entity_idx ON responsibility_delegations (entity_type, entity_id, ends_at DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delegatedResponsibleSQL returns a column that equals the employee ID in
+// eidArg when an active delegation makes them a deputy on one of levels,
+// and an empty string otherwise, so the permission checks can pass it to
+// canActInBuilding next to the responsible IDs. levels are the b, f and c
+// aliases of office_buildings, floors and coworkings in the outer query.
+func delegatedResponsibleSQL(eidArg string, levels ...string) string {
+	tuples := make([]string, 0, len(levels))
+	for _, alias := range levels {
+		tuples = append(tuples, fmt.Sprintf(
+			"('%[1]s', %[2]s.id, COALESCE(TRIM(%[2]s.responsible_employee_id), ''))",
+			delegationLevelTypes[alias], alias,
+		))
+	}
+	return `CASE WHEN EXISTS (
+		        SELECT 1 FROM responsibility_delegations d
+		         WHERE d.deputy_employee_id = ` + eidArg + `
+		           AND d.revoked_at IS NULL
+		           AND d.starts_at <= now() AND d.ends_at > now()
+		           AND (d.entity_type, d.entity_id, d.delegator_employee_id) IN (` + strings.Join(tuples, ", ") + `)
+		        ) THEN ` + eidArg + `::text ELSE '' END`
+}
+
+// validateDelegationPeriod checks the requested period against now.
+func validateDelegationPeriod(startsAt, endsAt, now time.Time) error {
+	if !endsAt.After(startsAt) || !endsAt.After(now) {
+		return errDelegationPeriodInvalid
+	}
+	if endsAt.Sub(startsAt) > delegationMaxPeriod {
+		return errDelegationPeriodTooLong
+	}
+	return nil
+}
+
+// delegationStatus derives the status of d; currentResponsible is invalid
+// when the entity no longer exists.
+func delegationStatus(d delegation, currentResponsible sql.NullString, now time.Time) string {
+	switch {
+	case d.RevokedAt != nil:
+		return delegationStatusRevoked
+	case !d.EndsAt.After(now):
+		return delegationStatusExpired
+	case !currentResponsible.Valid || currentResponsible.String != d.DelegatorEmployeeID:
+		return delegationStatusLapsed
+	case d.StartsAt.After(now):
+		return delegationStatusScheduled
+	default:
+		return delegationStatusActive
+	}
+}
+
+func (a *app) loadDelegationTarget(ctx context.Context, entityType string, entityID int64) (delegationTarget, error) {
+	var target delegationTarget
+	query, ok := delegationTargetQueries[entityType]
+	if !ok {
+		return target, errDelegationEntityNotFound
+	}
+	err := a.db.QueryRowContext(ctx, query, entityID).Scan(&target.BuildingID, &target.Name, &target.ResponsibleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return target, errDelegationEntityNotFound
+	}
+	return target, err
+}
+
+// queryDelegations lists delegations matching where (a condition on d).
+func (a *app) queryDelegations(ctx context.Context, where string, args ...any) ([]delegation, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT d.id,
+		        d.entity_type,
+		        d.entity_id,
+		        `+fmt.Sprintf(responsibilityEntityNameSQL, "d.entity_type", "d.entity_id")+`,
+		        d.delegator_employee_id,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "d.delegator_employee_id")+`,
+		        d.deputy_employee_id,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "d.deputy_employee_id")+`,
+		        d.starts_at,
+		        d.ends_at,
+		        d.reason,
+		        d.created_by_employee_id,
+		        d.created_at,
+		        d.revoked_at,
+		        d.revoked_by_employee_id,
+		        `+delegationCurrentResponsibleSQL+`
+		   FROM responsibility_delegations d
+		  WHERE `+where+`
+		  ORDER BY d.starts_at DESC, d.id DESC
+		  LIMIT `+strconv.Itoa(delegationListLimit),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	items := make([]delegation, 0)
+	for rows.Next() {
+		var (
+			d                  delegation
+			revokedAt          sql.NullTime
+			currentResponsible sql.NullString
+		)
+		if err := rows.Scan(
+			&d.ID,
+			&d.EntityType,
+			&d.EntityID,
+			&d.EntityName,
+			&d.DelegatorEmployeeID,
+			&d.DelegatorName,
+			&d.DeputyEmployeeID,
+			&d.DeputyName,
+			&d.StartsAt,
+			&d.EndsAt,
+			&d.Reason,
+			&d.CreatedByEmployeeID,
+			&d.CreatedAt,
+			&revokedAt,
+			&d.RevokedByEmployeeID,
+			&currentResponsible,
+		); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			d.RevokedAt = &revokedAt.Time
+		}
+		d.Status = delegationStatus(d, currentResponsible, now)
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// loadActiveDelegations returns the entities currently delegated to
+// employeeID, by entity type.
+func (a *app) loadActiveDelegations(ctx context.Context, employeeID string) (map[string][]int64, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT d.entity_type, d.entity_id
+		   FROM responsibility_delegations d
+		  WHERE d.deputy_employee_id = $1
+		    AND d.revoked_at IS NULL
+		    AND d.starts_at <= now() AND d.ends_at > now()
+		    AND d.delegator_employee_id = `+delegationCurrentResponsibleSQL+`
+		  ORDER BY d.entity_type, d.entity_id`,
+		employeeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	delegated := make(map[string][]int64)
+	for rows.Next() {
+		var (
+			entityType string
+			entityID   int64
+		)
+		if err := rows.Scan(&entityType, &entityID); err != nil {
+			return nil, err
+		}
+		delegated[entityType] = append(delegated[entityType], entityID)
+	}
+	return delegated, rows.Err()
+}
+
+// appendMissingIDs appends the IDs from extra that ids does not contain yet.
+func appendMissingIDs(ids, extra []int64) []int64 {
+	for _, id := range extra {
+		found := false
+		for _, existing := range ids {
+			if existing == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// handleDelegations manages temporary deputies.
+// GET    /api/delegations?entity_type=&entity_id=&employee_id=&include_past=1
+// POST   /api/delegations
+// DELETE /api/delegations/{id}
+func (a *app) handleDelegations(w http.ResponseWriter, r *http.Request) {
+	suffix := strings.TrimPrefix(r.URL.Path, delegationsPath)
+	switch {
+	case (suffix == "" || suffix == "/") && r.Method == http.MethodGet:
+		a.listDelegations(w, r)
+	case (suffix == "" || suffix == "/") && r.Method == http.MethodPost:
+		a.createDelegation(w, r)
+	case suffix != "" && suffix != "/" && r.Method == http.MethodDelete:
+		id, err := strconv.ParseInt(strings.TrimPrefix(suffix, "/"), 10, 64)
+		if err != nil || id <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid delegation id")
+			return
+		}
+		a.revokeDelegation(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *app) listDelegations(w http.ResponseWriter, r *http.Request) {
+	role, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return
+	}
+	eid := a.requestActorEmployeeID(r)
+	query := r.URL.Query()
+
+	conditions := []string{"TRUE"}
+	var args []any
+	addArg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !hasRequestPermission(r, role, permissionViewAnyResponsibilities) {
+		if eid == "" {
+			respondError(w, http.StatusForbidden, "Недостаточно прав")
+			return
+		}
+		p := addArg(eid)
+		conditions = append(conditions, "(d.delegator_employee_id = "+p+" OR d.deputy_employee_id = "+p+" OR d.created_by_employee_id = "+p+")")
+	}
+	if entityType := strings.TrimSpace(query.Get("entity_type")); entityType != "" {
+		if _, ok := delegationTargetQueries[entityType]; !ok {
+			respondError(w, http.StatusBadRequest, "entity_type must be building, floor or coworking")
+			return
+		}
+		conditions = append(conditions, "d.entity_type = "+addArg(entityType))
+	}
+	if raw := strings.TrimSpace(query.Get("entity_id")); raw != "" {
+		entityID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || entityID <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid entity_id")
+			return
+		}
+		conditions = append(conditions, "d.entity_id = "+addArg(entityID))
+	}
+	if employeeID := strings.TrimSpace(query.Get("employee_id")); employeeID != "" {
+		p := addArg(employeeID)
+		conditions = append(conditions, "(d.delegator_employee_id = "+p+" OR d.deputy_employee_id = "+p+")")
+	}
+	if includePast, _ := strconv.ParseBool(query.Get("include_past")); !includePast {
+		conditions = append(conditions, "d.revoked_at IS NULL AND d.ends_at > now()")
+	}
+
+	items, err := a.queryDelegations(r.Context(), strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *app) createDelegation(w http.ResponseWriter, r *http.Request) {
+	role, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return
+	}
+	var req delegationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.EntityType = strings.TrimSpace(req.EntityType)
+	req.DeputyEmployeeID = strings.TrimSpace(req.DeputyEmployeeID)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if _, ok := delegationTargetQueries[req.EntityType]; !ok {
+		respondError(w, http.StatusBadRequest, "entity_type must be building, floor or coworking")
+		return
+	}
+	if req.EntityID <= 0 {
+		respondError(w, http.StatusBadRequest, "entity_id is required")
+		return
+	}
+	if req.DeputyEmployeeID == "" {
+		respondError(w, http.StatusBadRequest, "deputy_employee_id is required")
+		return
+	}
+	now := time.Now()
+	startsAt := now
+	if req.StartsAt != nil && !req.StartsAt.IsZero() {
+		startsAt = *req.StartsAt
+	}
+	if err := validateDelegationPeriod(startsAt, req.EndsAt, now); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	target, err := a.loadDelegationTarget(r.Context(), req.EntityType, req.EntityID)
+	if err != nil {
+		if errors.Is(err, errDelegationEntityNotFound) {
+			respondError(w, http.StatusNotFound, req.EntityType+" not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	eid := a.requestActorEmployeeID(r)
+	if !isResponsibleEmployee(eid, target.ResponsibleID) && !hasPermissionInBuilding(role, permissionManageLayout, target.BuildingID) {
+		respondError(w, http.StatusForbidden, "Недостаточно прав")
+		return
+	}
+	if err := a.validateDelegationDeputy(r.Context(), req.DeputyEmployeeID, target.ResponsibleID); err != nil {
+		if errors.Is(err, errDelegationDeputyInvalid) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	var id int64
+	if err := a.db.QueryRowContext(r.Context(),
+		`INSERT INTO responsibility_delegations (
+			entity_type,
+			entity_id,
+			delegator_employee_id,
+			deputy_employee_id,
+			starts_at,
+			ends_at,
+			reason,
+			created_by_employee_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		req.EntityType,
+		req.EntityID,
+		target.ResponsibleID,
+		req.DeputyEmployeeID,
+		startsAt,
+		req.EndsAt,
+		req.Reason,
+		eid,
+	).Scan(&id); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	items, err := a.queryDelegations(r.Context(), "d.id = $1", id)
+	if err != nil || len(items) == 0 {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	created := items[0]
+	a.logAuditEventFromRequest(r, auditActionDelegate, created.EntityType, created.EntityID, target.Name, map[string]any{
+		"delegation_id":         created.ID,
+		"delegator_employee_id": created.DelegatorEmployeeID,
+		"delegator_name":        created.DelegatorName,
+		"deputy_employee_id":    created.DeputyEmployeeID,
+		"deputy_name":           created.DeputyName,
+		"starts_at":             created.StartsAt.UTC().Format(time.RFC3339),
+		"ends_at":               created.EndsAt.UTC().Format(time.RFC3339),
+		"reason":                created.Reason,
+	})
+	respondJSON(w, http.StatusCreated, created)
+}
+
+func (a *app) validateDelegationDeputy(ctx context.Context, deputyID, responsibleID string) error {
+	if deputyID == responsibleID || isServiceAccountPrincipal(deputyID) {
+		return errDelegationDeputyInvalid
+	}
+	var ok bool
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE employee_id = $1 AND active)`,
+		deputyID,
+	).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return errDelegationDeputyInvalid
+	}
+	return nil
+}
+
+// revokeDelegation ends a delegation early. The delegator, the deputy, the
+// employee who granted it and roles with manage_layout in the building may
+// do so.
+func (a *app) revokeDelegation(w http.ResponseWriter, r *http.Request, id int64) {
+	role, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return
+	}
+	items, err := a.queryDelegations(r.Context(), "d.id = $1", id)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if len(items) == 0 {
+		respondError(w, http.StatusNotFound, "delegation not found")
+		return
+	}
+	d := items[0]
+
+	eid := a.requestActorEmployeeID(r)
+	allowed := isResponsibleEmployee(eid, d.DelegatorEmployeeID, d.DeputyEmployeeID, d.CreatedByEmployeeID) ||
+		hasRequestPermission(r, role, permissionManageLayout)
+	if !allowed {
+		target, err := a.loadDelegationTarget(r.Context(), d.EntityType, d.EntityID)
+		if err != nil && !errors.Is(err, errDelegationEntityNotFound) {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		allowed = err == nil && hasPermissionInBuilding(role, permissionManageLayout, target.BuildingID)
+	}
+	if !allowed {
+		respondError(w, http.StatusForbidden, "Недостаточно прав")
+		return
+	}
+	if d.Status == delegationStatusRevoked || d.Status == delegationStatusExpired {
+		respondError(w, http.StatusConflict, "delegation has already ended")
+		return
+	}
+
+	res, err := a.db.ExecContext(r.Context(),
+		`UPDATE responsibility_delegations
+		    SET revoked_at = now(), revoked_by_employee_id = $2
+		  WHERE id = $1 AND revoked_at IS NULL`,
+		id, eid,
+	)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		respondError(w, http.StatusConflict, "delegation has already ended")
+		return
+	}
+	a.logAuditEventFromRequest(r, auditActionRevoke, d.EntityType, d.EntityID, d.EntityName, map[string]any{
+		"delegation_id":         d.ID,
+		"delegator_employee_id": d.DelegatorEmployeeID,
+		"deputy_employee_id":    d.DeputyEmployeeID,
+		"deputy_name":           d.DeputyName,
+		"ends_at":               d.EndsAt.UTC().Format(time.RFC3339),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDelegationPeriod(t *testing.T) {
+	now := time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		starts   time.Time
+		ends     time.Time
+		expected error
+	}{
+		{"two weeks", now, now.Add(14 * 24 * time.Hour), nil},
+		{"scheduled", now.Add(24 * time.Hour), now.Add(48 * time.Hour), nil},
+		{"ends before start", now.Add(48 * time.Hour), now.Add(24 * time.Hour), errDelegationPeriodInvalid},
+		{"already over", now.Add(-48 * time.Hour), now.Add(-time.Hour), errDelegationPeriodInvalid},
+		{"too long", now, now.Add(delegationMaxPeriod + time.Hour), errDelegationPeriodTooLong},
+	}
+	for _, tc := range cases {
+		if err := validateDelegationPeriod(tc.starts, tc.ends, now); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.expected)
+		}
+	}
+}
+
+func TestDelegationStatus(t *testing.T) {
+	now := time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)
+	base := delegation{
+		DelegatorEmployeeID: "10",
+		StartsAt:            now.Add(-time.Hour),
+		EndsAt:              now.Add(time.Hour),
+	}
+	responsible := sql.NullString{String: "10", Valid: true}
+
+	if got := delegationStatus(base, responsible, now); got != delegationStatusActive {
+		t.Fatalf("active: got %s", got)
+	}
+	scheduled := base
+	scheduled.StartsAt = now.Add(time.Minute)
+	if got := delegationStatus(scheduled, responsible, now); got != delegationStatusScheduled {
+		t.Fatalf("scheduled: got %s", got)
+	}
+	if got := delegationStatus(base, sql.NullString{String: "20", Valid: true}, now); got != delegationStatusLapsed {
+		t.Fatalf("new responsible: got %s", got)
+	}
+	if got := delegationStatus(base, sql.NullString{}, now); got != delegationStatusLapsed {
+		t.Fatalf("deleted entity: got %s", got)
+	}
+	expired := base
+	expired.EndsAt = now
+	if got := delegationStatus(expired, responsible, now); got != delegationStatusExpired {
+		t.Fatalf("expired: got %s", got)
+	}
+	revoked := base
+	revoked.RevokedAt = &now
+	if got := delegationStatus(revoked, responsible, now); got != delegationStatusRevoked {
+		t.Fatalf("revoked: got %s", got)
+	}
+}
+
+func TestDelegatedResponsibleSQLLevels(t *testing.T) {
+	query := delegatedResponsibleSQL("$3", "b", "c")
+	for _, want := range []string{
+		"d.deputy_employee_id = $3",
+		"THEN $3::text",
+		"('building', b.id, COALESCE(TRIM(b.responsible_employee_id), ''))",
+		"('coworking', c.id, COALESCE(TRIM(c.responsible_employee_id), ''))",
+	} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("query does not contain %q:\n%s", want, query)
+		}
+	}
+	if strings.Contains(query, "'floor'") {
+		t.Fatalf("unexpected floor level:\n%s", query)
+	}
+}
+
+func TestAppendMissingIDs(t *testing.T) {
+	got := appendMissingIDs([]int64{1, 2}, []int64{2, 3, 3})
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("got %v", got)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errFloorLevelTaken = errors.New("floor level is already taken")
+var errFloorOrderMismatch = errors.New("floors must list every floor of the building exactly once")
+var errFloorLevelsNotIncreasing = errors.New("levels must be strictly increasing from bottom to top")
+var errFloorLevelsPartial = errors.New("level must be set for every floor or for none")
+
+// floorOrderTempLevelBase is where floors are parked while a reorder is in
+// progress so that the unique (building_id, level) index never sees two active
+// floors on the same level.
+const floorOrderTempLevelBase = -1000000
+
+type floorOrderItem struct {
+	ID    int64   `json:"id"`
+	Level *int    `json:"level"`
+	Name  *string `json:"name"`
+}
+
+// floorMove describes how a single floor changed during a reorder.
+type floorMove struct {
+	ID          int64  `json:"id"`
+	BeforeLevel int    `json:"before_level"`
+	AfterLevel  int    `json:"after_level"`
+	BeforeName  string `json:"before_name"`
+	AfterName   string `json:"after_name"`
+}
+
+// ensureFloorLevelIndex makes a level unique among the active floors of a
+// building. Existing installations may already contain duplicates; those are
+// reported and left for an administrator to resolve with the reorder
+// endpoint, and the index is created on the first start after that.
+func ensureFloorLevelIndex(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(
+		`SELECT to_regclass('floors_building_level_active_uidx') IS NOT NULL`,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	var duplicates int
+	if err := db.QueryRow(
+		`SELECT COUNT(*) FROM (
+		   SELECT building_id, level
+		     FROM floors
+		    WHERE deleted_at IS NULL
+		    GROUP BY building_id, level
+		   HAVING COUNT(*) > 1
+		 ) d`,
+	).Scan(&duplicates); err != nil {
+		return err
+	}
+	if duplicates > 0 {
+		log.Printf("WARNING: %d building level(s) are shared by several floors; floors_building_level_active_uidx is created once they are reordered", duplicates)
+		return nil
+	}
+	_, err := db.Exec(
+		`CREATE UNIQUE INDEX IF NOT EXISTS floors_building_level_active_uidx
+		 ON floors (building_id, level)
+		 WHERE deleted_at IS NULL`,
+	)
+	return err
+}
+
+// defaultFloorName is the name given to generated floors: "B1", "B2", ... for
+// underground levels and the level number otherwise.
+func defaultFloorName(level int) string {
+	if level < 0 {
+		return fmt.Sprintf("B%d", -level)
+	}
+	return strconv.Itoa(level)
+}
+
+func floorLevelTakenInTx(ctx context.Context, tx *sql.Tx, buildingID int64, level int, excludeFloorID int64) (bool, error) {
+	var taken bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (
+		   SELECT 1 FROM floors
+		    WHERE building_id = $1 AND level = $2 AND id <> $3 AND deleted_at IS NULL
+		 )`,
+		buildingID,
+		level,
+		excludeFloorID,
+	).Scan(&taken)
+	return taken, err
+}
+
+func (a *app) floorLevelTaken(buildingID int64, level int) (bool, error) {
+	var taken bool
+	err := a.db.QueryRow(
+		`SELECT EXISTS (
+		   SELECT 1 FROM floors WHERE building_id = $1 AND level = $2 AND deleted_at IS NULL
+		 )`,
+		buildingID,
+		level,
+	).Scan(&taken)
+	return taken, err
+}
+
+// reorderFloors applies a new bottom-to-top order to the floors of a
+// building. When no levels are given the building keeps its current set of
+// levels and the floors are redistributed over them, so a basement stays a
+// basement. Floors that still carry a generated name are renamed to match
+// their new level. Only floors that actually changed are returned.
+func (a *app) reorderFloors(ctx context.Context, buildingID int64, items []floorOrderItem) ([]floorMove, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var lockedID int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT id FROM office_buildings WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		buildingID,
+	).Scan(&lockedID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, name, level FROM floors
+		  WHERE building_id = $1 AND deleted_at IS NULL
+		  ORDER BY level, id
+		  FOR UPDATE`,
+		buildingID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[int64]floorMove)
+	levels := make([]int, 0)
+	for rows.Next() {
+		var item floorMove
+		if err := rows.Scan(&item.ID, &item.BeforeName, &item.BeforeLevel); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		current[item.ID] = item
+		levels = append(levels, item.BeforeLevel)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	if len(items) != len(current) {
+		return nil, errFloorOrderMismatch
+	}
+	explicitLevels := 0
+	seen := make(map[int64]struct{}, len(items))
+	for _, item := range items {
+		if _, ok := current[item.ID]; !ok {
+			return nil, errFloorOrderMismatch
+		}
+		if _, dup := seen[item.ID]; dup {
+			return nil, errFloorOrderMismatch
+		}
+		seen[item.ID] = struct{}{}
+		if item.Level != nil {
+			explicitLevels++
+		}
+		if item.Name != nil && strings.TrimSpace(*item.Name) == "" {
+			return nil, errNameRequired
+		}
+	}
+	if explicitLevels != 0 && explicitLevels != len(items) {
+		return nil, errFloorLevelsPartial
+	}
+
+	moves := make([]floorMove, 0, len(items))
+	for idx, item := range items {
+		move := current[item.ID]
+		move.AfterLevel = levels[idx]
+		if item.Level != nil {
+			move.AfterLevel = *item.Level
+			if idx > 0 && move.AfterLevel <= *items[idx-1].Level {
+				return nil, errFloorLevelsNotIncreasing
+			}
+		}
+		move.AfterName = move.BeforeName
+		if item.Name != nil {
+			move.AfterName = strings.TrimSpace(*item.Name)
+		} else if move.AfterLevel != move.BeforeLevel && move.BeforeName == defaultFloorName(move.BeforeLevel) {
+			move.AfterName = defaultFloorName(move.AfterLevel)
+		}
+		if move.AfterLevel != move.BeforeLevel || move.AfterName != move.BeforeName {
+			moves = append(moves, move)
+		}
+	}
+	if len(moves) == 0 {
+		return moves, nil
+	}
+
+	// Park every moving floor on a temporary level first so that swaps do not
+	// collide with each other on the unique index.
+	for idx, move := range moves {
+		if move.AfterLevel == move.BeforeLevel {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE floors SET level = $2 WHERE id = $1`,
+			move.ID,
+			floorOrderTempLevelBase-idx,
+		); err != nil {
+			return nil, err
+		}
+	}
+	for _, move := range moves {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE floors SET level = $2, name = $3 WHERE id = $1`,
+			move.ID,
+			move.AfterLevel,
+			move.AfterName,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := syncBuildingFloorIDsInTx(tx, buildingID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+func (a *app) handleBuildingFloorOrder(w http.ResponseWriter, r *http.Request, buildingID int64) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		Floors []floorOrderItem `json:"floors"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.ensureCanManageBuilding(w, r, buildingID) {
+		return
+	}
+	existing, err := a.getBuilding(buildingID)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			respondError(w, http.StatusNotFound, "building not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	moves, err := a.reorderFloors(r.Context(), buildingID, payload.Floors)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotFound):
+			respondError(w, http.StatusNotFound, "building not found")
+		case errors.Is(err, errFloorOrderMismatch),
+			errors.Is(err, errFloorLevelsNotIncreasing),
+			errors.Is(err, errFloorLevelsPartial),
+			errors.Is(err, errNameRequired):
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	for _, move := range moves {
+		a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityFloor, move.ID, move.AfterName, map[string]any{
+			"floor_id":      move.ID,
+			"floor_name":    move.AfterName,
+			"floor_level":   move.AfterLevel,
+			"building_id":   existing.ID,
+			"building_name": existing.Name,
+			"changes":       describeFloorMove(move),
+			"before_level":  move.BeforeLevel,
+			"after_level":   move.AfterLevel,
+			"before_name":   move.BeforeName,
+			"after_name":    move.AfterName,
+			"reason":        "reorder",
+		})
+	}
+	items, err := a.listFloorsByBuilding(buildingID)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items, "moves": moves})
+}
+
+func describeFloorMove(move floorMove) []string {
+	changes := make([]string, 0, 2)
+	if move.BeforeLevel != move.AfterLevel {
+		changes = append(changes, fmt.Sprintf(
+			"Уровень: %d -> %d (%s -> %s)",
+			move.BeforeLevel,
+			move.AfterLevel,
+			floorLabel(move.BeforeName, move.BeforeLevel),
+			floorLabel(move.AfterName, move.AfterLevel),
+		))
+	}
+	if move.BeforeName != move.AfterName {
+		changes = append(changes, fmt.Sprintf("Название: %q -> %q", move.BeforeName, move.AfterName))
+	}
+	return changes
+}
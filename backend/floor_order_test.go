@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestFloorLabel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		floorName string
+		level     int
+		want      string
+	}{
+		{name: "custom name", floorName: "Мезонин", level: 2, want: "Мезонин"},
+		{name: "basement name", floorName: "B2", level: -2, want: "B2"},
+		{name: "numeric name", floorName: "3", level: 3, want: "Этаж 3"},
+		{name: "negative numeric name", floorName: "-1", level: -1, want: "Подземный этаж 1"},
+		{name: "unnamed underground", level: -2, want: "Подземный этаж 2"},
+		{name: "unnamed aboveground", level: 4, want: "Этаж 4"},
+		{name: "unnamed ground level", level: 0, want: ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := floorLabel(tt.floorName, tt.level); got != tt.want {
+				t.Fatalf("floorLabel(%q, %d) = %q, want %q", tt.floorName, tt.level, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeFloorMoveRenamesGeneratedName(t *testing.T) {
+	t.Parallel()
+
+	move := floorMove{ID: 1, BeforeLevel: 2, AfterLevel: 3, BeforeName: "2", AfterName: "3"}
+	changes := describeFloorMove(move)
+	if len(changes) != 2 {
+		t.Fatalf("expected level and name changes, got %v", changes)
+	}
+	if changes[0] != "Уровень: 2 -> 3 (Этаж 2 -> Этаж 3)" {
+		t.Fatalf("unexpected level change: %q", changes[0])
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	identityProviderWB   = "wb"
+	identityProviderOIDC = "oidc"
+
+	defaultWBAuthBaseURL = "https://auth-hrtech.wb.ru"
+	defaultWBTeamBaseURL = "https://team.wb.ru"
+)
+
+var errIdentityFlowNotSupported = errors.New("login flow is not supported by the configured identity provider")
+
+// identityProvider authenticates users against an external identity service.
+// Whatever the login flow looks like, it ends with a verifiedUserInfo that is
+// stored with upsertUserInfo and exchanged for office tokens.
+type identityProvider interface {
+	Name() string
+	// VerifyToken validates a bearer token issued by the provider and returns
+	// the identity it belongs to. It must never trust unverified claims.
+	VerifyToken(ctx context.Context, token string, r *http.Request) (*verifiedUserInfo, error)
+}
+
+// verifiedUserInfo holds identity fields confirmed by the identity provider.
+// WbUserID is the provider's stable subject; for providers other than WB it
+// is namespaced ("oidc:<sub>") so that subjects never collide with WB ids.
+type verifiedUserInfo struct {
+	WbUserID   string
+	UserName   string
+	FullName   string
+	EmployeeID string
+	ProfileID  string
+	AvatarURL  string
+}
+
+// upstreamDoer sends a request to an external auth service; the app wires in
+// doUpstreamRequest so providers share its retry policy.
+type upstreamDoer func(req *http.Request, allowRetry bool) (*http.Response, error)
+
+// loadIdentityProviderFromEnv builds the provider selected by
+// IDENTITY_PROVIDER (wb by default), or the dev mock when
+// OFFICE_DEV_MOCK_IDP is set. stateKey signs short-lived login state for
+// redirect-based providers.
+func loadIdentityProviderFromEnv(client *http.Client, do upstreamDoer, stateKey []byte) (identityProvider, error) {
+	kind := strings.ToLower(strings.TrimSpace(os.Getenv("IDENTITY_PROVIDER")))
+	mock, err := loadMockIdentityProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if mock != nil {
+		if kind != "" && kind != identityProviderWB {
+			return nil, fmt.Errorf("OFFICE_DEV_MOCK_IDP replaces the wb provider and cannot be combined with IDENTITY_PROVIDER=%s", kind)
+		}
+		return mock, nil
+	}
+	switch kind {
+	case "", identityProviderWB:
+		return newWBIdentityProvider(
+			strings.TrimSpace(os.Getenv("WB_AUTH_BASE_URL")),
+			strings.TrimSpace(os.Getenv("WB_TEAM_BASE_URL")),
+			do,
+		)
+	case identityProviderOIDC:
+		cfg, err := loadOIDCConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if len(stateKey) == 0 {
+			stateKey = make([]byte, 32)
+			if _, err := rand.Read(stateKey); err != nil {
+				return nil, err
+			}
+			log.Println("WARNING: OFFICE_REFRESH_PEPPER is not set — OIDC login state is signed with a per-process key")
+		}
+		return newOIDCIdentityProvider(cfg, client, stateKey)
+	default:
+		return nil, fmt.Errorf("unknown IDENTITY_PROVIDER %q (expected wb or oidc)", kind)
+	}
+}
+
+// wbIdentityProvider is the phone + one-time code login of auth-hrtech.wb.ru
+// with user data from team.wb.ru. Both base URLs are configurable so the
+// flow can be pointed at a stand-in service.
+type wbIdentityProvider struct {
+	authBaseURL string
+	teamBaseURL string
+	do          upstreamDoer
+}
+
+func newWBIdentityProvider(authBaseURL, teamBaseURL string, do upstreamDoer) (*wbIdentityProvider, error) {
+	if authBaseURL == "" {
+		authBaseURL = defaultWBAuthBaseURL
+	}
+	if teamBaseURL == "" {
+		teamBaseURL = defaultWBTeamBaseURL
+	}
+	for name, raw := range map[string]string{"WB_AUTH_BASE_URL": authBaseURL, "WB_TEAM_BASE_URL": teamBaseURL} {
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s must be an absolute http(s) URL", name)
+		}
+	}
+	if do == nil {
+		do = func(req *http.Request, _ bool) (*http.Response, error) {
+			return http.DefaultClient.Do(req)
+		}
+	}
+	return &wbIdentityProvider{
+		authBaseURL: strings.TrimRight(authBaseURL, "/"),
+		teamBaseURL: strings.TrimRight(teamBaseURL, "/"),
+		do:          do,
+	}, nil
+}
+
+func (p *wbIdentityProvider) Name() string {
+	return identityProviderWB
+}
+
+func (p *wbIdentityProvider) authURL(path string) string {
+	return p.authBaseURL + path
+}
+
+func (p *wbIdentityProvider) teamURL(path string) string {
+	return p.teamBaseURL + path
+}
+
+// setTeamHeaders makes a request look like it comes from the team.wb.ru web
+// client, which the upstream API expects.
+func (p *wbIdentityProvider) setTeamHeaders(req *http.Request, token string) {
+	req.Header.Set("accept", "application/json, text/plain, */*")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Header.Set("cache-control", "no-cache")
+	req.Header.Set("pragma", "no-cache")
+	req.Header.Set("referer", p.teamURL("/account"))
+	req.Header.Set("sec-fetch-dest", "empty")
+	req.Header.Set("sec-fetch-mode", "cors")
+	req.Header.Set("sec-fetch-site", "same-origin")
+	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
+}
+
+// setAuthHeaders sets the headers expected by the code login endpoints.
+func (p *wbIdentityProvider) setAuthHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("Origin", p.teamBaseURL)
+	req.Header.Set("Referer", p.teamBaseURL+"/")
+}
+
+// VerifyToken validates the bearer token by calling the team user info
+// endpoint. If it responds with valid user data, we trust the identity; an
+// error response or a failed request rejects the token.
+//
+// This is the ONLY way to confirm that a WB token is legitimate — we cannot
+// verify its signature locally because the signing key belongs to the
+// external auth service.
+func (p *wbIdentityProvider) VerifyToken(ctx context.Context, token string, originalReq *http.Request) (*verifiedUserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.teamURL("/api/v1/user/info"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("verifyExternalToken: create request: %w", err)
+	}
+	p.setTeamHeaders(req, token)
+
+	if originalReq != nil {
+		cookie, _ := collectCookies(originalReq)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+	}
+
+	resp, err := p.do(req, true)
+	if err != nil {
+		return nil, fmt.Errorf("verifyExternalToken: upstream request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("verifyExternalToken: upstream returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("verifyExternalToken: read response: %w", err)
+	}
+
+	var result struct {
+		Status int `json:"status"`
+		Data   struct {
+			ID         any    `json:"id"`
+			WbUserID   any    `json:"wbUserID"`
+			EmployeeID any    `json:"employeeID"`
+			FullName   string `json:"fullName"`
+			Name       string `json:"name"`
+			AvatarURL  string `json:"avatar_url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("verifyExternalToken: parse response: %w", err)
+	}
+
+	wbUserID := normalizeIDString(result.Data.WbUserID)
+	employeeID := normalizeIDString(result.Data.EmployeeID)
+	userName := firstNonEmptyString(result.Data.FullName, result.Data.Name)
+
+	if wbUserID == "" && employeeID == "" {
+		return nil, fmt.Errorf("verifyExternalToken: upstream returned empty identity")
+	}
+
+	return &verifiedUserInfo{
+		WbUserID:   wbUserID,
+		UserName:   userName,
+		FullName:   strings.TrimSpace(result.Data.FullName),
+		EmployeeID: employeeID,
+		ProfileID:  normalizeIDString(result.Data.ID),
+		AvatarURL:  strings.TrimSpace(result.Data.AvatarURL),
+	}, nil
+}
+
+// requireWBIdentity returns the WB provider for the endpoints that proxy its
+// code login flow, or answers 404 when another provider is configured.
+func (a *app) requireWBIdentity(w http.ResponseWriter) (*wbIdentityProvider, bool) {
+	provider, ok := a.identity.(*wbIdentityProvider)
+	if !ok {
+		respondError(w, http.StatusNotFound, errIdentityFlowNotSupported.Error())
+		return nil, false
+	}
+	return provider, true
+}
+
+// handleAuthProvider tells the frontend which login flow to show.
+// GET /api/auth/provider
+func (a *app) handleAuthProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.identity == nil {
+		respondError(w, http.StatusServiceUnavailable, "identity provider is not configured")
+		return
+	}
+	response := map[string]any{"provider": a.identity.Name()}
+	switch a.identity.(type) {
+	case *oidcIdentityProvider:
+		response["login"] = "redirect"
+		response["login_url"] = oidcLoginPath
+	default:
+		response["login"] = "code"
+	}
+	respondJSON(w, http.StatusOK, response)
+}
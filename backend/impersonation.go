@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Impersonation lets support reproduce what an employee sees. An admin with
+// impersonate_users gets a short-lived access token for the employee whose
+// claims also carry the admin; the admin's refresh cookie is left as is, so
+// the next refresh, or POST /api/auth/impersonation/stop, returns them to
+// their own session. Mutating requests are rejected unless the session was
+// started with allow_writes, and every request made with the token is
+// audited with both identities.
+const (
+	adminImpersonationPath    = "/api/admin/impersonation"
+	authImpersonationStopPath = "/api/auth/impersonation/stop"
+
+	auditEntityImpersonation       = "impersonation"
+	auditActionImpersonate         = "impersonate"
+	auditActionImpersonatedRequest = "impersonated_request"
+	auditActionEndImpersonation    = "end_impersonation"
+
+	impersonationDefaultTTL   = 15 * time.Minute
+	impersonationMaxTTL       = 30 * time.Minute
+	impersonationReasonMaxLen = 500
+	impersonationListLimit    = 100
+)
+
+var (
+	errImpersonationTargetInvalid  = errors.New("employee_id must be an active employee other than yourself")
+	errImpersonationRoleNotCovered = errors.New("cannot impersonate an employee whose role has permissions you do not hold")
+	errImpersonationReadOnly       = errors.New("impersonation session is read-only")
+	errImpersonationEnded          = errors.New("impersonation session has ended")
+)
+
+type impersonationRequest struct {
+	EmployeeID      string `json:"employee_id"`
+	Reason          string `json:"reason"`
+	AllowWrites     bool   `json:"allow_writes"`
+	DurationMinutes int    `json:"duration_minutes"`
+}
+
+type impersonationSession struct {
+	ID              int64      `json:"id"`
+	AdminEmployeeID string     `json:"admin_employee_id"`
+	AdminName       string     `json:"admin_name"`
+	EmployeeID      string     `json:"employee_id"`
+	EmployeeName    string     `json:"employee_name"`
+	Reason          string     `json:"reason"`
+	AllowWrites     bool       `json:"allow_writes"`
+	StartedAt       time.Time  `json:"started_at"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	RequestCount    int64      `json:"request_count"`
+	Active          bool       `json:"active"`
+}
+
+// sessionImpersonation is the flag /api/auth/session returns while an admin
+// acts as the session's employee.
+type sessionImpersonation struct {
+	ID                     int64  `json:"id"`
+	ImpersonatorEmployeeID string `json:"impersonator_employee_id"`
+	ImpersonatorName       string `json:"impersonator_name,omitempty"`
+	AllowWrites            bool   `json:"allow_writes"`
+}
+
+func ensureImpersonationStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS impersonation_sessions (
+			id BIGSERIAL PRIMARY KEY,
+			admin_employee_id TEXT NOT NULL,
+			employee_id TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			allow_writes BOOLEAN NOT NULL DEFAULT FALSE,
+			ip_address TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			ended_at TIMESTAMPTZ,
+			request_count BIGINT NOT NULL DEFAULT 0,
+			last_request_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS impersonation_sessions_started_idx ON impersonation_sessions (started_at DESC);`,
+		`CREATE INDEX IF NOT EXISTS impersonation_sessions_employee_idx ON impersonation_sessions (employee_id, started_at DESC);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// impersonatedAuditActor returns the actor an audit event should name. For
+// impersonated requests that is the admin; the employee they act as goes
+// into details.
+func impersonatedAuditActor(ctx context.Context, details map[string]any, employeeID, name string) (string, string) {
+	claims := officeAccessTokenClaimsFromContext(ctx)
+	if claims == nil || claims.Impersonation == nil {
+		return employeeID, name
+	}
+	details["impersonation_id"] = claims.Impersonation.ID
+	details["impersonated_employee_id"] = employeeID
+	details["impersonated_name"] = name
+	return claims.Impersonation.EmployeeID, claims.Impersonation.UserName
+}
+
+func sessionImpersonationFromClaims(claims *OfficeAccessTokenClaims) *sessionImpersonation {
+	if claims == nil || claims.Impersonation == nil {
+		return nil
+	}
+	return &sessionImpersonation{
+		ID:                     claims.Impersonation.ID,
+		ImpersonatorEmployeeID: claims.Impersonation.EmployeeID,
+		ImpersonatorName:       claims.Impersonation.UserName,
+		AllowWrites:            claims.Impersonation.AllowWrites,
+	}
+}
+
+// serveImpersonatedRequest runs a request authenticated with an impersonation
+// token: the session must still be open, writes need allow_writes, and the
+// outcome is audited under the admin.
+func (a *app) serveImpersonatedRequest(w http.ResponseWriter, r *http.Request, next http.Handler, claims *OfficeAccessTokenClaims) {
+	active, err := a.touchImpersonationSession(r.Context(), claims)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if !active {
+		respondError(w, http.StatusUnauthorized, errImpersonationEnded.Error())
+		return
+	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if isMutatingMethod(r.Method) && !claims.Impersonation.AllowWrites {
+		respondError(rec, http.StatusForbidden, errImpersonationReadOnly.Error())
+	} else {
+		next.ServeHTTP(rec, r)
+	}
+	a.logAuditEvent(context.WithoutCancel(r.Context()), auditLogWriteInput{
+		ActionType:      auditActionImpersonatedRequest,
+		EntityType:      auditEntityImpersonation,
+		EntityID:        claims.Impersonation.ID,
+		EntityName:      claims.UserName,
+		ActorEmployeeID: claims.Impersonation.EmployeeID,
+		ActorName:       claims.Impersonation.UserName,
+		Details: map[string]any{
+			"impersonated_employee_id": claims.EmployeeID,
+			"impersonated_name":        claims.UserName,
+			"method":                   r.Method,
+			"path":                     r.URL.Path,
+			"status":                   rec.status,
+		},
+	})
+}
+
+// touchImpersonationSession counts a request against the session and
+// reports whether it is still open. A session closes when it is stopped,
+// expires or its employee is deactivated.
+func (a *app) touchImpersonationSession(ctx context.Context, claims *OfficeAccessTokenClaims) (bool, error) {
+	if a.db == nil {
+		return false, nil
+	}
+	var id int64
+	err := a.db.QueryRowContext(ctx,
+		`UPDATE impersonation_sessions s
+		    SET request_count = request_count + 1,
+		        last_request_at = now()
+		  WHERE s.id = $1
+		    AND s.admin_employee_id = $2
+		    AND s.employee_id = $3
+		    AND s.ended_at IS NULL
+		    AND s.expires_at > now()
+		    AND EXISTS (SELECT 1 FROM users u WHERE u.employee_id = s.employee_id AND u.active)
+		RETURNING s.id`,
+		claims.Impersonation.ID, claims.Impersonation.EmployeeID, claims.EmployeeID,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// impersonationSessionOpen is touchImpersonationSession without counting,
+// for /api/auth/session.
+func (a *app) impersonationSessionOpen(ctx context.Context, claims *OfficeAccessTokenClaims) (bool, error) {
+	var open bool
+	err := a.db.QueryRowContext(ctx,
+		`SELECT EXISTS (
+			SELECT 1
+			  FROM impersonation_sessions s
+			 WHERE s.id = $1
+			   AND s.admin_employee_id = $2
+			   AND s.employee_id = $3
+			   AND s.ended_at IS NULL
+			   AND s.expires_at > now()
+		)`,
+		claims.Impersonation.ID, claims.Impersonation.EmployeeID, claims.EmployeeID,
+	).Scan(&open)
+	return open, err
+}
+
+func (a *app) handleAdminImpersonation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.listImpersonationSessions(w, r)
+	case http.MethodPost:
+		a.startImpersonation(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *app) listImpersonationSessions(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermission(w, r, a.db, permissionImpersonateUsers) {
+		return
+	}
+	conditions := []string{"TRUE"}
+	var args []any
+	if employeeID := strings.TrimSpace(r.URL.Query().Get("employee_id")); employeeID != "" {
+		args = append(args, employeeID)
+		conditions = append(conditions, "(s.employee_id = $1 OR s.admin_employee_id = $1)")
+	}
+	if activeOnly, _ := strconv.ParseBool(r.URL.Query().Get("active")); activeOnly {
+		conditions = append(conditions, "s.ended_at IS NULL AND s.expires_at > now()")
+	}
+	rows, err := a.db.QueryContext(r.Context(),
+		`SELECT s.id, s.admin_employee_id, `+fmt.Sprintf(responsibilityEmployeeNameSQL, "s.admin_employee_id")+`,
+		        s.employee_id, `+fmt.Sprintf(responsibilityEmployeeNameSQL, "s.employee_id")+`,
+		        s.reason, s.allow_writes, s.started_at, s.expires_at, s.ended_at, s.request_count,
+		        (s.ended_at IS NULL AND s.expires_at > now())
+		   FROM impersonation_sessions s
+		  WHERE `+strings.Join(conditions, " AND ")+`
+		  ORDER BY s.started_at DESC, s.id DESC
+		  LIMIT `+fmt.Sprint(impersonationListLimit),
+		args...,
+	)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer rows.Close()
+	items := make([]impersonationSession, 0)
+	for rows.Next() {
+		var (
+			item    impersonationSession
+			endedAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&item.ID, &item.AdminEmployeeID, &item.AdminName,
+			&item.EmployeeID, &item.EmployeeName,
+			&item.Reason, &item.AllowWrites, &item.StartedAt, &item.ExpiresAt, &endedAt, &item.RequestCount,
+			&item.Active,
+		); err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if endedAt.Valid {
+			item.EndedAt = &endedAt.Time
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+func (a *app) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermissionFresh(w, r, a.db, permissionImpersonateUsers) {
+		return
+	}
+	adminClaims := officeAccessTokenClaimsFromContext(r.Context())
+	if adminClaims == nil || serviceAccountFromContext(r.Context()) != nil {
+		respondError(w, http.StatusForbidden, "impersonation requires a browser session")
+		return
+	}
+	if adminClaims.Impersonation != nil {
+		respondError(w, http.StatusConflict, "stop the current impersonation session first")
+		return
+	}
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanSign() {
+		respondError(w, http.StatusServiceUnavailable, "Office token keys are not configured")
+		return
+	}
+	var req impersonationRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.EmployeeID = strings.TrimSpace(req.EmployeeID)
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.EmployeeID == "" {
+		respondError(w, http.StatusBadRequest, "employee_id is required")
+		return
+	}
+	if req.Reason == "" {
+		respondError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+	if utf8.RuneCountInString(req.Reason) > impersonationReasonMaxLen {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("reason must be at most %d characters", impersonationReasonMaxLen))
+		return
+	}
+	ttl := impersonationDefaultTTL
+	if req.DurationMinutes != 0 {
+		ttl = time.Duration(req.DurationMinutes) * time.Minute
+		if ttl < time.Minute || ttl > impersonationMaxTTL {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("duration_minutes must be between 1 and %d", int(impersonationMaxTTL/time.Minute)))
+			return
+		}
+	}
+	adminID := adminClaims.EmployeeID
+	if req.EmployeeID == adminID || isServiceAccountPrincipal(req.EmployeeID) {
+		respondError(w, http.StatusBadRequest, errImpersonationTargetInvalid.Error())
+		return
+	}
+
+	var (
+		employeeName string
+		active       bool
+	)
+	err := a.db.QueryRowContext(r.Context(),
+		`SELECT COALESCE(full_name, ''), active FROM users WHERE employee_id = $1 ORDER BY id LIMIT 1`,
+		req.EmployeeID,
+	).Scan(&employeeName, &active)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !active) {
+		respondError(w, http.StatusBadRequest, errImpersonationTargetInvalid.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	employeeRole, err := getUserRoleByWbUserID(r.Context(), a.db, req.EmployeeID)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	adminRole, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return
+	}
+	if !roleCovers(adminRole, employeeRole) {
+		respondError(w, http.StatusForbidden, errImpersonationRoleNotCovered.Error())
+		return
+	}
+	adminName := adminClaims.UserName
+	if name, err := getUserNameByEmployeeID(r.Context(), a.db, adminID); err == nil && strings.TrimSpace(name) != "" {
+		adminName = strings.TrimSpace(name)
+	}
+
+	session := impersonationSession{
+		AdminEmployeeID: adminID,
+		AdminName:       adminName,
+		EmployeeID:      req.EmployeeID,
+		EmployeeName:    employeeName,
+		Reason:          req.Reason,
+		AllowWrites:     req.AllowWrites,
+		Active:          true,
+	}
+	if err := a.db.QueryRowContext(r.Context(),
+		`INSERT INTO impersonation_sessions (
+			admin_employee_id, employee_id, reason, allow_writes, ip_address, user_agent, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, now() + make_interval(secs => $7))
+		RETURNING id, started_at, expires_at`,
+		adminID, req.EmployeeID, req.Reason, req.AllowWrites, clientIP(r), truncateUA(r.UserAgent()), ttl.Seconds(),
+	).Scan(&session.ID, &session.StartedAt, &session.ExpiresAt); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	claims := OfficeAccessTokenClaims{
+		EmployeeID:       req.EmployeeID,
+		UserName:         employeeName,
+		Role:             employeeRole,
+		Responsibilities: a.loadResponsibilitiesForToken(req.EmployeeID),
+		Exp:              session.ExpiresAt.Unix(),
+		Impersonation: &TokenImpersonation{
+			ID:          session.ID,
+			EmployeeID:  adminID,
+			UserName:    adminName,
+			AllowWrites: req.AllowWrites,
+		},
+	}
+	accessToken, err := SignOfficeAccessTokenWithKeyManager(claims, a.officeTokenKeys)
+	if err != nil {
+		log.Printf("startImpersonation: failed to sign access token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to issue access token")
+		return
+	}
+	a.setAccessTokenCookie(w, r, accessToken, claims.Exp)
+	a.logAuditEventFromRequest(r, auditActionImpersonate, auditEntityImpersonation, session.ID, employeeName, map[string]any{
+		"employee_id":  req.EmployeeID,
+		"reason":       req.Reason,
+		"allow_writes": req.AllowWrites,
+		"expires_at":   session.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+
+	respondJSON(w, http.StatusCreated, map[string]any{
+		"impersonation": session,
+		"session": sessionResponse{
+			EmployeeID:       claims.EmployeeID,
+			UserName:         claims.UserName,
+			Role:             claims.Role,
+			Responsibilities: claims.Responsibilities,
+			Permissions:      roleDefinitions.grantsFor(claims.Role),
+			AccessExp:        claims.Exp,
+			Impersonated:     true,
+			Impersonation:    sessionImpersonationFromClaims(&claims),
+		},
+	})
+}
+
+// handleAuthImpersonationStop ends the impersonation session of the access
+// cookie and drops that cookie; the client then calls /api/auth/refresh to
+// get the admin's own access token back.
+//
+// POST /api/auth/impersonation/stop
+func (a *app) handleAuthImpersonationStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanVerify() {
+		respondError(w, http.StatusServiceUnavailable, "Office token verification is not configured")
+		return
+	}
+	if a.endImpersonationFromRequest(r, "stop") == nil {
+		respondError(w, http.StatusBadRequest, "No impersonation session")
+		return
+	}
+	a.clearAccessTokenCookie(w, r)
+	respondJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// endImpersonationFromRequest closes the impersonation session named by the
+// request's access cookie, if there is one, and returns its claims.
+func (a *app) endImpersonationFromRequest(r *http.Request, endedBy string) *OfficeAccessTokenClaims {
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanVerify() {
+		return nil
+	}
+	c, err := r.Cookie(accessTokenCookieName)
+	if err != nil || strings.TrimSpace(c.Value) == "" {
+		return nil
+	}
+	claims, err := VerifyOfficeAccessTokenWithKeyManager(c.Value, a.officeTokenKeys)
+	if err != nil || claims.Impersonation == nil {
+		return nil
+	}
+	res, err := a.db.ExecContext(r.Context(),
+		`UPDATE impersonation_sessions SET ended_at = now()
+		  WHERE id = $1 AND admin_employee_id = $2 AND ended_at IS NULL`,
+		claims.Impersonation.ID, claims.Impersonation.EmployeeID,
+	)
+	if err != nil {
+		log.Printf("impersonation: failed to end session %d: %v", claims.Impersonation.ID, err)
+		return claims
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return claims
+	}
+	a.logAuditEvent(r.Context(), auditLogWriteInput{
+		ActionType:      auditActionEndImpersonation,
+		EntityType:      auditEntityImpersonation,
+		EntityID:        claims.Impersonation.ID,
+		EntityName:      claims.UserName,
+		ActorEmployeeID: claims.Impersonation.EmployeeID,
+		ActorName:       claims.Impersonation.UserName,
+		Details: map[string]any{
+			"impersonated_employee_id": claims.EmployeeID,
+			"impersonated_name":        claims.UserName,
+			"ended_by":                 endedBy,
+		},
+	})
+	return claims
+}
+
+func (a *app) clearAccessTokenCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookieName,
+		Value:    "",
+		Domain:   a.cookieDomainForRequest(r),
+		Path:     "/api/",
+		HttpOnly: true,
+		Secure:   isSecureContext(r),
+		SameSite: a.authCookieSameSite,
+		MaxAge:   -1,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImpersonatedAuditActor(t *testing.T) {
+	details := map[string]any{}
+	id, name := impersonatedAuditActor(context.Background(), details, "200", "Сотрудник")
+	if id != "200" || name != "Сотрудник" || len(details) != 0 {
+		t.Fatalf("plain request: got %q %q %v", id, name, details)
+	}
+
+	ctx := context.WithValue(context.Background(), officeAccessTokenClaimsCtxKey, &OfficeAccessTokenClaims{
+		EmployeeID:    "200",
+		Impersonation: &TokenImpersonation{ID: 5, EmployeeID: "100", UserName: "Админ"},
+	})
+	id, name = impersonatedAuditActor(ctx, details, "200", "Сотрудник")
+	if id != "100" || name != "Админ" {
+		t.Fatalf("impersonated request: actor %q %q, want the admin", id, name)
+	}
+	if details["impersonated_employee_id"] != "200" || details["impersonation_id"] != int64(5) {
+		t.Fatalf("impersonated request: details %v", details)
+	}
+}
+
+func TestImpersonationTokenClaims(t *testing.T) {
+	keys := newLegacyHS256KeyManager([]byte("secret"))
+	exp := time.Now().Add(impersonationDefaultTTL).Unix()
+	token, err := SignOfficeAccessTokenWithKeyManager(OfficeAccessTokenClaims{
+		EmployeeID:    "200",
+		Role:          roleEmployee,
+		Exp:           exp,
+		Impersonation: &TokenImpersonation{ID: 5, EmployeeID: "100", UserName: "Админ"},
+	}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyOfficeAccessTokenWithKeyManager(token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Exp != exp {
+		t.Errorf("exp = %d, want %d", claims.Exp, exp)
+	}
+	flag := sessionImpersonationFromClaims(claims)
+	if flag == nil || flag.ID != 5 || flag.ImpersonatorEmployeeID != "100" || flag.AllowWrites {
+		t.Fatalf("session flag = %+v", flag)
+	}
+}
+
+func TestImpersonationStopRequiresImpersonationToken(t *testing.T) {
+	keys := newLegacyHS256KeyManager([]byte("secret"))
+	a := &app{officeTokenKeys: keys}
+	token, err := SignOfficeAccessTokenWithKeyManager(OfficeAccessTokenClaims{EmployeeID: "100", Role: roleAdmin}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, authImpersonationStopPath, nil)
+	req.AddCookie(&http.Cookie{Name: accessTokenCookieName, Value: token})
+	rec := httptest.NewRecorder()
+	a.handleAuthImpersonationStop(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
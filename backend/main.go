@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -9,14 +8,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"mime"
-	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -36,27 +36,33 @@ const defaultBuildingTimezone = "Europe/Moscow"
 
 type app struct {
 	db                 *sql.DB
-	uploadDir          string
-	buildingUploadDir  string
+	storage            objectStorage
 	dbDumpDir          string
-	authRateLimiter    *ipRateLimiter
+	rateLimiter        *rateLimiter
 	officeTokenKeys    *officeTokenKeyManager
 	refreshTokenPepper []byte
 	authCookieSameSite http.SameSite
 	authCookieDomain   string
 	externalHTTPClient *http.Client
 	externalMaxRetries int
+	identity           identityProvider
+	webpTools          webpTools
+	trashRetention     time.Duration
+	auditSinks         *auditSinkSet
+
+	auditCheckpointKeyPins auditCheckpointKeyPins
 }
 
 type building struct {
-	ID                    int64     `json:"id"`
-	Name                  string    `json:"name"`
-	Address               string    `json:"address"`
-	Timezone              string    `json:"timezone"`
-	ImageURL              string    `json:"image_url,omitempty"`
-	ResponsibleEmployeeID string    `json:"responsible_employee_id,omitempty"`
-	Floors                []int64   `json:"floors"`
-	CreatedAt             time.Time `json:"created_at"`
+	ID                    int64             `json:"id"`
+	Name                  string            `json:"name"`
+	Address               string            `json:"address"`
+	Timezone              string            `json:"timezone"`
+	ImageURL              string            `json:"image_url,omitempty"`
+	ImageVariants         map[string]string `json:"image_variants,omitempty"`
+	ResponsibleEmployeeID string            `json:"responsible_employee_id,omitempty"`
+	Floors                []int64           `json:"floors"`
+	CreatedAt             time.Time         `json:"created_at"`
 }
 
 type floor struct {
@@ -64,6 +70,7 @@ type floor struct {
 	BuildingID            int64     `json:"building_id"`
 	Name                  string    `json:"name"`
 	Level                 int       `json:"level"`
+	Label                 string    `json:"label"`
 	SpacesCount           int       `json:"spaces_count"`
 	ResponsibleEmployeeID string    `json:"responsible_employee_id,omitempty"`
 	PlanSVG               string    `json:"plan_svg,omitempty"`
@@ -81,6 +88,7 @@ type space struct {
 	SubdivisionL1         string    `json:"subdivision_level_1"`
 	SubdivisionL2         string    `json:"subdivision_level_2"`
 	ResponsibleEmployeeID string    `json:"responsible_employee_id,omitempty"`
+	ZoneID                *int64    `json:"zone_id"`
 	Points                []point   `json:"points"`
 	CreatedAt             time.Time `json:"created_at"`
 }
@@ -185,6 +193,10 @@ func postgresDSN() string {
 func main() {
 	_ = godotenv.Load()
 
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	db, err := sql.Open("pgx", postgresDSN())
 	if err != nil {
 		log.Fatalf("open db: %v", err)
@@ -207,15 +219,21 @@ func main() {
 		log.Println("migrations applied; exiting because MIGRATE_ONLY=true")
 		return
 	}
+	if defs, err := loadRoleDefinitions(context.Background(), db); err != nil {
+		log.Fatalf("load roles: %v", err)
+	} else {
+		roleDefinitions.replace(defs)
+	}
 
 	uploadDir, err := filepath.Abs(uploadDirName)
 	if err != nil {
 		log.Fatalf("resolve upload dir: %v", err)
 	}
-	buildingUploadDir := filepath.Join(uploadDir, buildingUploadDirName)
-	if err := os.MkdirAll(buildingUploadDir, 0o755); err != nil {
-		log.Fatalf("create upload dir: %v", err)
+	storage, err := loadObjectStorageFromEnv(uploadDir)
+	if err != nil {
+		log.Fatalf("configure upload storage: %v", err)
 	}
+	log.Printf("upload storage: %s", storage.Name())
 	dbDumpDir, err := filepath.Abs(dbDumpDirName)
 	if err != nil {
 		log.Fatalf("resolve db dump dir: %v", err)
@@ -228,12 +246,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("load office jwt keys: %v", err)
 	}
-	if !officeTokenKeys.CanSign() {
-		log.Println("WARNING: office JWT signing key is not set — Office_Token will not be issued")
-	}
-	if !officeTokenKeys.CanVerify() {
-		log.Println("WARNING: office JWT verification keys are not set — Office_Token will not be verified")
-	}
 	refreshPepper := strings.TrimSpace(os.Getenv("OFFICE_REFRESH_PEPPER"))
 	if refreshPepper == "" {
 		// Backward-compatible fallback: reuse legacy HS secret only when present.
@@ -261,6 +273,22 @@ func main() {
 	}
 	jwtClockSkew := parseEnvDurationSeconds("OFFICE_JWT_CLOCK_SKEW_SECONDS", 30, 0, 120)
 	configureOfficeJWTValidation(jwtIssuer, jwtAccessAudience, jwtRefreshAudience, jwtClockSkew)
+	jwtKeyStore, err := loadOfficeJWTKeyStoreFromEnv(db, officeTokenKeys)
+	if err != nil {
+		log.Fatalf("configure office jwt key storage: %v", err)
+	}
+	if jwtKeyStore != nil {
+		if err := jwtKeyStore.rotate(context.Background()); err != nil {
+			log.Fatalf("load office jwt keys from db: %v", err)
+		}
+		log.Println("office JWT keys: DB storage with automatic rotation")
+	}
+	if !officeTokenKeys.CanSign() {
+		log.Println("WARNING: office JWT signing key is not set — Office_Token will not be issued")
+	}
+	if !officeTokenKeys.CanVerify() {
+		log.Println("WARNING: office JWT verification keys are not set — Office_Token will not be verified")
+	}
 	authSameSite := parseSameSiteEnv("AUTH_COOKIE_SAMESITE", http.SameSiteStrictMode)
 	authCookieDomain := parseCookieDomainEnv("AUTH_COOKIE_DOMAIN")
 	externalAuthTimeout := parseEnvDurationSeconds("EXTERNAL_AUTH_TIMEOUT_SECONDS", 6, 2, 20)
@@ -285,72 +313,44 @@ func main() {
 		Transport: externalTransport,
 	}
 
+	webpTools := loadWebPToolsFromEnv()
+	if !webpTools.CanEncode() {
+		log.Println("WARNING: cwebp is not available — building images will be stored as JPEG/PNG")
+	}
+
 	app := &app{
 		db:                 db,
-		uploadDir:          uploadDir,
-		buildingUploadDir:  buildingUploadDir,
+		storage:            storage,
 		dbDumpDir:          dbDumpDir,
-		authRateLimiter:    newIPRateLimiter(10, time.Minute), // 10 auth requests per IP per minute
+		rateLimiter:        loadRateLimiterFromEnv(db),
 		officeTokenKeys:    officeTokenKeys,
 		refreshTokenPepper: []byte(refreshPepper),
 		authCookieSameSite: authSameSite,
 		authCookieDomain:   authCookieDomain,
 		externalHTTPClient: externalHTTPClient,
 		externalMaxRetries: externalAuthMaxRetries,
+		webpTools:          webpTools,
+		trashRetention:     parseEnvDurationDays("OFFICE_TRASH_RETENTION_DAYS", defaultTrashRetentionDays, 1, 365),
 	}
-
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/buildings", app.handleBuildings)
-	mux.HandleFunc("/api/buildings/", app.handleBuildingSubroutes)
-	mux.HandleFunc("/api/floors", app.handleFloors)
-	mux.HandleFunc("/api/floors/", app.handleFloorSubroutes)
-	mux.HandleFunc("/api/spaces", app.handleSpaces)
-	mux.HandleFunc("/api/spaces/", app.handleSpaceSubroutes)
-	mux.HandleFunc("/api/desks", app.handleDesks)
-	mux.HandleFunc("/api/desks/bulk", app.handleDeskBulk)
-	mux.HandleFunc("/api/desks/", app.handleDeskSubroutes)
-	mux.HandleFunc("/api/meeting-rooms", app.handleMeetingRooms)
-	mux.HandleFunc("/api/meeting-room-bookings", app.handleMeetingRoomBookings)
-	mux.HandleFunc("/api/meeting-room-bookings/", app.handleMeetingRoomBookingsSubroutes)
-	mux.HandleFunc("/api/bookings", app.handleBookings)
-	mux.HandleFunc("/api/bookings/", app.handleBookingsSubroutes)
-	mux.HandleFunc("/api/users", app.handleUsers)
-	mux.HandleFunc("/api/users/role", app.handleUserRole)
-	mux.HandleFunc("/api/responsibilities", app.handleResponsibilities)
-	mux.HandleFunc("/api/admin/logs", app.handleAdminAuditLogs)
-	mux.HandleFunc("/api/admin/db-dumps/export", app.handleDatabaseDumpExport)
-	mux.HandleFunc("/api/admin/db-dumps/import", app.handleDatabaseDumpImport)
-	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
-	})
-	mux.HandleFunc("/api/user/info", app.handleAuthUserInfo)
-	mux.HandleFunc("/api/user/wb-band", app.handleAuthUserWbBand)
-	mux.HandleFunc("/api/auth/office-token", app.handleAuthOfficeToken)
-	mux.HandleFunc("/api/auth/refresh", app.handleAuthRefreshToken)
-	mux.HandleFunc("/api/auth/session", app.handleAuthSession)
-	mux.HandleFunc("/api/auth/logout", app.handleAuthLogout)
-	mux.HandleFunc("/api/v2/auth/code/wb-captcha", app.handleAuthRequestCode)
-	mux.HandleFunc("/api/v2/auth/confirm", app.handleAuthConfirmCode)
-
-	webDir := filepath.Join("..", "frontend")
-	serveFrontendPage := func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, filepath.Join(webDir, "index.html"))
+	var oidcStateKey []byte
+	if refreshPepper != "" {
+		oidcStateKey = hmacSHA256([]byte("oidc-login-state"), []byte(refreshPepper))
 	}
-	mux.HandleFunc("/buildings", serveFrontendPage)
-	mux.HandleFunc("/buildings/", serveFrontendPage)
-	mux.HandleFunc("/spaces", serveFrontendPage)
-	mux.HandleFunc("/spaces/", serveFrontendPage)
-	mux.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir(app.uploadDir))))
-	mux.Handle("/", http.FileServer(http.Dir(webDir)))
+	app.identity, err = loadIdentityProviderFromEnv(externalHTTPClient, app.doUpstreamRequest, oidcStateKey)
+	if err != nil {
+		log.Fatalf("configure identity provider: %v", err)
+	}
+	log.Printf("identity provider: %s", app.identity.Name())
+	app.auditSinks, err = loadAuditSinksFromEnv()
+	if err != nil {
+		log.Fatalf("configure audit sinks: %v", err)
+	}
+	if app.auditSinks != nil {
+		log.Printf("audit sinks: %s", strings.Join(app.auditSinks.names(), ", "))
+	}
+	app.auditCheckpointKeyPins = auditCheckpointKeyPinsFromEnv()
 
-	handler := http.Handler(mux)
-	handler = app.authMiddleware(handler)
-	handler = csrfProtectionMiddleware(handler)
-	handler = corsMiddleware(handler)
-	handler = securityHeadersMiddleware(handler)
-	handler = loggingMiddleware(handler, slowAPIThreshold)
+	handler := app.routes(filepath.Join("..", "frontend"), slowAPIThreshold)
 
 	port := strings.TrimSpace(os.Getenv("PORT"))
 	if port == "" {
@@ -365,6 +365,31 @@ func main() {
 		IdleTimeout:       120 * time.Second,
 	}
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go app.runTrashPurgeLoop(jobsCtx)
+	go app.runRoleDefinitionsLoop(jobsCtx)
+	go app.runRateLimitCleanupLoop(jobsCtx)
+	if interval := auditCheckpointIntervalFromEnv(); interval > 0 {
+		if !officeTokenKeys.CanSign() {
+			log.Println("WARNING: OFFICE_AUDIT_CHECKPOINT_INTERVAL is set but no office JWT signing key is configured")
+		}
+		go app.runAuditCheckpointLoop(jobsCtx, interval)
+	}
+	if retention := auditRetentionFromEnv(); retention > 0 {
+		archiveDir, err := auditArchiveDirFromEnv()
+		if err != nil {
+			log.Fatalf("create audit archive dir: %v", err)
+		}
+		go app.runAuditRetentionLoop(jobsCtx, retention, archiveDir)
+	}
+	if app.auditSinks != nil {
+		go app.runAuditSinkLoop(jobsCtx)
+	}
+	if jwtKeyStore != nil {
+		go jwtKeyStore.run(jobsCtx)
+	}
+
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
@@ -377,6 +402,7 @@ func main() {
 
 	sig := <-shutdown
 	log.Printf("received signal %v, shutting down gracefully...", sig)
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
@@ -387,6 +413,102 @@ func main() {
 	log.Println("server stopped")
 }
 
+// routes builds the HTTP handler: API routes, the frontend from webDir and
+// the middleware chain.
+func (a *app) routes(webDir string, slowAPIThreshold time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/buildings", a.handleBuildings)
+	mux.HandleFunc("/api/buildings/", a.handleBuildingSubroutes)
+	mux.HandleFunc("/api/floors", a.handleFloors)
+	mux.HandleFunc("/api/floors/", a.handleFloorSubroutes)
+	mux.HandleFunc("/api/spaces", a.handleSpaces)
+	mux.HandleFunc("/api/spaces/", a.handleSpaceSubroutes)
+	mux.HandleFunc("/api/zones", a.handleZones)
+	mux.HandleFunc("/api/zones/", a.handleZoneSubroutes)
+	mux.HandleFunc("/api/resources", a.handleResources)
+	mux.HandleFunc("/api/resources/", a.handleResourceSubroutes)
+	mux.HandleFunc("/api/resource-bookings", a.handleResourceBookings)
+	mux.HandleFunc("/api/resource-bookings/", a.handleResourceBookingSubroutes)
+	mux.HandleFunc("/api/desks", a.handleDesks)
+	mux.HandleFunc("/api/desks/bulk", a.handleDeskBulk)
+	mux.HandleFunc("/api/desks/", a.handleDeskSubroutes)
+	mux.HandleFunc("/api/meeting-rooms", a.handleMeetingRooms)
+	mux.HandleFunc("/api/meeting-room-bookings", a.handleMeetingRoomBookings)
+	mux.HandleFunc("/api/meeting-room-bookings/", a.handleMeetingRoomBookingsSubroutes)
+	mux.HandleFunc("/api/bookings", a.handleBookings)
+	mux.HandleFunc("/api/bookings/", a.handleBookingsSubroutes)
+	mux.HandleFunc("/api/users", a.handleUsers)
+	mux.HandleFunc("/api/users/role", a.handleUserRole)
+	mux.HandleFunc("/api/responsibilities", a.handleResponsibilities)
+	mux.HandleFunc(delegationsPath, a.handleDelegations)
+	mux.HandleFunc(delegationsPath+"/", a.handleDelegations)
+	mux.HandleFunc("/api/admin/logs", a.handleAdminAuditLogs)
+	mux.HandleFunc(adminAuditLogsVerifyPath, a.handleAdminAuditLogsVerify)
+	mux.HandleFunc(adminAuditLogsExportPath, a.handleAdminAuditLogsExport)
+	mux.HandleFunc(adminAuditLogsStatsPath, a.handleAdminAuditLogsStats)
+	mux.HandleFunc(adminAuditSinksPath, a.handleAdminAuditSinks)
+	mux.HandleFunc(adminAuditSinksRetryPath, a.handleAdminAuditSinksRetry)
+	mux.HandleFunc(adminEntityHistoryPath, a.handleAdminEntityHistory)
+	mux.HandleFunc(adminPermissionExplainPath, a.handleAdminPermissionExplain)
+	mux.HandleFunc(adminImpersonationPath, a.handleAdminImpersonation)
+	mux.HandleFunc(adminPersonalDataPath, a.handleAdminPersonalData)
+	mux.HandleFunc(adminPersonalDataErasePath, a.handleAdminPersonalDataErase)
+	mux.HandleFunc(personalDataPath, a.handlePersonalData)
+	mux.HandleFunc("/api/admin/trash", a.handleAdminTrash)
+	mux.HandleFunc("/api/admin/trash/", a.handleAdminTrashSubroutes)
+	mux.HandleFunc(adminSessionsPath, a.handleAdminSessions)
+	mux.HandleFunc(adminSessionsPath+"/", a.handleAdminSessions)
+	mux.HandleFunc(adminServiceAccountsPath, a.handleAdminServiceAccounts)
+	mux.HandleFunc(adminRolesPath, a.handleAdminRoles)
+	mux.HandleFunc(adminRolesPath+"/", a.handleAdminRoleSubroutes)
+	mux.HandleFunc(adminServiceAccountsPath+"/", a.handleAdminServiceAccountSubroutes)
+	mux.HandleFunc("/api/admin/db-dumps/export", a.handleDatabaseDumpExport)
+	mux.HandleFunc("/api/admin/db-dumps/import", a.handleDatabaseDumpImport)
+	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/api/user/info", a.handleAuthUserInfo)
+	mux.HandleFunc("/api/user/wb-band", a.handleAuthUserWbBand)
+	mux.HandleFunc("/api/auth/office-token", a.handleAuthOfficeToken)
+	mux.HandleFunc("/api/auth/refresh", a.handleAuthRefreshToken)
+	mux.HandleFunc("/api/auth/session", a.handleAuthSession)
+	mux.HandleFunc("/api/auth/logout", a.handleAuthLogout)
+	mux.HandleFunc(authImpersonationStopPath, a.handleAuthImpersonationStop)
+	mux.HandleFunc("/api/auth/provider", a.handleAuthProvider)
+	mux.HandleFunc(authSessionsPath, a.handleAuthSessions)
+	mux.HandleFunc(authSessionsPath+"/", a.handleAuthSessions)
+	mux.HandleFunc(oidcLoginPath, a.handleOIDCLogin)
+	mux.HandleFunc(oidcCallbackPath, a.handleOIDCCallback)
+	mux.HandleFunc("/api/v2/auth/code/wb-captcha", a.handleAuthRequestCode)
+	mux.HandleFunc("/api/v2/auth/confirm", a.handleAuthConfirmCode)
+	mux.HandleFunc(jwksPath, a.handleJWKS)
+	mux.HandleFunc(adminOffboardingPath, a.handleAdminOffboarding)
+	mux.HandleFunc(scimUsersPath, a.handleSCIMUsers)
+	mux.HandleFunc(scimUsersPath+"/", a.handleSCIMUserSubroutes)
+	mux.HandleFunc(scimBasePath+"/ServiceProviderConfig", a.handleSCIMServiceProviderConfig)
+
+	serveFrontendPage := func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(webDir, "index.html"))
+	}
+	mux.HandleFunc("/buildings", serveFrontendPage)
+	mux.HandleFunc("/buildings/", serveFrontendPage)
+	mux.HandleFunc("/spaces", serveFrontendPage)
+	mux.HandleFunc("/spaces/", serveFrontendPage)
+	mux.HandleFunc("/uploads/", a.handleUploads)
+	mux.Handle("/", http.FileServer(http.Dir(webDir)))
+
+	handler := http.Handler(mux)
+	handler = a.rateLimitMiddleware(handler)
+	handler = a.authMiddleware(handler)
+	handler = csrfProtectionMiddleware(handler)
+	handler = corsMiddleware(handler)
+	handler = securityHeadersMiddleware(handler)
+	handler = loggingMiddleware(handler, slowAPIThreshold)
+	return handler
+}
+
 func isTrueEnv(name string) bool {
 	value := strings.TrimSpace(os.Getenv(name))
 	if value == "" {
@@ -541,7 +663,10 @@ func parseCookieDomainEnv(name string) string {
 	return value
 }
 
-func migrate(db *sql.DB) error {
+// migrateBaseline is schema migration 1: every step that existed before
+// schema_migrations. The steps probe the schema themselves, so it also
+// applies cleanly to databases created by earlier releases.
+func migrateBaseline(db *sql.DB) error {
 	if err := migrateSpaceStorage(db); err != nil {
 		return err
 	}
@@ -573,6 +698,7 @@ func migrate(db *sql.DB) error {
 			timezone TEXT NOT NULL DEFAULT 'Europe/Moscow',
 			responsible_employee_id TEXT NOT NULL DEFAULT '',
 			image_url TEXT,
+			image_variants TEXT NOT NULL DEFAULT '{}',
 			floors TEXT NOT NULL DEFAULT '[]',
 			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 		);`,
@@ -697,9 +823,54 @@ func migrate(db *sql.DB) error {
 	if err := ensureColumn(db, "office_buildings", "image_url", "TEXT"); err != nil {
 		return err
 	}
+	if err := ensureColumn(db, "office_buildings", "image_variants", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return err
+	}
 	if err := ensureColumn(db, "office_buildings", "floors", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
 		return err
 	}
+	if err := ensureTrashStorage(db); err != nil {
+		return err
+	}
+	if err := ensureZonesStorage(db); err != nil {
+		return err
+	}
+	if err := ensureResourcesStorage(db); err != nil {
+		return err
+	}
+	if err := ensureOfficeJWTKeysStorage(db); err != nil {
+		return err
+	}
+	if err := ensureServiceAccountsStorage(db); err != nil {
+		return err
+	}
+	if err := ensureRolesStorage(db); err != nil {
+		return err
+	}
+	if err := ensureRateLimitStorage(db); err != nil {
+		return err
+	}
+	if err := ensureSCIMStorage(db); err != nil {
+		return err
+	}
+	if err := ensureAuditChainStorage(db); err != nil {
+		return err
+	}
+	if err := ensureAuditArchiveStorage(db); err != nil {
+		return err
+	}
+	if err := ensureAuditSinkStorage(db); err != nil {
+		return err
+	}
+	if err := ensureResponsibilityAuditMigration(db); err != nil {
+		return err
+	}
+	if err := ensureDelegationStorage(db); err != nil {
+		return err
+	}
+	if err := ensureImpersonationStorage(db); err != nil {
+		return err
+	}
 	if err := ensureColumn(db, "office_buildings", "timezone", "TEXT NOT NULL DEFAULT 'Europe/Moscow'"); err != nil {
 		return err
 	}
@@ -2157,6 +2328,22 @@ func (a *app) upsertUserInfo(wbUserID, userName, fullName, employeeID, profileID
 		return err
 	}
 
+	// A user provisioned through SCIM waits under a placeholder wb_user_id
+	// until the first login claims it by employee_id.
+	_, err = tx.Exec(
+		`UPDATE users
+		    SET wb_user_id = $1
+		  WHERE wb_user_id LIKE 'scim:%'
+		    AND employee_id = $2
+		    AND $2 <> ''
+		    AND NOT EXISTS (SELECT 1 FROM users WHERE wb_user_id = $1)`,
+		strings.TrimSpace(wbUserID),
+		strings.TrimSpace(employeeID),
+	)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(
 		`INSERT INTO users (wb_user_id, full_name, employee_id, wb_team_profile_id, avatar_url, wb_band, role)
 		 VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -2399,7 +2586,7 @@ func (a *app) handleBuildings(w http.ResponseWriter, r *http.Request) {
 		}
 		respondJSON(w, http.StatusOK, map[string]any{"items": items})
 	case http.MethodPost:
-		if !ensureNotEmployeeRole(w, r, a.db) {
+		if !ensurePermission(w, r, a.db, permissionManageBuildings) {
 			return
 		}
 		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
@@ -2519,11 +2706,11 @@ func (a *app) createBuildingFromMultipart(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return building{}, http.StatusInternalServerError, err
 	}
-	imageURL, err := a.saveBuildingImage(created.ID, file, header)
+	image, err := a.saveBuildingImage(created.ID, file, header)
 	if err != nil {
 		return building{}, http.StatusBadRequest, err
 	}
-	updated, err := a.updateBuildingImage(created.ID, imageURL)
+	updated, err := a.updateBuildingImage(created.ID, image)
 	if err != nil {
 		return building{}, http.StatusInternalServerError, err
 	}
@@ -2602,7 +2789,7 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 					respondRoleResolutionError(w, err)
 					return
 				}
-				if role != roleAdmin {
+				if !hasRequestPermission(r, role, permissionManageBuildings) {
 					respondError(w, http.StatusForbidden, "Недостаточно прав")
 					return
 				}
@@ -2643,6 +2830,7 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 				payload.ResponsibleEmployeeID,
 				payload.UndergroundFloors,
 				payload.AbovegroundFloors,
+				requesterEmployeeID,
 			)
 			if err != nil {
 				if errors.Is(err, errNotFound) {
@@ -2688,7 +2876,7 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 			}
 			respondJSON(w, http.StatusOK, result)
 		case http.MethodDelete:
-			if !ensureNotEmployeeRole(w, r, a.db) {
+			if !ensurePermission(w, r, a.db, permissionManageBuildings) {
 				return
 			}
 			existing, existingErr := a.getBuilding(id)
@@ -2701,7 +2889,7 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 				respondError(w, http.StatusInternalServerError, "internal error")
 				return
 			}
-			if err := a.deleteBuilding(id); err != nil {
+			if err := a.deleteBuilding(id, a.requestActorEmployeeID(r)); err != nil {
 				if errors.Is(err, errNotFound) {
 					respondError(w, http.StatusNotFound, "building not found")
 					return
@@ -2767,12 +2955,12 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			defer file.Close()
-			imageURL, err := a.saveBuildingImage(id, file, header)
+			image, err := a.saveBuildingImage(id, file, header)
 			if err != nil {
 				respondError(w, http.StatusBadRequest, err.Error())
 				return
 			}
-			updated, err := a.updateBuildingImage(id, imageURL)
+			updated, err := a.updateBuildingImage(id, image)
 			if err != nil {
 				if errors.Is(err, errNotFound) {
 					respondError(w, http.StatusNotFound, "building not found")
@@ -2782,8 +2970,8 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 				respondError(w, http.StatusInternalServerError, "internal error")
 				return
 			}
-			if existing.ImageURL != "" && existing.ImageURL != imageURL {
-				_ = a.removeUploadedFile(existing.ImageURL)
+			if existing.ImageURL != "" && existing.ImageURL != image.URL {
+				_ = a.removeBuildingImageFiles(existing)
 			}
 			if strings.TrimSpace(existing.ImageURL) != strings.TrimSpace(updated.ImageURL) {
 				a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityBuilding, existing.ID, existing.Name, map[string]any{
@@ -2834,6 +3022,10 @@ func (a *app) handleBuildingSubroutes(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if suffix == "/floors/order" {
+		a.handleBuildingFloorOrder(w, r, id)
+		return
+	}
 	if suffix != "/floors" {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -2876,6 +3068,16 @@ func (a *app) handleFloors(w http.ResponseWriter, r *http.Request) {
 	if !a.ensureCanManageBuilding(w, r, payload.BuildingID) {
 		return
 	}
+	taken, err := a.floorLevelTaken(payload.BuildingID, payload.Level)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if taken {
+		respondError(w, http.StatusConflict, errFloorLevelTaken.Error())
+		return
+	}
 	result, err := a.createFloor(payload.BuildingID, payload.Name, payload.Level, payload.PlanSVG)
 	if err != nil {
 		log.Printf("internal error: %v", err)
@@ -2973,7 +3175,7 @@ func (a *app) handleFloorSubroutes(w http.ResponseWriter, r *http.Request) {
 				opErr   error
 			)
 			if payload.PlanSVG != nil {
-				updated, opErr = a.updateFloorPlan(id, *payload.PlanSVG)
+				updated, opErr = a.updateFloorPlan(id, *payload.PlanSVG, requesterEmployeeID)
 				if opErr != nil {
 					if errors.Is(opErr, errNotFound) {
 						respondError(w, http.StatusNotFound, "floor not found")
@@ -3043,7 +3245,7 @@ func (a *app) handleFloorSubroutes(w http.ResponseWriter, r *http.Request) {
 				respondError(w, http.StatusInternalServerError, "internal error")
 				return
 			}
-			if err := a.deleteFloorAndShift(id); err != nil {
+			if err := a.deleteFloor(id, a.requestActorEmployeeID(r)); err != nil {
 				if errors.Is(err, errNotFound) {
 					respondError(w, http.StatusNotFound, "floor not found")
 					return
@@ -3066,7 +3268,16 @@ func (a *app) handleFloorSubroutes(w http.ResponseWriter, r *http.Request) {
 	case "/spaces":
 		switch r.Method {
 		case http.MethodGet:
-			items, err := a.listSpacesByFloor(id)
+			var zoneID int64
+			if zoneIDRaw := strings.TrimSpace(r.URL.Query().Get("zone_id")); zoneIDRaw != "" {
+				parsed, err := strconv.ParseInt(zoneIDRaw, 10, 64)
+				if err != nil || parsed <= 0 {
+					respondError(w, http.StatusBadRequest, "zone_id must be a number")
+					return
+				}
+				zoneID = parsed
+			}
+			items, err := a.listSpacesByFloor(id, zoneID)
 			if err != nil {
 				log.Printf("internal error: %v", err)
 				respondError(w, http.StatusInternalServerError, "internal error")
@@ -3076,6 +3287,18 @@ func (a *app) handleFloorSubroutes(w http.ResponseWriter, r *http.Request) {
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
+	case "/zones":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		items, err := a.listZonesByFloor(id)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"items": items})
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -3103,6 +3326,7 @@ func (a *app) handleSpaces(w http.ResponseWriter, r *http.Request) {
 		SubdivisionLevel2          string  `json:"subdivision_level_2"`
 		ResponsibleEmployeeID      string  `json:"responsible_employee_id"`
 		FloorResponsibleEmployeeID string  `json:"floor_responsible_employee_id"`
+		ZoneID                     *int64  `json:"zone_id"`
 		Points                     []point `json:"points"`
 	}
 	if err := decodeJSON(r, &payload); err != nil {
@@ -3127,6 +3351,20 @@ func (a *app) handleSpaces(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "points are required")
 		return
 	}
+	if payload.ZoneID != nil && *payload.ZoneID <= 0 {
+		payload.ZoneID = nil
+	}
+	if payload.ZoneID != nil {
+		zoneFloorID, err := a.getZoneFloorID(*payload.ZoneID)
+		if err != nil {
+			respondZoneError(w, err)
+			return
+		}
+		if zoneFloorID != payload.FloorID {
+			respondError(w, http.StatusBadRequest, errZoneFloorMismatch.Error())
+			return
+		}
+	}
 	capacity := 0
 	if payload.Capacity != nil {
 		capacity = *payload.Capacity
@@ -3180,6 +3418,14 @@ func (a *app) handleSpaces(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	if payload.ZoneID != nil {
+		result, err = a.setSpaceZone(result.ID, payload.ZoneID)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
 	if payload.FloorResponsibleEmployeeID != "" {
 		if err := a.updateFloorResponsibleEmployeeID(payload.FloorID, payload.FloorResponsibleEmployeeID); err != nil {
 			if errors.Is(err, errNotFound) {
@@ -3209,6 +3455,7 @@ func (a *app) handleSpaces(w http.ResponseWriter, r *http.Request) {
 		"subdivision_level_2":   result.SubdivisionL2,
 		"responsible_employee":  result.ResponsibleEmployeeID,
 		"snapshot_hidden":       result.SnapshotHidden,
+		"zone_id":               formatOptionalID(result.ZoneID),
 	})
 	respondJSON(w, http.StatusCreated, result)
 }
@@ -3433,6 +3680,7 @@ func (a *app) handleSpaceSubroutes(w http.ResponseWriter, r *http.Request) {
 				"subdivision_level_2":   result.SubdivisionL2,
 				"responsible_employee":  result.ResponsibleEmployeeID,
 				"snapshot_hidden":       result.SnapshotHidden,
+				"zone_id":               formatOptionalID(result.ZoneID),
 				"changes":               changes,
 				"before_name":           existingSpaceForAudit.Name,
 				"after_name":            result.Name,
@@ -3468,7 +3716,7 @@ func (a *app) handleSpaceSubroutes(w http.ResponseWriter, r *http.Request) {
 				respondError(w, http.StatusInternalServerError, "internal error")
 				return
 			}
-			if err := a.deleteSpace(id); err != nil {
+			if err := a.deleteSpace(id, a.requestActorEmployeeID(r)); err != nil {
 				if errors.Is(err, errNotFound) {
 					respondError(w, http.StatusNotFound, "space not found")
 					return
@@ -3489,11 +3737,14 @@ func (a *app) handleSpaceSubroutes(w http.ResponseWriter, r *http.Request) {
 				"capacity":            existing.Capacity,
 				"subdivision_level_1": existing.SubdivisionL1,
 				"subdivision_level_2": existing.SubdivisionL2,
+				"zone_id":             formatOptionalID(existing.ZoneID),
 			})
 			w.WriteHeader(http.StatusNoContent)
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
+	case "/zone":
+		a.handleSpaceZone(w, r, id)
 	case "/desks":
 		if r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -3942,8 +4193,9 @@ func (a *app) handleMeetingRooms(w http.ResponseWriter, r *http.Request) {
 
 func (a *app) listBuildings() ([]building, error) {
 	rows, err := a.db.Query(
-		`SELECT id, name, address, COALESCE(timezone, ''), COALESCE(responsible_employee_id, ''), COALESCE(image_url, ''), COALESCE(floors, '[]'), created_at
+		`SELECT id, name, address, COALESCE(timezone, ''), COALESCE(responsible_employee_id, ''), COALESCE(image_url, ''), COALESCE(image_variants, '{}'), COALESCE(floors, '[]'), created_at
 		FROM office_buildings
+		WHERE deleted_at IS NULL
 		ORDER BY id DESC`,
 	)
 	if err != nil {
@@ -3955,6 +4207,7 @@ func (a *app) listBuildings() ([]building, error) {
 	for rows.Next() {
 		var b building
 		var floorsJSON string
+		var variantsJSON string
 		if err := rows.Scan(
 			&b.ID,
 			&b.Name,
@@ -3962,6 +4215,7 @@ func (a *app) listBuildings() ([]building, error) {
 			&b.Timezone,
 			&b.ResponsibleEmployeeID,
 			&b.ImageURL,
+			&variantsJSON,
 			&floorsJSON,
 			&b.CreatedAt,
 		); err != nil {
@@ -3971,6 +4225,7 @@ func (a *app) listBuildings() ([]building, error) {
 			b.Timezone = defaultBuildingTimezone
 		}
 		b.Floors = decodeFloorIDs(floorsJSON)
+		b.ImageVariants = decodeImageVariants(variantsJSON)
 		items = append(items, b)
 	}
 	return items, rows.Err()
@@ -4014,8 +4269,7 @@ func (a *app) createBuildingWithFloors(name, address, timezone, imageURL string,
 
 	floorIDs := make([]int64, 0, undergroundFloors+abovegroundFloors)
 	for level := -undergroundFloors; level <= -1; level++ {
-		floorName := fmt.Sprintf("B%d", -level)
-		id, floorErr := a.createFloorInTx(tx, buildingID, floorName, level, "")
+		id, floorErr := a.createFloorInTx(tx, buildingID, defaultFloorName(level), level, "")
 		if floorErr != nil {
 			err = floorErr
 			return building{}, floorErr
@@ -4023,8 +4277,7 @@ func (a *app) createBuildingWithFloors(name, address, timezone, imageURL string,
 		floorIDs = append(floorIDs, id)
 	}
 	for level := 1; level <= abovegroundFloors; level++ {
-		floorName := strconv.Itoa(level)
-		id, floorErr := a.createFloorInTx(tx, buildingID, floorName, level, "")
+		id, floorErr := a.createFloorInTx(tx, buildingID, defaultFloorName(level), level, "")
 		if floorErr != nil {
 			err = floorErr
 			return building{}, floorErr
@@ -4056,13 +4309,14 @@ func (a *app) createBuildingWithFloors(name, address, timezone, imageURL string,
 
 func (a *app) getBuilding(id int64) (building, error) {
 	row := a.db.QueryRow(
-		`SELECT id, name, address, COALESCE(timezone, ''), COALESCE(responsible_employee_id, ''), COALESCE(image_url, ''), COALESCE(floors, '[]'), created_at
+		`SELECT id, name, address, COALESCE(timezone, ''), COALESCE(responsible_employee_id, ''), COALESCE(image_url, ''), COALESCE(image_variants, '{}'), COALESCE(floors, '[]'), created_at
 		FROM office_buildings
-		WHERE id = $1`,
+		WHERE id = $1 AND deleted_at IS NULL`,
 		id,
 	)
 	var b building
 	var floorsJSON string
+	var variantsJSON string
 	if err := row.Scan(
 		&b.ID,
 		&b.Name,
@@ -4070,6 +4324,7 @@ func (a *app) getBuilding(id int64) (building, error) {
 		&b.Timezone,
 		&b.ResponsibleEmployeeID,
 		&b.ImageURL,
+		&variantsJSON,
 		&floorsJSON,
 		&b.CreatedAt,
 	); err != nil {
@@ -4082,6 +4337,7 @@ func (a *app) getBuilding(id int64) (building, error) {
 		b.Timezone = defaultBuildingTimezone
 	}
 	b.Floors = decodeFloorIDs(floorsJSON)
+	b.ImageVariants = decodeImageVariants(variantsJSON)
 	return b, nil
 }
 
@@ -4093,6 +4349,7 @@ func (a *app) updateBuilding(
 	responsibleEmployeeID *string,
 	undergroundFloors,
 	abovegroundFloors *int,
+	actorEmployeeID string,
 ) (building, error) {
 	tx, err := a.db.Begin()
 	if err != nil {
@@ -4102,7 +4359,7 @@ func (a *app) updateBuilding(
 
 	responsibleValue := ""
 	if responsibleEmployeeID == nil {
-		row := tx.QueryRow(`SELECT responsible_employee_id FROM office_buildings WHERE id = $1`, id)
+		row := tx.QueryRow(`SELECT responsible_employee_id FROM office_buildings WHERE id = $1 AND deleted_at IS NULL`, id)
 		if err := row.Scan(&responsibleValue); err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return building{}, errNotFound
@@ -4115,7 +4372,7 @@ func (a *app) updateBuilding(
 	responsibleValue = strings.TrimSpace(responsibleValue)
 
 	result, err := tx.Exec(
-		`UPDATE office_buildings SET name = $1, address = $2, timezone = $3, responsible_employee_id = $4 WHERE id = $5`,
+		`UPDATE office_buildings SET name = $1, address = $2, timezone = $3, responsible_employee_id = $4 WHERE id = $5 AND deleted_at IS NULL`,
 		name,
 		address,
 		timezone,
@@ -4139,7 +4396,7 @@ func (a *app) updateBuilding(
 			Level int
 		}
 		var levels []floorLevel
-		rows, err := tx.Query(`SELECT id, level FROM floors WHERE building_id = $1`, id)
+		rows, err := tx.Query(`SELECT id, level FROM floors WHERE building_id = $1 AND deleted_at IS NULL`, id)
 		if err != nil {
 			return building{}, err
 		}
@@ -4157,15 +4414,20 @@ func (a *app) updateBuilding(
 		}
 		rows.Close()
 
-		currentUnderground := 0
-		currentAboveground := 0
+		// Levels may have gaps after deletes and reorders, so work with the
+		// sorted levels rather than assuming 1..N and -1..-N.
+		var undergroundLevels, abovegroundLevels []int
 		for _, entry := range levels {
 			if entry.Level < 0 {
-				currentUnderground++
+				undergroundLevels = append(undergroundLevels, entry.Level)
 			} else if entry.Level > 0 {
-				currentAboveground++
+				abovegroundLevels = append(abovegroundLevels, entry.Level)
 			}
 		}
+		sort.Sort(sort.Reverse(sort.IntSlice(undergroundLevels)))
+		sort.Ints(abovegroundLevels)
+		currentUnderground := len(undergroundLevels)
+		currentAboveground := len(abovegroundLevels)
 		targetUnderground := currentUnderground
 		targetAboveground := currentAboveground
 		if undergroundFloors != nil {
@@ -4179,112 +4441,59 @@ func (a *app) updateBuilding(
 		}
 
 		if targetUnderground < currentUnderground || targetAboveground < currentAboveground {
-			lowerLevel := -targetUnderground
-			upperLevel := targetAboveground
-			if _, err := tx.Exec(
-				`DELETE FROM workplace_bookings
-				  WHERE workplace_id IN (
-				    SELECT w.id
-				      FROM workplaces w
-				      JOIN coworkings c ON c.id = w.coworking_id
-				      JOIN floors f ON f.id = c.floor_id
-				     WHERE f.building_id = $1
-				       AND (f.level < $2 OR f.level > $3)
-				  )`,
-				id,
-				lowerLevel,
-				upperLevel,
-			); err != nil {
-				return building{}, err
-			}
-			if _, err := tx.Exec(
-				`DELETE FROM workplaces
-				  WHERE coworking_id IN (
-				    SELECT c.id
-				      FROM coworkings c
-				      JOIN floors f ON f.id = c.floor_id
-				     WHERE f.building_id = $1
-				       AND (f.level < $2 OR f.level > $3)
-				  )`,
-				id,
-				lowerLevel,
-				upperLevel,
-			); err != nil {
-				return building{}, err
-			}
-			if _, err := tx.Exec(
-				`DELETE FROM coworkings
-				  WHERE floor_id IN (
-				    SELECT f.id
-				      FROM floors f
-				     WHERE f.building_id = $1
-				       AND (f.level < $2 OR f.level > $3)
-				  )`,
-				id,
-				lowerLevel,
-				upperLevel,
-			); err != nil {
-				return building{}, err
-			}
-			if _, err := tx.Exec(
-				`DELETE FROM meeting_room_bookings
-				  WHERE meeting_room_id IN (
-				    SELECT m.id
-				      FROM meeting_rooms m
-				      JOIN floors f ON f.id = m.floor_id
-				     WHERE f.building_id = $1
-				       AND (f.level < $2 OR f.level > $3)
-				  )`,
-				id,
-				lowerLevel,
-				upperLevel,
-			); err != nil {
-				return building{}, err
+			lowerLevel := math.MinInt32
+			if targetUnderground < currentUnderground {
+				lowerLevel = 0
+				if targetUnderground > 0 {
+					lowerLevel = undergroundLevels[targetUnderground-1]
+				}
 			}
-			if _, err := tx.Exec(
-				`DELETE FROM meeting_rooms
-				  WHERE floor_id IN (
-				    SELECT f.id
-				      FROM floors f
-				     WHERE f.building_id = $1
-				       AND (f.level < $2 OR f.level > $3)
-				  )`,
-				id,
-				lowerLevel,
-				upperLevel,
-			); err != nil {
-				return building{}, err
+			upperLevel := math.MaxInt32
+			if targetAboveground < currentAboveground {
+				upperLevel = 0
+				if targetAboveground > 0 {
+					upperLevel = abovegroundLevels[targetAboveground-1]
+				}
 			}
-			_, err := tx.Exec(
-				`DELETE FROM floors WHERE building_id = $1 AND (level < $2 OR level > $3)`,
-				id,
-				lowerLevel,
-				upperLevel,
-			)
-			if err != nil {
-				return building{}, err
+			// Removed floors go to the trash one by one, so each can be
+			// restored on its own.
+			for _, entry := range levels {
+				if entry.Level >= lowerLevel && entry.Level <= upperLevel {
+					continue
+				}
+				if err := a.deleteFloorInTx(tx, entry.ID, actorEmployeeID); err != nil {
+					return building{}, err
+				}
 			}
 		}
 
 		if targetUnderground > currentUnderground {
-			for level := currentUnderground + 1; level <= targetUnderground; level++ {
-				floorName := fmt.Sprintf("B%d", level)
-				if _, err := a.createFloorInTx(tx, id, floorName, -level, ""); err != nil {
+			lowest := 0
+			if currentUnderground > 0 {
+				lowest = undergroundLevels[currentUnderground-1]
+			}
+			for i := 1; i <= targetUnderground-currentUnderground; i++ {
+				level := lowest - i
+				if _, err := a.createFloorInTx(tx, id, defaultFloorName(level), level, ""); err != nil {
 					return building{}, err
 				}
 			}
 		}
 		if targetAboveground > currentAboveground {
-			for level := currentAboveground + 1; level <= targetAboveground; level++ {
-				floorName := strconv.Itoa(level)
-				if _, err := a.createFloorInTx(tx, id, floorName, level, ""); err != nil {
+			highest := 0
+			if currentAboveground > 0 {
+				highest = abovegroundLevels[currentAboveground-1]
+			}
+			for i := 1; i <= targetAboveground-currentAboveground; i++ {
+				level := highest + i
+				if _, err := a.createFloorInTx(tx, id, defaultFloorName(level), level, ""); err != nil {
 					return building{}, err
 				}
 			}
 		}
 
 		floorRows, err := tx.Query(
-			`SELECT id FROM floors WHERE building_id = $1 ORDER BY level ASC`,
+			`SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL ORDER BY level ASC, id ASC`,
 			id,
 		)
 		if err != nil {
@@ -4320,21 +4529,6 @@ func (a *app) updateBuilding(
 	return a.getBuilding(id)
 }
 
-func (a *app) deleteBuilding(id int64) error {
-	result, err := a.db.Exec(`DELETE FROM office_buildings WHERE id = $1`, id)
-	if err != nil {
-		return err
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rows == 0 {
-		return errNotFound
-	}
-	return nil
-}
-
 func (a *app) clearBuildingImage(id int64) (building, error) {
 	existing, err := a.getBuilding(id)
 	if err != nil {
@@ -4343,16 +4537,17 @@ func (a *app) clearBuildingImage(id int64) (building, error) {
 	if existing.ImageURL == "" {
 		return existing, nil
 	}
-	if err := a.removeUploadedFile(existing.ImageURL); err != nil {
+	if err := a.removeBuildingImageFiles(existing); err != nil {
 		return building{}, err
 	}
-	return a.updateBuildingImage(id, "")
+	return a.updateBuildingImage(id, buildingImage{})
 }
 
-func (a *app) updateBuildingImage(id int64, imageURL string) (building, error) {
+func (a *app) updateBuildingImage(id int64, image buildingImage) (building, error) {
 	result, err := a.db.Exec(
-		`UPDATE office_buildings SET image_url = $1 WHERE id = $2`,
-		imageURL,
+		`UPDATE office_buildings SET image_url = $1, image_variants = $2 WHERE id = $3`,
+		image.URL,
+		encodeImageVariants(image.Variants),
 		id,
 	)
 	if err != nil {
@@ -4376,10 +4571,10 @@ func (a *app) listFloorsByBuilding(buildingID int64) ([]floor, error) {
 		        f.level,
 		        COALESCE(f.responsible_employee_id, '') AS responsible_employee_id,
 		        f.created_at,
-		        (SELECT COUNT(*) FROM coworkings c WHERE c.floor_id = f.id)
-		        + (SELECT COUNT(*) FROM meeting_rooms m WHERE m.floor_id = f.id) AS spaces_count
+		        (SELECT COUNT(*) FROM coworkings c WHERE c.floor_id = f.id AND c.deleted_at IS NULL)
+		        + (SELECT COUNT(*) FROM meeting_rooms m WHERE m.floor_id = f.id AND m.deleted_at IS NULL) AS spaces_count
 		   FROM floors f
-		  WHERE f.building_id = $1
+		  WHERE f.building_id = $1 AND f.deleted_at IS NULL
 		  ORDER BY f.id DESC`,
 		buildingID,
 	)
@@ -4402,6 +4597,7 @@ func (a *app) listFloorsByBuilding(buildingID int64) ([]floor, error) {
 		); err != nil {
 			return nil, err
 		}
+		f.Label = floorLabel(f.Name, f.Level)
 		items = append(items, f)
 	}
 	return items, rows.Err()
@@ -4417,7 +4613,7 @@ func (a *app) getFloor(id int64) (floor, error) {
 		        COALESCE(responsible_employee_id, '') AS responsible_employee_id,
 		        created_at
 		   FROM floors
-		  WHERE id = $1`,
+		  WHERE id = $1 AND deleted_at IS NULL`,
 		id,
 	)
 	var f floor
@@ -4435,6 +4631,7 @@ func (a *app) getFloor(id int64) (floor, error) {
 		}
 		return floor{}, err
 	}
+	f.Label = floorLabel(f.Name, f.Level)
 	return f, nil
 }
 
@@ -4458,7 +4655,7 @@ func (a *app) updateFloorResponsibleEmployeeID(id int64, employeeID string) erro
 	return nil
 }
 
-func (a *app) updateFloorPlan(id int64, planSVG string) (floor, error) {
+func (a *app) updateFloorPlan(id int64, planSVG, actorEmployeeID string) (floor, error) {
 	tx, err := a.db.Begin()
 	if err != nil {
 		return floor{}, err
@@ -4480,11 +4677,17 @@ func (a *app) updateFloorPlan(id int64, planSVG string) (floor, error) {
 		return floor{}, errNotFound
 	}
 	if planSVG == "" {
-		if _, err = tx.Exec(`DELETE FROM coworkings WHERE floor_id = $1`, id); err != nil {
+		// Without a plan the spaces cannot be placed any more; they go to
+		// the trash so that re-uploading the plan can bring them back.
+		var spaceIDs []int64
+		spaceIDs, err = activeFloorSpaceIDsInTx(tx, id)
+		if err != nil {
 			return floor{}, err
 		}
-		if _, err = tx.Exec(`DELETE FROM meeting_rooms WHERE floor_id = $1`, id); err != nil {
-			return floor{}, err
+		for _, spaceID := range spaceIDs {
+			if err = a.deleteSpaceInTx(tx, spaceID, actorEmployeeID); err != nil {
+				return floor{}, err
+			}
 		}
 	}
 	if err = tx.Commit(); err != nil {
@@ -4529,108 +4732,6 @@ func (a *app) updateFloorDetails(id int64, name *string, responsibleEmployeeID *
 	return a.getFloor(id)
 }
 
-func (a *app) deleteFloorAndShift(id int64) error {
-	tx, err := a.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			_ = tx.Rollback()
-		}
-	}()
-	var buildingID int64
-	var level int
-	row := tx.QueryRow(`SELECT building_id, level FROM floors WHERE id = $1`, id)
-	if err = row.Scan(&buildingID, &level); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errNotFound
-		}
-		return err
-	}
-	if _, err = tx.Exec(
-		`DELETE FROM workplace_bookings
-		  WHERE workplace_id IN (
-		    SELECT w.id FROM workplaces w
-		    JOIN coworkings c ON c.id = w.coworking_id
-		    WHERE c.floor_id = $1
-		  )`,
-		id,
-	); err != nil {
-		return err
-	}
-	if _, err = tx.Exec(
-		`DELETE FROM workplaces
-		  WHERE coworking_id IN (SELECT id FROM coworkings WHERE floor_id = $1)`,
-		id,
-	); err != nil {
-		return err
-	}
-	if _, err = tx.Exec(`DELETE FROM coworkings WHERE floor_id = $1`, id); err != nil {
-		return err
-	}
-	if _, err = tx.Exec(
-		`DELETE FROM meeting_room_bookings
-		  WHERE meeting_room_id IN (SELECT id FROM meeting_rooms WHERE floor_id = $1)`,
-		id,
-	); err != nil {
-		return err
-	}
-	if _, err = tx.Exec(`DELETE FROM meeting_rooms WHERE floor_id = $1`, id); err != nil {
-		return err
-	}
-	if _, err = tx.Exec(`DELETE FROM floors WHERE id = $1`, id); err != nil {
-		return err
-	}
-	if level >= 0 {
-		if _, err = tx.Exec(
-			`UPDATE floors SET level = level - 1 WHERE building_id = $1 AND level > $2`,
-			buildingID,
-			level,
-		); err != nil {
-			return err
-		}
-	} else {
-		if _, err = tx.Exec(
-			`UPDATE floors SET level = level + 1 WHERE building_id = $1 AND level < $2`,
-			buildingID,
-			level,
-		); err != nil {
-			return err
-		}
-	}
-	rows, err := tx.Query(
-		`SELECT id FROM floors WHERE building_id = $1 ORDER BY id`,
-		buildingID,
-	)
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-	ids := make([]int64, 0)
-	for rows.Next() {
-		var floorID int64
-		if err := rows.Scan(&floorID); err != nil {
-			return err
-		}
-		ids = append(ids, floorID)
-	}
-	if err := rows.Err(); err != nil {
-		return err
-	}
-	floorsJSON, err := encodeFloorIDs(ids)
-	if err != nil {
-		return err
-	}
-	if _, err = tx.Exec(`UPDATE office_buildings SET floors = $1 WHERE id = $2`, floorsJSON, buildingID); err != nil {
-		return err
-	}
-	if err = tx.Commit(); err != nil {
-		return err
-	}
-	return nil
-}
-
 func (a *app) createFloor(buildingID int64, name string, level int, planSVG string) (floor, error) {
 	var id int64
 	if err := a.db.QueryRow(
@@ -4649,6 +4750,7 @@ func (a *app) createFloor(buildingID int64, name string, level int, planSVG stri
 		BuildingID:            buildingID,
 		Name:                  name,
 		Level:                 level,
+		Label:                 floorLabel(name, level),
 		ResponsibleEmployeeID: "",
 		PlanSVG:               planSVG,
 		CreatedAt:             time.Now().UTC(),
@@ -4671,7 +4773,9 @@ func (a *app) createFloorInTx(tx *sql.Tx, buildingID int64, name string, level i
 	return id, nil
 }
 
-func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
+// listSpacesByFloor lists the active spaces of a floor. A non-zero zoneID
+// narrows the list to the spaces of that zone.
+func (a *app) listSpacesByFloor(floorID, zoneID int64) ([]space, error) {
 	rows, err := a.db.Query(
 		`SELECT id,
 		        floor_id,
@@ -4684,6 +4788,7 @@ func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
 		        COALESCE(color, ''),
 		        COALESCE(snapshot_hidden, 0),
 		        COALESCE(responsible_employee_id, ''),
+		        zone_id,
 		        created_at
 		   FROM (
 		     SELECT id,
@@ -4697,9 +4802,10 @@ func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
 		            COALESCE(color, '') AS color,
 		            COALESCE(snapshot_hidden, 0) AS snapshot_hidden,
 		            COALESCE(responsible_employee_id, '') AS responsible_employee_id,
+		            zone_id,
 		            created_at
 		       FROM coworkings
-		      WHERE floor_id = $1
+		      WHERE floor_id = $1 AND deleted_at IS NULL AND ($2::bigint = 0 OR zone_id = $2)
 		     UNION ALL
 		     SELECT id,
 		            floor_id,
@@ -4712,12 +4818,14 @@ func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
 		            COALESCE(color, '') AS color,
 		            0 AS snapshot_hidden,
 		            '' AS responsible_employee_id,
+		            zone_id,
 		            created_at
 		       FROM meeting_rooms
-		      WHERE floor_id = $1
+		      WHERE floor_id = $1 AND deleted_at IS NULL AND ($2::bigint = 0 OR zone_id = $2)
 		   ) s
 		  ORDER BY id DESC`,
 		floorID,
+		zoneID,
 	)
 	if err != nil {
 		return nil, err
@@ -4729,6 +4837,7 @@ func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
 		var s space
 		var pointsJSON string
 		var snapshotHidden int
+		var storedZoneID sql.NullInt64
 		if err := rows.Scan(
 			&s.ID,
 			&s.FloorID,
@@ -4741,10 +4850,14 @@ func (a *app) listSpacesByFloor(floorID int64) ([]space, error) {
 			&s.Color,
 			&snapshotHidden,
 			&s.ResponsibleEmployeeID,
+			&storedZoneID,
 			&s.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
+		if storedZoneID.Valid {
+			s.ZoneID = &storedZoneID.Int64
+		}
 		s.Points = decodePoints(pointsJSON)
 		s.SnapshotHidden = snapshotHidden != 0
 		items = append(items, s)
@@ -4765,6 +4878,7 @@ func (a *app) getSpace(id int64) (space, error) {
 		        COALESCE(color, ''),
 		        COALESCE(snapshot_hidden, 0),
 		        COALESCE(responsible_employee_id, ''),
+		        zone_id,
 		        created_at
 		   FROM (
 		     SELECT id,
@@ -4778,9 +4892,10 @@ func (a *app) getSpace(id int64) (space, error) {
 		            COALESCE(color, '') AS color,
 		            COALESCE(snapshot_hidden, 0) AS snapshot_hidden,
 		            COALESCE(responsible_employee_id, '') AS responsible_employee_id,
+		            zone_id,
 		            created_at
 		       FROM coworkings
-		      WHERE id = $1
+		      WHERE id = $1 AND deleted_at IS NULL
 		     UNION ALL
 		     SELECT id,
 		            floor_id,
@@ -4793,9 +4908,10 @@ func (a *app) getSpace(id int64) (space, error) {
 		            COALESCE(color, '') AS color,
 		            0 AS snapshot_hidden,
 		            '' AS responsible_employee_id,
+		            zone_id,
 		            created_at
 		       FROM meeting_rooms
-		      WHERE id = $1
+		      WHERE id = $1 AND deleted_at IS NULL
 		   ) s
 		  LIMIT 1`,
 		id,
@@ -4803,6 +4919,7 @@ func (a *app) getSpace(id int64) (space, error) {
 	var s space
 	var pointsStored string
 	var snapshotHidden int
+	var zoneID sql.NullInt64
 	if err := row.Scan(
 		&s.ID,
 		&s.FloorID,
@@ -4815,6 +4932,7 @@ func (a *app) getSpace(id int64) (space, error) {
 		&s.Color,
 		&snapshotHidden,
 		&s.ResponsibleEmployeeID,
+		&zoneID,
 		&s.CreatedAt,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -4824,18 +4942,21 @@ func (a *app) getSpace(id int64) (space, error) {
 	}
 	s.Points = decodePoints(pointsStored)
 	s.SnapshotHidden = snapshotHidden != 0
+	if zoneID.Valid {
+		s.ZoneID = &zoneID.Int64
+	}
 	return s, nil
 }
 
 func (a *app) getSpaceKind(id int64) (string, error) {
-	row := a.db.QueryRow(`SELECT id FROM coworkings WHERE id = $1`, id)
+	row := a.db.QueryRow(`SELECT id FROM coworkings WHERE id = $1 AND deleted_at IS NULL`, id)
 	var coworkingID int64
 	if err := row.Scan(&coworkingID); err == nil {
 		return "coworking", nil
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		return "", err
 	}
-	row = a.db.QueryRow(`SELECT id FROM meeting_rooms WHERE id = $1`, id)
+	row = a.db.QueryRow(`SELECT id FROM meeting_rooms WHERE id = $1 AND deleted_at IS NULL`, id)
 	var meetingID int64
 	if err := row.Scan(&meetingID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -4847,14 +4968,14 @@ func (a *app) getSpaceKind(id int64) (string, error) {
 }
 
 func (a *app) getSpaceFloorID(spaceID int64) (int64, error) {
-	row := a.db.QueryRow(`SELECT floor_id FROM coworkings WHERE id = $1`, spaceID)
+	row := a.db.QueryRow(`SELECT floor_id FROM coworkings WHERE id = $1 AND deleted_at IS NULL`, spaceID)
 	var floorID int64
 	if err := row.Scan(&floorID); err == nil {
 		return floorID, nil
 	} else if !errors.Is(err, sql.ErrNoRows) {
 		return 0, err
 	}
-	row = a.db.QueryRow(`SELECT floor_id FROM meeting_rooms WHERE id = $1`, spaceID)
+	row = a.db.QueryRow(`SELECT floor_id FROM meeting_rooms WHERE id = $1 AND deleted_at IS NULL`, spaceID)
 	if err := row.Scan(&floorID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, errNotFound
@@ -5032,8 +5153,8 @@ func (a *app) updateSpaceDetails(
 		}
 		defer tx.Rollback()
 		if _, err := tx.Exec(
-			`INSERT INTO coworkings (id, floor_id, name, subdivision_level_1, subdivision_level_2, points_json, color, snapshot_hidden, responsible_employee_id, created_at)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			`INSERT INTO coworkings (id, floor_id, name, subdivision_level_1, subdivision_level_2, points_json, color, snapshot_hidden, responsible_employee_id, zone_id, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
 			id,
 			current.FloorID,
 			name,
@@ -5043,6 +5164,7 @@ func (a *app) updateSpaceDetails(
 			color,
 			snapshotHidden,
 			responsibleValue,
+			current.ZoneID,
 			current.CreatedAt,
 		); err != nil {
 			return space{}, err
@@ -5093,14 +5215,15 @@ func (a *app) updateSpaceDetails(
 	}
 	defer tx.Rollback()
 	if _, err := tx.Exec(
-		`INSERT INTO meeting_rooms (id, floor_id, name, capacity, points_json, color, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		`INSERT INTO meeting_rooms (id, floor_id, name, capacity, points_json, color, zone_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		id,
 		current.FloorID,
 		name,
 		capacity,
 		pointsJSON,
 		color,
+		current.ZoneID,
 		current.CreatedAt,
 	); err != nil {
 		return space{}, err
@@ -5140,31 +5263,6 @@ func (a *app) updateSpaceSnapshotHidden(id int64, hidden bool) (space, error) {
 	return a.getSpace(id)
 }
 
-func (a *app) deleteSpace(id int64) error {
-	result, err := a.db.Exec(`DELETE FROM coworkings WHERE id = $1`, id)
-	if err != nil {
-		return err
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-	if rows == 0 {
-		result, err = a.db.Exec(`DELETE FROM meeting_rooms WHERE id = $1`, id)
-		if err != nil {
-			return err
-		}
-		rows, err = result.RowsAffected()
-		if err != nil {
-			return err
-		}
-		if rows == 0 {
-			return errNotFound
-		}
-	}
-	return nil
-}
-
 func (a *app) listDesksBySpace(spaceID int64) ([]desk, error) {
 	rows, err := a.db.Query(
 		`SELECT id, coworking_id, label, COALESCE(points_json, '{}'), created_at FROM workplaces WHERE coworking_id = $1 ORDER BY id DESC`,
@@ -5367,7 +5465,10 @@ func (a *app) getDesk(id int64) (desk, error) {
 	var d desk
 	var geomJSON string
 	row := a.db.QueryRow(
-		`SELECT id, coworking_id, label, COALESCE(points_json, '{}'), created_at FROM workplaces WHERE id = $1`,
+		`SELECT w.id, w.coworking_id, w.label, COALESCE(w.points_json, '{}'), w.created_at
+		   FROM workplaces w
+		   JOIN coworkings c ON c.id = w.coworking_id
+		  WHERE w.id = $1 AND c.deleted_at IS NULL`,
 		id,
 	)
 	if err := row.Scan(&d.ID, &d.SpaceID, &d.Label, &geomJSON, &d.CreatedAt); err != nil {
@@ -5496,7 +5597,7 @@ func (a *app) listMeetingRoomsBySpace(spaceID int64) ([]meetingRoom, error) {
 	rows, err := a.db.Query(
 		`SELECT id, floor_id, name, capacity, COALESCE(points_json, '[]'), COALESCE(color, ''), created_at
 		   FROM meeting_rooms
-		  WHERE floor_id = $1
+		  WHERE floor_id = $1 AND deleted_at IS NULL
 		  ORDER BY id DESC`,
 		floorID,
 	)
@@ -5615,60 +5716,14 @@ func decodeFloorIDs(payload string) []int64 {
 	return ids
 }
 
-func (a *app) saveBuildingImage(buildingID int64, file multipart.File, header *multipart.FileHeader) (string, error) {
-	defer file.Close()
-	if header.Size == 0 {
-		return "", errors.New("empty image file")
-	}
-	if header.Size > maxBuildingImageSize {
-		return "", errors.New("image file is too large")
-	}
-
-	buffer := make([]byte, 512)
-	n, readErr := file.Read(buffer)
-	if readErr != nil && !errors.Is(readErr, io.EOF) {
-		return "", readErr
-	}
-	contentType := http.DetectContentType(buffer[:n])
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext == "" {
-		ext = extensionFromContentType(contentType)
-	}
-	if !isAllowedImageType(ext, contentType) {
-		return "", errors.New("unsupported image type")
-	}
-
-	filename := fmt.Sprintf("building-%d-%d%s", buildingID, time.Now().UnixNano(), ext)
-	targetPath := filepath.Join(a.buildingUploadDir, filename)
-	output, err := os.Create(targetPath)
-	if err != nil {
-		return "", err
-	}
-	defer output.Close()
-
-	if _, err := io.Copy(output, io.MultiReader(bytes.NewReader(buffer[:n]), file)); err != nil {
-		return "", err
-	}
-	return "/" + filepath.ToSlash(filepath.Join(uploadDirName, buildingUploadDirName, filename)), nil
-}
-
 func (a *app) removeUploadedFile(imageURL string) error {
-	prefix := "/" + uploadDirName + "/"
-	if !strings.HasPrefix(imageURL, prefix) {
+	key, ok := objectKeyFromUploadURL(imageURL)
+	if !ok {
 		return nil
 	}
-	relativePath := strings.TrimPrefix(imageURL, prefix)
-	targetPath := filepath.Clean(filepath.Join(a.uploadDir, filepath.FromSlash(relativePath)))
-
-	// Prevent path traversal: ensure the resolved path stays within uploadDir.
-	if !strings.HasPrefix(targetPath, filepath.Clean(a.uploadDir)+string(filepath.Separator)) {
-		return errors.New("invalid file path: outside upload directory")
-	}
-
-	if err := os.Remove(targetPath); err != nil && !errors.Is(err, os.ErrNotExist) {
-		return err
-	}
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), storageOperationTimeout)
+	defer cancel()
+	return a.storage.Delete(ctx, key)
 }
 
 func extensionFromContentType(contentType string) string {
@@ -5711,7 +5766,7 @@ func (a *app) handleResponsibilities(w http.ResponseWriter, r *http.Request) {
 	requesterEmployeeID = strings.TrimSpace(requesterEmployeeID)
 
 	employeeID := strings.TrimSpace(r.URL.Query().Get("employee_id"))
-	if !hasPermission(requesterRole, permissionViewAnyResponsibilities) {
+	if !hasRequestPermission(r, requesterRole, permissionViewAnyResponsibilities) {
 		if requesterEmployeeID == "" {
 			respondError(w, http.StatusUnauthorized, "User identity is required")
 			return
@@ -5761,7 +5816,7 @@ func (a *app) handleResponsibilities(w http.ResponseWriter, r *http.Request) {
 
 	// Buildings where user is responsible.
 	buildingRows, err := a.db.Query(
-		`SELECT id, name, address FROM office_buildings WHERE responsible_employee_id = $1 ORDER BY name`,
+		`SELECT id, name, address FROM office_buildings WHERE responsible_employee_id = $1 AND deleted_at IS NULL ORDER BY name`,
 		employeeID,
 	)
 	if err != nil {
@@ -5791,7 +5846,7 @@ func (a *app) handleResponsibilities(w http.ResponseWriter, r *http.Request) {
 		`SELECT f.id, f.name, f.level, b.id, b.name, b.address
 		   FROM floors f
 		   JOIN office_buildings b ON b.id = f.building_id
-		  WHERE f.responsible_employee_id = $1
+		  WHERE f.responsible_employee_id = $1 AND f.deleted_at IS NULL
 		  ORDER BY b.name, f.level`,
 		employeeID,
 	)
@@ -5824,7 +5879,7 @@ func (a *app) handleResponsibilities(w http.ResponseWriter, r *http.Request) {
 		   FROM coworkings c
 		   JOIN floors f ON f.id = c.floor_id
 		   JOIN office_buildings b ON b.id = f.building_id
-		  WHERE c.responsible_employee_id = $1
+		  WHERE c.responsible_employee_id = $1 AND c.deleted_at IS NULL
 		  ORDER BY b.name, f.level, c.name`,
 		employeeID,
 	)
@@ -5884,7 +5939,7 @@ func (a *app) loadResponsibilitiesForToken(employeeID string) *TokenResponsibili
 
 	// Load building IDs
 	buildingRows, err := a.db.Query(
-		`SELECT id FROM office_buildings WHERE responsible_employee_id = $1`,
+		`SELECT id FROM office_buildings WHERE responsible_employee_id = $1 AND deleted_at IS NULL`,
 		employeeID,
 	)
 	if err == nil {
@@ -5899,7 +5954,7 @@ func (a *app) loadResponsibilitiesForToken(employeeID string) *TokenResponsibili
 
 	// Load floor IDs
 	floorRows, err := a.db.Query(
-		`SELECT id FROM floors WHERE responsible_employee_id = $1`,
+		`SELECT id FROM floors WHERE responsible_employee_id = $1 AND deleted_at IS NULL`,
 		employeeID,
 	)
 	if err == nil {
@@ -5914,7 +5969,7 @@ func (a *app) loadResponsibilitiesForToken(employeeID string) *TokenResponsibili
 
 	// Load coworking IDs
 	coworkingRows, err := a.db.Query(
-		`SELECT id FROM coworkings WHERE responsible_employee_id = $1`,
+		`SELECT id FROM coworkings WHERE responsible_employee_id = $1 AND deleted_at IS NULL`,
 		employeeID,
 	)
 	if err == nil {
@@ -5927,6 +5982,15 @@ func (a *app) loadResponsibilitiesForToken(employeeID string) *TokenResponsibili
 		}
 	}
 
+	// Objects delegated to the user count while the delegation is active.
+	if delegated, err := a.loadActiveDelegations(context.Background(), employeeID); err == nil {
+		buildingIDs = appendMissingIDs(buildingIDs, delegated[auditEntityBuilding])
+		floorIDs = appendMissingIDs(floorIDs, delegated[auditEntityFloor])
+		coworkingIDs = appendMissingIDs(coworkingIDs, delegated[auditEntityCoworking])
+	} else {
+		log.Printf("responsibilities: failed to load delegations of %s: %v", employeeID, err)
+	}
+
 	// Return nil if user has no responsibilities at all
 	if len(buildingIDs) == 0 && len(floorIDs) == 0 && len(coworkingIDs) == 0 {
 		return nil
@@ -105,6 +105,7 @@ func (a *app) handleListMyMeetingRoomBookings(w http.ResponseWriter, r *http.Req
 		   JOIN floors f ON f.id = m.floor_id
 		   JOIN office_buildings ob ON ob.id = f.building_id
 		  WHERE b.applier_employee_id = $1 AND b.end_at > now() AND b.cancelled_at IS NULL
+		    AND m.deleted_at IS NULL
 		  ORDER BY b.start_at ASC`,
 		employeeID,
 	)
@@ -549,7 +550,7 @@ func (a *app) handleCancelMeetingRoomBooking(w http.ResponseWriter, r *http.Requ
 }
 
 func (a *app) ensureMeetingSpace(spaceID int64) error {
-	row := a.db.QueryRow(`SELECT id FROM meeting_rooms WHERE id = $1`, spaceID)
+	row := a.db.QueryRow(`SELECT id FROM meeting_rooms WHERE id = $1 AND deleted_at IS NULL`, spaceID)
 	var id int64
 	if err := row.Scan(&id); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
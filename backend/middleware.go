@@ -8,8 +8,6 @@ import (
 	"net/url"
 	"os"
 	"strings"
-	"sync"
-	"time"
 )
 
 type statusRecorder struct {
@@ -22,6 +20,12 @@ func (rec *statusRecorder) WriteHeader(code int) {
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach Flush and deadlines of the
+// underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
 // --- Auth context ---
 
 type contextKey string
@@ -67,13 +71,22 @@ func (a *app) authMiddleware(next http.Handler) http.Handler {
 			"/api/user/info",
 			"/api/user/wb-band",
 		}
+		// Session management lives under /api/auth/ so that it receives the
+		// refresh cookie, but it is not public.
+		isSessionsPath := path == authSessionsPath || strings.HasPrefix(path, authSessionsPath+"/")
 		for _, publicPath := range publicPaths {
-			if strings.HasPrefix(path, publicPath) {
+			if !isSessionsPath && strings.HasPrefix(path, publicPath) {
 				next.ServeHTTP(w, r)
 				return
 			}
 		}
 
+		// Machine clients authenticate with a service account API key.
+		if token := extractBearerToken(r.Header.Get("Authorization")); isAPIKeyToken(token) {
+			a.serveAPIKeyRequest(w, r, next, token)
+			return
+		}
+
 		// ── Require Office-Access-Token (server-signed JWT) for all protected endpoints ──
 		// Single channel only: HttpOnly cookie for browser/API clients behind trusted frontend.
 		// Reject the legacy header explicitly to avoid mixed-channel auth.
@@ -113,6 +126,10 @@ func (a *app) authMiddleware(next http.Handler) http.Handler {
 		}
 		ctx := context.WithValue(r.Context(), authClaimsCtxKey, claims)
 		ctx = context.WithValue(ctx, officeAccessTokenClaimsCtxKey, atClaims)
+		if atClaims.Impersonation != nil {
+			a.serveImpersonatedRequest(w, r.WithContext(ctx), next, atClaims)
+			return
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -139,7 +156,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Office-Refresh-Token, X-Device-ID, X-CSRF-Token, deviceid, devicename, Accept, Cache-Control, Pragma, X-Cookie, wb-apptype, Origin, Referer")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Authorization, Office-Refresh-Token, X-Set-Cookie, Content-Disposition")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Type, Authorization, Office-Refresh-Token, X-Set-Cookie, Content-Disposition, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After")
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
 		if r.Method == http.MethodOptions {
@@ -283,72 +300,6 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// --- IP-based rate limiter ---
-
-type ipRateLimiter struct {
-	mu       sync.Mutex
-	requests map[string][]time.Time
-	limit    int
-	window   time.Duration
-}
-
-func newIPRateLimiter(limit int, window time.Duration) *ipRateLimiter {
-	rl := &ipRateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
-	}
-	go rl.cleanupLoop()
-	return rl
-}
-
-func (rl *ipRateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	for range ticker.C {
-		rl.mu.Lock()
-		cutoff := time.Now().Add(-rl.window)
-		for ip, times := range rl.requests {
-			var valid []time.Time
-			for _, t := range times {
-				if t.After(cutoff) {
-					valid = append(valid, t)
-				}
-			}
-			if len(valid) == 0 {
-				delete(rl.requests, ip)
-			} else {
-				rl.requests[ip] = valid
-			}
-		}
-		rl.mu.Unlock()
-	}
-}
-
-func (rl *ipRateLimiter) allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	now := time.Now()
-	cutoff := now.Add(-rl.window)
-
-	times := rl.requests[ip]
-	var valid []time.Time
-	for _, t := range times {
-		if t.After(cutoff) {
-			valid = append(valid, t)
-		}
-	}
-
-	if len(valid) >= rl.limit {
-		rl.requests[ip] = valid
-		return false
-	}
-
-	rl.requests[ip] = append(valid, now)
-	return true
-}
-
 func clientIP(r *http.Request) string {
 	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
 		parts := strings.Split(forwarded, ",")
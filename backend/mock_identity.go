@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The dev mock identity provider serves the auth-hrtech.wb.ru and team.wb.ru
+// contracts the WB provider relies on from local fixtures, so the whole login
+// flow works offline. Requests never leave the process: the mock is wired in
+// as the provider's upstreamDoer.
+const (
+	officeEnvDevelopment = "development"
+	officeEnvProduction  = "production"
+
+	mockIdentityBaseURL    = "http://mock-idp.invalid"
+	mockIdentityStickerTTL = 5 * time.Minute
+	mockIdentityTokenTTL   = 12 * time.Hour
+)
+
+var errMockIdentityInProduction = errors.New("OFFICE_DEV_MOCK_IDP is only allowed with OFFICE_ENV=development")
+
+// officeEnvironment returns OFFICE_ENV. Anything but an explicit
+// "development" is treated as production.
+func officeEnvironment() string {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("OFFICE_ENV")), officeEnvDevelopment) {
+		return officeEnvDevelopment
+	}
+	return officeEnvProduction
+}
+
+type mockIdentityFixtures struct {
+	// Code is the one-time code accepted for every phone number.
+	Code  int                `json:"code"`
+	Users []mockIdentityUser `json:"users"`
+}
+
+type mockIdentityUser struct {
+	Phone      string `json:"phone"`
+	ProfileID  string `json:"profile_id"`
+	WbUserID   string `json:"wb_user_id"`
+	EmployeeID string `json:"employee_id"`
+	FullName   string `json:"full_name"`
+	AvatarURL  string `json:"avatar_url"`
+	WBBand     string `json:"wb_band"`
+}
+
+func defaultMockIdentityFixtures() mockIdentityFixtures {
+	return mockIdentityFixtures{
+		Code: 111111,
+		Users: []mockIdentityUser{
+			{Phone: "79990000001", ProfileID: "1001", WbUserID: "9001", EmployeeID: "DEV-0001", FullName: "Разработчик Тестовый", WBBand: "Office"},
+			{Phone: "79990000002", ProfileID: "1002", WbUserID: "9002", EmployeeID: "DEV-0002", FullName: "Сотрудник Тестовый", WBBand: "Office"},
+		},
+	}
+}
+
+// loadMockIdentityFixtures reads fixtures from path, or returns the built-in
+// set when path is empty.
+func loadMockIdentityFixtures(path string) (mockIdentityFixtures, error) {
+	if path == "" {
+		return defaultMockIdentityFixtures(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mockIdentityFixtures{}, fmt.Errorf("read mock identity fixtures: %w", err)
+	}
+	var fixtures mockIdentityFixtures
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return mockIdentityFixtures{}, fmt.Errorf("parse mock identity fixtures: %w", err)
+	}
+	if fixtures.Code <= 0 {
+		return mockIdentityFixtures{}, errors.New("mock identity fixtures: code must be a positive number")
+	}
+	if len(fixtures.Users) == 0 {
+		return mockIdentityFixtures{}, errors.New("mock identity fixtures: at least one user is required")
+	}
+	for i := range fixtures.Users {
+		user := &fixtures.Users[i]
+		user.Phone = normalizeMockPhone(user.Phone)
+		if user.Phone == "" || !profileIDPattern.MatchString(user.ProfileID) || strings.TrimSpace(user.EmployeeID) == "" {
+			return mockIdentityFixtures{}, fmt.Errorf("mock identity fixtures: user %d needs phone, numeric profile_id and employee_id", i)
+		}
+	}
+	return fixtures, nil
+}
+
+func normalizeMockPhone(raw string) string {
+	var b strings.Builder
+	for _, r := range raw {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+type mockIdentitySticker struct {
+	user      int
+	expiresAt time.Time
+}
+
+type mockIdentityToken struct {
+	user      int
+	expiresAt time.Time
+}
+
+// mockIdentityServer keeps issued stickers and access tokens in memory; they
+// do not survive a restart, just like a real login would not survive a wiped
+// upstream session.
+type mockIdentityServer struct {
+	fixtures mockIdentityFixtures
+	now      func() time.Time
+	mux      *http.ServeMux
+
+	mu       sync.Mutex
+	stickers map[string]mockIdentitySticker
+	tokens   map[string]mockIdentityToken
+}
+
+func newMockIdentityServer(fixtures mockIdentityFixtures) *mockIdentityServer {
+	m := &mockIdentityServer{
+		fixtures: fixtures,
+		now:      time.Now,
+		mux:      http.NewServeMux(),
+		stickers: make(map[string]mockIdentitySticker),
+		tokens:   make(map[string]mockIdentityToken),
+	}
+	m.mux.HandleFunc("/v2/code/wb-captcha", m.handleRequestCode)
+	m.mux.HandleFunc("/v2/auth", m.handleConfirm)
+	m.mux.HandleFunc("/api/v1/user/info", m.handleUserInfo)
+	m.mux.HandleFunc("/api/v1/user/profile/", m.handleWBBand)
+	return m
+}
+
+// newMockIdentityProvider returns a WB provider whose upstream is the mock.
+func newMockIdentityProvider(fixtures mockIdentityFixtures) (*wbIdentityProvider, *mockIdentityServer, error) {
+	mock := newMockIdentityServer(fixtures)
+	provider, err := newWBIdentityProvider(mockIdentityBaseURL, mockIdentityBaseURL, mock.do)
+	if err != nil {
+		return nil, nil, err
+	}
+	return provider, mock, nil
+}
+
+// loadMockIdentityProviderFromEnv enables the mock when OFFICE_DEV_MOCK_IDP is
+// set. It returns nil when the flag is off and refuses to start outside
+// OFFICE_ENV=development.
+func loadMockIdentityProviderFromEnv() (identityProvider, error) {
+	if !isTrueEnv("OFFICE_DEV_MOCK_IDP") {
+		return nil, nil
+	}
+	if officeEnvironment() != officeEnvDevelopment {
+		return nil, errMockIdentityInProduction
+	}
+	fixtures, err := loadMockIdentityFixtures(strings.TrimSpace(os.Getenv("OFFICE_DEV_MOCK_IDP_FIXTURES")))
+	if err != nil {
+		return nil, err
+	}
+	provider, _, err := newMockIdentityProvider(fixtures)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("WARNING: dev mock identity provider is enabled — any of %d fixture users can log in with code %d", len(fixtures.Users), fixtures.Code)
+	return provider, nil
+}
+
+// do serves req in-process and implements upstreamDoer.
+func (m *mockIdentityServer) do(req *http.Request, _ bool) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	rec := httptest.NewRecorder()
+	m.mux.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+func (m *mockIdentityServer) userByPhone(phone string) (int, bool) {
+	for i, user := range m.fixtures.Users {
+		if user.Phone == phone {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// handleRequestCode mirrors POST /v2/code/wb-captcha.
+func (m *mockIdentityServer) handleRequestCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		PhoneNumber string `json:"phone_number"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]any{"result": 1, "error": "invalid request body"})
+		return
+	}
+	user, ok := m.userByPhone(normalizeMockPhone(payload.PhoneNumber))
+	if !ok {
+		respondJSON(w, http.StatusOK, map[string]any{"result": 4, "error": "Пользователь не найден"})
+		return
+	}
+	sticker, err := randomMockToken()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]any{"result": 1, "error": "internal error"})
+		return
+	}
+	m.mu.Lock()
+	m.stickers[sticker] = mockIdentitySticker{user: user, expiresAt: m.now().Add(mockIdentityStickerTTL)}
+	m.mu.Unlock()
+	respondJSON(w, http.StatusOK, map[string]any{
+		"result":  0,
+		"payload": map[string]any{"sticker": sticker, "ttl": int(mockIdentityStickerTTL / time.Second)},
+	})
+}
+
+// handleConfirm mirrors POST /v2/auth. A sticker is single-use.
+func (m *mockIdentityServer) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		Code    int    `json:"code"`
+		Sticker string `json:"sticker"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]any{"result": 1, "error": "invalid request body"})
+		return
+	}
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sticker, ok := m.stickers[payload.Sticker]
+	if !ok || now.After(sticker.expiresAt) {
+		delete(m.stickers, payload.Sticker)
+		respondJSON(w, http.StatusOK, map[string]any{"result": 5, "error": "Код устарел, запросите новый"})
+		return
+	}
+	if payload.Code != m.fixtures.Code {
+		respondJSON(w, http.StatusOK, map[string]any{"result": 6, "error": "Неверный код"})
+		return
+	}
+	delete(m.stickers, payload.Sticker)
+	token, err := randomMockToken()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]any{"result": 1, "error": "internal error"})
+		return
+	}
+	m.tokens[token] = mockIdentityToken{user: sticker.user, expiresAt: now.Add(mockIdentityTokenTTL)}
+	respondJSON(w, http.StatusOK, map[string]any{"result": 0, "payload": map[string]any{"access_token": token}})
+}
+
+// authorizedUser resolves the bearer token of a team.wb.ru request.
+func (m *mockIdentityServer) authorizedUser(r *http.Request) (mockIdentityUser, bool) {
+	token := extractBearerToken(r.Header.Get("Authorization"))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	issued, ok := m.tokens[token]
+	if !ok || m.now().After(issued.expiresAt) {
+		return mockIdentityUser{}, false
+	}
+	return m.fixtures.Users[issued.user], true
+}
+
+// handleUserInfo mirrors GET /api/v1/user/info.
+func (m *mockIdentityServer) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	user, ok := m.authorizedUser(r)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]any{"status": 401, "error": "unauthorized"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{
+		"status": 200,
+		"data": map[string]any{
+			"id":         user.ProfileID,
+			"wbUserID":   user.WbUserID,
+			"employeeID": user.EmployeeID,
+			"fullName":   user.FullName,
+			"name":       user.FullName,
+			"avatar_url": user.AvatarURL,
+		},
+	})
+}
+
+// handleWBBand mirrors GET /api/v1/user/profile/{id}/wbband. Users may only
+// read their own band.
+func (m *mockIdentityServer) handleWBBand(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/user/profile/"), "/wbband")
+	if r.Method != http.MethodGet || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	user, ok := m.authorizedUser(r)
+	if !ok {
+		respondJSON(w, http.StatusUnauthorized, map[string]any{"status": 401, "error": "unauthorized"})
+		return
+	}
+	if user.ProfileID != profileID {
+		respondJSON(w, http.StatusForbidden, map[string]any{"status": 403, "error": "forbidden"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"status": 200, "data": map[string]any{"wb_band": user.WBBand}})
+}
+
+func randomMockToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "mock-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Offboarding removes a leaving employee from day-to-day operation in one
+// step: the user is deactivated, bookings from today on are cancelled, every
+// refresh token is revoked and the buildings, floors, coworkings, zones and
+// resources the employee is responsible for are handed over to another
+// employee or left without a responsible. GET previews the impact, POST
+// applies it.
+const (
+	adminOffboardingPath = "/api/admin/offboarding"
+
+	auditActionOffboard = "offboard"
+)
+
+var errOffboardingTargetInvalid = errors.New("reassign_to must be an active employee other than the one being offboarded")
+
+// offboardingResponsibilityTables lists the entities that carry a
+// responsible_employee_id, keyed by their responsibility_audit_log type.
+var offboardingResponsibilityTables = []struct {
+	EntityType string
+	Table      string
+}{
+	{"building", "office_buildings"},
+	{"floor", "floors"},
+	{"coworking", "coworkings"},
+	{auditEntityZone, "zones"},
+	{auditEntityResource, "resources"},
+}
+
+type offboardingBooking struct {
+	ID     int64      `json:"id"`
+	Kind   string     `json:"kind"`
+	Target string     `json:"target"`
+	Date   string     `json:"date,omitempty"`
+	Start  *time.Time `json:"start_at,omitempty"`
+	End    *time.Time `json:"end_at,omitempty"`
+}
+
+type offboardingResponsibility struct {
+	EntityType string `json:"entity_type"`
+	EntityID   int64  `json:"entity_id"`
+	Name       string `json:"name"`
+}
+
+type offboardingReport struct {
+	EmployeeID       string                      `json:"employee_id"`
+	FullName         string                      `json:"full_name"`
+	UserFound        bool                        `json:"user_found"`
+	AlreadyInactive  bool                        `json:"already_inactive"`
+	ReassignTo       string                      `json:"reassign_to"`
+	Bookings         []offboardingBooking        `json:"bookings"`
+	Responsibilities []offboardingResponsibility `json:"responsibilities"`
+	ActiveSessions   int                         `json:"active_sessions"`
+	Applied          bool                        `json:"applied"`
+}
+
+type offboardingRequest struct {
+	EmployeeID string `json:"employee_id"`
+	ReassignTo string `json:"reassign_to"`
+}
+
+func (a *app) handleAdminOffboarding(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermissionFresh(w, r, a.db, permissionOffboardUsers) {
+		return
+	}
+	var req offboardingRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.EmployeeID = r.URL.Query().Get("employee_id")
+		req.ReassignTo = r.URL.Query().Get("reassign_to")
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req.EmployeeID = strings.TrimSpace(req.EmployeeID)
+	req.ReassignTo = strings.TrimSpace(req.ReassignTo)
+	if err := req.validate(a.requestActorEmployeeID(r)); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requesterRole, err := resolveRoleFromRequestFresh(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return
+	}
+	if err := a.checkOffboardingRole(r.Context(), requesterRole, req.EmployeeID); err != nil {
+		if errors.Is(err, errRoleAssignmentAdminOnly) || errors.Is(err, errRoleAssignmentNotCovered) {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := a.validateOffboardingTarget(r.Context(), req); err != nil {
+		if errors.Is(err, errOffboardingTargetInvalid) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		report, err := a.previewOffboarding(r.Context(), a.db, req)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	report, err := a.applyOffboarding(r.Context(), req, a.requestActorEmployeeID(r))
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.auditOffboarding(r, report)
+	respondJSON(w, http.StatusOK, report)
+}
+
+// validate checks what can be decided without the database: who is being
+// offboarded, by whom and to whom responsibilities go.
+func (req offboardingRequest) validate(actorEmployeeID string) error {
+	switch {
+	case req.EmployeeID == "":
+		return errors.New("employee_id is required")
+	case isServiceAccountPrincipal(req.EmployeeID):
+		return errors.New("service accounts are disabled through " + adminServiceAccountsPath)
+	case req.EmployeeID == actorEmployeeID:
+		return errors.New("cannot offboard yourself")
+	case req.ReassignTo != "" && (req.ReassignTo == req.EmployeeID || isServiceAccountPrincipal(req.ReassignTo)):
+		return errOffboardingTargetInvalid
+	}
+	return nil
+}
+
+// checkOffboardingRole applies the role assignment rule to offboarding:
+// it takes away everything the employee holds, so only admins offboard
+// admins and nobody offboards a role with permissions they do not hold.
+func (a *app) checkOffboardingRole(ctx context.Context, requesterRole int, employeeID string) error {
+	targetRole, err := getUserRoleByWbUserID(ctx, a.db, employeeID)
+	if err != nil {
+		return err
+	}
+	return checkRoleAssignment(requesterRole, targetRole, targetRole)
+}
+
+// validateOffboardingTarget checks that reassign_to is an active employee.
+func (a *app) validateOffboardingTarget(ctx context.Context, req offboardingRequest) error {
+	if req.ReassignTo == "" {
+		return nil
+	}
+	var ok bool
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE employee_id = $1 AND active)`,
+		req.ReassignTo,
+	).Scan(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return errOffboardingTargetInvalid
+	}
+	return nil
+}
+
+type offboardingQueryer interface {
+	rowQueryer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// previewOffboarding collects everything applyOffboarding would change.
+func (a *app) previewOffboarding(ctx context.Context, q offboardingQueryer, req offboardingRequest) (offboardingReport, error) {
+	report := offboardingReport{
+		EmployeeID:       req.EmployeeID,
+		ReassignTo:       req.ReassignTo,
+		Bookings:         make([]offboardingBooking, 0),
+		Responsibilities: make([]offboardingResponsibility, 0),
+	}
+	var active bool
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(full_name, ''), active FROM users WHERE employee_id = $1 ORDER BY id LIMIT 1`,
+		req.EmployeeID,
+	).Scan(&report.FullName, &active)
+	switch {
+	case err == nil:
+		report.UserFound = true
+		report.AlreadyInactive = !active
+	case !errors.Is(err, sql.ErrNoRows):
+		return report, err
+	}
+
+	// Desk bookings are dates in the building's timezone. The query takes
+	// every date that is still today somewhere; the rest is decided per
+	// building by offboardingDeskBookingDue.
+	now := time.Now()
+	rows, err := q.QueryContext(ctx,
+		`SELECT b.id, COALESCE(w.label, ''), b.date, COALESCE(ob.timezone, '')
+		   FROM workplace_bookings b
+		   LEFT JOIN workplaces w ON w.id = b.workplace_id
+		   LEFT JOIN coworkings c ON c.id = w.coworking_id
+		   LEFT JOIN floors f ON f.id = c.floor_id
+		   LEFT JOIN office_buildings ob ON ob.id = f.building_id
+		  WHERE b.applier_employee_id = $1 AND b.date >= $2 AND b.cancelled_at IS NULL
+		  ORDER BY b.date, b.id`,
+		req.EmployeeID,
+		now.UTC().Add(-12*time.Hour).Format("2006-01-02"),
+	)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		item := offboardingBooking{Kind: "desk"}
+		var timezone string
+		if err := rows.Scan(&item.ID, &item.Target, &item.Date, &timezone); err != nil {
+			rows.Close()
+			return report, err
+		}
+		if offboardingDeskBookingDue(item.Date, timezone, now) {
+			report.Bookings = append(report.Bookings, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return report, err
+	}
+	rows.Close()
+
+	bookingQueries := []struct {
+		kind  string
+		query string
+	}{
+		{"meeting_room",
+			`SELECT b.id, COALESCE(m.name, ''), '', b.start_at, b.end_at
+			   FROM meeting_room_bookings b
+			   LEFT JOIN meeting_rooms m ON m.id = b.meeting_room_id
+			  WHERE b.applier_employee_id = $1 AND b.end_at > now() AND b.cancelled_at IS NULL
+			  ORDER BY b.start_at, b.id`},
+		{"resource",
+			`SELECT b.id, COALESCE(res.name, ''), '', b.start_at, b.end_at
+			   FROM resource_bookings b
+			   LEFT JOIN resources res ON res.id = b.resource_id
+			  WHERE b.applier_employee_id = $1 AND b.end_at > now() AND b.cancelled_at IS NULL
+			  ORDER BY b.start_at, b.id`},
+	}
+	for _, bq := range bookingQueries {
+		rows, err := q.QueryContext(ctx, bq.query, req.EmployeeID)
+		if err != nil {
+			return report, err
+		}
+		for rows.Next() {
+			item := offboardingBooking{Kind: bq.kind}
+			var start, end sql.NullTime
+			if err := rows.Scan(&item.ID, &item.Target, &item.Date, &start, &end); err != nil {
+				rows.Close()
+				return report, err
+			}
+			if start.Valid && end.Valid {
+				item.Start, item.End = &start.Time, &end.Time
+			}
+			report.Bookings = append(report.Bookings, item)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return report, err
+		}
+		rows.Close()
+	}
+
+	for _, rt := range offboardingResponsibilityTables {
+		rows, err := q.QueryContext(ctx,
+			fmt.Sprintf(`SELECT id, COALESCE(name, '') FROM %s WHERE responsible_employee_id = $1 ORDER BY id`, rt.Table),
+			req.EmployeeID,
+		)
+		if err != nil {
+			return report, err
+		}
+		for rows.Next() {
+			item := offboardingResponsibility{EntityType: rt.EntityType}
+			if err := rows.Scan(&item.EntityID, &item.Name); err != nil {
+				rows.Close()
+				return report, err
+			}
+			report.Responsibilities = append(report.Responsibilities, item)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return report, err
+		}
+		rows.Close()
+	}
+
+	if err := q.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT family_id)
+		   FROM office_refresh_tokens
+		  WHERE employee_id = $1 AND revoked_at IS NULL AND expires_at > now()`,
+		req.EmployeeID,
+	).Scan(&report.ActiveSessions); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// applyOffboarding performs the offboarding in one transaction and returns
+// what was changed. Refresh tokens are revoked once the transaction commits.
+func (a *app) applyOffboarding(ctx context.Context, req offboardingRequest, actorEmployeeID string) (offboardingReport, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return offboardingReport{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	report, err := a.previewOffboarding(ctx, tx, req)
+	if err != nil {
+		return report, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET active = FALSE, updated_at = now() WHERE employee_id = $1 AND active`,
+		req.EmployeeID,
+	); err != nil {
+		return report, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE workplace_bookings
+		    SET cancelled_at = now(), canceller_employee_id = $2
+		  WHERE id = ANY($1) AND cancelled_at IS NULL`,
+		report.deskBookingIDs(),
+		actorEmployeeID,
+	); err != nil {
+		return report, err
+	}
+	cancellations := []string{
+		`UPDATE meeting_room_bookings
+		    SET cancelled_at = now(), canceller_employee_id = $2
+		  WHERE applier_employee_id = $1 AND end_at > now() AND cancelled_at IS NULL`,
+		`UPDATE resource_bookings
+		    SET cancelled_at = now(), canceller_employee_id = $2
+		  WHERE applier_employee_id = $1 AND end_at > now() AND cancelled_at IS NULL`,
+	}
+	for _, stmt := range cancellations {
+		if _, err := tx.ExecContext(ctx, stmt, req.EmployeeID, actorEmployeeID); err != nil {
+			return report, err
+		}
+	}
+	// Delegations from and to the employee end with the employment.
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE responsibility_delegations
+		    SET revoked_at = now(), revoked_by_employee_id = $2
+		  WHERE (delegator_employee_id = $1 OR deputy_employee_id = $1)
+		    AND revoked_at IS NULL AND ends_at > now()`,
+		req.EmployeeID,
+		actorEmployeeID,
+	); err != nil {
+		return report, err
+	}
+	for _, rt := range offboardingResponsibilityTables {
+		if _, err := tx.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE %s SET responsible_employee_id = $2 WHERE responsible_employee_id = $1`, rt.Table),
+			req.EmployeeID,
+			req.ReassignTo,
+		); err != nil {
+			return report, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	report.Applied = true
+
+	if err := a.revokeAllUserRefreshTokens(ctx, req.EmployeeID); err != nil {
+		log.Printf("offboarding: failed to revoke refresh tokens of %s: %v", req.EmployeeID, err)
+	}
+	for _, item := range report.Responsibilities {
+		a.auditResponsibilityChange(ctx, item.EntityType, item.EntityID, req.EmployeeID, req.ReassignTo, actorEmployeeID)
+	}
+	return report, nil
+}
+
+func (a *app) auditOffboarding(r *http.Request, report offboardingReport) {
+	name := report.FullName
+	if name == "" {
+		name = report.EmployeeID
+	}
+	reassignLabel := ""
+	if report.ReassignTo != "" && len(report.Responsibilities) > 0 {
+		reassignLabel = a.resolveBookingTargetLabel(r.Context(), report.ReassignTo)
+	}
+	changes := report.changes(reassignLabel)
+	var userID int64
+	if err := a.db.QueryRowContext(r.Context(),
+		`SELECT id FROM users WHERE employee_id = $1 ORDER BY id LIMIT 1`, report.EmployeeID,
+	).Scan(&userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("audit log: failed to resolve user id for %q: %v", report.EmployeeID, err)
+	}
+	a.logAuditEventFromRequest(r, auditActionOffboard, auditEntityUser, userID, name, map[string]any{
+		"employee_id":      report.EmployeeID,
+		"reassign_to":      report.ReassignTo,
+		"bookings":         len(report.Bookings),
+		"responsibilities": len(report.Responsibilities),
+		"sessions":         report.ActiveSessions,
+		"changes":          changes,
+	})
+}
+
+// changes summarizes an applied offboarding for the audit log.
+// reassignLabel names the employee who took over the responsibilities.
+func (report offboardingReport) changes(reassignLabel string) []string {
+	changes := []string{
+		fmt.Sprintf("Отменено бронирований: %d", len(report.Bookings)),
+		fmt.Sprintf("Отозвано сессий: %d", report.ActiveSessions),
+	}
+	if len(report.Responsibilities) > 0 {
+		target := "снята"
+		if report.ReassignTo != "" {
+			target = "передана " + reassignLabel
+		}
+		changes = append(changes, fmt.Sprintf("Ответственность за %d объектов %s", len(report.Responsibilities), target))
+	}
+	return changes
+}
+
+func (report offboardingReport) deskBookingIDs() []int64 {
+	ids := make([]int64, 0, len(report.Bookings))
+	for _, b := range report.Bookings {
+		if b.Kind == "desk" {
+			ids = append(ids, b.ID)
+		}
+	}
+	return ids
+}
+
+// offboardingDeskBookingDue reports whether a desk booking for date is today
+// or later in the building's timezone, i.e. still to be cancelled.
+func offboardingDeskBookingDue(date, timezone string, now time.Time) bool {
+	location, err := time.LoadLocation(strings.TrimSpace(timezone))
+	if strings.TrimSpace(timezone) == "" || err != nil {
+		location, err = time.LoadLocation(defaultBuildingTimezone)
+		if err != nil {
+			location = time.Local
+		}
+	}
+	return date >= now.In(location).Format("2006-01-02")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOffboardingRequestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		req     offboardingRequest
+		actor   string
+		wantErr string
+	}{
+		{name: "valid", req: offboardingRequest{EmployeeID: "100", ReassignTo: "200"}, actor: "1"},
+		{name: "valid without reassign", req: offboardingRequest{EmployeeID: "100"}, actor: "1"},
+		{name: "missing employee", req: offboardingRequest{}, actor: "1", wantErr: "employee_id is required"},
+		{name: "service account", req: offboardingRequest{EmployeeID: serviceAccountPrincipalPrefix + "7"}, actor: "1", wantErr: "service accounts"},
+		{name: "self", req: offboardingRequest{EmployeeID: "100"}, actor: "100", wantErr: "cannot offboard yourself"},
+		{name: "reassign to self", req: offboardingRequest{EmployeeID: "100", ReassignTo: "100"}, actor: "1", wantErr: errOffboardingTargetInvalid.Error()},
+		{name: "reassign to service account", req: offboardingRequest{EmployeeID: "100", ReassignTo: serviceAccountPrincipalPrefix + "7"}, actor: "1", wantErr: errOffboardingTargetInvalid.Error()},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.req.validate(tt.actor)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOffboardingDeskBookingDue(t *testing.T) {
+	t.Parallel()
+
+	// 22:30 UTC: already March 2 in Moscow, still March 1 in New York.
+	now := time.Date(2026, 3, 1, 22, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		date     string
+		timezone string
+		want     bool
+	}{
+		{name: "moscow today", date: "2026-03-02", timezone: "Europe/Moscow", want: true},
+		{name: "moscow yesterday", date: "2026-03-01", timezone: "Europe/Moscow", want: false},
+		{name: "new york today", date: "2026-03-01", timezone: "America/New_York", want: true},
+		{name: "new york yesterday", date: "2026-02-28", timezone: "America/New_York", want: false},
+		{name: "future", date: "2026-04-01", timezone: "America/New_York", want: true},
+		{name: "empty timezone uses default", date: "2026-03-01", timezone: "", want: false},
+		{name: "invalid timezone uses default", date: "2026-03-02", timezone: "Mars/Olympus", want: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := offboardingDeskBookingDue(tt.date, tt.timezone, now); got != tt.want {
+				t.Fatalf("offboardingDeskBookingDue(%q, %q) = %v, want %v", tt.date, tt.timezone, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffboardingReportChanges(t *testing.T) {
+	t.Parallel()
+
+	report := offboardingReport{
+		Bookings: []offboardingBooking{
+			{ID: 1, Kind: "desk", Date: "2026-03-02"},
+			{ID: 2, Kind: "meeting_room"},
+			{ID: 3, Kind: "desk", Date: "2026-03-03"},
+		},
+		ActiveSessions: 2,
+	}
+	if ids := report.deskBookingIDs(); fmt.Sprint(ids) != "[1 3]" {
+		t.Fatalf("deskBookingIDs() = %v, want [1 3]", ids)
+	}
+
+	tests := []struct {
+		name             string
+		responsibilities int
+		reassignTo       string
+		want             []string
+	}{
+		{name: "no responsibilities", want: []string{"Отменено бронирований: 3", "Отозвано сессий: 2"}},
+		{name: "cleared", responsibilities: 2, want: []string{"Отменено бронирований: 3", "Отозвано сессий: 2", "Ответственность за 2 объектов снята"}},
+		{name: "reassigned", responsibilities: 1, reassignTo: "200", want: []string{"Отменено бронирований: 3", "Отозвано сессий: 2", "Ответственность за 1 объектов передана Пётр"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := report
+			r.ReassignTo = tt.reassignTo
+			r.Responsibilities = make([]offboardingResponsibility, tt.responsibilities)
+			if got := r.changes("Пётр"); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("changes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOffboardingPreviewAndApply runs an offboarding against the database in
+// OFFICE_TEST_DATABASE_URL. The building is at UTC+14, so "today" there is
+// usually not the database's today.
+func TestOffboardingPreviewAndApply(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	employeeID := "offboard-" + suffix
+	reassignTo := "offboard-deputy-" + suffix
+
+	for _, id := range []string{employeeID, reassignTo} {
+		if _, err := db.Exec(
+			`INSERT INTO users (full_name, employee_id, wb_user_id, active) VALUES ($1, $1, $1, TRUE)`,
+			id,
+		); err != nil {
+			t.Fatalf("create user %s: %v", id, err)
+		}
+	}
+	const timezone = "Pacific/Kiritimati"
+	b, err := a.createBuildingWithFloors("Offboarding test", "Test street 3", timezone, "", 0, 1, employeeID)
+	if err != nil {
+		t.Fatalf("create building: %v", err)
+	}
+	sp, err := a.createSpace(b.Floors[0], "Coworking", "coworking", 0, "", "", nil, "", employeeID)
+	if err != nil {
+		t.Fatalf("create space: %v", err)
+	}
+	d, err := a.createDesk(sp.ID, "D1", 0, 0, 100, 100, 0)
+	if err != nil {
+		t.Fatalf("create desk: %v", err)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localToday := time.Now().In(location)
+	bookingIDs := map[string]int64{}
+	for name, date := range map[string]time.Time{"yesterday": localToday.AddDate(0, 0, -1), "today": localToday} {
+		var id int64
+		if err := db.QueryRow(
+			`INSERT INTO workplace_bookings (workplace_id, applier_employee_id, date) VALUES ($1, $2, $3) RETURNING id`,
+			d.ID, employeeID, date.Format("2006-01-02"),
+		).Scan(&id); err != nil {
+			t.Fatalf("create %s booking: %v", name, err)
+		}
+		bookingIDs[name] = id
+	}
+
+	req := offboardingRequest{EmployeeID: employeeID, ReassignTo: reassignTo}
+	if err := a.validateOffboardingTarget(ctx, req); err != nil {
+		t.Fatalf("validate target: %v", err)
+	}
+	if err := a.validateOffboardingTarget(ctx, offboardingRequest{EmployeeID: employeeID, ReassignTo: "missing-" + suffix}); !errors.Is(err, errOffboardingTargetInvalid) {
+		t.Fatalf("unknown reassign_to: %v, want %v", err, errOffboardingTargetInvalid)
+	}
+
+	preview, err := a.previewOffboarding(ctx, db, req)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if !preview.UserFound || preview.AlreadyInactive || preview.Applied {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+	if len(preview.Bookings) != 1 || preview.Bookings[0].ID != bookingIDs["today"] {
+		t.Fatalf("preview bookings = %+v, want only booking %d", preview.Bookings, bookingIDs["today"])
+	}
+	got := map[string]int64{}
+	for _, item := range preview.Responsibilities {
+		got[item.EntityType] = item.EntityID
+	}
+	if len(got) != 2 || got["building"] != b.ID || got["coworking"] != sp.ID {
+		t.Fatalf("preview responsibilities = %+v", preview.Responsibilities)
+	}
+
+	report, err := a.applyOffboarding(ctx, req, "offboard-admin-"+suffix)
+	if err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if !report.Applied || len(report.Bookings) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	var active bool
+	if err := db.QueryRow(`SELECT active FROM users WHERE employee_id = $1`, employeeID).Scan(&active); err != nil || active {
+		t.Fatalf("user active = %v, %v", active, err)
+	}
+	for name, wantCancelled := range map[string]bool{"today": true, "yesterday": false} {
+		var cancelled bool
+		if err := db.QueryRow(`SELECT cancelled_at IS NOT NULL FROM workplace_bookings WHERE id = $1`, bookingIDs[name]).Scan(&cancelled); err != nil {
+			t.Fatal(err)
+		}
+		if cancelled != wantCancelled {
+			t.Errorf("%s booking cancelled = %v, want %v", name, cancelled, wantCancelled)
+		}
+	}
+	var buildingResp, coworkingResp string
+	if err := db.QueryRow(
+		`SELECT (SELECT responsible_employee_id FROM office_buildings WHERE id = $1),
+		        (SELECT responsible_employee_id FROM coworkings WHERE id = $2)`,
+		b.ID, sp.ID,
+	).Scan(&buildingResp, &coworkingResp); err != nil {
+		t.Fatal(err)
+	}
+	if buildingResp != reassignTo || coworkingResp != reassignTo {
+		t.Fatalf("responsibles = %q, %q, want %q", buildingResp, coworkingResp, reassignTo)
+	}
+}
+
+// TestOffboardingRoleCheck checks against OFFICE_TEST_DATABASE_URL that only
+// admins may offboard an admin.
+func TestOffboardingRoleCheck(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+	ctx := context.Background()
+	adminID := "offboard-admin-target-" + fmt.Sprint(time.Now().UnixNano())
+	if _, err := db.Exec(
+		`INSERT INTO users (full_name, employee_id, wb_user_id, role, active) VALUES ($1, $1, $1, $2, TRUE)`,
+		adminID, roleAdmin,
+	); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	if err := a.checkOffboardingRole(ctx, roleFacilityManager, adminID); !errors.Is(err, errRoleAssignmentAdminOnly) {
+		t.Fatalf("facility manager offboards admin: %v, want %v", err, errRoleAssignmentAdminOnly)
+	}
+	if err := a.checkOffboardingRole(ctx, roleAdmin, adminID); err != nil {
+		t.Fatalf("admin offboards admin: %v", err)
+	}
+	if err := a.checkOffboardingRole(ctx, roleFacilityManager, "offboard-unknown"); err != nil {
+		t.Fatalf("facility manager offboards employee: %v", err)
+	}
+}
@@ -11,9 +11,11 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -42,22 +44,92 @@ type officeJWTVerificationKey struct {
 	rsaPublic *rsa.PublicKey
 }
 
+// officeTokenKeyManager holds the keys configured in env and, when DB key
+// storage is enabled, the rotated keys loaded by officeJWTKeyStore. Rotated
+// keys take precedence for signing; both sets are used for verification.
 type officeTokenKeyManager struct {
 	signingKey               *officeJWTSigningKey
 	verificationKeysByKID    map[string]*officeJWTVerificationKey
 	legacyHS256VerifierNoKID *officeJWTVerificationKey
 	legacyHS256Secret        []byte
+
+	mu                      sync.RWMutex
+	rotatedSigningKey       *officeJWTSigningKey
+	rotatedVerificationKeys map[string]*officeJWTVerificationKey
 }
 
 func (m *officeTokenKeyManager) CanSign() bool {
-	return m != nil && m.signingKey != nil
+	return m.currentSigningKey() != nil
 }
 
 func (m *officeTokenKeyManager) CanVerify() bool {
 	if m == nil {
 		return false
 	}
-	return len(m.verificationKeysByKID) > 0 || m.legacyHS256VerifierNoKID != nil
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.verificationKeysByKID) > 0 || len(m.rotatedVerificationKeys) > 0 || m.legacyHS256VerifierNoKID != nil
+}
+
+func (m *officeTokenKeyManager) currentSigningKey() *officeJWTSigningKey {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.rotatedSigningKey != nil {
+		return m.rotatedSigningKey
+	}
+	return m.signingKey
+}
+
+// setRotatedKeys replaces the DB-managed keys. signing may be nil while the
+// first key is still staged; env keys keep signing in that case.
+func (m *officeTokenKeyManager) setRotatedKeys(signing *officeJWTSigningKey, verifiers map[string]*officeJWTVerificationKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rotatedSigningKey = signing
+	m.rotatedVerificationKeys = verifiers
+}
+
+// publicJWKs returns the RSA verification keys as JWKs, sorted by kid.
+// HS256 secrets are never published.
+func (m *officeTokenKeyManager) publicJWKs() []map[string]string {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	byKID := make(map[string]*rsa.PublicKey, len(m.verificationKeysByKID)+len(m.rotatedVerificationKeys))
+	for _, keys := range []map[string]*officeJWTVerificationKey{m.verificationKeysByKID, m.rotatedVerificationKeys} {
+		for kid, key := range keys {
+			if key.alg == jwtAlgRS256 && key.rsaPublic != nil {
+				byKID[kid] = key.rsaPublic
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	kids := make([]string, 0, len(byKID))
+	for kid := range byKID {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	jwks := make([]map[string]string, 0, len(kids))
+	for _, kid := range kids {
+		jwks = append(jwks, rsaPublicJWK(kid, byKID[kid]))
+	}
+	return jwks
+}
+
+func rsaPublicJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": jwtAlgRS256,
+		"kid": kid,
+		"n":   base64URLEncode(key.N.Bytes()),
+		"e":   base64URLEncode(big.NewInt(int64(key.E)).Bytes()),
+	}
 }
 
 func (m *officeTokenKeyManager) LegacySecret() []byte {
@@ -303,15 +375,16 @@ func (k *officeJWTVerificationKey) verify(signingInput, sig []byte) bool {
 }
 
 func (m *officeTokenKeyManager) signJWT(claims any, tokenType string) (string, error) {
-	if m == nil || m.signingKey == nil {
+	signingKey := m.currentSigningKey()
+	if signingKey == nil {
 		return "", fmt.Errorf("%s: no signing key configured", tokenType)
 	}
 	header := jwtHeaderData{
-		Alg: m.signingKey.alg,
+		Alg: signingKey.alg,
 		Typ: "JWT",
 	}
-	if strings.TrimSpace(m.signingKey.kid) != "" {
-		header.Kid = strings.TrimSpace(m.signingKey.kid)
+	if strings.TrimSpace(signingKey.kid) != "" {
+		header.Kid = strings.TrimSpace(signingKey.kid)
 	}
 	headerJSON, err := json.Marshal(header)
 	if err != nil {
@@ -324,7 +397,7 @@ func (m *officeTokenKeyManager) signJWT(claims any, tokenType string) (string, e
 	headerEncoded := base64URLEncode(headerJSON)
 	payloadEncoded := base64URLEncode(payloadJSON)
 	signingInput := headerEncoded + "." + payloadEncoded
-	signature, err := m.signingKey.sign([]byte(signingInput))
+	signature, err := signingKey.sign([]byte(signingInput))
 	if err != nil {
 		return "", fmt.Errorf("%s: sign jwt: %w", tokenType, err)
 	}
@@ -348,11 +421,6 @@ func (m *officeTokenKeyManager) verifyJWT(tokenStr, tokenType string, dst any) e
 	if err := json.Unmarshal(headerBytes, &header); err != nil {
 		return fmt.Errorf("%s: parse header: %w", tokenType, err)
 	}
-	signingInput := parts[0] + "." + parts[1]
-	sigBytes, err := base64URLDecode(parts[2])
-	if err != nil {
-		return fmt.Errorf("%s: decode signature: %w", tokenType, err)
-	}
 
 	verifier := m.selectVerifier(header)
 	if verifier == nil {
@@ -361,7 +429,21 @@ func (m *officeTokenKeyManager) verifyJWT(tokenStr, tokenType string, dst any) e
 		}
 		return fmt.Errorf("%s: no verification key for alg=%s kid=%s", tokenType, header.Alg, header.Kid)
 	}
-	if !verifier.verify([]byte(signingInput), sigBytes) {
+	return verifier.verifyJWT(tokenStr, tokenType, dst)
+}
+
+// verifyJWT checks tokenStr against this key alone, whatever kid it names.
+func (k *officeJWTVerificationKey) verifyJWT(tokenStr, tokenType string, dst any) error {
+	parts := strings.Split(tokenStr, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%s: malformed token", tokenType)
+	}
+	signingInput := parts[0] + "." + parts[1]
+	sigBytes, err := base64URLDecode(parts[2])
+	if err != nil {
+		return fmt.Errorf("%s: decode signature: %w", tokenType, err)
+	}
+	if !k.verify([]byte(signingInput), sigBytes) {
 		return fmt.Errorf("%s: invalid signature", tokenType)
 	}
 
@@ -383,6 +465,11 @@ func (m *officeTokenKeyManager) selectVerifier(header jwtHeaderData) *officeJWTV
 	kid := strings.TrimSpace(header.Kid)
 	if kid != "" {
 		verifier := m.verificationKeysByKID[kid]
+		if verifier == nil {
+			m.mu.RLock()
+			verifier = m.rotatedVerificationKeys[kid]
+			m.mu.RUnlock()
+		}
 		if verifier == nil {
 			return nil
 		}
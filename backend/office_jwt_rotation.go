@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DB-backed signing keys. Every instance runs the same rotation step under an
+// advisory lock, so the steps are idempotent and any instance may create the
+// next key. A key goes through three stages:
+//
+//  1. staged — published in JWKS and accepted for verification, not used for
+//     signing until activates_at, so every instance and every JWKS consumer
+//     learns the public key before the first token signed with it appears;
+//  2. active — the newest key whose activates_at has passed signs new tokens;
+//  3. retired — once a successor is active the key only verifies. It is
+//     deleted at retires_at, when the last refresh token it signed expired.
+const (
+	officeJWTKeyRotationLockID        = 7_340_021
+	officeJWTKeyRotationCheckInterval = 10 * time.Minute
+	officeJWTKeyBits                  = 2048
+	jwksPath                          = "/.well-known/jwks.json"
+)
+
+var errOfficeJWTKeySecretMissing = errors.New("OFFICE_JWT_DB_KEYS_SECRET is required when OFFICE_JWT_DB_KEYS is enabled")
+
+func ensureOfficeJWTKeysStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS office_jwt_keys (
+			kid TEXT PRIMARY KEY,
+			alg TEXT NOT NULL DEFAULT 'RS256',
+			private_key_enc BYTEA NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			activates_at TIMESTAMPTZ NOT NULL,
+			retires_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS office_jwt_keys_activates_at_idx ON office_jwt_keys (activates_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type officeJWTRotationConfig struct {
+	// interval is how long a key stays active before its successor takes over.
+	interval time.Duration
+	// stage is how long a new key is published before it starts signing.
+	stage time.Duration
+}
+
+type officeJWTKeyRecord struct {
+	kid         string
+	createdAt   time.Time
+	activatesAt time.Time
+	retiresAt   *time.Time
+	privateKey  *rsa.PrivateKey
+}
+
+// officeJWTRotationPlan is what one rotation step changes in office_jwt_keys.
+type officeJWTRotationPlan struct {
+	delete    []string
+	retire    map[string]time.Time
+	generate  bool
+	newActive time.Time
+}
+
+// planOfficeJWTRotation decides the next rotation step from the stored keys.
+// retireAfter is the lifetime of the longest token a key may have signed.
+func planOfficeJWTRotation(keys []officeJWTKeyRecord, cfg officeJWTRotationConfig, retireAfter time.Duration, now time.Time) officeJWTRotationPlan {
+	plan := officeJWTRotationPlan{retire: map[string]time.Time{}}
+	live := make([]officeJWTKeyRecord, 0, len(keys))
+	for _, key := range keys {
+		if key.retiresAt != nil && !now.Before(*key.retiresAt) {
+			plan.delete = append(plan.delete, key.kid)
+			continue
+		}
+		live = append(live, key)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].activatesAt.Before(live[j].activatesAt) })
+
+	active, pending := -1, false
+	for i, key := range live {
+		if key.activatesAt.After(now) {
+			pending = true
+			continue
+		}
+		active = i
+	}
+	// Every key activated before the current one has been superseded.
+	for i := 0; i < active; i++ {
+		if live[i].retiresAt == nil {
+			plan.retire[live[i].kid] = live[i+1].activatesAt.Add(retireAfter)
+		}
+	}
+
+	switch {
+	case active < 0 && !pending:
+		// Bootstrap: nothing has been signed with DB keys yet, so the first
+		// key can sign right away.
+		plan.generate = true
+		plan.newActive = now
+	case active >= 0 && !pending:
+		due := live[active].activatesAt.Add(cfg.interval)
+		if !now.Before(due.Add(-cfg.stage)) {
+			plan.generate = true
+			plan.newActive = due
+			if earliest := now.Add(cfg.stage); plan.newActive.Before(earliest) {
+				plan.newActive = earliest
+			}
+		}
+	}
+	return plan
+}
+
+// officeJWTKeyStore keeps the rotated signing keys in office_jwt_keys and
+// loads them into the key manager. Private keys are encrypted with
+// AES-256-GCM under a key derived from OFFICE_JWT_DB_KEYS_SECRET.
+type officeJWTKeyStore struct {
+	db   *sql.DB
+	keys *officeTokenKeyManager
+	aead cipher.AEAD
+	cfg  officeJWTRotationConfig
+}
+
+// loadOfficeJWTKeyStoreFromEnv returns nil when OFFICE_JWT_DB_KEYS is off.
+func loadOfficeJWTKeyStoreFromEnv(db *sql.DB, keys *officeTokenKeyManager) (*officeJWTKeyStore, error) {
+	if !isTrueEnv("OFFICE_JWT_DB_KEYS") {
+		return nil, nil
+	}
+	secret := strings.TrimSpace(os.Getenv("OFFICE_JWT_DB_KEYS_SECRET"))
+	if secret == "" {
+		return nil, errOfficeJWTKeySecretMissing
+	}
+	cfg := officeJWTRotationConfig{
+		interval: parseEnvDurationDays("OFFICE_JWT_ROTATION_DAYS", 30, 1, 365),
+		stage:    time.Duration(parseEnvInt("OFFICE_JWT_ROTATION_STAGE_HOURS", 24, 1, 168)) * time.Hour,
+	}
+	if cfg.stage >= cfg.interval {
+		cfg.stage = cfg.interval / 2
+		log.Printf("WARNING: OFFICE_JWT_ROTATION_STAGE_HOURS must be shorter than the rotation interval, using %s", cfg.stage)
+	}
+	return newOfficeJWTKeyStore(db, keys, []byte(secret), cfg)
+}
+
+func newOfficeJWTKeyStore(db *sql.DB, keys *officeTokenKeyManager, secret []byte, cfg officeJWTRotationConfig) (*officeJWTKeyStore, error) {
+	derived := sha256.Sum256(append([]byte("office-jwt-db-keys:"), secret...))
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &officeJWTKeyStore{db: db, keys: keys, aead: aead, cfg: cfg}, nil
+}
+
+// sealPrivateKey encrypts key bound to its kid so rows cannot be swapped.
+func (s *officeJWTKeyStore) sealPrivateKey(kid string, key *rsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, der, []byte(kid)), nil
+}
+
+func (s *officeJWTKeyStore) openPrivateKey(kid string, sealed []byte) (*rsa.PrivateKey, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("jwt key %q: ciphertext too short", kid)
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	der, err := s.aead.Open(nil, nonce, ciphertext, []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %q: decrypt (wrong OFFICE_JWT_DB_KEYS_SECRET?): %w", kid, err)
+	}
+	keyAny, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %q: parse: %w", kid, err)
+	}
+	key, ok := keyAny.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt key %q: not an RSA key", kid)
+	}
+	return key, nil
+}
+
+// rotate applies one rotation step and reloads the key manager.
+func (s *officeJWTKeyStore) rotate(ctx context.Context) error {
+	now := time.Now().UTC()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, officeJWTKeyRotationLockID); err != nil {
+		return err
+	}
+	keys, err := s.loadKeys(ctx, tx)
+	if err != nil {
+		return err
+	}
+	plan := planOfficeJWTRotation(keys, s.cfg, officeRefreshTokenTTL+officeJWTClockSkew, now)
+
+	for _, kid := range plan.delete {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM office_jwt_keys WHERE kid = $1`, kid); err != nil {
+			return err
+		}
+		log.Printf("office jwt keys: deleted retired key %s", kid)
+	}
+	for kid, retiresAt := range plan.retire {
+		if _, err := tx.ExecContext(ctx, `UPDATE office_jwt_keys SET retires_at = $2 WHERE kid = $1`, kid, retiresAt); err != nil {
+			return err
+		}
+		log.Printf("office jwt keys: key %s retires at %s", kid, retiresAt.Format(time.RFC3339))
+	}
+	if plan.generate {
+		privateKey, err := rsa.GenerateKey(rand.Reader, officeJWTKeyBits)
+		if err != nil {
+			return err
+		}
+		kid := "office-" + now.Format("20060102T150405Z") + "-" + generateTokenID()[:8]
+		sealed, err := s.sealPrivateKey(kid, privateKey)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO office_jwt_keys (kid, alg, private_key_enc, created_at, activates_at) VALUES ($1, $2, $3, $4, $5)`,
+			kid, jwtAlgRS256, sealed, now, plan.newActive,
+		); err != nil {
+			return err
+		}
+		log.Printf("office jwt keys: generated key %s, signing from %s", kid, plan.newActive.Format(time.RFC3339))
+	}
+
+	if len(plan.delete) > 0 || len(plan.retire) > 0 || plan.generate {
+		if keys, err = s.loadKeys(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.apply(keys, now)
+	return nil
+}
+
+// load publishes the stored keys without rotating them, for tools that only
+// verify signatures.
+func (s *officeJWTKeyStore) load(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	keys, err := s.loadKeys(ctx, tx)
+	if err != nil {
+		return err
+	}
+	s.apply(keys, time.Now().UTC())
+	return nil
+}
+
+func (s *officeJWTKeyStore) loadKeys(ctx context.Context, tx *sql.Tx) ([]officeJWTKeyRecord, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT kid, private_key_enc, created_at, activates_at, retires_at FROM office_jwt_keys WHERE alg = $1`, jwtAlgRS256)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []officeJWTKeyRecord
+	for rows.Next() {
+		var (
+			key       officeJWTKeyRecord
+			sealed    []byte
+			retiresAt sql.NullTime
+		)
+		if err := rows.Scan(&key.kid, &sealed, &key.createdAt, &key.activatesAt, &retiresAt); err != nil {
+			return nil, err
+		}
+		if retiresAt.Valid {
+			key.retiresAt = &retiresAt.Time
+		}
+		if key.privateKey, err = s.openPrivateKey(key.kid, sealed); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// apply publishes every unexpired key for verification and signs with the
+// newest active one.
+func (s *officeJWTKeyStore) apply(keys []officeJWTKeyRecord, now time.Time) {
+	verifiers := make(map[string]*officeJWTVerificationKey, len(keys))
+	var active *officeJWTKeyRecord
+	for i := range keys {
+		key := &keys[i]
+		if key.retiresAt != nil && !now.Before(*key.retiresAt) {
+			continue
+		}
+		verifiers[key.kid] = &officeJWTVerificationKey{kid: key.kid, alg: jwtAlgRS256, rsaPublic: &key.privateKey.PublicKey}
+		if !key.activatesAt.After(now) && (active == nil || key.activatesAt.After(active.activatesAt)) {
+			active = key
+		}
+	}
+	var signing *officeJWTSigningKey
+	if active != nil {
+		signing = &officeJWTSigningKey{kid: active.kid, alg: jwtAlgRS256, rsaPrivate: active.privateKey}
+	}
+	s.keys.setRotatedKeys(signing, verifiers)
+}
+
+func (s *officeJWTKeyStore) run(ctx context.Context) {
+	ticker := time.NewTicker(officeJWTKeyRotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.rotate(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("office jwt key rotation failed: %v", err)
+		}
+	}
+}
+
+// handleJWKS publishes the public keys that verify office tokens so other
+// services can validate them without sharing a secret.
+// GET /.well-known/jwks.json
+func (a *app) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keys := a.officeTokenKeys.publicJWKs()
+	if keys == nil {
+		keys = []map[string]string{}
+	}
+	// Staged keys are published a full stage ahead of use, so consumers can
+	// cache the set for a few minutes.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
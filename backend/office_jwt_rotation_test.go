@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanOfficeJWTRotation(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	cfg := officeJWTRotationConfig{interval: 30 * 24 * time.Hour, stage: 24 * time.Hour}
+	retireAfter := 30 * 24 * time.Hour
+	ts := func(d time.Duration) *time.Time { v := now.Add(d); return &v }
+
+	t.Run("bootstrap signs immediately", func(t *testing.T) {
+		plan := planOfficeJWTRotation(nil, cfg, retireAfter, now)
+		if !plan.generate || !plan.newActive.Equal(now) {
+			t.Fatalf("unexpected plan %+v", plan)
+		}
+	})
+
+	t.Run("fresh key needs nothing", func(t *testing.T) {
+		keys := []officeJWTKeyRecord{{kid: "a", activatesAt: now.Add(-24 * time.Hour)}}
+		plan := planOfficeJWTRotation(keys, cfg, retireAfter, now)
+		if plan.generate || len(plan.retire) > 0 || len(plan.delete) > 0 {
+			t.Fatalf("unexpected plan %+v", plan)
+		}
+	})
+
+	t.Run("successor is staged before the key is due", func(t *testing.T) {
+		activated := now.Add(-cfg.interval + 12*time.Hour)
+		keys := []officeJWTKeyRecord{{kid: "a", activatesAt: activated}}
+		plan := planOfficeJWTRotation(keys, cfg, retireAfter, now)
+		if !plan.generate || !plan.newActive.Equal(now.Add(cfg.stage)) {
+			t.Fatalf("expected successor one stage ahead, got %+v", plan)
+		}
+	})
+
+	t.Run("overdue key still gets a full stage", func(t *testing.T) {
+		keys := []officeJWTKeyRecord{{kid: "a", activatesAt: now.Add(-2 * cfg.interval)}}
+		plan := planOfficeJWTRotation(keys, cfg, retireAfter, now)
+		if !plan.generate || plan.newActive.Before(now.Add(cfg.stage)) {
+			t.Fatalf("expected successor after a full stage, got %+v", plan)
+		}
+	})
+
+	t.Run("pending successor blocks generation", func(t *testing.T) {
+		keys := []officeJWTKeyRecord{
+			{kid: "a", activatesAt: now.Add(-cfg.interval)},
+			{kid: "b", activatesAt: now.Add(time.Hour)},
+		}
+		plan := planOfficeJWTRotation(keys, cfg, retireAfter, now)
+		if plan.generate || len(plan.retire) > 0 {
+			t.Fatalf("unexpected plan %+v", plan)
+		}
+	})
+
+	t.Run("superseded key retires after the refresh TTL", func(t *testing.T) {
+		keys := []officeJWTKeyRecord{
+			{kid: "b", activatesAt: now.Add(-time.Hour)},
+			{kid: "a", activatesAt: now.Add(-cfg.interval)},
+			{kid: "old", activatesAt: now.Add(-3 * cfg.interval), retiresAt: ts(-time.Minute)},
+		}
+		plan := planOfficeJWTRotation(keys, cfg, retireAfter, now)
+		if got, ok := plan.retire["a"]; !ok || !got.Equal(now.Add(-time.Hour).Add(retireAfter)) {
+			t.Fatalf("expected a to retire a refresh TTL after b activated, got %+v", plan.retire)
+		}
+		if _, ok := plan.retire["b"]; ok {
+			t.Fatal("active key must not be retired")
+		}
+		if len(plan.delete) != 1 || plan.delete[0] != "old" {
+			t.Fatalf("expected expired key to be deleted, got %v", plan.delete)
+		}
+	})
+}
+
+func TestOfficeJWTKeyStoreSealsPrivateKeys(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	store, err := newOfficeJWTKeyStore(nil, nil, []byte("secret"), officeJWTRotationConfig{})
+	if err != nil {
+		t.Fatalf("newOfficeJWTKeyStore: %v", err)
+	}
+	sealed, err := store.sealPrivateKey("k1", key)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	opened, err := store.openPrivateKey("k1", sealed)
+	if err != nil || !opened.Equal(key) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	if _, err := store.openPrivateKey("k2", sealed); err == nil {
+		t.Fatal("expected a row moved to another kid to be rejected")
+	}
+	other, _ := newOfficeJWTKeyStore(nil, nil, []byte("other"), officeJWTRotationConfig{})
+	if _, err := other.openPrivateKey("k1", sealed); err == nil {
+		t.Fatal("expected a different secret to be rejected")
+	}
+}
+
+func TestJWKSPublishesRotatedKeys(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().UTC()
+	manager := newLegacyHS256KeyManager([]byte("legacy-secret"))
+	var records []officeJWTKeyRecord
+	for _, rec := range []struct {
+		kid         string
+		activatesAt time.Time
+	}{{"active", now.Add(-time.Hour)}, {"staged", now.Add(time.Hour)}} {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		records = append(records, officeJWTKeyRecord{kid: rec.kid, activatesAt: rec.activatesAt, privateKey: key})
+	}
+	store := &officeJWTKeyStore{keys: manager}
+	store.apply(records, now)
+
+	token, err := SignOfficeAccessTokenWithKeyManager(OfficeAccessTokenClaims{EmployeeID: "E-1", Role: roleEmployee}, manager)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	var header jwtHeaderData
+	headerJSON, _ := base64URLDecode(strings.SplitN(token, ".", 2)[0])
+	_ = json.Unmarshal(headerJSON, &header)
+	if header.Alg != jwtAlgRS256 || header.Kid != "active" {
+		t.Fatalf("expected token signed by the active DB key, got %+v", header)
+	}
+	if _, err := VerifyOfficeAccessTokenWithKeyManager(token, manager); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	a := &app{officeTokenKeys: manager}
+	rec := httptest.NewRecorder()
+	a.handleJWKS(rec, httptest.NewRequest(http.MethodGet, jwksPath, nil))
+	var body struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode jwks: %v", err)
+	}
+	if len(body.Keys) != 2 || body.Keys[0]["kid"] != "active" || body.Keys[1]["kid"] != "staged" {
+		t.Fatalf("expected active and staged RSA keys only, got %v", body.Keys)
+	}
+	if body.Keys[0]["kty"] != "RSA" || body.Keys[0]["e"] != "AQAB" {
+		t.Fatalf("unexpected jwk %v", body.Keys[0])
+	}
+
+	// Once a key is gone from the store its tokens stop verifying.
+	manager.setRotatedKeys(nil, nil)
+	if _, err := VerifyOfficeAccessTokenWithKeyManager(token, manager); err == nil {
+		t.Fatal("expected token of a removed key to be rejected")
+	}
+}
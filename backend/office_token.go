@@ -53,19 +53,31 @@ func configureOfficeJWTValidation(issuer, accessAudience, refreshAudience string
 }
 
 // TokenResponsibilities holds the IDs of buildings, floors, and coworkings
-// that the user is responsible for (can edit).
+// that the user is responsible for (can edit), including the ones currently
+// delegated to them.
 type TokenResponsibilities struct {
 	Buildings  []int64 `json:"buildings,omitempty"`
 	Floors     []int64 `json:"floors,omitempty"`
 	Coworkings []int64 `json:"coworkings,omitempty"`
 }
 
+// TokenImpersonation marks an access token issued to an admin acting as
+// another employee. EmployeeID and UserName identify the admin; the token's
+// own EmployeeID is the impersonated employee.
+type TokenImpersonation struct {
+	ID          int64  `json:"id"`
+	EmployeeID  string `json:"employee_id"`
+	UserName    string `json:"user_name,omitempty"`
+	AllowWrites bool   `json:"allow_writes,omitempty"`
+}
+
 // OfficeAccessTokenClaims carries minimal identity + role in the signed Office Access Token JWT.
 type OfficeAccessTokenClaims struct {
 	EmployeeID       string                 `json:"employee_id"`
 	UserName         string                 `json:"user_name,omitempty"`
 	Role             int                    `json:"role"`
 	Responsibilities *TokenResponsibilities `json:"responsibilities,omitempty"`
+	Impersonation    *TokenImpersonation    `json:"impersonation,omitempty"`
 	Iss              string                 `json:"iss"`
 	Aud              []string               `json:"aud"`
 	Exp              int64                  `json:"exp"`
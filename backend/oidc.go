@@ -0,0 +1,718 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Generic OpenID Connect login: authorization code flow with PKCE (S256).
+// The ID token is verified locally against the provider's JWKS; the login
+// state (state, nonce, PKCE verifier) travels in a short-lived HMAC-signed
+// cookie so any instance can finish a login another one started.
+
+const (
+	oidcLoginPath     = "/api/auth/oidc/login"
+	oidcCallbackPath  = "/api/auth/oidc/callback"
+	oidcStateCookie   = "office_oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+	oidcClockSkew     = time.Minute
+	oidcJWKSMinReload = 30 * time.Second
+	oidcMaxBodyBytes  = 1 << 20
+	oidcSubjectPrefix = "oidc:"
+)
+
+var (
+	errOIDCStateInvalid = errors.New("oidc: login state is missing, expired or does not match")
+	errOIDCTokenInvalid = errors.New("oidc: id token is invalid")
+)
+
+type oidcConfig struct {
+	Issuer            string
+	ClientID          string
+	ClientSecret      string
+	RedirectURL       string
+	Scopes            []string
+	EmployeeIDClaim   string
+	NameClaim         string
+	PostLoginRedirect string
+}
+
+func loadOIDCConfigFromEnv() (oidcConfig, error) {
+	cfg := oidcConfig{
+		Issuer:            strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER_URL")), "/"),
+		ClientID:          strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret:      strings.TrimSpace(os.Getenv("OIDC_CLIENT_SECRET")),
+		RedirectURL:       strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		Scopes:            strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " ")),
+		EmployeeIDClaim:   strings.TrimSpace(os.Getenv("OIDC_EMPLOYEE_ID_CLAIM")),
+		NameClaim:         strings.TrimSpace(os.Getenv("OIDC_NAME_CLAIM")),
+		PostLoginRedirect: strings.TrimSpace(os.Getenv("OIDC_POST_LOGIN_REDIRECT")),
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return oidcConfig{}, errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when IDENTITY_PROVIDER=oidc")
+	}
+	return cfg, nil
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcIdentityProvider struct {
+	cfg      oidcConfig
+	client   *http.Client
+	stateKey []byte
+	now      func() time.Time
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func newOIDCIdentityProvider(cfg oidcConfig, client *http.Client, stateKey []byte) (*oidcIdentityProvider, error) {
+	for name, raw := range map[string]string{"OIDC_ISSUER_URL": cfg.Issuer, "OIDC_REDIRECT_URL": cfg.RedirectURL} {
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("%s must be an absolute http(s) URL", name)
+		}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	hasOpenID := false
+	for _, scope := range cfg.Scopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.EmployeeIDClaim == "" {
+		cfg.EmployeeIDClaim = "employee_id"
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "name"
+	}
+	if !isLocalRedirectPath(cfg.PostLoginRedirect) {
+		cfg.PostLoginRedirect = "/"
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &oidcIdentityProvider{
+		cfg:      cfg,
+		client:   client,
+		stateKey: stateKey,
+		now:      time.Now,
+	}, nil
+}
+
+func (p *oidcIdentityProvider) Name() string {
+	return identityProviderOIDC
+}
+
+func (p *oidcIdentityProvider) getJSON(ctx context.Context, endpoint string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned status %d", endpoint, resp.StatusCode)
+	}
+	return json.Unmarshal(body, dst)
+}
+
+// discover loads the provider metadata once; a failed attempt is retried on
+// the next call.
+func (p *oidcIdentityProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	cached := p.discovery
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+	var doc oidcDiscovery
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is incomplete")
+	}
+	p.mu.Lock()
+	p.discovery = &doc
+	p.mu.Unlock()
+	return &doc, nil
+}
+
+// signingKey returns the JWKS key with the given kid. Unknown kids trigger a
+// reload (rate limited) so that provider key rotation is picked up.
+func (p *oidcIdentityProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key := pickOIDCKey(p.keys, kid)
+	canReload := p.now().Sub(p.keysFetchedAt) >= oidcJWKSMinReload
+	p.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	if !canReload {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := p.getJSON(ctx, doc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: jwks: %w", err)
+	}
+	keys := parseJWKS(set.Keys)
+	p.mu.Lock()
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+	p.mu.Unlock()
+	if key := pickOIDCKey(keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// pickOIDCKey selects a key by kid; a token without kid is accepted only
+// when the set holds exactly one key.
+func pickOIDCKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid != "" {
+		return keys[kid]
+	}
+	if len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// parseJWKS converts RSA and EC signature keys of a JWK set. Keys that are
+// malformed or meant for encryption are skipped.
+func parseJWKS(raw []json.RawMessage) map[string]crypto.PublicKey {
+	keys := make(map[string]crypto.PublicKey, len(raw))
+	for idx, item := range raw {
+		var jwk struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}
+		if err := json.Unmarshal(item, &jwk); err != nil {
+			continue
+		}
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		kid := jwk.Kid
+		if kid == "" {
+			kid = fmt.Sprintf("#%d", idx)
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64URLDecode(jwk.N)
+			e, errE := base64URLDecode(jwk.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			exponent := int(new(big.Int).SetBytes(e).Int64())
+			if exponent < 3 {
+				continue
+			}
+			keys[kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64URLDecode(jwk.X)
+			y, errY := base64URLDecode(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				continue
+			}
+			keys[kid] = key
+		}
+	}
+	return keys
+}
+
+// verifyJWTSignature checks an asymmetric JWS signature. Symmetric and "none"
+// algorithms are rejected: an ID token must be signed by the provider's key.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signingInput, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	hasher := hash.New()
+	hasher.Write(signingInput)
+	digest := hasher.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(rsaKey, hash, digest, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, sig)
+	default:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg")
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("malformed ecdsa signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+// verifyIDToken checks signature, issuer, audience, lifetime and, when
+// nonce is set, the nonce of an ID token and returns its claims.
+func (p *oidcIdentityProvider) verifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", errOIDCTokenInvalid)
+	}
+	headerBytes, err := base64URLDecode(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode header: %v", errOIDCTokenInvalid, err)
+	}
+	var header jwtHeaderData
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("%w: parse header: %v", errOIDCTokenInvalid, err)
+	}
+	sig, err := base64URLDecode(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode signature: %v", errOIDCTokenInvalid, err)
+	}
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", errOIDCTokenInvalid, err)
+	}
+	payload, err := base64URLDecode(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode payload: %v", errOIDCTokenInvalid, err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: parse payload: %v", errOIDCTokenInvalid, err)
+	}
+	if err := validateOIDCClaims(claims, p.cfg.Issuer, p.cfg.ClientID, nonce, p.now()); err != nil {
+		return nil, fmt.Errorf("%w: %v", errOIDCTokenInvalid, err)
+	}
+	return claims, nil
+}
+
+func validateOIDCClaims(claims map[string]any, issuer, clientID, nonce string, now time.Time) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, item := range aud {
+			if value, ok := item.(string); ok {
+				audiences = append(audiences, value)
+			}
+		}
+	}
+	if !audContains(audiences, clientID) {
+		return errors.New("token is not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != "" && azp != clientID {
+		return fmt.Errorf("unexpected authorized party %q", azp)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("exp is required")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(iat), 0)) {
+		return errors.New("token is issued in the future")
+	}
+	if nonce != "" {
+		got, _ := claims["nonce"].(string)
+		if subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+			return errors.New("nonce does not match")
+		}
+	}
+	if sub, _ := claims["sub"].(string); strings.TrimSpace(sub) == "" {
+		return errors.New("sub is required")
+	}
+	return nil
+}
+
+// identityFromClaims maps ID token claims to an identity. The employee id
+// claim is configurable; without it the employee is looked up by subject.
+func (p *oidcIdentityProvider) identityFromClaims(claims map[string]any) *verifiedUserInfo {
+	sub, _ := claims["sub"].(string)
+	name := firstNonEmptyString(
+		normalizeIDString(claims[p.cfg.NameClaim]),
+		normalizeIDString(claims["name"]),
+		normalizeIDString(claims["preferred_username"]),
+		normalizeIDString(claims["email"]),
+	)
+	return &verifiedUserInfo{
+		WbUserID:   oidcSubjectPrefix + strings.TrimSpace(sub),
+		UserName:   name,
+		FullName:   name,
+		EmployeeID: normalizeIDString(claims[p.cfg.EmployeeIDClaim]),
+		AvatarURL:  normalizeIDString(claims["picture"]),
+	}
+}
+
+// VerifyToken accepts an ID token issued to this client, so API clients that
+// already hold one can exchange it for office tokens.
+func (p *oidcIdentityProvider) VerifyToken(ctx context.Context, token string, _ *http.Request) (*verifiedUserInfo, error) {
+	claims, err := p.verifyIDToken(ctx, token, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.identityFromClaims(claims), nil
+}
+
+func (p *oidcIdentityProvider) authorizationURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: authorization endpoint: %w", err)
+	}
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), nil
+}
+
+// exchangeCode redeems an authorization code and returns the raw ID token.
+func (p *oidcIdentityProvider) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBodyBytes))
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &result)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc: token endpoint returned status %d: %s %s", resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+	if result.IDToken == "" {
+		return "", errors.New("oidc: token response has no id_token")
+	}
+	return result.IDToken, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64URLEncode(sum[:])
+}
+
+func randomURLToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64URLEncode(buf), nil
+}
+
+// isLocalRedirectPath accepts only same-origin paths so the login flow cannot
+// be turned into an open redirect.
+func isLocalRedirectPath(raw string) bool {
+	return strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "//") && !strings.ContainsAny(raw, "\\\r\n")
+}
+
+type oidcLoginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	ReturnTo string `json:"r"`
+	Exp      int64  `json:"e"`
+}
+
+func encodeOIDCLoginState(st oidcLoginState, key []byte) (string, error) {
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64URLEncode(payload)
+	return encoded + "." + base64URLEncode(hmacSHA256([]byte(encoded), key)), nil
+}
+
+func decodeOIDCLoginState(raw string, key []byte, now time.Time) (oidcLoginState, error) {
+	encoded, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return oidcLoginState{}, errOIDCStateInvalid
+	}
+	gotSig, err := base64URLDecode(sig)
+	if err != nil || !hmac.Equal(gotSig, hmacSHA256([]byte(encoded), key)) {
+		return oidcLoginState{}, errOIDCStateInvalid
+	}
+	payload, err := base64URLDecode(encoded)
+	if err != nil {
+		return oidcLoginState{}, errOIDCStateInvalid
+	}
+	var st oidcLoginState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return oidcLoginState{}, errOIDCStateInvalid
+	}
+	if now.Unix() > st.Exp {
+		return oidcLoginState{}, errOIDCStateInvalid
+	}
+	return st, nil
+}
+
+func (a *app) setOIDCStateCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc/",
+		HttpOnly: true,
+		Secure:   isSecureContext(r),
+		// Lax: the cookie must survive the top-level redirect back from the
+		// identity provider.
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
+
+// handleOIDCLogin starts the authorization code flow.
+// GET /api/auth/oidc/login?return_to=/buildings
+func (a *app) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	provider, ok := a.identity.(*oidcIdentityProvider)
+	if !ok {
+		respondError(w, http.StatusNotFound, errIdentityFlowNotSupported.Error())
+		return
+	}
+	if !a.allowAuthAttempt(w, r) {
+		return
+	}
+	returnTo := strings.TrimSpace(r.URL.Query().Get("return_to"))
+	if !isLocalRedirectPath(returnTo) {
+		returnTo = provider.cfg.PostLoginRedirect
+	}
+	st := oidcLoginState{ReturnTo: returnTo, Exp: provider.now().Add(oidcStateTTL).Unix()}
+	var err error
+	for _, dst := range []*string{&st.State, &st.Nonce, &st.Verifier} {
+		if *dst, err = randomURLToken(32); err != nil {
+			log.Printf("handleOIDCLogin: random: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	target, err := provider.authorizationURL(r.Context(), st.State, st.Nonce, st.Verifier)
+	if err != nil {
+		log.Printf("handleOIDCLogin: %v", err)
+		respondError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+	cookieValue, err := encodeOIDCLoginState(st, provider.stateKey)
+	if err != nil {
+		log.Printf("handleOIDCLogin: encode state: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.setOIDCStateCookie(w, r, cookieValue, int(oidcStateTTL.Seconds()))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback finishes the flow: it checks the state, redeems the code
+// with the PKCE verifier, verifies the ID token and opens an office session.
+// Failures redirect to the post-login page with ?auth_error=<reason>.
+// GET /api/auth/oidc/callback?code=...&state=...
+func (a *app) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	provider, ok := a.identity.(*oidcIdentityProvider)
+	if !ok {
+		respondError(w, http.StatusNotFound, errIdentityFlowNotSupported.Error())
+		return
+	}
+	if !a.allowAuthAttempt(w, r) {
+		return
+	}
+	fail := func(reason string, err error) {
+		log.Printf("handleOIDCCallback: %s: %v", reason, err)
+		target := provider.cfg.PostLoginRedirect
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		http.Redirect(w, r, target+separator+"auth_error="+url.QueryEscape(reason), http.StatusFound)
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	// The state is single-use whatever the outcome.
+	a.setOIDCStateCookie(w, r, "", -1)
+	if err != nil {
+		fail("state", errOIDCStateInvalid)
+		return
+	}
+	st, err := decodeOIDCLoginState(cookie.Value, provider.stateKey, provider.now())
+	if err != nil {
+		fail("state", err)
+		return
+	}
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(st.State)) != 1 {
+		fail("state", errOIDCStateInvalid)
+		return
+	}
+	if providerErr := strings.TrimSpace(query.Get("error")); providerErr != "" {
+		fail("denied", fmt.Errorf("provider returned %s: %s", providerErr, query.Get("error_description")))
+		return
+	}
+	code := strings.TrimSpace(query.Get("code"))
+	if code == "" {
+		fail("code", errors.New("code is missing"))
+		return
+	}
+	idToken, err := provider.exchangeCode(r.Context(), code, st.Verifier)
+	if err != nil {
+		fail("exchange", err)
+		return
+	}
+	claims, err := provider.verifyIDToken(r.Context(), idToken, st.Nonce)
+	if err != nil {
+		fail("token", err)
+		return
+	}
+	user := provider.identityFromClaims(claims)
+	if err := a.upsertUserInfo(user.WbUserID, user.UserName, user.FullName, user.EmployeeID, user.ProfileID, user.AvatarURL, ""); err != nil {
+		log.Printf("handleOIDCCallback: failed to save user info: %v", err)
+	}
+	employeeID, err := a.resolveOfficeEmployeeID(r.Context(), user)
+	if err != nil {
+		fail("employee", err)
+		return
+	}
+	if _, err := a.issueOfficeSession(w, r, employeeID, user.UserName); err != nil {
+		fail("session", err)
+		return
+	}
+	http.Redirect(w, r, st.ReturnTo, http.StatusFound)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOIDCServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newTestOIDCServer(t *testing.T) *testOIDCServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	srv := &testOIDCServer{key: key}
+	mux := http.NewServeMux()
+	srv.Server = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64URLEncode(key.N.Bytes()),
+			"e":   base64URLEncode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if pkceChallenge(r.Form.Get("code_verifier")) != srv.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "pkce"})
+			return
+		}
+		claims := srv.defaultClaims()
+		claims["nonce"] = srv.nonce
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": srv.sign(t, "RS256", "k1", claims)})
+	})
+	return srv
+}
+
+func (s *testOIDCServer) defaultClaims() map[string]any {
+	now := time.Now()
+	claims := map[string]any{
+		"iss":         s.URL,
+		"aud":         "office",
+		"sub":         "user-42",
+		"name":        "Иван Петров",
+		"employee_id": "E-42",
+		"iat":         now.Unix(),
+		"exp":         now.Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+	return claims
+}
+
+func (s *testOIDCServer) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64URLEncode(header) + "." + base64URLEncode(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return input + "." + base64URLEncode(sig)
+}
+
+func (s *testOIDCServer) provider(t *testing.T) *oidcIdentityProvider {
+	t.Helper()
+	p, err := newOIDCIdentityProvider(oidcConfig{
+		Issuer:      s.URL,
+		ClientID:    "office",
+		RedirectURL: "https://office.example.com" + oidcCallbackPath,
+	}, s.Client(), []byte("state-key"))
+	if err != nil {
+		t.Fatalf("newOIDCIdentityProvider: %v", err)
+	}
+	return p
+}
+
+func TestPKCEChallengeMatchesRFC7636(t *testing.T) {
+	t.Parallel()
+
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("unexpected S256 challenge %q", got)
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestOIDCServer(t)
+	p := srv.provider(t)
+	ctx := context.Background()
+
+	verifier := "test-verifier-0123456789-0123456789-0123456789"
+	target, err := p.authorizationURL(ctx, "st", "n-1", verifier)
+	if err != nil {
+		t.Fatalf("authorizationURL: %v", err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		t.Fatalf("parse authorization url: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "office" || !strings.Contains(query.Get("scope"), "openid") {
+		t.Fatalf("unexpected authorization query %v", query)
+	}
+	srv.challenge = query.Get("code_challenge")
+	srv.nonce = query.Get("nonce")
+
+	if _, err := p.exchangeCode(ctx, "good-code", "wrong-verifier"); err == nil {
+		t.Fatal("expected exchange with a wrong PKCE verifier to fail")
+	}
+	idToken, err := p.exchangeCode(ctx, "good-code", verifier)
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	claims, err := p.verifyIDToken(ctx, idToken, "n-1")
+	if err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+	user := p.identityFromClaims(claims)
+	if user.WbUserID != "oidc:user-42" || user.EmployeeID != "E-42" || user.UserName != "Иван Петров" {
+		t.Fatalf("unexpected identity %+v", user)
+	}
+	if _, err := p.verifyIDToken(ctx, idToken, "other-nonce"); !errors.Is(err, errOIDCTokenInvalid) {
+		t.Fatalf("expected nonce mismatch to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestOIDCServer(t)
+	p := srv.provider(t)
+	ctx := context.Background()
+
+	valid := srv.defaultClaims()
+	if _, err := p.VerifyToken(ctx, srv.sign(t, "RS256", "k1", valid), nil); err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{name: "wrong audience", token: func() string {
+			claims := srv.defaultClaims()
+			claims["aud"] = "someone-else"
+			return srv.sign(t, "RS256", "k1", claims)
+		}},
+		{name: "wrong issuer", token: func() string {
+			claims := srv.defaultClaims()
+			claims["iss"] = "https://evil.example.com"
+			return srv.sign(t, "RS256", "k1", claims)
+		}},
+		{name: "expired", token: func() string {
+			claims := srv.defaultClaims()
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			return srv.sign(t, "RS256", "k1", claims)
+		}},
+		{name: "unsigned", token: func() string {
+			header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "k1"})
+			payload, _ := json.Marshal(srv.defaultClaims())
+			return base64URLEncode(header) + "." + base64URLEncode(payload) + "."
+		}},
+		{name: "tampered payload", token: func() string {
+			parts := strings.Split(srv.sign(t, "RS256", "k1", srv.defaultClaims()), ".")
+			claims := srv.defaultClaims()
+			claims["employee_id"] = "ADMIN"
+			payload, _ := json.Marshal(claims)
+			return parts[0] + "." + base64URLEncode(payload) + "." + parts[2]
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := p.VerifyToken(ctx, tt.token(), nil); !errors.Is(err, errOIDCTokenInvalid) {
+				t.Fatalf("expected errOIDCTokenInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginStateIsSignedAndExpires(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("state-key")
+	now := time.Now()
+	st := oidcLoginState{State: "s", Nonce: "n", Verifier: "v", ReturnTo: "/buildings", Exp: now.Add(time.Minute).Unix()}
+	encoded, err := encodeOIDCLoginState(st, key)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := decodeOIDCLoginState(encoded, key, now)
+	if err != nil || decoded != st {
+		t.Fatalf("round trip failed: %+v, %v", decoded, err)
+	}
+	if _, err := decodeOIDCLoginState(encoded, []byte("other-key"), now); !errors.Is(err, errOIDCStateInvalid) {
+		t.Fatalf("expected foreign key to be rejected, got %v", err)
+	}
+	if _, err := decodeOIDCLoginState(encoded, key, now.Add(2*time.Minute)); !errors.Is(err, errOIDCStateInvalid) {
+		t.Fatalf("expected expired state to be rejected, got %v", err)
+	}
+}
+
+func TestIsLocalRedirectPath(t *testing.T) {
+	t.Parallel()
+
+	for raw, want := range map[string]bool{
+		"/":                    true,
+		"/buildings/1":         true,
+		"":                     false,
+		"//evil.example.com":   false,
+		"https://evil.example": false,
+		"/\\evil.example.com":  false,
+	} {
+		if got := isLocalRedirectPath(raw); got != want {
+			t.Errorf("isLocalRedirectPath(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
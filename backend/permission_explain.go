@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The explain endpoint answers "why does this employee get Недостаточно
+// прав?" without reproducing the request. It replays the same decision the
+// handlers make — role from the token or the database, global and
+// building-scoped grants, then the responsible employees along the building →
+// floor → zone → object chain and active delegations — and reports every
+// step. It reads only; nothing is audited.
+const adminPermissionExplainPath = "/api/admin/permissions/explain"
+
+const (
+	explainResultPass = "pass"
+	explainResultFail = "fail"
+	explainResultSkip = "skip"
+	explainResultInfo = "info"
+)
+
+const (
+	explainDecidedByRole           = "role"
+	explainDecidedByBuildingGrant  = "building_grant"
+	explainDecidedByResponsibility = "responsibility"
+	explainDecidedByDelegation     = "delegation"
+	explainDecidedByDenied         = "denied"
+)
+
+const (
+	explainRoleSourceAdminEnv       = "admin_env"
+	explainRoleSourceServiceAccount = "service_account"
+	explainRoleSourceDatabase       = "database"
+	explainRoleSourceDefault        = "default"
+)
+
+// Every explainChainQueries entry returns four levels: building, floor, zone
+// and the object itself, each as id, name and responsible.
+// explainLevelColumnsNull fills the levels the entity does not have.
+const explainLevelColumnsNull = `NULL::bigint, NULL::text, NULL::text`
+
+// explainChainQueries resolve the chain the permission checks look at for
+// each entity type, together with the type of the last level.
+var explainChainQueries = map[string]struct {
+	LeafType string
+	Query    string
+}{
+	auditEntityBuilding: {"", `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        ` + explainLevelColumnsNull + `,
+	        ` + explainLevelColumnsNull + `,
+	        ` + explainLevelColumnsNull + `
+	   FROM office_buildings b
+	  WHERE b.id = $1`},
+	auditEntityFloor: {"", `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        ` + explainLevelColumnsNull + `,
+	        ` + explainLevelColumnsNull + `
+	   FROM floors f
+	   JOIN office_buildings b ON b.id = f.building_id
+	  WHERE f.id = $1`},
+	auditEntityZone: {"", `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        z.id, z.name, COALESCE(TRIM(z.responsible_employee_id), ''),
+	        ` + explainLevelColumnsNull + `
+	   FROM zones z
+	   JOIN floors f ON f.id = z.floor_id
+	   JOIN office_buildings b ON b.id = f.building_id
+	  WHERE z.id = $1`},
+	auditEntityCoworking: {auditEntityCoworking, `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        z.id, z.name, COALESCE(TRIM(z.responsible_employee_id), ''),
+	        c.id, c.name, COALESCE(TRIM(c.responsible_employee_id), '')
+	   FROM coworkings c
+	   JOIN floors f ON f.id = c.floor_id
+	   JOIN office_buildings b ON b.id = f.building_id
+	   LEFT JOIN zones z ON z.id = c.zone_id
+	  WHERE c.id = $1`},
+	auditEntityMeetingRoom: {"", `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        z.id, z.name, COALESCE(TRIM(z.responsible_employee_id), ''),
+	        ` + explainLevelColumnsNull + `
+	   FROM meeting_rooms mr
+	   JOIN floors f ON f.id = mr.floor_id
+	   JOIN office_buildings b ON b.id = f.building_id
+	   LEFT JOIN zones z ON z.id = mr.zone_id
+	  WHERE mr.id = $1`},
+	auditEntityDesk: {auditEntityCoworking, `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        z.id, z.name, COALESCE(TRIM(z.responsible_employee_id), ''),
+	        c.id, c.name, COALESCE(TRIM(c.responsible_employee_id), '')
+	   FROM workplaces w
+	   JOIN coworkings c ON c.id = w.coworking_id
+	   JOIN floors f ON f.id = c.floor_id
+	   JOIN office_buildings b ON b.id = f.building_id
+	   LEFT JOIN zones z ON z.id = c.zone_id
+	  WHERE w.id = $1`},
+	auditEntityResource: {auditEntityResource, `SELECT b.id, b.name, COALESCE(TRIM(b.responsible_employee_id), ''),
+	        f.id, f.name, COALESCE(TRIM(f.responsible_employee_id), ''),
+	        z.id, z.name, COALESCE(TRIM(z.responsible_employee_id), ''),
+	        res.id, res.name, COALESCE(TRIM(res.responsible_employee_id), '')
+	   FROM resources res
+	   JOIN floors f ON f.id = res.floor_id
+	   JOIN office_buildings b ON b.id = f.building_id
+	   LEFT JOIN zones z ON z.id = res.zone_id
+	  WHERE res.id = $1 AND res.deleted_at IS NULL`},
+}
+
+type permissionExplainRole struct {
+	ID     int    `json:"id"`
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// UsedFrom is where the checked request takes the role from: "token"
+	// for reads with an Office-Access-Token, "database" otherwise.
+	UsedFrom           string     `json:"used_from"`
+	TokenIssuedAt      *time.Time `json:"token_issued_at,omitempty"`
+	TokenValidUntil    *time.Time `json:"token_valid_until,omitempty"`
+	TokenMayBeOutdated bool       `json:"token_may_be_outdated"`
+}
+
+type permissionExplainLevel struct {
+	EntityType            string `json:"entity_type"`
+	EntityID              int64  `json:"entity_id"`
+	Name                  string `json:"name"`
+	ResponsibleEmployeeID string `json:"responsible_employee_id"`
+	Responsible           bool   `json:"responsible"`
+	DelegationID          int64  `json:"delegation_id,omitempty"`
+}
+
+type permissionExplainStep struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+type permissionExplanation struct {
+	EmployeeID   string                   `json:"employee_id"`
+	EmployeeName string                   `json:"employee_name"`
+	UserFound    bool                     `json:"user_found"`
+	Active       bool                     `json:"active"`
+	Action       permission               `json:"action"`
+	Method       string                   `json:"method"`
+	EntityType   string                   `json:"entity_type,omitempty"`
+	EntityID     int64                    `json:"entity_id,omitempty"`
+	BuildingID   int64                    `json:"building_id,omitempty"`
+	Allowed      bool                     `json:"allowed"`
+	DecidedBy    string                   `json:"decided_by"`
+	Role         permissionExplainRole    `json:"role"`
+	Levels       []permissionExplainLevel `json:"levels"`
+	Steps        []permissionExplainStep  `json:"steps"`
+}
+
+func (e *permissionExplanation) step(check, result, format string, args ...any) {
+	e.Steps = append(e.Steps, permissionExplainStep{Check: check, Result: result, Detail: fmt.Sprintf(format, args...)})
+}
+
+// handleAdminPermissionExplain explains one decision.
+// GET /api/admin/permissions/explain?employee_id=&action=&entity_type=&entity_id=&method=
+func (a *app) handleAdminPermissionExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	// The report shows any employee's grants, responsibilities and
+	// delegations, which manage_roles alone does not reveal.
+	if !ensureAdminFresh(w, r, a.db) {
+		return
+	}
+	query := r.URL.Query()
+	employeeID := strings.TrimSpace(query.Get("employee_id"))
+	if employeeID == "" {
+		respondError(w, http.StatusBadRequest, "employee_id is required")
+		return
+	}
+	action := permission(strings.TrimSpace(query.Get("action")))
+	if _, ok := permissionDescriptions[action]; !ok {
+		respondError(w, http.StatusBadRequest, "unknown action")
+		return
+	}
+	method := strings.ToUpper(strings.TrimSpace(query.Get("method")))
+	if method == "" {
+		method = http.MethodGet
+	}
+	entityType := strings.TrimSpace(query.Get("entity_type"))
+	var entityID int64
+	if entityType != "" {
+		if _, ok := explainChainQueries[entityType]; !ok {
+			respondError(w, http.StatusBadRequest, "entity_type must be building, floor, zone, coworking, meeting_room, desk or resource")
+			return
+		}
+		id, err := parseAuditLogsID(query.Get("entity_id"))
+		if err != nil || id == 0 {
+			respondError(w, http.StatusBadRequest, "entity_id is required")
+			return
+		}
+		entityID = id
+	}
+
+	explanation, err := a.explainPermission(r.Context(), employeeID, action, method, entityType, entityID)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			respondError(w, http.StatusNotFound, entityType+" not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, explanation)
+}
+
+func (a *app) explainPermission(ctx context.Context, employeeID string, action permission, method, entityType string, entityID int64) (permissionExplanation, error) {
+	e := permissionExplanation{
+		EmployeeID: employeeID,
+		Action:     action,
+		Method:     method,
+		EntityType: entityType,
+		EntityID:   entityID,
+		DecidedBy:  explainDecidedByDenied,
+		Levels:     []permissionExplainLevel{},
+		Steps:      []permissionExplainStep{},
+	}
+	if err := a.explainRole(ctx, &e); err != nil {
+		return e, err
+	}
+	if entityType != "" {
+		buildingID, levels, err := a.loadExplainLevels(ctx, entityType, entityID)
+		if err != nil {
+			return e, err
+		}
+		e.BuildingID = buildingID
+		e.Levels = levels
+		if err := a.explainDelegations(ctx, &e); err != nil {
+			return e, err
+		}
+	}
+	e.decide()
+	return e, nil
+}
+
+// decide replays canActInBuilding on the resolved role and levels.
+func (e *permissionExplanation) decide() {
+	role, action := e.Role.ID, e.Action
+	if hasPermission(role, action) {
+		e.Allowed = true
+		e.DecidedBy = explainDecidedByRole
+		e.step("global_grant", explainResultPass, "role %s holds %s in every building", e.Role.Key, action)
+	} else {
+		e.step("global_grant", explainResultFail, "role %s does not hold %s in every building", e.Role.Key, action)
+	}
+	switch {
+	case e.EntityType == "":
+		if !e.Allowed && buildingScopedPermissions[action] {
+			e.step("entity", explainResultSkip, "no entity given; building grants and responsibility were not checked")
+		}
+		return
+	case e.Allowed:
+		e.step("building_grant", explainResultSkip, "already allowed by the global grant")
+		e.step("responsibility", explainResultSkip, "already allowed by the global grant")
+		return
+	case !buildingScopedPermissions[action]:
+		e.step("building_grant", explainResultSkip, "%s cannot be granted for a single building", action)
+		e.step("responsibility", explainResultSkip, "responsibility does not grant %s", action)
+		return
+	}
+	if hasPermissionInBuilding(role, action, e.BuildingID) {
+		e.Allowed = true
+		e.DecidedBy = explainDecidedByBuildingGrant
+		e.step("building_grant", explainResultPass, "role %s holds %s in building %d", e.Role.Key, action, e.BuildingID)
+		return
+	}
+	e.step("building_grant", explainResultFail, "role %s holds no %s grant for building %d", e.Role.Key, action, e.BuildingID)
+
+	for _, level := range e.Levels {
+		switch {
+		case level.Responsible:
+			e.step("responsibility", explainResultPass, "responsible for %s %d (%s)", level.EntityType, level.EntityID, level.Name)
+		case level.DelegationID != 0:
+			e.step("delegation", explainResultPass, "deputy for %s %d (%s) through delegation %d from %s",
+				level.EntityType, level.EntityID, level.Name, level.DelegationID, level.ResponsibleEmployeeID)
+		case level.ResponsibleEmployeeID == "":
+			e.step("responsibility", explainResultFail, "%s %d (%s) has no responsible", level.EntityType, level.EntityID, level.Name)
+		default:
+			e.step("responsibility", explainResultFail, "%s %d (%s) is the responsibility of %s", level.EntityType, level.EntityID, level.Name, level.ResponsibleEmployeeID)
+		}
+		if e.Allowed {
+			continue
+		}
+		if level.Responsible {
+			e.Allowed = true
+			e.DecidedBy = explainDecidedByResponsibility
+		} else if level.DelegationID != 0 {
+			e.Allowed = true
+			e.DecidedBy = explainDecidedByDelegation
+		}
+	}
+}
+
+// explainRole resolves the role the way getUserRoleByWbUserID does and
+// records where it comes from.
+func (a *app) explainRole(ctx context.Context, e *permissionExplanation) error {
+	var rawRole sql.NullInt64
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(full_name, ''), active, role
+		   FROM users
+		  WHERE wb_user_id = $1 OR wb_team_profile_id = $1 OR employee_id = $1
+		  ORDER BY (wb_user_id = $1) DESC, (employee_id = $1) DESC
+		  LIMIT 1`,
+		e.EmployeeID,
+	).Scan(&e.EmployeeName, &e.Active, &rawRole)
+	switch {
+	case err == nil:
+		e.UserFound = true
+	case errors.Is(err, sql.ErrNoRows):
+	default:
+		return err
+	}
+
+	role, err := getUserRoleByWbUserID(ctx, a.db, e.EmployeeID)
+	if err != nil {
+		return err
+	}
+	e.Role.ID = role
+	if def, ok := roleDefinitions.lookup(role); ok {
+		e.Role.Key = def.Key
+		e.Role.Name = def.Name
+	}
+
+	switch {
+	case isServiceAccountPrincipal(e.EmployeeID):
+		e.Role.Source = explainRoleSourceServiceAccount
+		e.step("identity", explainResultInfo, "service account; its API key scopes are checked before any permission")
+	case !e.UserFound:
+		e.step("identity", explainResultFail, "no user with this employee_id, wb_user_id or team profile id")
+	case !e.Active:
+		e.step("identity", explainResultFail, "user is deactivated: sign-in is rejected, tokens issued earlier work until they expire")
+	default:
+		e.step("identity", explainResultPass, "active user %s", e.EmployeeName)
+	}
+
+	switch {
+	case isAdminEmployeeID(e.EmployeeID):
+		e.Role.Source = explainRoleSourceAdminEnv
+		e.step("role", explainResultInfo, "admin through %s", adminEmployeeIDsEnvKey)
+	case e.Role.Source == explainRoleSourceServiceAccount:
+		e.step("role", explainResultInfo, "role %s from service_accounts.role", e.Role.Key)
+	case e.UserFound && rawRole.Valid && isValidRole(int(rawRole.Int64)):
+		e.Role.Source = explainRoleSourceDatabase
+		e.step("role", explainResultInfo, "role %s from users.role", e.Role.Key)
+	default:
+		e.Role.Source = explainRoleSourceDefault
+		e.step("role", explainResultInfo, "no valid stored role; falling back to %s", e.Role.Key)
+	}
+
+	// Mirrors resolveRoleFromRequest: only reads by a signed-in employee take
+	// the role from the Office-Access-Token.
+	e.Role.UsedFrom = explainRoleSourceDatabase
+	if isMutatingMethod(e.Method) || e.Role.Source == explainRoleSourceServiceAccount {
+		e.step("role_source", explainResultInfo, "%s requests read the role from the database on every call", e.Method)
+		return nil
+	}
+	e.Role.UsedFrom = "token"
+	var issuedAt sql.NullTime
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT MAX(created_at) FROM office_refresh_tokens WHERE employee_id = $1`,
+		e.EmployeeID,
+	).Scan(&issuedAt); err != nil {
+		return err
+	}
+	if !issuedAt.Valid {
+		e.step("role_source", explainResultInfo, "%s requests use the role from the Office-Access-Token; no token has been issued yet", e.Method)
+		return nil
+	}
+	validUntil := issuedAt.Time.Add(officeAccessTokenTTL)
+	e.Role.TokenIssuedAt = &issuedAt.Time
+	e.Role.TokenValidUntil = &validUntil
+	e.Role.TokenMayBeOutdated = validUntil.After(time.Now())
+	if e.Role.TokenMayBeOutdated {
+		e.step("role_source", explainResultInfo,
+			"%s requests use the role from the Office-Access-Token issued at %s; a role change made since then applies to reads after the next refresh (by %s)",
+			e.Method, issuedAt.Time.UTC().Format(time.RFC3339), validUntil.UTC().Format(time.RFC3339))
+	} else {
+		e.step("role_source", explainResultInfo,
+			"%s requests use the role from the Office-Access-Token; the last one expired at %s, so the next one will carry the current role",
+			e.Method, validUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadExplainLevels returns the building and the levels whose responsible
+// employees the permission checks accept for the entity.
+func (a *app) loadExplainLevels(ctx context.Context, entityType string, entityID int64) (int64, []permissionExplainLevel, error) {
+	chain := explainChainQueries[entityType]
+	var (
+		ids   [4]sql.NullInt64
+		names [4]sql.NullString
+		resps [4]sql.NullString
+	)
+	dest := make([]any, 0, 12)
+	for i := range ids {
+		dest = append(dest, &ids[i], &names[i], &resps[i])
+	}
+	if err := a.db.QueryRowContext(ctx, chain.Query, entityID).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, errNotFound
+		}
+		return 0, nil, err
+	}
+	types := [4]string{auditEntityBuilding, auditEntityFloor, auditEntityZone, chain.LeafType}
+	levels := make([]permissionExplainLevel, 0, len(types))
+	for i, levelType := range types {
+		if !ids[i].Valid || levelType == "" {
+			continue
+		}
+		levels = append(levels, permissionExplainLevel{
+			EntityType:            levelType,
+			EntityID:              ids[i].Int64,
+			Name:                  names[i].String,
+			ResponsibleEmployeeID: resps[i].String,
+		})
+	}
+	return ids[0].Int64, levels, nil
+}
+
+// explainDelegations marks the levels the employee is responsible for,
+// directly or through an active delegation from the current responsible.
+func (a *app) explainDelegations(ctx context.Context, e *permissionExplanation) error {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT d.id, d.entity_type, d.entity_id, d.delegator_employee_id
+		   FROM responsibility_delegations d
+		  WHERE d.deputy_employee_id = $1
+		    AND d.revoked_at IS NULL
+		    AND d.starts_at <= now() AND d.ends_at > now()`,
+		e.EmployeeID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type delegationKey struct {
+		EntityType string
+		EntityID   int64
+		Delegator  string
+	}
+	active := make(map[delegationKey]int64)
+	for rows.Next() {
+		var (
+			id  int64
+			key delegationKey
+		)
+		if err := rows.Scan(&id, &key.EntityType, &key.EntityID, &key.Delegator); err != nil {
+			return err
+		}
+		active[key] = id
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range e.Levels {
+		level := &e.Levels[i]
+		level.Responsible = isResponsibleEmployee(e.EmployeeID, level.ResponsibleEmployeeID)
+		level.DelegationID = active[delegationKey{level.EntityType, level.EntityID, level.ResponsibleEmployeeID}]
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPermissionExplanationDecide(t *testing.T) {
+	levels := func() []permissionExplainLevel {
+		return []permissionExplainLevel{
+			{EntityType: auditEntityBuilding, EntityID: 1, Name: "БЦ", ResponsibleEmployeeID: "100"},
+			{EntityType: auditEntityFloor, EntityID: 2, Name: "3 этаж"},
+			{EntityType: auditEntityCoworking, EntityID: 3, Name: "Open space", ResponsibleEmployeeID: "200"},
+		}
+	}
+	cases := []struct {
+		name      string
+		role      int
+		action    permission
+		entity    string
+		mutate    func([]permissionExplainLevel)
+		allowed   bool
+		decidedBy string
+	}{
+		{"global role", roleFacilityManager, permissionManageLayout, auditEntityCoworking, nil, true, explainDecidedByRole},
+		{"responsible for building", roleEmployee, permissionManageLayout, auditEntityCoworking,
+			func(l []permissionExplainLevel) { l[0].Responsible = true }, true, explainDecidedByResponsibility},
+		{"deputy for coworking", roleEmployee, permissionManageBookings, auditEntityCoworking,
+			func(l []permissionExplainLevel) { l[2].DelegationID = 7 }, true, explainDecidedByDelegation},
+		{"no match", roleEmployee, permissionManageLayout, auditEntityCoworking, nil, false, explainDecidedByDenied},
+		{"not building scoped", roleEmployee, permissionViewAuditLogs, auditEntityCoworking,
+			func(l []permissionExplainLevel) { l[0].Responsible = true }, false, explainDecidedByDenied},
+		{"no entity", roleEmployee, permissionManageLayout, "", nil, false, explainDecidedByDenied},
+	}
+	for _, tc := range cases {
+		e := permissionExplanation{
+			Action:     tc.action,
+			EntityType: tc.entity,
+			BuildingID: 1,
+			DecidedBy:  explainDecidedByDenied,
+			Role:       permissionExplainRole{ID: tc.role},
+		}
+		if tc.entity != "" {
+			e.Levels = levels()
+			if tc.mutate != nil {
+				tc.mutate(e.Levels)
+			}
+		}
+		e.decide()
+		if e.Allowed != tc.allowed || e.DecidedBy != tc.decidedBy {
+			t.Errorf("%s: allowed=%v decided_by=%s, want %v %s", tc.name, e.Allowed, e.DecidedBy, tc.allowed, tc.decidedBy)
+		}
+		if len(e.Steps) == 0 {
+			t.Errorf("%s: no steps recorded", tc.name)
+		}
+	}
+}
+
+func TestPermissionExplanationListsEveryLevel(t *testing.T) {
+	e := permissionExplanation{
+		Action:     permissionManageLayout,
+		EntityType: auditEntityZone,
+		Role:       permissionExplainRole{ID: roleEmployee},
+		Levels: []permissionExplainLevel{
+			{EntityType: auditEntityBuilding, EntityID: 1, ResponsibleEmployeeID: "100"},
+			{EntityType: auditEntityFloor, EntityID: 2, Responsible: true},
+			{EntityType: auditEntityZone, EntityID: 3},
+		},
+	}
+	e.decide()
+	responsibilitySteps := 0
+	for _, s := range e.Steps {
+		if s.Check == "responsibility" {
+			responsibilitySteps++
+		}
+	}
+	if responsibilitySteps != len(e.Levels) {
+		t.Fatalf("got %d responsibility steps, want %d: %+v", responsibilitySteps, len(e.Levels), e.Steps)
+	}
+}
+
+func TestAdminPermissionExplainRequiresAdmin(t *testing.T) {
+	t.Setenv(adminEmployeeIDsEnvKey, "explain-admin")
+	a := &app{}
+	explain := func(employeeID string) int {
+		ctx := context.WithValue(context.Background(), authClaimsCtxKey, authClaims{EmployeeID: employeeID})
+		req := httptest.NewRequest(http.MethodGet, adminPermissionExplainPath, nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		a.handleAdminPermissionExplain(rec, req)
+		return rec.Code
+	}
+	// The admin gets past the guard to the query validation.
+	if code := explain("explain-admin"); code != http.StatusBadRequest {
+		t.Fatalf("admin: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := explain(""); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: status %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// Any role stored in the database other than admin is refused; needs
+	// OFFICE_TEST_DATABASE_URL.
+	db := openTestDatabase(t)
+	a.db = db
+	employeeID := fmt.Sprintf("explain-%d", time.Now().UnixNano())
+	if _, err := db.Exec(
+		`INSERT INTO users (full_name, employee_id, wb_user_id, role) VALUES ($1, $1, $1, $2)`,
+		employeeID, roleAuditor,
+	); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if code := explain(employeeID); code != http.StatusForbidden {
+		t.Fatalf("non-admin: status %d, want %d", code, http.StatusForbidden)
+	}
+}
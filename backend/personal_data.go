@@ -0,0 +1,818 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Personal data requests. The export collects everything tied to an employee
+// ID into one JSON document: the users row, bookings, sessions, audit events
+// the employee performed, responsibilities and delegations. Employees export
+// their own data; personal data managers export anyone's.
+//
+// Erasure replaces the employee ID with a random pseudonym everywhere it is
+// stored, so bookings and audit events stay countable per person without
+// pointing at anyone. The users row is blanked, sessions are deleted and
+// audit events are rewritten in place (see audit_chain.go for how the chain
+// stays verifiable). Only offboarded employees without responsibilities can
+// be erased. Events already moved to audit archive files are not rewritten:
+// the files keep the personal data until they are deleted by hand.
+const (
+	personalDataPath           = "/api/personal-data"
+	adminPersonalDataPath      = "/api/admin/personal-data"
+	adminPersonalDataErasePath = "/api/admin/personal-data/erase"
+
+	auditActionExportPersonalData = "export_personal_data"
+	auditActionErasePersonalData  = "erase_personal_data"
+
+	personalDataExportFormat   = "office-personal-data/v1"
+	personalDataPseudonymBytes = 6
+	personalDataAuditPageSize  = 500
+	erasedEmployeeName         = "Удалённый сотрудник"
+)
+
+var (
+	errPersonalDataSubjectNotFound = errors.New("employee not found")
+	errPersonalDataSubjectActive   = errors.New("employee is still active; offboard them first")
+	errPersonalDataResponsible     = errors.New("employee is still responsible for buildings, floors, coworkings, zones or resources; reassign them first")
+)
+
+// personalDataReferences lists the columns outside users, office_refresh_tokens
+// and audit_log_events that hold an employee ID. Erasure pseudonymizes them.
+var personalDataReferences = []struct {
+	Table   string
+	Columns []string
+}{
+	{"workplace_bookings", []string{"applier_employee_id", "tenant_employee_id", "canceller_employee_id"}},
+	{"meeting_room_bookings", []string{"applier_employee_id", "canceller_employee_id"}},
+	{"resource_bookings", []string{"applier_employee_id", "canceller_employee_id"}},
+	{"responsibility_audit_log", []string{"previous_employee_id", "new_employee_id", "changed_by_employee_id"}},
+	{"responsibility_delegations", []string{"delegator_employee_id", "deputy_employee_id", "created_by_employee_id", "revoked_by_employee_id"}},
+	{"impersonation_sessions", []string{"admin_employee_id", "employee_id"}},
+	{"trash_items", []string{"deleted_by_employee_id"}},
+	{"service_accounts", []string{"created_by"}},
+	{"service_account_keys", []string{"created_by"}},
+}
+
+type personalDataUser struct {
+	ID                int64     `json:"id"`
+	EmployeeID        string    `json:"employee_id"`
+	FullName          string    `json:"full_name"`
+	WbTeamProfileID   string    `json:"wb_team_profile_id"`
+	WbUserID          string    `json:"wb_user_id"`
+	AvatarURL         string    `json:"avatar_url"`
+	WbBand            string    `json:"wb_band"`
+	Role              int       `json:"role"`
+	SCIMUserName      string    `json:"scim_user_name"`
+	SCIMExternalID    string    `json:"scim_external_id"`
+	SCIMGivenName     string    `json:"scim_given_name"`
+	SCIMFamilyName    string    `json:"scim_family_name"`
+	SubdivisionLevel1 string    `json:"subdivision_level_1"`
+	SubdivisionLevel2 string    `json:"subdivision_level_2"`
+	Active            bool      `json:"active"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+type personalDataBooking struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Target      string     `json:"target"`
+	Date        string     `json:"date,omitempty"`
+	Start       *time.Time `json:"start_at,omitempty"`
+	End         *time.Time `json:"end_at,omitempty"`
+	Roles       []string   `json:"roles"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type personalDataSession struct {
+	FamilyID     string    `json:"family_id"`
+	DeviceID     string    `json:"device_id"`
+	Device       string    `json:"device"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddresses  []string  `json:"ip_addresses"`
+	StartedAt    time.Time `json:"started_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Tokens       int       `json:"tokens"`
+	Active       bool      `json:"active"`
+}
+
+type personalDataExport struct {
+	Format           string                      `json:"format"`
+	GeneratedAt      time.Time                   `json:"generated_at"`
+	EmployeeID       string                      `json:"employee_id"`
+	User             *personalDataUser           `json:"user"`
+	DeskBookings     []personalDataBooking       `json:"desk_bookings"`
+	MeetingBookings  []personalDataBooking       `json:"meeting_bookings"`
+	ResourceBookings []personalDataBooking       `json:"resource_bookings"`
+	Sessions         []personalDataSession       `json:"sessions"`
+	AuditEvents      []auditLogItem              `json:"audit_events"`
+	Responsibilities []offboardingResponsibility `json:"responsibilities"`
+	Delegations      []delegation                `json:"delegations"`
+}
+
+type personalDataErasureRequest struct {
+	EmployeeID string `json:"employee_id"`
+}
+
+type personalDataErasureReport struct {
+	EmployeeID       string           `json:"employee_id"`
+	Pseudonym        string           `json:"pseudonym,omitempty"`
+	UserFound        bool             `json:"user_found"`
+	Active           bool             `json:"active"`
+	Responsibilities int              `json:"responsibilities"`
+	Records          map[string]int64 `json:"records"`
+	Sessions         int64            `json:"sessions"`
+	AuditEvents      int64            `json:"audit_events"`
+	Applied          bool             `json:"applied"`
+}
+
+// personalDataSubject is what erasure looks for: identifiers are replaced
+// with the pseudonym wherever they occur as a whole value, the full name
+// also inside longer strings.
+type personalDataSubject struct {
+	EmployeeID  string
+	FullName    string
+	Identifiers []string
+	UserIDs     []int64
+	Pseudonym   string
+}
+
+func newPersonalDataPseudonym() (string, error) {
+	buf := make([]byte, personalDataPseudonymBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "erased-" + hex.EncodeToString(buf), nil
+}
+
+// personalDataPseudonymizeSQL replaces employee ID $1 with $2 in columns of
+// table.
+func personalDataPseudonymizeSQL(table string, columns []string) string {
+	sets := make([]string, 0, len(columns))
+	for _, column := range columns {
+		sets = append(sets, fmt.Sprintf("%[1]s = CASE WHEN %[1]s = $1 THEN $2 ELSE %[1]s END", column))
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE $1 IN (%s)", table, strings.Join(sets, ", "), strings.Join(columns, ", "))
+}
+
+func personalDataBookingRoles(employeeID, applier, tenant, canceller string) []string {
+	roles := make([]string, 0, 3)
+	if applier == employeeID {
+		roles = append(roles, "applier")
+	}
+	if tenant == employeeID {
+		roles = append(roles, "tenant")
+	}
+	if canceller == employeeID {
+		roles = append(roles, "canceller")
+	}
+	return roles
+}
+
+func (s personalDataSubject) redactString(value string) string {
+	for _, identifier := range s.Identifiers {
+		if value == identifier {
+			return s.Pseudonym
+		}
+	}
+	if s.FullName != "" && strings.Contains(value, s.FullName) {
+		return strings.ReplaceAll(value, s.FullName, erasedEmployeeName)
+	}
+	return value
+}
+
+func (s personalDataSubject) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return s.redactString(v)
+	case map[string]any:
+		for key, item := range v {
+			v[key] = s.redactValue(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = s.redactValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// redactAuditEvent rewrites the fields of e that identify the subject and
+// reports whether anything changed.
+func (s personalDataSubject) redactAuditEvent(e *auditChainEvent) bool {
+	changed := false
+	if e.ActorEmployeeID == s.EmployeeID {
+		e.ActorEmployeeID = s.Pseudonym
+		e.ActorName = erasedEmployeeName
+		changed = true
+	} else if name := s.redactString(e.ActorName); name != e.ActorName {
+		e.ActorName = name
+		changed = true
+	}
+	if e.EntityType == auditEntityUser && containsInt64(s.UserIDs, e.EntityID) {
+		if e.EntityName != s.Pseudonym {
+			e.EntityName = s.Pseudonym
+			changed = true
+		}
+	} else if name := s.redactString(e.EntityName); name != e.EntityName {
+		e.EntityName = name
+		changed = true
+	}
+	// Numbers are kept as written so that untouched details compare equal.
+	dec := json.NewDecoder(bytes.NewReader(e.DetailsJSON))
+	dec.UseNumber()
+	var details any
+	if err := dec.Decode(&details); err == nil {
+		redacted, err := json.Marshal(s.redactValue(details))
+		if err == nil && string(canonicalAuditDetails(redacted)) != string(canonicalAuditDetails(e.DetailsJSON)) {
+			e.DetailsJSON = redacted
+			changed = true
+		}
+	}
+	return changed
+}
+
+func containsInt64(values []int64, want int64) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *app) handlePersonalData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if serviceAccountFromContext(r.Context()) != nil {
+		respondError(w, http.StatusForbidden, "service accounts have no personal data")
+		return
+	}
+	if claims := officeAccessTokenClaimsFromContext(r.Context()); claims != nil && claims.Impersonation != nil {
+		respondError(w, http.StatusForbidden, "personal data cannot be exported while impersonating; use "+adminPersonalDataPath)
+		return
+	}
+	employeeID := a.requestActorEmployeeID(r)
+	if employeeID == "" {
+		respondError(w, http.StatusUnauthorized, errRequesterIdentityRequired.Error())
+		return
+	}
+	a.writePersonalDataExport(w, r, employeeID, true)
+}
+
+func (a *app) handleAdminPersonalData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermissionFresh(w, r, a.db, permissionManagePersonalData) {
+		return
+	}
+	employeeID := strings.TrimSpace(r.URL.Query().Get("employee_id"))
+	if employeeID == "" {
+		respondError(w, http.StatusBadRequest, "employee_id is required")
+		return
+	}
+	a.writePersonalDataExport(w, r, employeeID, false)
+}
+
+func (a *app) writePersonalDataExport(w http.ResponseWriter, r *http.Request, employeeID string, self bool) {
+	export, err := a.collectPersonalData(r.Context(), employeeID)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if export.User == nil && len(export.DeskBookings)+len(export.MeetingBookings)+len(export.ResourceBookings)+len(export.AuditEvents) == 0 {
+		respondError(w, http.StatusNotFound, errPersonalDataSubjectNotFound.Error())
+		return
+	}
+	var (
+		userID   int64
+		userName string
+	)
+	if export.User != nil {
+		userID, userName = export.User.ID, export.User.FullName
+	}
+	a.logAuditEventFromRequest(r, auditActionExportPersonalData, auditEntityUser, userID, userName, map[string]any{
+		"employee_id":  employeeID,
+		"self_service": self,
+	})
+
+	fileName := fmt.Sprintf("personal-data-%s.json", export.GeneratedAt.Format(auditExportFileTimeLayout))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(export)
+}
+
+// collectPersonalData builds the export for employeeID.
+func (a *app) collectPersonalData(ctx context.Context, employeeID string) (personalDataExport, error) {
+	export := personalDataExport{
+		Format:           personalDataExportFormat,
+		GeneratedAt:      time.Now().UTC(),
+		EmployeeID:       employeeID,
+		DeskBookings:     make([]personalDataBooking, 0),
+		MeetingBookings:  make([]personalDataBooking, 0),
+		ResourceBookings: make([]personalDataBooking, 0),
+		Sessions:         make([]personalDataSession, 0),
+		AuditEvents:      make([]auditLogItem, 0),
+		Responsibilities: make([]offboardingResponsibility, 0),
+	}
+
+	var user personalDataUser
+	err := a.db.QueryRowContext(ctx,
+		`SELECT id, employee_id, full_name, wb_team_profile_id, wb_user_id, avatar_url, wb_band, role,
+		        scim_user_name, scim_external_id, scim_given_name, scim_family_name, subdivision_level_1, subdivision_level_2,
+		        active, created_at, updated_at
+		   FROM users
+		  WHERE employee_id = $1
+		  ORDER BY id
+		  LIMIT 1`,
+		employeeID,
+	).Scan(
+		&user.ID, &user.EmployeeID, &user.FullName, &user.WbTeamProfileID, &user.WbUserID, &user.AvatarURL, &user.WbBand, &user.Role,
+		&user.SCIMUserName, &user.SCIMExternalID, &user.SCIMGivenName, &user.SCIMFamilyName, &user.SubdivisionLevel1, &user.SubdivisionLevel2,
+		&user.Active, &user.CreatedAt, &user.UpdatedAt,
+	)
+	switch {
+	case err == nil:
+		export.User = &user
+	case !errors.Is(err, sql.ErrNoRows):
+		return export, err
+	}
+
+	bookingQueries := []struct {
+		kind   string
+		target *[]personalDataBooking
+		query  string
+	}{
+		{"desk", &export.DeskBookings,
+			`SELECT b.id, COALESCE(w.label, ''), b.date, NULL::timestamptz, NULL::timestamptz,
+			        b.applier_employee_id, b.tenant_employee_id, b.canceller_employee_id, b.cancelled_at, b.created_at
+			   FROM workplace_bookings b
+			   LEFT JOIN workplaces w ON w.id = b.workplace_id
+			  WHERE $1 IN (b.applier_employee_id, b.tenant_employee_id, b.canceller_employee_id)
+			  ORDER BY b.date DESC, b.id DESC`},
+		{"meeting_room", &export.MeetingBookings,
+			`SELECT b.id, COALESCE(m.name, ''), '', b.start_at, b.end_at,
+			        b.applier_employee_id, '', b.canceller_employee_id, b.cancelled_at, b.created_at
+			   FROM meeting_room_bookings b
+			   LEFT JOIN meeting_rooms m ON m.id = b.meeting_room_id
+			  WHERE $1 IN (b.applier_employee_id, b.canceller_employee_id)
+			  ORDER BY b.start_at DESC, b.id DESC`},
+		{"resource", &export.ResourceBookings,
+			`SELECT b.id, COALESCE(res.name, ''), '', b.start_at, b.end_at,
+			        b.applier_employee_id, '', b.canceller_employee_id, b.cancelled_at, b.created_at
+			   FROM resource_bookings b
+			   LEFT JOIN resources res ON res.id = b.resource_id
+			  WHERE $1 IN (b.applier_employee_id, b.canceller_employee_id)
+			  ORDER BY b.start_at DESC, b.id DESC`},
+	}
+	for _, bq := range bookingQueries {
+		rows, err := a.db.QueryContext(ctx, bq.query, employeeID)
+		if err != nil {
+			return export, err
+		}
+		for rows.Next() {
+			item := personalDataBooking{Kind: bq.kind}
+			var (
+				start, end, cancelledAt    sql.NullTime
+				applier, tenant, canceller string
+			)
+			if err := rows.Scan(&item.ID, &item.Target, &item.Date, &start, &end,
+				&applier, &tenant, &canceller, &cancelledAt, &item.CreatedAt); err != nil {
+				rows.Close()
+				return export, err
+			}
+			if start.Valid && end.Valid {
+				item.Start, item.End = &start.Time, &end.Time
+			}
+			if cancelledAt.Valid {
+				item.CancelledAt = &cancelledAt.Time
+			}
+			item.Roles = personalDataBookingRoles(employeeID, applier, tenant, canceller)
+			*bq.target = append(*bq.target, item)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return export, err
+		}
+		rows.Close()
+	}
+
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT family_id,
+		        (array_agg(device_id ORDER BY created_at DESC))[1],
+		        (array_agg(user_agent ORDER BY created_at DESC))[1],
+		        array_remove(array_agg(DISTINCT ip_address), ''),
+		        MIN(created_at),
+		        MAX(GREATEST(created_at, COALESCE(last_used_at, created_at))),
+		        MAX(expires_at),
+		        COUNT(*),
+		        COUNT(*) FILTER (WHERE revoked_at IS NULL AND expires_at > now()) > 0
+		   FROM office_refresh_tokens
+		  WHERE employee_id = $1
+		  GROUP BY family_id
+		  ORDER BY 6 DESC`,
+		employeeID,
+	)
+	if err != nil {
+		return export, err
+	}
+	for rows.Next() {
+		var (
+			item personalDataSession
+			ips  []string
+		)
+		if err := rows.Scan(&item.FamilyID, &item.DeviceID, &item.UserAgent, &ips,
+			&item.StartedAt, &item.LastActiveAt, &item.ExpiresAt, &item.Tokens, &item.Active); err != nil {
+			rows.Close()
+			return export, err
+		}
+		item.Device = describeUserAgent(item.UserAgent)
+		item.IPAddresses = ips
+		if item.IPAddresses == nil {
+			item.IPAddresses = []string{}
+		}
+		export.Sessions = append(export.Sessions, item)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return export, err
+	}
+	rows.Close()
+
+	var cursor *auditLogCursor
+	for {
+		items, next, err := a.queryAuditLogPage(ctx, auditLogFilter{ActorEmployeeID: employeeID}, cursor, personalDataAuditPageSize, 0)
+		if err != nil {
+			return export, err
+		}
+		export.AuditEvents = append(export.AuditEvents, items...)
+		if next == "" {
+			break
+		}
+		last := items[len(items)-1]
+		cursor = &auditLogCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	for _, rt := range offboardingResponsibilityTables {
+		rows, err := a.db.QueryContext(ctx,
+			fmt.Sprintf(`SELECT id, COALESCE(name, '') FROM %s WHERE responsible_employee_id = $1 ORDER BY id`, rt.Table),
+			employeeID,
+		)
+		if err != nil {
+			return export, err
+		}
+		for rows.Next() {
+			item := offboardingResponsibility{EntityType: rt.EntityType}
+			if err := rows.Scan(&item.EntityID, &item.Name); err != nil {
+				rows.Close()
+				return export, err
+			}
+			export.Responsibilities = append(export.Responsibilities, item)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return export, err
+		}
+		rows.Close()
+	}
+
+	export.Delegations, err = a.queryDelegations(ctx,
+		"(d.delegator_employee_id = $1 OR d.deputy_employee_id = $1)", employeeID)
+	if err != nil {
+		return export, err
+	}
+	return export, nil
+}
+
+func (a *app) handleAdminPersonalDataErase(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermissionFresh(w, r, a.db, permissionManagePersonalData) {
+		return
+	}
+	var req personalDataErasureRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.EmployeeID = r.URL.Query().Get("employee_id")
+	case http.MethodPost:
+		if err := decodeJSON(r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req.EmployeeID = strings.TrimSpace(req.EmployeeID)
+	if req.EmployeeID == "" {
+		respondError(w, http.StatusBadRequest, "employee_id is required")
+		return
+	}
+	if isServiceAccountPrincipal(req.EmployeeID) {
+		respondError(w, http.StatusBadRequest, "service accounts have no personal data")
+		return
+	}
+	actorEmployeeID := a.requestActorEmployeeID(r)
+	if actorEmployeeID == req.EmployeeID {
+		respondError(w, http.StatusBadRequest, "cannot erase your own data")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		report, _, err := a.previewPersonalDataErasure(r.Context(), a.db, req.EmployeeID)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, report)
+		return
+	}
+
+	actorName, err := getUserNameByEmployeeID(r.Context(), a.db, actorEmployeeID)
+	if err != nil {
+		log.Printf("personal data: failed to resolve actor name for %q: %v", actorEmployeeID, err)
+	}
+	report, err := a.applyPersonalDataErasure(r.Context(), req.EmployeeID, actorEmployeeID, strings.TrimSpace(actorName))
+	switch {
+	case errors.Is(err, errPersonalDataSubjectNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errPersonalDataSubjectActive), errors.Is(err, errPersonalDataResponsible):
+		respondError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, errAuditCheckpointNoSigningKey):
+		respondError(w, http.StatusServiceUnavailable, "Office token signing is not configured")
+		return
+	case err != nil:
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.auditSinks.notify()
+	respondJSON(w, http.StatusOK, report)
+}
+
+// previewPersonalDataErasure counts what applyPersonalDataErasure would
+// change and returns the subject it would look for.
+func (a *app) previewPersonalDataErasure(ctx context.Context, q offboardingQueryer, employeeID string) (personalDataErasureReport, personalDataSubject, error) {
+	report := personalDataErasureReport{EmployeeID: employeeID, Records: make(map[string]int64)}
+	subject := personalDataSubject{EmployeeID: employeeID, Identifiers: []string{employeeID}}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, full_name, wb_team_profile_id, wb_user_id, scim_user_name, scim_external_id, active
+		   FROM users
+		  WHERE employee_id = $1
+		  ORDER BY id`,
+		employeeID,
+	)
+	if err != nil {
+		return report, subject, err
+	}
+	for rows.Next() {
+		var (
+			id                                      int64
+			fullName, profileID, wbUserID, scimName string
+			scimExternalID                          string
+			active                                  bool
+		)
+		if err := rows.Scan(&id, &fullName, &profileID, &wbUserID, &scimName, &scimExternalID, &active); err != nil {
+			rows.Close()
+			return report, subject, err
+		}
+		report.UserFound = true
+		report.Active = report.Active || active
+		subject.UserIDs = append(subject.UserIDs, id)
+		if subject.FullName == "" {
+			subject.FullName = strings.TrimSpace(fullName)
+		}
+		for _, identifier := range []string{profileID, wbUserID, scimName, scimExternalID} {
+			if identifier = strings.TrimSpace(identifier); identifier != "" {
+				subject.Identifiers = append(subject.Identifiers, identifier)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return report, subject, err
+	}
+	rows.Close()
+
+	for _, rt := range offboardingResponsibilityTables {
+		var n int
+		if err := q.QueryRowContext(ctx,
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE responsible_employee_id = $1`, rt.Table),
+			employeeID,
+		).Scan(&n); err != nil {
+			return report, subject, err
+		}
+		report.Responsibilities += n
+	}
+	for _, ref := range personalDataReferences {
+		var n int64
+		if err := q.QueryRowContext(ctx,
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE $1 IN (%s)`, ref.Table, strings.Join(ref.Columns, ", ")),
+			employeeID,
+		).Scan(&n); err != nil {
+			return report, subject, err
+		}
+		report.Records[ref.Table] = n
+	}
+	if err := q.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT family_id) FROM office_refresh_tokens WHERE employee_id = $1`,
+		employeeID,
+	).Scan(&report.Sessions); err != nil {
+		return report, subject, err
+	}
+	if err := q.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM audit_log_events WHERE `+personalDataAuditCandidateSQL,
+		employeeID, subject.FullName, subject.Identifiers, subject.UserIDs,
+	).Scan(&report.AuditEvents); err != nil {
+		return report, subject, err
+	}
+	return report, subject, nil
+}
+
+// personalDataAuditCandidateSQL narrows audit_log_events to rows that may
+// mention the subject; redactAuditEvent decides. $1 is the employee ID, $2
+// the full name, $3 all identifiers and $4 the users row IDs.
+const personalDataAuditCandidateSQL = `(actor_employee_id = $1
+	    OR (entity_type = 'user' AND entity_id = ANY($4::bigint[]))
+	    OR EXISTS (SELECT 1 FROM unnest($3::text[]) v
+	                WHERE jsonb_path_exists(details_json, '$.** ? (@ == $v)', jsonb_build_object('v', v)))
+	    OR ($2 <> '' AND (strpos(actor_name, $2) > 0
+	                      OR strpos(entity_name, $2) > 0
+	                      OR strpos(details_json::text, $2) > 0)))`
+
+// applyPersonalDataErasure pseudonymizes the employee in one transaction
+// that also holds the audit chain lock, so the rewritten events, the
+// erase_personal_data event listing them and the erasure checkpoint signing
+// their new hashes are committed together.
+func (a *app) applyPersonalDataErasure(ctx context.Context, employeeID, actorEmployeeID, actorName string) (personalDataErasureReport, error) {
+	if a.officeTokenKeys == nil || !a.officeTokenKeys.CanSign() {
+		return personalDataErasureReport{}, errAuditCheckpointNoSigningKey
+	}
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return personalDataErasureReport{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return personalDataErasureReport{}, err
+	}
+
+	report, subject, err := a.previewPersonalDataErasure(ctx, tx, employeeID)
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case !report.UserFound:
+		return report, errPersonalDataSubjectNotFound
+	case report.Active:
+		return report, errPersonalDataSubjectActive
+	case report.Responsibilities > 0:
+		return report, errPersonalDataResponsible
+	}
+	subject.Pseudonym, err = newPersonalDataPseudonym()
+	if err != nil {
+		return report, err
+	}
+	report.Pseudonym = subject.Pseudonym
+
+	for _, ref := range personalDataReferences {
+		res, err := tx.ExecContext(ctx, personalDataPseudonymizeSQL(ref.Table, ref.Columns), employeeID, subject.Pseudonym)
+		if err != nil {
+			return report, err
+		}
+		report.Records[ref.Table], _ = res.RowsAffected()
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM office_refresh_tokens WHERE employee_id = $1`, employeeID); err != nil {
+		return report, err
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, prev_hash, hash, action_type, entity_type, entity_id, entity_name,
+		        actor_employee_id, actor_name, details_json::text, created_at,
+		        redacted_at IS NOT NULL
+		   FROM audit_log_events
+		  WHERE `+personalDataAuditCandidateSQL+`
+		  ORDER BY id`,
+		employeeID, subject.FullName, subject.Identifiers, subject.UserIDs,
+	)
+	if err != nil {
+		return report, err
+	}
+	events, err := scanAuditChainEvents(rows)
+	if err != nil {
+		return report, err
+	}
+	redactedIDs := make([]int64, 0, len(events))
+	redactions := make(map[int64]string, len(events))
+	for _, e := range events {
+		if !subject.redactAuditEvent(&e) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE audit_log_events
+			    SET entity_name = $2, actor_employee_id = $3, actor_name = $4, details_json = $5::jsonb, redacted_at = now()
+			  WHERE id = $1`,
+			e.ID, e.EntityName, e.ActorEmployeeID, e.ActorName, string(e.DetailsJSON),
+		); err != nil {
+			return report, err
+		}
+		redactedIDs = append(redactedIDs, e.ID)
+		redactions[e.ID] = auditEventHash(e)
+	}
+	report.AuditEvents = int64(len(redactedIDs))
+
+	// wb_user_id has a unique index, so it takes the pseudonym rather than
+	// an empty value, like the SCIM placeholders.
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users
+		    SET employee_id = $2,
+		        full_name = '',
+		        wb_team_profile_id = '',
+		        wb_user_id = $2,
+		        avatar_url = '',
+		        wb_band = '',
+		        scim_user_name = '',
+		        scim_external_id = '',
+		        scim_given_name = '',
+		        scim_family_name = '',
+		        role = $3,
+		        active = FALSE,
+		        updated_at = now()
+		  WHERE employee_id = $1`,
+		employeeID, subject.Pseudonym, roleEmployee,
+	); err != nil {
+		return report, err
+	}
+
+	details, err := json.Marshal(map[string]any{
+		"pseudonym":          subject.Pseudonym,
+		"records":            report.Records,
+		"sessions":           report.Sessions,
+		"redacted_event_ids": redactedIDs,
+	})
+	if err != nil {
+		return report, err
+	}
+	var entityID int64
+	if len(subject.UserIDs) > 0 {
+		entityID = subject.UserIDs[0]
+	}
+	eraseEventID, err := appendAuditEventTx(ctx, tx, auditChainEvent{
+		ActionType:      auditActionErasePersonalData,
+		EntityType:      auditEntityUser,
+		EntityID:        entityID,
+		EntityName:      subject.Pseudonym,
+		ActorEmployeeID: actorEmployeeID,
+		ActorName:       actorName,
+		DetailsJSON:     details,
+	}, a.auditSinks.names())
+	if err != nil {
+		return report, err
+	}
+	var eraseEventHash string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT hash FROM audit_log_events WHERE id = $1`, eraseEventID,
+	).Scan(&eraseEventHash); err != nil {
+		return report, err
+	}
+	if err := a.insertAuditCheckpointTx(ctx, tx, auditCheckpointClaims{
+		LastEventID: eraseEventID,
+		LastHash:    eraseEventHash,
+		Erasure:     true,
+		Redactions:  redactions,
+	}); err != nil {
+		return report, err
+	}
+	if err := tx.Commit(); err != nil {
+		return report, err
+	}
+	report.Applied = true
+	return report, nil
+}
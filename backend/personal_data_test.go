@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersonalDataRedactAuditEvent(t *testing.T) {
+	subject := personalDataSubject{
+		EmployeeID:  "42",
+		FullName:    "Иван Петров",
+		Identifiers: []string{"42", "ivan@example.com"},
+		UserIDs:     []int64{7},
+		Pseudonym:   "erased-0a1b2c",
+	}
+
+	events := buildAuditChain(3)
+	events[0].ActorName = "Иван Петров"
+	events[0].DetailsJSON = []byte(`{"tenant_employee_id": "42", "note": "для Иван Петров", "count": 42}`)
+	events[1].ActorEmployeeID = "100"
+	events[1].EntityType = auditEntityUser
+	events[1].EntityID = 7
+	events[1].EntityName = "Иван Петров"
+	events[1].DetailsJSON = []byte(`{"changes": [{"field": "scim_user_name", "from": "ivan@example.com"}]}`)
+	events[2].ActorEmployeeID = "100"
+
+	if !subject.redactAuditEvent(&events[0]) {
+		t.Fatal("actor event not redacted")
+	}
+	if events[0].ActorEmployeeID != subject.Pseudonym || events[0].ActorName != erasedEmployeeName {
+		t.Errorf("actor = %q %q", events[0].ActorEmployeeID, events[0].ActorName)
+	}
+	var details map[string]any
+	if err := json.Unmarshal(events[0].DetailsJSON, &details); err != nil {
+		t.Fatal(err)
+	}
+	if details["tenant_employee_id"] != subject.Pseudonym || details["note"] != "для "+erasedEmployeeName || details["count"] != float64(42) {
+		t.Errorf("details = %v", details)
+	}
+
+	if !subject.redactAuditEvent(&events[1]) {
+		t.Fatal("user event not redacted")
+	}
+	if events[1].ActorEmployeeID != "100" || events[1].EntityName != subject.Pseudonym {
+		t.Errorf("user event = %q %q", events[1].ActorEmployeeID, events[1].EntityName)
+	}
+	if strings.Contains(string(events[1].DetailsJSON), "ivan@example.com") {
+		t.Errorf("details still hold the identifier: %s", events[1].DetailsJSON)
+	}
+
+	if subject.redactAuditEvent(&events[2]) {
+		t.Error("unrelated event redacted")
+	}
+}
+
+func TestRedactedAuditEventKeepsChain(t *testing.T) {
+	events := buildAuditChain(3)
+	events[1].ActorEmployeeID = "erased-0a1b2c"
+	if b := firstAuditChainBreak(events); b == nil || b.Reason != auditChainBreakContent {
+		t.Fatalf("rewritten event: break %+v, want %s", b, auditChainBreakContent)
+	}
+	events[1].Redacted = true
+	if b := firstAuditChainBreak(events); b != nil {
+		t.Fatalf("redacted event breaks the chain: %+v", b)
+	}
+	events[1].Hash = "forged"
+	if b := firstAuditChainBreak(events); b == nil {
+		t.Fatal("redacted event with a forged hash verifies")
+	}
+}
+
+func TestFirstUnsignedRedaction(t *testing.T) {
+	redacted := []auditRedactedEvent{{ID: 3, Hash: "a"}, {ID: 5, Hash: "b"}}
+	signed := map[int64]string{3: "a", 5: "b"}
+	erasures := []int64{6}
+	signedErasures := map[int64]bool{6: true}
+	if b := firstUnsignedRedaction(redacted, signed, erasures, signedErasures); b != nil {
+		t.Fatalf("signed redactions reported broken: %+v", b)
+	}
+
+	tests := []struct {
+		name           string
+		signed         map[int64]string
+		signedErasures map[int64]bool
+		wantID         int64
+		wantReason     string
+	}{
+		{name: "unsigned redaction", signed: map[int64]string{3: "a"}, signedErasures: signedErasures, wantID: 5, wantReason: auditChainBreakRedaction},
+		{name: "content changed after erasure", signed: map[int64]string{3: "forged", 5: "b"}, signedErasures: signedErasures, wantID: 3, wantReason: auditChainBreakContent},
+		{name: "erase event without checkpoint", signed: signed, signedErasures: nil, wantID: 6, wantReason: auditChainBreakErasure},
+		{name: "earliest break wins", signed: map[int64]string{3: "a"}, signedErasures: nil, wantID: 5, wantReason: auditChainBreakRedaction},
+	}
+	for _, tt := range tests {
+		b := firstUnsignedRedaction(redacted, tt.signed, erasures, tt.signedErasures)
+		if b == nil || b.EventID != tt.wantID || b.Reason != tt.wantReason {
+			t.Errorf("%s: got %+v, want %s at event %d", tt.name, b, tt.wantReason, tt.wantID)
+		}
+	}
+}
+
+func TestPersonalDataPseudonymizeSQL(t *testing.T) {
+	got := personalDataPseudonymizeSQL("meeting_room_bookings", []string{"applier_employee_id", "canceller_employee_id"})
+	want := "UPDATE meeting_room_bookings SET " +
+		"applier_employee_id = CASE WHEN applier_employee_id = $1 THEN $2 ELSE applier_employee_id END, " +
+		"canceller_employee_id = CASE WHEN canceller_employee_id = $1 THEN $2 ELSE canceller_employee_id END " +
+		"WHERE $1 IN (applier_employee_id, canceller_employee_id)"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if roles := personalDataBookingRoles("42", "42", "", "42"); strings.Join(roles, ",") != "applier,canceller" {
+		t.Errorf("roles = %v", roles)
+	}
+}
+
+// TestPersonalDataErasureOfSeveralUsers erases two employees against the
+// database in OFFICE_TEST_DATABASE_URL: the blanked users rows must not
+// collide on the unique wb_user_id index.
+func TestPersonalDataErasureOfSeveralUsers(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db, officeTokenKeys: newLegacyHS256KeyManager([]byte(testAuditCheckpointSecret))}
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+
+	for i := 0; i < 2; i++ {
+		employeeID := fmt.Sprintf("erase-%d-%d", suffix, i)
+		if _, err := db.Exec(
+			`INSERT INTO users (full_name, employee_id, wb_user_id, active) VALUES ('Иван Петров', $1, $2, FALSE)`,
+			employeeID, "wb-"+employeeID,
+		); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		report, err := a.applyPersonalDataErasure(ctx, employeeID, "erase-admin", "Админ")
+		if err != nil {
+			t.Fatalf("erase user %d: %v", i, err)
+		}
+		var wbUserID, fullName string
+		if err := db.QueryRow(
+			`SELECT wb_user_id, full_name FROM users WHERE employee_id = $1`, report.Pseudonym,
+		).Scan(&wbUserID, &fullName); err != nil {
+			t.Fatalf("load erased user %d: %v", i, err)
+		}
+		if wbUserID != report.Pseudonym || fullName != "" {
+			t.Fatalf("erased user %d: wb_user_id %q, full_name %q", i, wbUserID, fullName)
+		}
+	}
+}
+
+// testAuditCheckpointSecret signs the checkpoints database tests leave
+// behind, so later runs can still verify them.
+const testAuditCheckpointSecret = "audit-checkpoint-test-secret-0123456789"
+
+// TestPersonalDataErasureKeepsChainVerifiable erases an employee against the
+// database in OFFICE_TEST_DATABASE_URL and tampers with the redaction
+// afterwards: only the content signed by the erasure checkpoint verifies.
+func TestPersonalDataErasureKeepsChainVerifiable(t *testing.T) {
+	db := openTestDatabase(t)
+	keys := newLegacyHS256KeyManager([]byte(testAuditCheckpointSecret))
+	a := &app{db: db, officeTokenKeys: keys}
+	ctx := context.Background()
+	employeeID := fmt.Sprintf("erase-chain-%d", time.Now().UnixNano())
+
+	if _, err := db.Exec(
+		`INSERT INTO users (full_name, employee_id, wb_user_id, active) VALUES ('Пётр Иванов', $1, $2, FALSE)`,
+		employeeID, "wb-"+employeeID,
+	); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	a.logAuditEvent(ctx, auditLogWriteInput{ActionType: auditActionUpdate, EntityType: auditEntityDesk, EntityID: 1, EntityName: "erase chain test", ActorEmployeeID: employeeID, ActorName: "Пётр Иванов"})
+	var redactedID, otherID int64
+	if err := db.QueryRow(`SELECT MAX(id) FROM audit_log_events WHERE actor_employee_id = $1`, employeeID).Scan(&redactedID); err != nil {
+		t.Fatalf("load event: %v", err)
+	}
+	a.logAuditEvent(ctx, auditLogWriteInput{ActionType: auditActionUpdate, EntityType: auditEntityDesk, EntityID: 1, EntityName: "erase chain test", ActorEmployeeID: "erase-chain-other"})
+	if err := db.QueryRow(`SELECT MAX(id) FROM audit_log_events WHERE actor_employee_id = 'erase-chain-other'`).Scan(&otherID); err != nil {
+		t.Fatalf("load event: %v", err)
+	}
+	if _, err := a.applyPersonalDataErasure(ctx, employeeID, "erase-admin", "Админ"); err != nil {
+		t.Fatalf("erase: %v", err)
+	}
+
+	verify := func() auditChainReport {
+		t.Helper()
+		report, err := verifyAuditChain(ctx, db, keys, nil)
+		if err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		return report
+	}
+	if report := verify(); !report.Valid {
+		t.Fatalf("chain after erasure: %+v", report)
+	}
+
+	var actorName string
+	if err := db.QueryRow(`SELECT actor_name FROM audit_log_events WHERE id = $1`, redactedID).Scan(&actorName); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec(`UPDATE audit_log_events SET actor_name = $2 WHERE id = $1`, redactedID, actorName)
+		_, _ = db.Exec(`UPDATE audit_log_events SET redacted_at = NULL WHERE id = $1`, otherID)
+	})
+	if _, err := db.Exec(`UPDATE audit_log_events SET actor_name = 'Подмена' WHERE id = $1`, redactedID); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Valid || report.BrokenLink == nil || report.BrokenLink.EventID != redactedID || report.BrokenLink.Reason != auditChainBreakContent {
+		t.Fatalf("redacted event changed after erasure: %+v", report.BrokenLink)
+	}
+	if _, err := db.Exec(`UPDATE audit_log_events SET actor_name = $2 WHERE id = $1`, redactedID, actorName); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(`UPDATE audit_log_events SET redacted_at = now() WHERE id = $1`, otherID); err != nil {
+		t.Fatal(err)
+	}
+	if report := verify(); report.Valid || report.BrokenLink == nil || report.BrokenLink.EventID != otherID || report.BrokenLink.Reason != auditChainBreakRedaction {
+		t.Fatalf("event marked redacted by hand: %+v", report.BrokenLink)
+	}
+}
+
+// TestPersonalDataErasureNameIsNotAPattern erases an employee whose name
+// holds "_" against the database in OFFICE_TEST_DATABASE_URL: events of a
+// namesake that only matches with "_" as a wildcard stay untouched.
+func TestPersonalDataErasureNameIsNotAPattern(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db, officeTokenKeys: newLegacyHS256KeyManager([]byte(testAuditCheckpointSecret))}
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+	employeeID := fmt.Sprintf("erase-pattern-%d", suffix)
+	fullName := fmt.Sprintf("Анна_%d", suffix)
+	namesake := fmt.Sprintf("АннаК%d", suffix)
+
+	if _, err := db.Exec(
+		`INSERT INTO users (full_name, employee_id, wb_user_id, active) VALUES ($1, $2, $3, FALSE)`,
+		fullName, employeeID, "wb-"+employeeID,
+	); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	a.logAuditEvent(ctx, auditLogWriteInput{ActionType: auditActionUpdate, EntityType: auditEntityDesk, EntityID: 1, EntityName: "Стол " + namesake, ActorEmployeeID: "erase-pattern-other", ActorName: namesake})
+
+	preview, _, err := a.previewPersonalDataErasure(ctx, db, employeeID)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if preview.AuditEvents != 0 {
+		t.Fatalf("preview counts %d audit event(s) of a namesake", preview.AuditEvents)
+	}
+	if _, err := a.applyPersonalDataErasure(ctx, employeeID, "erase-admin", "Админ"); err != nil {
+		t.Fatalf("erase: %v", err)
+	}
+	var redacted bool
+	if err := db.QueryRow(
+		`SELECT redacted_at IS NOT NULL FROM audit_log_events WHERE actor_name = $1`, namesake,
+	).Scan(&redacted); err != nil {
+		t.Fatalf("load namesake event: %v", err)
+	}
+	if redacted {
+		t.Fatal("event of a namesake redacted")
+	}
+}
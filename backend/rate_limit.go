@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting uses token buckets keyed by policy and principal. Every
+// request is charged to the policy of its route group (see
+// rateLimitRoutes); routes outside the listed groups fall back to read or
+// write by method. With the Postgres store the buckets are shared by all
+// replicas and survive deploys; the memory store is kept for development and
+// as a fallback while the database is unavailable, so a DB outage never
+// locks everyone out.
+const (
+	rateLimitGroupAuth   = "auth"
+	rateLimitGroupRead   = "read"
+	rateLimitGroupWrite  = "write"
+	rateLimitGroupAdmin  = "admin"
+	rateLimitGroupExport = "export"
+	rateLimitGroupSCIM   = "scim"
+
+	rateLimitStorePostgres = "postgres"
+	rateLimitStoreMemory   = "memory"
+
+	rateLimitCleanupInterval = 10 * time.Minute
+)
+
+// rateLimitPolicy allows bursts of up to Limit requests and refills the
+// bucket completely over Window.
+type rateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+func (p rateLimitPolicy) refillPerSecond() float64 {
+	return float64(p.Limit) / p.Window.Seconds()
+}
+
+type rateLimitDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
+
+// decide turns the token count left after a take into a decision.
+func (p rateLimitPolicy) decide(allowed bool, tokens float64) rateLimitDecision {
+	rate := p.refillPerSecond()
+	d := rateLimitDecision{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     time.Duration((float64(p.Limit) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		d.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return d
+}
+
+type rateLimitStore interface {
+	Name() string
+	take(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitDecision, error)
+}
+
+// --- In-memory store ---
+
+type memoryRateLimitBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryRateLimitBucket
+	now     func() time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*memoryRateLimitBucket), now: time.Now}
+}
+
+func (s *memoryRateLimitStore) Name() string { return rateLimitStoreMemory }
+
+func (s *memoryRateLimitStore) take(_ context.Context, key string, policy rateLimitPolicy) (rateLimitDecision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryRateLimitBucket{tokens: float64(policy.Limit), updated: now}
+		s.buckets[key] = bucket
+	}
+	elapsed := now.Sub(bucket.updated).Seconds()
+	bucket.tokens = math.Min(float64(policy.Limit), bucket.tokens+elapsed*policy.refillPerSecond())
+	bucket.updated = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return policy.decide(allowed, bucket.tokens), nil
+}
+
+// cleanup drops buckets idle for longer than maxIdle; they would be full.
+func (s *memoryRateLimitStore) cleanup(maxIdle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-maxIdle)
+	for key, bucket := range s.buckets {
+		if bucket.updated.Before(cutoff) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// --- Postgres store ---
+
+type postgresRateLimitStore struct {
+	db *sql.DB
+}
+
+func (s *postgresRateLimitStore) Name() string { return rateLimitStorePostgres }
+
+func ensureRateLimitStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+			key TEXT PRIMARY KEY,
+			tokens DOUBLE PRECISION NOT NULL,
+			allowed BOOLEAN NOT NULL DEFAULT TRUE,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		`CREATE INDEX IF NOT EXISTS rate_limit_buckets_updated_idx ON rate_limit_buckets (updated_at);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// take refills and decrements the bucket in one statement; the row lock taken
+// by the upsert serialises concurrent requests for the same key across
+// replicas. SET expressions see the old row, so the refill is repeated in
+// each of them. Database time is used so that replica clock skew does not
+// matter.
+func (s *postgresRateLimitStore) take(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitDecision, error) {
+	var (
+		tokens  float64
+		allowed bool
+	)
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		 VALUES ($1, $2::double precision - 1, TRUE, now())
+		 ON CONFLICT (key) DO UPDATE SET
+		     tokens = CASE WHEN LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at)) * $3::double precision) >= 1
+		                   THEN LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at)) * $3::double precision) - 1
+		                   ELSE LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at)) * $3::double precision) END,
+		     allowed = LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at)) * $3::double precision) >= 1,
+		     updated_at = now()
+		 RETURNING tokens, allowed`,
+		key, policy.Limit, policy.refillPerSecond(),
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return rateLimitDecision{}, err
+	}
+	return policy.decide(allowed, tokens), nil
+}
+
+// --- Limiter ---
+
+type rateLimiter struct {
+	store    rateLimitStore
+	fallback *memoryRateLimitStore
+	policies map[string]rateLimitPolicy
+}
+
+func newRateLimiter(store rateLimitStore, policies map[string]rateLimitPolicy) *rateLimiter {
+	return &rateLimiter{store: store, fallback: newMemoryRateLimitStore(), policies: policies}
+}
+
+// defaultRateLimitPolicies keeps the historical auth limit of 10 requests per
+// IP per minute and adds per-principal limits for the rest of the API.
+func defaultRateLimitPolicies() map[string]rateLimitPolicy {
+	return map[string]rateLimitPolicy{
+		rateLimitGroupAuth:   {Name: rateLimitGroupAuth, Limit: 10, Window: time.Minute},
+		rateLimitGroupRead:   {Name: rateLimitGroupRead, Limit: 600, Window: time.Minute},
+		rateLimitGroupWrite:  {Name: rateLimitGroupWrite, Limit: 120, Window: time.Minute},
+		rateLimitGroupAdmin:  {Name: rateLimitGroupAdmin, Limit: 300, Window: time.Minute},
+		rateLimitGroupExport: {Name: rateLimitGroupExport, Limit: 10, Window: time.Minute},
+		rateLimitGroupSCIM:   {Name: rateLimitGroupSCIM, Limit: 600, Window: time.Minute},
+	}
+}
+
+// rateLimitRoutes assigns route groups by path, most specific first. A
+// route matches its exact path and everything below it. Auth routes are
+// limited by their handlers through allowAuthAttempt, so the middleware
+// leaves them alone instead of charging them twice.
+var rateLimitRoutes = []struct {
+	Path  string
+	Group string
+}{
+	{"/api/v2/auth/code/wb-captcha", rateLimitGroupAuth},
+	{"/api/v2/auth/confirm", rateLimitGroupAuth},
+	{"/api/auth/office-token", rateLimitGroupAuth},
+	{oidcLoginPath, rateLimitGroupAuth},
+	{oidcCallbackPath, rateLimitGroupAuth},
+	{adminAuditLogsExportPath, rateLimitGroupExport},
+	{"/api/admin/db-dumps", rateLimitGroupExport},
+	{adminPersonalDataPath, rateLimitGroupExport},
+	{personalDataPath, rateLimitGroupExport},
+	{"/api/admin", rateLimitGroupAdmin},
+	{scimBasePath, rateLimitGroupSCIM},
+}
+
+// rateLimitGroupForRequest returns the route group of r, or "" when r is not
+// rate limited by the middleware.
+func rateLimitGroupForRequest(r *http.Request) string {
+	path := r.URL.Path
+	if r.Method == http.MethodOptions || path == "/api/health" {
+		return ""
+	}
+	for _, route := range rateLimitRoutes {
+		if path == route.Path || strings.HasPrefix(path, route.Path+"/") {
+			return route.Group
+		}
+	}
+	if !strings.HasPrefix(path, "/api/") {
+		return ""
+	}
+	if isMutatingMethod(r.Method) {
+		return rateLimitGroupWrite
+	}
+	return rateLimitGroupRead
+}
+
+// parseRateLimitPolicy parses "<limit>/<window>", e.g. "120/1m". "off"
+// disables the policy.
+func parseRateLimitPolicy(name, raw string) (rateLimitPolicy, bool, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.EqualFold(raw, "off") || raw == "0" {
+		return rateLimitPolicy{}, false, nil
+	}
+	limitRaw, windowRaw, ok := strings.Cut(raw, "/")
+	if !ok {
+		return rateLimitPolicy{}, false, fmt.Errorf("expected <limit>/<window>, got %q", raw)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitRaw))
+	if err != nil || limit <= 0 {
+		return rateLimitPolicy{}, false, fmt.Errorf("invalid limit %q", limitRaw)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowRaw))
+	if err != nil || window < time.Second {
+		return rateLimitPolicy{}, false, fmt.Errorf("invalid window %q", windowRaw)
+	}
+	return rateLimitPolicy{Name: name, Limit: limit, Window: window}, true, nil
+}
+
+func loadRateLimiterFromEnv(db *sql.DB) *rateLimiter {
+	policies := defaultRateLimitPolicies()
+	for group, envKey := range map[string]string{
+		rateLimitGroupAuth:   "OFFICE_RATE_LIMIT_AUTH",
+		rateLimitGroupRead:   "OFFICE_RATE_LIMIT_READ",
+		rateLimitGroupWrite:  "OFFICE_RATE_LIMIT_WRITE",
+		rateLimitGroupAdmin:  "OFFICE_RATE_LIMIT_ADMIN",
+		rateLimitGroupExport: "OFFICE_RATE_LIMIT_EXPORT",
+		rateLimitGroupSCIM:   "OFFICE_RATE_LIMIT_SCIM",
+	} {
+		raw := strings.TrimSpace(os.Getenv(envKey))
+		if raw == "" {
+			continue
+		}
+		policy, enabled, err := parseRateLimitPolicy(group, raw)
+		if err != nil {
+			log.Printf("WARNING: %s: %v, using default", envKey, err)
+			continue
+		}
+		if !enabled {
+			delete(policies, group)
+			continue
+		}
+		policies[group] = policy
+	}
+
+	var store rateLimitStore
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("OFFICE_RATE_LIMIT_STORE"))) {
+	case rateLimitStoreMemory:
+		store = newMemoryRateLimitStore()
+	case "", rateLimitStorePostgres:
+		store = &postgresRateLimitStore{db: db}
+	default:
+		log.Printf("WARNING: OFFICE_RATE_LIMIT_STORE=%q is invalid, using postgres", os.Getenv("OFFICE_RATE_LIMIT_STORE"))
+		store = &postgresRateLimitStore{db: db}
+	}
+	return newRateLimiter(store, policies)
+}
+
+// take charges one request against group for key. Unknown or disabled groups
+// always allow.
+func (l *rateLimiter) take(ctx context.Context, group, key string) (rateLimitDecision, bool) {
+	policy, ok := l.policies[group]
+	if !ok {
+		return rateLimitDecision{Allowed: true}, false
+	}
+	bucketKey := group + ":" + key
+	decision, err := l.store.take(ctx, bucketKey, policy)
+	if err != nil {
+		log.Printf("rate limit: %s store failed, using in-memory fallback: %v", l.store.Name(), err)
+		decision, _ = l.fallback.take(ctx, bucketKey, policy)
+	}
+	return decision, true
+}
+
+func (l *rateLimiter) maxWindow() time.Duration {
+	var longest time.Duration
+	for _, policy := range l.policies {
+		if policy.Window > longest {
+			longest = policy.Window
+		}
+	}
+	return longest
+}
+
+func writeRateLimitHeaders(w http.ResponseWriter, policy rateLimitPolicy, d rateLimitDecision) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, ceilSeconds(policy.Window)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(d.RetryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// allowRequest charges the request against group for key, writes the
+// RateLimit-* headers and responds 429 when the bucket is empty.
+func (a *app) allowRequest(w http.ResponseWriter, r *http.Request, group, key string) bool {
+	if a.rateLimiter == nil {
+		return true
+	}
+	decision, limited := a.rateLimiter.take(r.Context(), group, key)
+	if !limited {
+		return true
+	}
+	writeRateLimitHeaders(w, a.rateLimiter.policies[group], decision)
+	if !decision.Allowed {
+		respondError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+// allowAuthAttempt applies the auth policy, keyed by client IP, to login
+// endpoints.
+func (a *app) allowAuthAttempt(w http.ResponseWriter, r *http.Request) bool {
+	return a.allowRequest(w, r, rateLimitGroupAuth, "ip:"+clientIP(r))
+}
+
+// rateLimitKey identifies the caller: the authenticated employee or service
+// account when known, the client IP otherwise.
+func rateLimitKey(r *http.Request) string {
+	claims := authClaimsFromContext(r.Context())
+	if id := strings.TrimSpace(claims.EmployeeID); id != "" {
+		return "employee:" + id
+	}
+	if id := strings.TrimSpace(claims.WbUserID); id != "" {
+		return "user:" + id
+	}
+	return "ip:" + clientIP(r)
+}
+
+// rateLimitMiddleware applies the policy of the request's route group. It
+// runs inside authMiddleware so that authenticated callers are limited per
+// principal rather than per IP.
+func (a *app) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := rateLimitGroupForRequest(r)
+		if group == "" || group == rateLimitGroupAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !a.allowRequest(w, r, group, rateLimitKey(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runRateLimitCleanupLoop deletes idle buckets until ctx is cancelled. A
+// bucket idle for longer than the longest window is full and can be dropped.
+func (a *app) runRateLimitCleanupLoop(ctx context.Context) {
+	if a.rateLimiter == nil {
+		return
+	}
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		maxIdle := a.rateLimiter.maxWindow()
+		a.rateLimiter.fallback.cleanup(maxIdle)
+		switch store := a.rateLimiter.store.(type) {
+		case *memoryRateLimitStore:
+			store.cleanup(maxIdle)
+		case *postgresRateLimitStore:
+			if _, err := store.db.ExecContext(ctx,
+				`DELETE FROM rate_limit_buckets WHERE updated_at < now() - make_interval(secs => $1)`,
+				maxIdle.Seconds(),
+			); err != nil && ctx.Err() == nil {
+				log.Printf("rate limit: cleanup failed: %v", err)
+			}
+		}
+	}
+}
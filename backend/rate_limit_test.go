@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	policy := rateLimitPolicy{Name: "test", Limit: 3, Window: 30 * time.Second}
+
+	for i := 0; i < 3; i++ {
+		d, _ := store.take(context.Background(), "k", policy)
+		if !d.Allowed || d.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected decision %+v", i, d)
+		}
+	}
+	d, _ := store.take(context.Background(), "k", policy)
+	if d.Allowed || d.RetryAfter != 10*time.Second {
+		t.Fatalf("expected denial with a 10s retry, got %+v", d)
+	}
+	if other, _ := store.take(context.Background(), "other", policy); !other.Allowed {
+		t.Fatal("buckets must be independent per key")
+	}
+
+	now = now.Add(10 * time.Second)
+	if d, _ := store.take(context.Background(), "k", policy); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected one refilled token, got %+v", d)
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, enabled, err := parseRateLimitPolicy("write", "120/1m")
+	if err != nil || !enabled || policy.Limit != 120 || policy.Window != time.Minute {
+		t.Fatalf("unexpected policy %+v, %v, %v", policy, enabled, err)
+	}
+	if _, enabled, err := parseRateLimitPolicy("write", "off"); err != nil || enabled {
+		t.Fatalf("expected off to disable the policy, got %v, %v", enabled, err)
+	}
+	for _, bad := range []string{"120", "x/1m", "10/0s", "-1/1m"} {
+		if _, _, err := parseRateLimitPolicy("write", bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareHeaders(t *testing.T) {
+	t.Parallel()
+
+	a := &app{rateLimiter: newRateLimiter(newMemoryRateLimitStore(), map[string]rateLimitPolicy{
+		rateLimitGroupRead: {Name: rateLimitGroupRead, Limit: 1, Window: time.Minute},
+	})}
+	handler := a.rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/buildings", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve()
+	if first.Code != http.StatusOK || first.Header().Get("RateLimit-Limit") != "1" || first.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("unexpected first response %d %v", first.Code, first.Header())
+	}
+	second := serve()
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", second.Code, second.Header())
+	}
+	// Writes use their own policy, which is disabled here.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/buildings", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("expected unlimited write, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRateLimitGroupForRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodGet, "/api/buildings", rateLimitGroupRead},
+		{http.MethodPost, "/api/buildings", rateLimitGroupWrite},
+		{http.MethodGet, "/api/admin/logs", rateLimitGroupAdmin},
+		{http.MethodPost, "/api/admin/roles", rateLimitGroupAdmin},
+		{http.MethodGet, "/api/admin/logs/export", rateLimitGroupExport},
+		{http.MethodPost, "/api/admin/personal-data/erase", rateLimitGroupExport},
+		{http.MethodGet, "/api/personal-data", rateLimitGroupExport},
+		{http.MethodPost, "/api/auth/office-token", rateLimitGroupAuth},
+		{http.MethodGet, "/api/auth/oidc/callback", rateLimitGroupAuth},
+		{http.MethodPost, "/api/auth/refresh", rateLimitGroupWrite},
+		{http.MethodPatch, "/scim/v2/Users/1", rateLimitGroupSCIM},
+		{http.MethodGet, "/api/health", ""},
+		{http.MethodOptions, "/api/buildings", ""},
+		{http.MethodGet, "/buildings", ""},
+	}
+	for _, tt := range tests {
+		if got := rateLimitGroupForRequest(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("%s %s: group %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitMiddlewareSkipsAuthRoutes(t *testing.T) {
+	t.Parallel()
+
+	policy := rateLimitPolicy{Limit: 1, Window: time.Minute}
+	a := &app{rateLimiter: newRateLimiter(newMemoryRateLimitStore(), map[string]rateLimitPolicy{
+		rateLimitGroupRead:  policy,
+		rateLimitGroupWrite: policy,
+	})}
+	handler := a.rateLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/office-token", nil)
+		req.RemoteAddr = "10.0.0.2:1234"
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("auth request %d charged by the middleware: %d %v", i, rec.Code, rec.Header())
+		}
+	}
+}
@@ -0,0 +1,1186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resources are bookable things that are neither desks nor meeting rooms:
+// parking spots, lockers, loanable equipment and whatever kind comes next.
+// A kind is just a slug; how it is booked is configured per resource through
+// booking_mode, so adding a kind needs no new table or endpoint.
+
+const (
+	auditEntityResource        = "resource"
+	auditEntityResourceBooking = "resource_booking"
+)
+
+const (
+	resourceBookingModeDay  = "day"
+	resourceBookingModeTime = "time"
+)
+
+// maxResourceBookingSpan caps time-range bookings; equipment loans may span
+// several days, but not an unbounded period.
+const maxResourceBookingSpan = 31 * 24 * time.Hour
+
+// resourceKindDefaults lists the built-in kinds with their default booking
+// mode. Other kinds are accepted when booking_mode is given explicitly.
+var resourceKindDefaults = map[string]string{
+	"parking":   resourceBookingModeDay,
+	"locker":    resourceBookingModeDay,
+	"equipment": resourceBookingModeTime,
+}
+
+var resourceKindPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+var (
+	errResourceKindInvalid     = errors.New("kind must be a lowercase slug")
+	errResourceModeInvalid     = errors.New("booking_mode must be day or time")
+	errResourceCapacityInvalid = errors.New("capacity must be greater than 0")
+	errResourceUnavailable     = errors.New("Ресурс занят на выбранное время")
+	errResourceAlreadyBooked   = errors.New("Ресурс уже забронирован вами на это время")
+)
+
+type resource struct {
+	ID                    int64     `json:"id"`
+	FloorID               int64     `json:"floor_id"`
+	ZoneID                *int64    `json:"zone_id"`
+	Kind                  string    `json:"kind"`
+	Name                  string    `json:"name"`
+	BookingMode           string    `json:"booking_mode"`
+	Capacity              int       `json:"capacity"`
+	ResponsibleEmployeeID string    `json:"responsible_employee_id,omitempty"`
+	Points                []point   `json:"points"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+type resourceInput struct {
+	Name                  *string  `json:"name"`
+	Kind                  *string  `json:"kind"`
+	BookingMode           *string  `json:"booking_mode"`
+	Capacity              *int     `json:"capacity"`
+	ZoneID                *int64   `json:"zone_id"`
+	ClearZone             bool     `json:"clear_zone"`
+	ResponsibleEmployeeID *string  `json:"responsible_employee_id"`
+	Points                *[]point `json:"points"`
+}
+
+type resourceBooking struct {
+	ID                int64     `json:"id"`
+	ResourceID        int64     `json:"resource_id"`
+	ResourceName      string    `json:"resource_name,omitempty"`
+	ResourceKind      string    `json:"resource_kind,omitempty"`
+	ApplierEmployeeID string    `json:"applier_employee_id"`
+	UserName          string    `json:"user_name,omitempty"`
+	Date              string    `json:"date,omitempty"`
+	StartTime         string    `json:"start_time"`
+	EndTime           string    `json:"end_time"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+func ensureResourcesStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS resources (
+			id BIGSERIAL PRIMARY KEY,
+			floor_id BIGINT NOT NULL,
+			zone_id BIGINT REFERENCES zones(id) ON DELETE SET NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			booking_mode TEXT NOT NULL DEFAULT 'day',
+			capacity INTEGER NOT NULL DEFAULT 1,
+			responsible_employee_id TEXT NOT NULL DEFAULT '',
+			points_json JSONB NOT NULL DEFAULT '[]'::jsonb,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			deleted_at TIMESTAMPTZ,
+			trash_item_id BIGINT,
+			FOREIGN KEY(floor_id) REFERENCES floors(id) ON DELETE CASCADE,
+			CHECK (booking_mode IN ('day', 'time')),
+			CHECK (capacity > 0)
+		);`,
+		`CREATE INDEX IF NOT EXISTS resources_floor_id_idx ON resources (floor_id) WHERE deleted_at IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS resources_zone_id_idx ON resources (zone_id) WHERE zone_id IS NOT NULL;`,
+		`CREATE INDEX IF NOT EXISTS resources_kind_idx ON resources (kind);`,
+		`CREATE INDEX IF NOT EXISTS resources_trash_item_id_idx ON resources (trash_item_id) WHERE trash_item_id IS NOT NULL;`,
+		`CREATE TABLE IF NOT EXISTS resource_bookings (
+			id BIGSERIAL PRIMARY KEY,
+			resource_id BIGINT NOT NULL,
+			applier_employee_id TEXT NOT NULL,
+			start_at TIMESTAMPTZ NOT NULL,
+			end_at TIMESTAMPTZ NOT NULL,
+			cancelled_at TIMESTAMPTZ,
+			canceller_employee_id TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			FOREIGN KEY(resource_id) REFERENCES resources(id) ON DELETE CASCADE,
+			CHECK (end_at > start_at)
+		);`,
+		`CREATE INDEX IF NOT EXISTS resource_bookings_resource_time_idx
+		 ON resource_bookings (resource_id, start_at, end_at) WHERE cancelled_at IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS resource_bookings_applier_idx
+		 ON resource_bookings (applier_employee_id, start_at) WHERE cancelled_at IS NULL;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeResourceKind validates kind and resolves the booking mode: an
+// explicit mode wins, otherwise the default of a built-in kind is used.
+func normalizeResourceKind(kind, mode string) (string, string, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if !resourceKindPattern.MatchString(kind) {
+		return "", "", errResourceKindInvalid
+	}
+	if mode == "" {
+		mode = resourceKindDefaults[kind]
+	}
+	if mode != resourceBookingModeDay && mode != resourceBookingModeTime {
+		return "", "", errResourceModeInvalid
+	}
+	return kind, mode, nil
+}
+
+const resourceSelectColumns = `r.id,
+		        r.floor_id,
+		        r.zone_id,
+		        r.kind,
+		        r.name,
+		        r.booking_mode,
+		        r.capacity,
+		        COALESCE(r.responsible_employee_id, ''),
+		        COALESCE(r.points_json, '[]'),
+		        r.created_at`
+
+func scanResource(row interface{ Scan(...any) error }) (resource, error) {
+	var item resource
+	var zoneID sql.NullInt64
+	var pointsJSON string
+	if err := row.Scan(
+		&item.ID,
+		&item.FloorID,
+		&zoneID,
+		&item.Kind,
+		&item.Name,
+		&item.BookingMode,
+		&item.Capacity,
+		&item.ResponsibleEmployeeID,
+		&pointsJSON,
+		&item.CreatedAt,
+	); err != nil {
+		return resource{}, err
+	}
+	if zoneID.Valid {
+		item.ZoneID = &zoneID.Int64
+	}
+	item.Points = decodePoints(pointsJSON)
+	return item, nil
+}
+
+type resourceFilter struct {
+	BuildingID int64
+	FloorID    int64
+	ZoneID     int64
+	Kind       string
+}
+
+func (a *app) listResources(ctx context.Context, filter resourceFilter) ([]resource, error) {
+	whereParts := []string{"r.deleted_at IS NULL", "f.deleted_at IS NULL"}
+	var args []any
+	if filter.BuildingID > 0 {
+		args = append(args, filter.BuildingID)
+		whereParts = append(whereParts, fmt.Sprintf("f.building_id = $%d", len(args)))
+	}
+	if filter.FloorID > 0 {
+		args = append(args, filter.FloorID)
+		whereParts = append(whereParts, fmt.Sprintf("r.floor_id = $%d", len(args)))
+	}
+	if filter.ZoneID > 0 {
+		args = append(args, filter.ZoneID)
+		whereParts = append(whereParts, fmt.Sprintf("r.zone_id = $%d", len(args)))
+	}
+	if filter.Kind != "" {
+		args = append(args, filter.Kind)
+		whereParts = append(whereParts, fmt.Sprintf("r.kind = $%d", len(args)))
+	}
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT `+resourceSelectColumns+`
+		   FROM resources r
+		   JOIN floors f ON f.id = r.floor_id
+		  WHERE `+strings.Join(whereParts, " AND ")+`
+		  ORDER BY r.kind, r.name, r.id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]resource, 0)
+	for rows.Next() {
+		item, err := scanResource(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (a *app) getResource(id int64) (resource, error) {
+	item, err := scanResource(a.db.QueryRow(
+		`SELECT `+resourceSelectColumns+`
+		   FROM resources r
+		  WHERE r.id = $1 AND r.deleted_at IS NULL`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return resource{}, errNotFound
+		}
+		return resource{}, err
+	}
+	return item, nil
+}
+
+func (a *app) getResourceTimezone(ctx context.Context, resourceID int64) (string, error) {
+	var timezone string
+	err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(b.timezone, '')
+		   FROM resources r
+		   JOIN floors f ON f.id = r.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		  WHERE r.id = $1 AND r.deleted_at IS NULL`,
+		resourceID,
+	).Scan(&timezone)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errNotFound
+		}
+		return "", err
+	}
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" {
+		timezone = defaultBuildingTimezone
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		timezone = defaultBuildingTimezone
+	}
+	return timezone, nil
+}
+
+// ensureResourceZone checks that zoneID, when set, is a zone of floorID.
+func (a *app) ensureResourceZone(floorID int64, zoneID *int64) error {
+	if zoneID == nil {
+		return nil
+	}
+	zoneFloorID, err := a.getZoneFloorID(*zoneID)
+	if err != nil {
+		return err
+	}
+	if zoneFloorID != floorID {
+		return errZoneFloorMismatch
+	}
+	return nil
+}
+
+func (a *app) createResource(floorID int64, zoneID *int64, kind, name, mode string, capacity int, responsibleEmployeeID string, points []point) (resource, error) {
+	pointsJSON, err := encodePoints(points)
+	if err != nil {
+		return resource{}, err
+	}
+	var id int64
+	if err := a.db.QueryRow(
+		`INSERT INTO resources (floor_id, zone_id, kind, name, booking_mode, capacity, responsible_employee_id, points_json)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING id`,
+		floorID,
+		zoneID,
+		kind,
+		name,
+		mode,
+		capacity,
+		strings.TrimSpace(responsibleEmployeeID),
+		pointsJSON,
+	).Scan(&id); err != nil {
+		return resource{}, err
+	}
+	return a.getResource(id)
+}
+
+func (a *app) updateResource(id int64, input resourceInput) (resource, error) {
+	current, err := a.getResource(id)
+	if err != nil {
+		return resource{}, err
+	}
+	next := current
+	if input.Name != nil {
+		next.Name = strings.TrimSpace(*input.Name)
+		if next.Name == "" {
+			return resource{}, errNameRequired
+		}
+	}
+	if input.Kind != nil || input.BookingMode != nil {
+		kind := current.Kind
+		if input.Kind != nil {
+			kind = *input.Kind
+		}
+		mode := ""
+		if input.BookingMode != nil {
+			mode = *input.BookingMode
+		} else if input.Kind == nil {
+			mode = current.BookingMode
+		}
+		next.Kind, next.BookingMode, err = normalizeResourceKind(kind, mode)
+		if err != nil {
+			return resource{}, err
+		}
+	}
+	if input.Capacity != nil {
+		if *input.Capacity <= 0 {
+			return resource{}, errResourceCapacityInvalid
+		}
+		next.Capacity = *input.Capacity
+	}
+	if input.ClearZone {
+		next.ZoneID = nil
+	} else if input.ZoneID != nil {
+		if err := a.ensureResourceZone(current.FloorID, input.ZoneID); err != nil {
+			return resource{}, err
+		}
+		next.ZoneID = input.ZoneID
+	}
+	if input.ResponsibleEmployeeID != nil {
+		next.ResponsibleEmployeeID = strings.TrimSpace(*input.ResponsibleEmployeeID)
+	}
+	if input.Points != nil {
+		next.Points = *input.Points
+	}
+	pointsJSON, err := encodePoints(next.Points)
+	if err != nil {
+		return resource{}, err
+	}
+	result, err := a.db.Exec(
+		`UPDATE resources
+		    SET name = $1,
+		        kind = $2,
+		        booking_mode = $3,
+		        capacity = $4,
+		        zone_id = $5,
+		        responsible_employee_id = $6,
+		        points_json = $7
+		  WHERE id = $8 AND deleted_at IS NULL`,
+		next.Name,
+		next.Kind,
+		next.BookingMode,
+		next.Capacity,
+		next.ZoneID,
+		next.ResponsibleEmployeeID,
+		pointsJSON,
+		id,
+	)
+	if err != nil {
+		return resource{}, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return resource{}, err
+	}
+	if rows == 0 {
+		return resource{}, errNotFound
+	}
+	return a.getResource(id)
+}
+
+// deleteResource moves the resource to the trash; its bookings stay attached
+// and come back with a restore.
+func (a *app) deleteResource(id int64, actorEmployeeID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	var floorID, buildingID int64
+	if err := tx.QueryRow(
+		`SELECT r.name, r.floor_id, f.building_id
+		   FROM resources r
+		   JOIN floors f ON f.id = r.floor_id
+		  WHERE r.id = $1 AND r.deleted_at IS NULL
+		  FOR UPDATE OF r`,
+		id,
+	).Scan(&name, &floorID, &buildingID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	}
+	var activeBookings int64
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM resource_bookings WHERE resource_id = $1 AND cancelled_at IS NULL AND end_at > now()`,
+		id,
+	).Scan(&activeBookings); err != nil {
+		return err
+	}
+	trashID, err := a.createTrashItemInTx(tx, auditEntityResource, id, name, buildingID, floorID, actorEmployeeID, map[string]any{
+		"resource_bookings": activeBookings,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE resources SET deleted_at = now(), trash_item_id = $2 WHERE id = $1`,
+		id,
+		trashID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func purgeResourceInTx(ctx context.Context, tx *sql.Tx, resourceID int64) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM resource_bookings WHERE resource_id = $1`, resourceID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM resources WHERE id = $1`, resourceID)
+	return err
+}
+
+// bookResource books a resource for [startAt, endAt). The resource row is
+// locked so that concurrent bookings cannot exceed its capacity.
+func (a *app) bookResource(ctx context.Context, resourceID int64, employeeID string, startAt, endAt time.Time) (int64, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var capacity int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT capacity FROM resources WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		resourceID,
+	).Scan(&capacity); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errNotFound
+		}
+		return 0, err
+	}
+	var overlapping, own int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE applier_employee_id = $4)
+		   FROM resource_bookings
+		  WHERE resource_id = $1
+		    AND cancelled_at IS NULL
+		    AND start_at < $3 AND end_at > $2`,
+		resourceID,
+		startAt,
+		endAt,
+		employeeID,
+	).Scan(&overlapping, &own); err != nil {
+		return 0, err
+	}
+	if own > 0 {
+		return 0, errResourceAlreadyBooked
+	}
+	if overlapping >= capacity {
+		return 0, errResourceUnavailable
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO resource_bookings (resource_id, applier_employee_id, start_at, end_at)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id`,
+		resourceID,
+		employeeID,
+		startAt,
+		endAt,
+	).Scan(&id); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (a *app) listResourceBookingsForDay(ctx context.Context, resourceID int64, dayStart, dayEnd time.Time, location *time.Location) ([]resourceBooking, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT rb.id,
+		        rb.resource_id,
+		        rb.applier_employee_id,
+		        COALESCE(u.full_name, ''),
+		        rb.start_at,
+		        rb.end_at,
+		        rb.created_at
+		   FROM resource_bookings rb
+		   LEFT JOIN users u ON u.employee_id = rb.applier_employee_id
+		  WHERE rb.resource_id = $1
+		    AND rb.cancelled_at IS NULL
+		    AND rb.start_at < $3 AND rb.end_at > $2
+		  ORDER BY rb.start_at, rb.id`,
+		resourceID,
+		dayStart,
+		dayEnd,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]resourceBooking, 0)
+	for rows.Next() {
+		var item resourceBooking
+		var startAt, endAt time.Time
+		if err := rows.Scan(
+			&item.ID,
+			&item.ResourceID,
+			&item.ApplierEmployeeID,
+			&item.UserName,
+			&startAt,
+			&endAt,
+			&item.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		fillResourceBookingTimes(&item, startAt, endAt, location)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (a *app) listMyResourceBookings(ctx context.Context, employeeID string) ([]resourceBooking, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT rb.id,
+		        rb.resource_id,
+		        r.name,
+		        r.kind,
+		        rb.applier_employee_id,
+		        rb.start_at,
+		        rb.end_at,
+		        rb.created_at,
+		        COALESCE(b.timezone, '')
+		   FROM resource_bookings rb
+		   JOIN resources r ON r.id = rb.resource_id
+		   JOIN floors f ON f.id = r.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		  WHERE rb.applier_employee_id = $1
+		    AND rb.cancelled_at IS NULL
+		    AND rb.end_at > now()
+		    AND r.deleted_at IS NULL
+		    AND f.deleted_at IS NULL
+		  ORDER BY rb.start_at, rb.id`,
+		employeeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]resourceBooking, 0)
+	for rows.Next() {
+		var item resourceBooking
+		var startAt, endAt time.Time
+		var timezone string
+		if err := rows.Scan(
+			&item.ID,
+			&item.ResourceID,
+			&item.ResourceName,
+			&item.ResourceKind,
+			&item.ApplierEmployeeID,
+			&startAt,
+			&endAt,
+			&item.CreatedAt,
+			&timezone,
+		); err != nil {
+			return nil, err
+		}
+		location, err := time.LoadLocation(strings.TrimSpace(timezone))
+		if err != nil || strings.TrimSpace(timezone) == "" {
+			location, _ = time.LoadLocation(defaultBuildingTimezone)
+		}
+		fillResourceBookingTimes(&item, startAt, endAt, location)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// fillResourceBookingTimes renders a booking in the building's local time.
+// Whole-day bookings additionally get their date.
+func fillResourceBookingTimes(item *resourceBooking, startAt, endAt time.Time, location *time.Location) {
+	item.StartTime = formatDateTimeInLocation(startAt, location)
+	item.EndTime = formatDateTimeInLocation(endAt, location)
+	localStart := startAt
+	if location != nil {
+		localStart = startAt.In(location)
+	}
+	if localStart.Hour() == 0 && localStart.Minute() == 0 && endAt.Sub(startAt) == 24*time.Hour {
+		item.Date = localStart.Format("2006-01-02")
+	}
+}
+
+func (a *app) handleResources(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		filter := resourceFilter{Kind: strings.ToLower(strings.TrimSpace(query.Get("kind")))}
+		for name, dest := range map[string]*int64{
+			"building_id": &filter.BuildingID,
+			"floor_id":    &filter.FloorID,
+			"zone_id":     &filter.ZoneID,
+		} {
+			raw := strings.TrimSpace(query.Get(name))
+			if raw == "" {
+				continue
+			}
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed <= 0 {
+				respondError(w, http.StatusBadRequest, name+" must be a number")
+				return
+			}
+			*dest = parsed
+		}
+		items, err := a.listResources(r.Context(), filter)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"items": items})
+	case http.MethodPost:
+		a.handleCreateResource(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *app) handleCreateResource(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		FloorID               int64   `json:"floor_id"`
+		ZoneID                *int64  `json:"zone_id"`
+		Kind                  string  `json:"kind"`
+		Name                  string  `json:"name"`
+		BookingMode           string  `json:"booking_mode"`
+		Capacity              *int    `json:"capacity"`
+		ResponsibleEmployeeID string  `json:"responsible_employee_id"`
+		Points                []point `json:"points"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.FloorID == 0 || payload.Name == "" || strings.TrimSpace(payload.Kind) == "" {
+		respondError(w, http.StatusBadRequest, "floor_id, name, and kind are required")
+		return
+	}
+	kind, mode, err := normalizeResourceKind(payload.Kind, payload.BookingMode)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	capacity := 1
+	if payload.Capacity != nil {
+		capacity = *payload.Capacity
+	}
+	if capacity <= 0 {
+		respondError(w, http.StatusBadRequest, errResourceCapacityInvalid.Error())
+		return
+	}
+	if payload.ZoneID != nil && *payload.ZoneID <= 0 {
+		payload.ZoneID = nil
+	}
+	if !a.ensureCanManageFloor(w, r, payload.FloorID) {
+		return
+	}
+	if err := a.ensureResourceZone(payload.FloorID, payload.ZoneID); err != nil {
+		if errors.Is(err, errZoneFloorMismatch) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondZoneError(w, err)
+		return
+	}
+	result, err := a.createResource(payload.FloorID, payload.ZoneID, kind, payload.Name, mode, capacity, payload.ResponsibleEmployeeID, payload.Points)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if result.ResponsibleEmployeeID != "" {
+		a.auditResponsibilityChange(r.Context(), auditEntityResource, result.ID, "", result.ResponsibleEmployeeID, a.requestActorEmployeeID(r))
+	}
+	a.logAuditEventFromRequest(r, auditActionCreate, auditEntityResource, result.ID, result.Name, resourceAuditDetails(result))
+	respondJSON(w, http.StatusCreated, result)
+}
+
+func (a *app) handleResourceSubroutes(w http.ResponseWriter, r *http.Request) {
+	id, suffix, err := parseIDFromPath(r.URL.Path, "/api/resources/")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch suffix {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			item, err := a.getResource(id)
+			if err != nil {
+				respondResourceError(w, err)
+				return
+			}
+			respondJSON(w, http.StatusOK, item)
+		case http.MethodPut:
+			var payload resourceInput
+			if err := decodeJSON(r, &payload); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if payload.ZoneID != nil && *payload.ZoneID <= 0 {
+				payload.ZoneID = nil
+				payload.ClearZone = true
+			}
+			if !a.ensureCanManageResource(w, r, id) {
+				return
+			}
+			existing, err := a.getResource(id)
+			if err != nil {
+				respondResourceError(w, err)
+				return
+			}
+			// Reassigning the responsible or the zone changes who manages the
+			// resource, which is up to the floor.
+			if payload.ResponsibleEmployeeID != nil || payload.ZoneID != nil || payload.ClearZone {
+				if !a.ensureCanManageFloor(w, r, existing.FloorID) {
+					return
+				}
+			}
+			updated, err := a.updateResource(id, payload)
+			if err != nil {
+				switch {
+				case errors.Is(err, errNameRequired),
+					errors.Is(err, errResourceKindInvalid),
+					errors.Is(err, errResourceModeInvalid),
+					errors.Is(err, errResourceCapacityInvalid),
+					errors.Is(err, errZoneFloorMismatch):
+					respondError(w, http.StatusBadRequest, err.Error())
+				default:
+					respondResourceError(w, err)
+				}
+				return
+			}
+			if existing.ResponsibleEmployeeID != updated.ResponsibleEmployeeID {
+				a.auditResponsibilityChange(r.Context(), auditEntityResource, id, existing.ResponsibleEmployeeID, updated.ResponsibleEmployeeID, a.requestActorEmployeeID(r))
+			}
+			details := resourceAuditDetails(updated)
+			details["changes"] = describeResourceAuditChanges(existing, updated)
+			details["before_name"] = existing.Name
+			details["after_name"] = updated.Name
+			details["before_capacity"] = existing.Capacity
+			details["after_capacity"] = updated.Capacity
+			details["before_booking_mode"] = existing.BookingMode
+			details["after_booking_mode"] = updated.BookingMode
+			a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityResource, updated.ID, updated.Name, details)
+			respondJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if !a.ensureCanManageResource(w, r, id) {
+				return
+			}
+			existing, err := a.getResource(id)
+			if err != nil {
+				respondResourceError(w, err)
+				return
+			}
+			if err := a.deleteResource(id, a.requestActorEmployeeID(r)); err != nil {
+				respondResourceError(w, err)
+				return
+			}
+			a.logAuditEventFromRequest(r, auditActionDelete, auditEntityResource, existing.ID, existing.Name, resourceAuditDetails(existing))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "/bookings":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		timezone, err := a.getResourceTimezone(r.Context(), id)
+		if err != nil {
+			respondResourceError(w, err)
+			return
+		}
+		location, _ := time.LoadLocation(timezone)
+		date := time.Now().In(location).Format("2006-01-02")
+		if dateRaw := strings.TrimSpace(r.URL.Query().Get("date")); dateRaw != "" {
+			normalized, err := normalizeBookingDate(dateRaw)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			date = normalized
+		}
+		dayStart, dayEnd, err := getBookingDayBounds(date, timezone)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		items, err := a.listResourceBookingsForDay(r.Context(), id, dayStart, dayEnd, location)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"items": items})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleResourceBookings is the single booking endpoint for every resource
+// kind: day resources take "date", time resources take "start_time" and
+// "end_time" in the building's local time.
+func (a *app) handleResourceBookings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	employeeID = strings.TrimSpace(employeeID)
+	if employeeID == "" {
+		respondError(w, http.StatusBadRequest, "employee_id is required")
+		return
+	}
+	var payload struct {
+		ResourceID int64  `json:"resource_id"`
+		Date       string `json:"date"`
+		StartTime  string `json:"start_time"`
+		EndTime    string `json:"end_time"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if payload.ResourceID == 0 {
+		respondError(w, http.StatusBadRequest, "resource_id is required")
+		return
+	}
+	item, err := a.getResource(payload.ResourceID)
+	if err != nil {
+		respondResourceError(w, err)
+		return
+	}
+	timezone, err := a.getResourceTimezone(r.Context(), item.ID)
+	if err != nil {
+		respondResourceError(w, err)
+		return
+	}
+	var startAt, endAt time.Time
+	switch item.BookingMode {
+	case resourceBookingModeDay:
+		date, err := normalizeBookingDate(payload.Date)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		startAt, endAt, err = getBookingDayBounds(date, timezone)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		startAt, err = parseLocalBookingDateTime(payload.StartTime, timezone)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		endAt, err = parseLocalBookingDateTime(payload.EndTime, timezone)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !endAt.After(startAt) {
+			respondError(w, http.StatusBadRequest, "end_time must be later than start_time")
+			return
+		}
+		if endAt.Sub(startAt) > maxResourceBookingSpan {
+			respondError(w, http.StatusBadRequest, "booking is too long")
+			return
+		}
+	}
+	if err := ensureMeetingRoomTimeNotPast(endAt, timezone); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bookingID, err := a.bookResource(r.Context(), item.ID, employeeID, startAt, endAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, errResourceUnavailable), errors.Is(err, errResourceAlreadyBooked):
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondResourceError(w, err)
+		}
+		return
+	}
+	location, _ := time.LoadLocation(timezone)
+	booking := resourceBooking{
+		ID:                bookingID,
+		ResourceID:        item.ID,
+		ResourceName:      item.Name,
+		ResourceKind:      item.Kind,
+		ApplierEmployeeID: employeeID,
+		CreatedAt:         time.Now().UTC(),
+	}
+	fillResourceBookingTimes(&booking, startAt, endAt, location)
+	details := resourceAuditDetails(item)
+	details["booking_id"] = bookingID
+	details["start_time"] = booking.StartTime
+	details["end_time"] = booking.EndTime
+	details["booked_by_employee_id"] = employeeID
+	details["changes"] = []string{fmt.Sprintf("Бронирование: %s", describeResourceBookingPeriod(booking))}
+	a.logAuditEventFromRequest(r, auditActionBook, auditEntityResourceBooking, item.ID, item.Name, details)
+	respondJSON(w, http.StatusCreated, booking)
+}
+
+func (a *app) handleResourceBookingSubroutes(w http.ResponseWriter, r *http.Request) {
+	suffix := strings.TrimPrefix(r.URL.Path, "/api/resource-bookings/")
+	if suffix == "me" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		employeeID = strings.TrimSpace(employeeID)
+		if employeeID == "" {
+			respondError(w, http.StatusBadRequest, "employee_id is required")
+			return
+		}
+		items, err := a.listMyResourceBookings(r.Context(), employeeID)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"items": items})
+		return
+	}
+	bookingID, rest, err := parseIDFromPath(r.URL.Path, "/api/resource-bookings/")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rest != "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	a.handleCancelResourceBooking(w, r, bookingID)
+}
+
+// handleCancelResourceBooking cancels a booking. Employees can cancel their
+// own bookings; anyone who can manage the resource can cancel any of them.
+func (a *app) handleCancelResourceBooking(w http.ResponseWriter, r *http.Request, bookingID int64) {
+	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	employeeID = strings.TrimSpace(employeeID)
+	var (
+		resourceID int64
+		applierID  string
+		startAt    time.Time
+		endAt      time.Time
+	)
+	err = a.db.QueryRowContext(r.Context(),
+		`SELECT resource_id, applier_employee_id, start_at, end_at
+		   FROM resource_bookings
+		  WHERE id = $1 AND cancelled_at IS NULL`,
+		bookingID,
+	).Scan(&resourceID, &applierID, &startAt, &endAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "booking not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if employeeID == "" || strings.TrimSpace(applierID) != employeeID {
+		if !a.ensureCanManageResourceBookings(w, r, resourceID) {
+			return
+		}
+	}
+	if _, err := a.db.ExecContext(r.Context(),
+		`UPDATE resource_bookings
+		    SET cancelled_at = now(), canceller_employee_id = $2
+		  WHERE id = $1 AND cancelled_at IS NULL`,
+		bookingID,
+		employeeID,
+	); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	item, err := a.getResource(resourceID)
+	if err == nil {
+		timezone, tzErr := a.getResourceTimezone(r.Context(), resourceID)
+		if tzErr != nil {
+			timezone = defaultBuildingTimezone
+		}
+		location, _ := time.LoadLocation(timezone)
+		booking := resourceBooking{ID: bookingID, ResourceID: resourceID, ApplierEmployeeID: applierID}
+		fillResourceBookingTimes(&booking, startAt, endAt, location)
+		details := resourceAuditDetails(item)
+		details["booking_id"] = bookingID
+		details["start_time"] = booking.StartTime
+		details["end_time"] = booking.EndTime
+		details["applier_employee_id"] = applierID
+		details["changes"] = []string{fmt.Sprintf("Отмена бронирования: %s", describeResourceBookingPeriod(booking))}
+		a.logAuditEventFromRequest(r, auditActionCancel, auditEntityResourceBooking, item.ID, item.Name, details)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ensureCanManageResource allows the building, floor, zone and resource
+// responsibles and roles with manage_layout.
+func (a *app) ensureCanManageResource(w http.ResponseWriter, r *http.Request, resourceID int64) bool {
+	return a.ensureResourcePermission(w, r, resourceID, permissionManageLayout)
+}
+
+// ensureCanManageResourceBookings guards cancelling other employees'
+// bookings of a resource.
+func (a *app) ensureCanManageResourceBookings(w http.ResponseWriter, r *http.Request, resourceID int64) bool {
+	return a.ensureResourcePermission(w, r, resourceID, permissionManageBookings)
+}
+
+func (a *app) ensureResourcePermission(w http.ResponseWriter, r *http.Request, resourceID int64, required permission) bool {
+	role, err := resolveRoleFromRequest(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return false
+	}
+	if hasRequestPermission(r, role, required) {
+		return true
+	}
+	employeeID, err := extractEmployeeIDFromRequest(r, a.db)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	eid := strings.TrimSpace(employeeID)
+	if eid == "" {
+		respondError(w, http.StatusForbidden, "Недостаточно прав")
+		return false
+	}
+	var buildingID int64
+	var buildingResp, floorResp, zoneResp, resourceResp, delegatedResp string
+	err = a.db.QueryRowContext(r.Context(),
+		`SELECT b.id,
+		        COALESCE(TRIM(b.responsible_employee_id), ''),
+		        COALESCE(TRIM(f.responsible_employee_id), ''),
+		        COALESCE(TRIM(z.responsible_employee_id), ''),
+		        COALESCE(TRIM(res.responsible_employee_id), ''),
+		        `+delegatedResponsibleSQL("$2", "b", "f")+`
+		   FROM resources res
+		   JOIN floors f ON f.id = res.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		   LEFT JOIN zones z ON z.id = res.zone_id
+		  WHERE res.id = $1 AND res.deleted_at IS NULL`,
+		resourceID, eid,
+	).Scan(&buildingID, &buildingResp, &floorResp, &zoneResp, &resourceResp, &delegatedResp)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "resource not found")
+		} else {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return false
+	}
+	if canRequestActInBuilding(r, role, required, eid, buildingID, buildingResp, floorResp, zoneResp, resourceResp, delegatedResp) {
+		return true
+	}
+	respondError(w, http.StatusForbidden, "Недостаточно прав")
+	return false
+}
+
+func respondResourceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		respondError(w, http.StatusNotFound, "resource not found")
+		return
+	}
+	log.Printf("internal error: %v", err)
+	respondError(w, http.StatusInternalServerError, "internal error")
+}
+
+func resourceAuditDetails(item resource) map[string]any {
+	return map[string]any{
+		"resource_id":          item.ID,
+		"resource_name":        item.Name,
+		"resource_kind":        item.Kind,
+		"booking_mode":         item.BookingMode,
+		"capacity":             item.Capacity,
+		"floor_id":             item.FloorID,
+		"zone_id":              formatOptionalID(item.ZoneID),
+		"responsible_employee": item.ResponsibleEmployeeID,
+	}
+}
+
+func describeResourceAuditChanges(before, after resource) []string {
+	changes := make([]string, 0)
+	if before.Name != after.Name {
+		changes = append(changes, fmt.Sprintf("Название: %q -> %q", before.Name, after.Name))
+	}
+	if before.Kind != after.Kind {
+		changes = append(changes, fmt.Sprintf("Тип: %q -> %q", before.Kind, after.Kind))
+	}
+	if before.BookingMode != after.BookingMode {
+		changes = append(changes, fmt.Sprintf("Режим бронирования: %q -> %q", before.BookingMode, after.BookingMode))
+	}
+	if before.Capacity != after.Capacity {
+		changes = append(changes, fmt.Sprintf("Вместимость: %d -> %d", before.Capacity, after.Capacity))
+	}
+	if formatOptionalID(before.ZoneID) != formatOptionalID(after.ZoneID) {
+		changes = append(changes, fmt.Sprintf("Зона: %s -> %s", formatOptionalID(before.ZoneID), formatOptionalID(after.ZoneID)))
+	}
+	if before.ResponsibleEmployeeID != after.ResponsibleEmployeeID {
+		changes = append(changes, fmt.Sprintf("Ответственный: %q -> %q", before.ResponsibleEmployeeID, after.ResponsibleEmployeeID))
+	}
+	if formatPointsForAudit(before.Points) != formatPointsForAudit(after.Points) {
+		changes = append(changes, "Полигон: изменен")
+	}
+	return changes
+}
+
+func describeResourceBookingPeriod(booking resourceBooking) string {
+	if booking.Date != "" {
+		return formatAuditDeskDate(booking.Date)
+	}
+	return formatAuditMeetingSlot(booking.StartTime, booking.EndTime)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeResourceKind(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		kind     string
+		mode     string
+		wantKind string
+		wantMode string
+		wantErr  error
+	}{
+		{name: "parking defaults to day", kind: "parking", wantKind: "parking", wantMode: resourceBookingModeDay},
+		{name: "equipment defaults to time", kind: " Equipment ", wantKind: "equipment", wantMode: resourceBookingModeTime},
+		{name: "explicit mode wins", kind: "locker", mode: "time", wantKind: "locker", wantMode: resourceBookingModeTime},
+		{name: "custom kind with mode", kind: "bike_rack", mode: "day", wantKind: "bike_rack", wantMode: resourceBookingModeDay},
+		{name: "custom kind without mode", kind: "bike_rack", wantErr: errResourceModeInvalid},
+		{name: "unknown mode", kind: "parking", mode: "hour", wantErr: errResourceModeInvalid},
+		{name: "invalid slug", kind: "parking spot", wantErr: errResourceKindInvalid},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			kind, mode, err := normalizeResourceKind(tt.kind, tt.mode)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("normalizeResourceKind(%q, %q) error = %v, want %v", tt.kind, tt.mode, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeResourceKind(%q, %q) unexpected error: %v", tt.kind, tt.mode, err)
+			}
+			if kind != tt.wantKind || mode != tt.wantMode {
+				t.Fatalf("normalizeResourceKind(%q, %q) = %q, %q, want %q, %q", tt.kind, tt.mode, kind, mode, tt.wantKind, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestFillResourceBookingTimesDetectsWholeDay(t *testing.T) {
+	t.Parallel()
+
+	location, err := time.LoadLocation(defaultBuildingTimezone)
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, location)
+
+	var day resourceBooking
+	fillResourceBookingTimes(&day, start, start.Add(24*time.Hour), location)
+	if day.Date != "2026-03-02" {
+		t.Fatalf("expected whole-day booking to get a date, got %q", day.Date)
+	}
+
+	var slot resourceBooking
+	fillResourceBookingTimes(&slot, start.Add(9*time.Hour), start.Add(11*time.Hour), location)
+	if slot.Date != "" {
+		t.Fatalf("expected time-range booking without date, got %q", slot.Date)
+	}
+	if slot.StartTime != "2026-03-02 09:00" || slot.EndTime != "2026-03-02 11:00" {
+		t.Fatalf("unexpected slot times %q - %q", slot.StartTime, slot.EndTime)
+	}
+}
@@ -2,38 +2,259 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
+	"time"
 )
 
+// Responsibility changes used to be recorded only in responsibility_audit_log.
+// They are now regular audit events with action change_responsible. The old
+// table is still written in the same transaction, with audit_event_id
+// pointing at the event, so readers of it keep working while they move to
+// the unified log; rows written before that are copied into the log once.
+const auditActionChangeResponsible = "change_responsible"
+
+const responsibilityMigrationBatch = 1000
+
+// responsibilityEntityNameSQL resolves the current name of a responsible
+// entity; %[1]s is the entity type and %[2]s the entity ID expression.
+const responsibilityEntityNameSQL = `COALESCE(CASE %[1]s
+		WHEN 'building' THEN (SELECT name FROM office_buildings WHERE id = %[2]s)
+		WHEN 'floor' THEN (SELECT name FROM floors WHERE id = %[2]s)
+		WHEN 'coworking' THEN (SELECT name FROM coworkings WHERE id = %[2]s)
+		WHEN 'zone' THEN (SELECT name FROM zones WHERE id = %[2]s)
+		WHEN 'resource' THEN (SELECT name FROM resources WHERE id = %[2]s)
+	END, '')`
+
+// responsibilityEmployeeNameSQL resolves an employee name; %s is the
+// employee ID expression.
+const responsibilityEmployeeNameSQL = `COALESCE((SELECT full_name FROM users WHERE employee_id = %s AND employee_id <> '' LIMIT 1), '')`
+
+type responsibilityChange struct {
+	EntityType           string
+	EntityID             int64
+	EntityName           string
+	PreviousEmployeeID   string
+	PreviousEmployeeName string
+	NewEmployeeID        string
+	NewEmployeeName      string
+	ActorEmployeeID      string
+	ActorName            string
+}
+
+func (c responsibilityChange) auditEvent(details map[string]any) auditChainEvent {
+	merged := map[string]any{
+		"previous_employee_id":   c.PreviousEmployeeID,
+		"previous_employee_name": c.PreviousEmployeeName,
+		"new_employee_id":        c.NewEmployeeID,
+		"new_employee_name":      c.NewEmployeeName,
+	}
+	for key, value := range details {
+		merged[key] = value
+	}
+	detailsJSON, err := json.Marshal(merged)
+	if err != nil {
+		detailsJSON = []byte("{}")
+	}
+	return auditChainEvent{
+		ActionType:      auditActionChangeResponsible,
+		EntityType:      c.EntityType,
+		EntityID:        c.EntityID,
+		EntityName:      c.EntityName,
+		ActorEmployeeID: c.ActorEmployeeID,
+		ActorName:       c.ActorName,
+		DetailsJSON:     detailsJSON,
+	}
+}
+
+// migratedResponsibilityDetails marks an event copied from
+// responsibility_audit_log. The event is stamped with the time of the copy,
+// so the chain stays in time order and retention can archive it; the time
+// of the change itself is kept in original_created_at.
+func migratedResponsibilityDetails(createdAt time.Time) map[string]any {
+	return map[string]any{
+		"migrated_from":       "responsibility_audit_log",
+		"original_created_at": createdAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
 func (a *app) auditResponsibilityChange(ctx context.Context, entityType string, entityID int64, previousEmployeeID, newEmployeeID, changedByEmployeeID string) {
 	if a == nil || a.db == nil {
 		return
 	}
-	previous := strings.TrimSpace(previousEmployeeID)
-	current := strings.TrimSpace(newEmployeeID)
-	actor := strings.TrimSpace(changedByEmployeeID)
-	if previous == current {
+	change := responsibilityChange{
+		EntityType:         strings.TrimSpace(entityType),
+		EntityID:           entityID,
+		PreviousEmployeeID: strings.TrimSpace(previousEmployeeID),
+		NewEmployeeID:      strings.TrimSpace(newEmployeeID),
+		ActorEmployeeID:    strings.TrimSpace(changedByEmployeeID),
+	}
+	if change.PreviousEmployeeID == change.NewEmployeeID {
+		return
+	}
+	if change.EntityType == "" || change.EntityID <= 0 {
 		return
 	}
-	if entityType = strings.TrimSpace(entityType); entityType == "" || entityID <= 0 {
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT `+fmt.Sprintf(responsibilityEntityNameSQL, "$1::text", "$2")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "$3")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "$4")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "$5"),
+		change.EntityType, change.EntityID, change.PreviousEmployeeID, change.NewEmployeeID, change.ActorEmployeeID,
+	).Scan(&change.EntityName, &change.PreviousEmployeeName, &change.NewEmployeeName, &change.ActorName); err != nil {
+		log.Printf("responsibility audit: failed to resolve names: entity=%s id=%d: %v", change.EntityType, change.EntityID, err)
+	}
+	details := a.enrichAuditLogDetails(ctx, change.EntityType, change.EntityID, nil)
+	if err := a.storeResponsibilityChange(ctx, change, details); err != nil {
+		log.Printf("responsibility audit: failed to store record: entity=%s id=%d: %v", change.EntityType, change.EntityID, err)
 		return
 	}
-	if _, err := a.db.ExecContext(
-		ctx,
+	a.auditSinks.notify()
+}
+
+// storeResponsibilityChange appends the audit event and the legacy row in
+// one transaction.
+func (a *app) storeResponsibilityChange(ctx context.Context, change responsibilityChange, details map[string]any) error {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return err
+	}
+	eventID, err := appendAuditEventTx(ctx, tx, change.auditEvent(details), a.auditSinks.names())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
 		`INSERT INTO responsibility_audit_log (
 			entity_type,
 			entity_id,
 			previous_employee_id,
 			new_employee_id,
-			changed_by_employee_id
-		) VALUES ($1, $2, $3, $4, $5)`,
-		entityType,
-		entityID,
-		previous,
-		current,
-		actor,
+			changed_by_employee_id,
+			audit_event_id
+		) VALUES ($1, $2, $3, $4, $5, $6)`,
+		change.EntityType,
+		change.EntityID,
+		change.PreviousEmployeeID,
+		change.NewEmployeeID,
+		change.ActorEmployeeID,
+		eventID,
 	); err != nil {
-		log.Printf("responsibility audit: failed to store record: entity=%s id=%d: %v", entityType, entityID, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureResponsibilityAuditMigration copies responsibility_audit_log rows
+// that have no audit event yet into audit_log_events, in the order of their
+// original time (see migratedResponsibilityDetails). Names are resolved as
+// of the migration.
+func ensureResponsibilityAuditMigration(db *sql.DB) error {
+	if err := ensureColumn(db, "responsibility_audit_log", "audit_event_id", "BIGINT"); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	total := 0
+	for {
+		migrated, err := migrateResponsibilityAuditBatch(ctx, db)
+		if err != nil {
+			return err
+		}
+		total += migrated
+		if migrated < responsibilityMigrationBatch {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("responsibility audit: copied %d record(s) into the audit log", total)
+	}
+	return nil
+}
+
+func migrateResponsibilityAuditBatch(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockID); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT r.id,
+		        r.entity_type,
+		        r.entity_id,
+		        r.previous_employee_id,
+		        r.new_employee_id,
+		        r.changed_by_employee_id,
+		        r.created_at,
+		        `+fmt.Sprintf(responsibilityEntityNameSQL, "r.entity_type", "r.entity_id")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "r.previous_employee_id")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "r.new_employee_id")+`,
+		        `+fmt.Sprintf(responsibilityEmployeeNameSQL, "r.changed_by_employee_id")+`
+		   FROM responsibility_audit_log r
+		  WHERE r.audit_event_id IS NULL
+		  ORDER BY r.created_at, r.id
+		  LIMIT $1`,
+		responsibilityMigrationBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		legacyIDs []int64
+		changes   []responsibilityChange
+		times     []time.Time
+	)
+	for rows.Next() {
+		var (
+			legacyID  int64
+			change    responsibilityChange
+			createdAt time.Time
+		)
+		if err := rows.Scan(
+			&legacyID,
+			&change.EntityType,
+			&change.EntityID,
+			&change.PreviousEmployeeID,
+			&change.NewEmployeeID,
+			&change.ActorEmployeeID,
+			&createdAt,
+			&change.EntityName,
+			&change.PreviousEmployeeName,
+			&change.NewEmployeeName,
+			&change.ActorName,
+		); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		legacyIDs = append(legacyIDs, legacyID)
+		changes = append(changes, change)
+		times = append(times, createdAt)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for i, change := range changes {
+		eventID, err := appendAuditEventTx(ctx, tx, change.auditEvent(migratedResponsibilityDetails(times[i])), nil)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE responsibility_audit_log SET audit_event_id = $2 WHERE id = $1`, legacyIDs[i], eventID,
+		); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
+	return len(changes), nil
 }
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role definitions and their permission matrix live in the roles and
+// role_permissions tables. A grant applies to every building (building_id 0)
+// or, for permissions in buildingScopedPermissions, to a single building.
+// The matrix is cached in roleDefinitions and reloaded after every change and
+// periodically, so that other instances pick up edits.
+const (
+	adminRolesPath          = "/api/admin/roles"
+	auditEntityRole         = "role"
+	roleDefinitionsInterval = 30 * time.Second
+)
+
+var permissionDescriptions = map[permission]string{
+	permissionManageRoleAssignments:   "Назначение ролей пользователям",
+	permissionViewAnyResponsibilities: "Просмотр ответственности любого сотрудника",
+	permissionManageRoles:             "Управление ролями и матрицей прав",
+	permissionManageBuildings:         "Создание и удаление зданий, назначение ответственных за здания",
+	permissionManageLayout:            "Изменение этажей, зон, пространств, столов и ресурсов",
+	permissionManageBookings:          "Бронирование за других и отмена чужих бронирований",
+	permissionViewUsers:               "Просмотр списка всех сотрудников",
+	permissionViewAuditLogs:           "Просмотр журнала аудита",
+	permissionManageTrash:             "Корзина: восстановление и окончательное удаление",
+	permissionManageSessions:          "Просмотр и отзыв сессий сотрудников",
+	permissionManageServiceAccounts:   "Управление сервисными аккаунтами и API-ключами",
+	permissionManageBackups:           "Экспорт и импорт дампа базы данных",
+	permissionOffboardUsers:           "Увольнение сотрудников: деактивация, отмена броней, передача ответственности",
+	permissionImpersonateUsers:        "Вход от имени сотрудника для поддержки",
+	permissionManagePersonalData:      "Выгрузка и удаление персональных данных сотрудников",
+	permissionManageAuditSinks:        "Повторная отправка событий аудита в SIEM",
+}
+
+// buildingScopedPermissions may be granted for a single building.
+var buildingScopedPermissions = map[permission]bool{
+	permissionManageLayout:   true,
+	permissionManageBookings: true,
+}
+
+var (
+	errRoleNotFound = errors.New("role not found")
+	errRoleBuiltin  = errors.New("built-in role cannot be changed this way")
+	errRoleInUse    = errors.New("role is assigned to users or service accounts")
+	roleKeyPattern  = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+)
+
+type rolePermissionGrant struct {
+	Permission permission `json:"permission"`
+	BuildingID int64      `json:"building_id,omitempty"`
+}
+
+type roleDefinition struct {
+	ID          int                   `json:"id"`
+	Key         string                `json:"key"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Builtin     bool                  `json:"builtin"`
+	Permissions []rolePermissionGrant `json:"permissions"`
+}
+
+// builtinRoleDefinitions are seeded into an empty database and serve as the
+// matrix until the stored one is loaded. Their grants stay editable; the
+// admin role always holds every permission.
+func builtinRoleDefinitions() []roleDefinition {
+	grants := func(perms ...permission) []rolePermissionGrant {
+		out := make([]rolePermissionGrant, 0, len(perms))
+		for _, p := range perms {
+			out = append(out, rolePermissionGrant{Permission: p})
+		}
+		return out
+	}
+	return []roleDefinition{
+		{ID: roleEmployee, Key: "employee", Name: "Сотрудник", Builtin: true, Permissions: grants()},
+		{ID: roleAdmin, Key: "admin", Name: "Администратор", Description: "Все права", Builtin: true, Permissions: grants()},
+		{ID: roleReceptionist, Key: "receptionist", Name: "Ресепшн", Builtin: true,
+			Description: "Бронирует за сотрудников и отменяет их брони",
+			Permissions: grants(permissionManageBookings, permissionViewUsers)},
+		{ID: roleFacilityManager, Key: "facility_manager", Name: "Менеджер офиса", Builtin: true,
+			Description: "Ведёт планировку и бронирования",
+			Permissions: grants(permissionManageLayout, permissionManageBookings, permissionViewUsers, permissionViewAnyResponsibilities)},
+		{ID: roleAuditor, Key: "auditor", Name: "Аудитор", Builtin: true,
+			Description: "Только чтение журнала аудита",
+			Permissions: grants(permissionViewAuditLogs)},
+		{ID: roleBookingCoordinator, Key: "booking_coordinator", Name: "Координатор бронирований", Builtin: true,
+			Description: "Управляет бронированиями сотрудников",
+			Permissions: grants(permissionManageBookings, permissionViewUsers, permissionViewAnyResponsibilities)},
+	}
+}
+
+type roleRegistry struct {
+	mu    sync.RWMutex
+	roles map[int]roleDefinition
+}
+
+var roleDefinitions = newRoleRegistry(builtinRoleDefinitions())
+
+func newRoleRegistry(defs []roleDefinition) *roleRegistry {
+	reg := &roleRegistry{}
+	reg.replace(defs)
+	return reg
+}
+
+func (reg *roleRegistry) replace(defs []roleDefinition) {
+	roles := make(map[int]roleDefinition, len(defs))
+	for _, def := range defs {
+		roles[def.ID] = def
+	}
+	reg.mu.Lock()
+	reg.roles = roles
+	reg.mu.Unlock()
+}
+
+func (reg *roleRegistry) lookup(role int) (roleDefinition, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	def, ok := reg.roles[role]
+	return def, ok
+}
+
+func (reg *roleRegistry) list() []roleDefinition {
+	reg.mu.RLock()
+	defs := make([]roleDefinition, 0, len(reg.roles))
+	for _, def := range reg.roles {
+		defs = append(defs, def)
+	}
+	reg.mu.RUnlock()
+	sort.Slice(defs, func(i, j int) bool { return defs[i].ID < defs[j].ID })
+	return defs
+}
+
+// allows reports whether role holds required. buildingID 0 asks for a
+// global grant; any other value also accepts a grant scoped to that building.
+func (reg *roleRegistry) allows(role int, required permission, buildingID int64) bool {
+	if role == roleAdmin {
+		return true
+	}
+	def, ok := reg.lookup(role)
+	if !ok {
+		return false
+	}
+	for _, grant := range def.Permissions {
+		if grant.Permission != required {
+			continue
+		}
+		if grant.BuildingID == 0 || grant.BuildingID == buildingID {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsFor returns the permissions of role as shown to the frontend.
+func (reg *roleRegistry) grantsFor(role int) []rolePermissionGrant {
+	if role == roleAdmin {
+		all := make([]rolePermissionGrant, 0, len(permissionDescriptions))
+		for p := range permissionDescriptions {
+			all = append(all, rolePermissionGrant{Permission: p})
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].Permission < all[j].Permission })
+		return all
+	}
+	def, ok := reg.lookup(role)
+	if !ok {
+		return []rolePermissionGrant{}
+	}
+	return def.Permissions
+}
+
+func ensureRolesStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS roles (
+			id INTEGER PRIMARY KEY,
+			key TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			builtin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);`,
+		fmt.Sprintf(`CREATE SEQUENCE IF NOT EXISTS roles_custom_id_seq START %d;`, customRoleIDStart),
+		`CREATE TABLE IF NOT EXISTS role_permissions (
+			role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+			permission TEXT NOT NULL,
+			building_id BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (role_id, permission, building_id)
+		);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	// Seed built-in roles once; later edits of their grants are kept.
+	for _, def := range builtinRoleDefinitions() {
+		var inserted int
+		err := db.QueryRow(
+			`INSERT INTO roles (id, key, name, description, builtin)
+			 VALUES ($1, $2, $3, $4, TRUE)
+			 ON CONFLICT (id) DO NOTHING
+			 RETURNING id`,
+			def.ID, def.Key, def.Name, def.Description,
+		).Scan(&inserted)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, grant := range def.Permissions {
+			if _, err := db.Exec(
+				`INSERT INTO role_permissions (role_id, permission, building_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+				def.ID, string(grant.Permission), grant.BuildingID,
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func loadRoleDefinitions(ctx context.Context, db *sql.DB) ([]roleDefinition, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT r.id, r.key, r.name, r.description, r.builtin,
+		        COALESCE(p.permission, ''), COALESCE(p.building_id, 0)
+		   FROM roles r
+		   LEFT JOIN role_permissions p ON p.role_id = r.id
+		  ORDER BY r.id, p.permission, p.building_id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	defs := make([]roleDefinition, 0)
+	for rows.Next() {
+		var (
+			def        roleDefinition
+			perm       string
+			buildingID int64
+		)
+		if err := rows.Scan(&def.ID, &def.Key, &def.Name, &def.Description, &def.Builtin, &perm, &buildingID); err != nil {
+			return nil, err
+		}
+		if len(defs) == 0 || defs[len(defs)-1].ID != def.ID {
+			def.Permissions = make([]rolePermissionGrant, 0)
+			defs = append(defs, def)
+		}
+		if perm != "" {
+			last := &defs[len(defs)-1]
+			last.Permissions = append(last.Permissions, rolePermissionGrant{Permission: permission(perm), BuildingID: buildingID})
+		}
+	}
+	return defs, rows.Err()
+}
+
+// reloadRoleDefinitions refreshes the cached matrix from the database.
+func (a *app) reloadRoleDefinitions(ctx context.Context) error {
+	defs, err := loadRoleDefinitions(ctx, a.db)
+	if err != nil {
+		return err
+	}
+	roleDefinitions.replace(defs)
+	return nil
+}
+
+// runRoleDefinitionsLoop keeps the cached matrix in sync with edits made
+// through other instances until ctx is cancelled.
+func (a *app) runRoleDefinitionsLoop(ctx context.Context) {
+	ticker := time.NewTicker(roleDefinitionsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := a.reloadRoleDefinitions(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("roles: reload failed: %v", err)
+		}
+	}
+}
+
+// normalizeRoleGrants validates grants and drops duplicates.
+func normalizeRoleGrants(raw []rolePermissionGrant) ([]rolePermissionGrant, error) {
+	seen := make(map[rolePermissionGrant]bool, len(raw))
+	grants := make([]rolePermissionGrant, 0, len(raw))
+	for _, grant := range raw {
+		grant.Permission = permission(strings.TrimSpace(string(grant.Permission)))
+		if _, ok := permissionDescriptions[grant.Permission]; !ok {
+			return nil, fmt.Errorf("unknown permission %q", grant.Permission)
+		}
+		if grant.BuildingID < 0 {
+			return nil, errors.New("building_id must be positive")
+		}
+		if grant.BuildingID != 0 && !buildingScopedPermissions[grant.Permission] {
+			return nil, fmt.Errorf("permission %q cannot be scoped to a building", grant.Permission)
+		}
+		if seen[grant] {
+			continue
+		}
+		seen[grant] = true
+		grants = append(grants, grant)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Permission != grants[j].Permission {
+			return grants[i].Permission < grants[j].Permission
+		}
+		return grants[i].BuildingID < grants[j].BuildingID
+	})
+	return grants, nil
+}
+
+func replaceRoleGrants(ctx context.Context, tx *sql.Tx, roleID int, grants []rolePermissionGrant) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID); err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if grant.BuildingID != 0 {
+			var exists bool
+			if err := tx.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM office_buildings WHERE id = $1)`,
+				grant.BuildingID,
+			).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: building %d", errNotFound, grant.BuildingID)
+			}
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO role_permissions (role_id, permission, building_id) VALUES ($1, $2, $3)`,
+			roleID, string(grant.Permission), grant.BuildingID,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type roleDefinitionPayload struct {
+	Key         *string                `json:"key"`
+	Name        *string                `json:"name"`
+	Description *string                `json:"description"`
+	Permissions *[]rolePermissionGrant `json:"permissions"`
+}
+
+func respondRoleWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errRoleNotFound):
+		respondError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func roleAuditDetails(def roleDefinition) map[string]any {
+	return map[string]any{
+		"role_key":    def.Key,
+		"permissions": def.Permissions,
+		"entity_path": "Роли · " + def.Name,
+	}
+}
+
+// ensureRoleGrantsCovered keeps holders of manage_roles from writing grants
+// they do not hold themselves into a role, or editing a role that has them:
+// the role could then be assigned to them.
+func (a *app) ensureRoleGrantsCovered(w http.ResponseWriter, r *http.Request, grantSets ...[]rolePermissionGrant) bool {
+	role, err := resolveRoleFromRequestFresh(r, a.db)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return false
+	}
+	for _, grants := range grantSets {
+		if !roleGrantsCovered(role, grants) {
+			respondError(w, http.StatusForbidden, errRoleAssignmentNotCovered.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// handleAdminRoles lists role definitions and creates custom roles.
+// GET  /api/admin/roles
+// POST /api/admin/roles  {"key", "name", "description", "permissions": [{"permission", "building_id"}]}
+func (a *app) handleAdminRoles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !ensurePermission(w, r, a.db, permissionManageRoles) {
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{
+			"roles":                       roleDefinitions.list(),
+			"permissions":                 permissionDescriptions,
+			"building_scoped_permissions": buildingScopedPermissions,
+		})
+	case http.MethodPost:
+		if !ensurePermission(w, r, a.db, permissionManageRoles) {
+			return
+		}
+		var payload roleDefinitionPayload
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		var def roleDefinition
+		if payload.Key != nil {
+			def.Key = strings.TrimSpace(*payload.Key)
+		}
+		if !roleKeyPattern.MatchString(def.Key) {
+			respondError(w, http.StatusBadRequest, "key must match [a-z][a-z0-9_]{1,39}")
+			return
+		}
+		if payload.Name != nil {
+			def.Name = strings.TrimSpace(*payload.Name)
+		}
+		if def.Name == "" {
+			respondError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		if payload.Description != nil {
+			def.Description = strings.TrimSpace(*payload.Description)
+		}
+		def.Permissions = []rolePermissionGrant{}
+		if payload.Permissions != nil {
+			grants, err := normalizeRoleGrants(*payload.Permissions)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			def.Permissions = grants
+		}
+		if !a.ensureRoleGrantsCovered(w, r, def.Permissions) {
+			return
+		}
+
+		tx, err := a.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		var taken bool
+		if err := tx.QueryRowContext(r.Context(), `SELECT EXISTS (SELECT 1 FROM roles WHERE key = $1)`, def.Key).Scan(&taken); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if taken {
+			respondError(w, http.StatusConflict, "role key already exists")
+			return
+		}
+		if err := tx.QueryRowContext(r.Context(),
+			`INSERT INTO roles (id, key, name, description)
+			 VALUES (nextval('roles_custom_id_seq'), $1, $2, $3)
+			 RETURNING id`,
+			def.Key, def.Name, def.Description,
+		).Scan(&def.ID); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if err := replaceRoleGrants(r.Context(), tx, def.ID, def.Permissions); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if err := a.reloadRoleDefinitions(r.Context()); err != nil {
+			log.Printf("roles: reload failed: %v", err)
+		}
+		a.logAuditEventFromRequest(r, auditActionCreate, auditEntityRole, int64(def.ID), def.Name, roleAuditDetails(def))
+		respondJSON(w, http.StatusCreated, def)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminRoleSubroutes reads, edits and deletes one role definition.
+// GET/PUT/DELETE /api/admin/roles/{id}
+// Built-in roles keep their key and cannot be deleted; the admin role cannot
+// be edited at all.
+func (a *app) handleAdminRoleSubroutes(w http.ResponseWriter, r *http.Request) {
+	id, suffix, err := parseIDFromPath(r.URL.Path, adminRolesPath+"/")
+	if err != nil || suffix != "" {
+		respondError(w, http.StatusBadRequest, "invalid role id")
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionManageRoles) {
+		return
+	}
+	roleID := int(id)
+	existing, ok := roleDefinitions.lookup(roleID)
+	if !ok {
+		respondError(w, http.StatusNotFound, errRoleNotFound.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		respondJSON(w, http.StatusOK, existing)
+	case http.MethodPut:
+		if roleID == roleAdmin {
+			respondError(w, http.StatusConflict, errRoleBuiltin.Error())
+			return
+		}
+		var payload roleDefinitionPayload
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		updated := existing
+		if payload.Key != nil && strings.TrimSpace(*payload.Key) != existing.Key {
+			respondError(w, http.StatusBadRequest, "key cannot be changed")
+			return
+		}
+		if payload.Name != nil {
+			updated.Name = strings.TrimSpace(*payload.Name)
+			if updated.Name == "" {
+				respondError(w, http.StatusBadRequest, "name is required")
+				return
+			}
+		}
+		if payload.Description != nil {
+			updated.Description = strings.TrimSpace(*payload.Description)
+		}
+		if payload.Permissions != nil {
+			grants, err := normalizeRoleGrants(*payload.Permissions)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			updated.Permissions = grants
+			if !a.ensureRoleGrantsCovered(w, r, existing.Permissions, updated.Permissions) {
+				return
+			}
+		}
+
+		tx, err := a.db.BeginTx(r.Context(), nil)
+		if err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		defer tx.Rollback()
+		result, err := tx.ExecContext(r.Context(),
+			`UPDATE roles SET name = $2, description = $3, updated_at = now() WHERE id = $1`,
+			roleID, updated.Name, updated.Description,
+		)
+		if err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondRoleWriteError(w, errRoleNotFound)
+			return
+		}
+		if payload.Permissions != nil {
+			if err := replaceRoleGrants(r.Context(), tx, roleID, updated.Permissions); err != nil {
+				respondRoleWriteError(w, err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if err := a.reloadRoleDefinitions(r.Context()); err != nil {
+			log.Printf("roles: reload failed: %v", err)
+		}
+		details := roleAuditDetails(updated)
+		details["before_name"] = existing.Name
+		details["before_permissions"] = existing.Permissions
+		a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityRole, int64(roleID), updated.Name, details)
+		respondJSON(w, http.StatusOK, updated)
+	case http.MethodDelete:
+		if existing.Builtin {
+			respondError(w, http.StatusConflict, errRoleBuiltin.Error())
+			return
+		}
+		var inUse bool
+		if err := a.db.QueryRowContext(r.Context(),
+			`SELECT EXISTS (SELECT 1 FROM users WHERE role = $1)
+			     OR EXISTS (SELECT 1 FROM service_accounts WHERE role = $1)`,
+			roleID,
+		).Scan(&inUse); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if inUse {
+			respondError(w, http.StatusConflict, errRoleInUse.Error())
+			return
+		}
+		if _, err := a.db.ExecContext(r.Context(), `DELETE FROM roles WHERE id = $1`, roleID); err != nil {
+			respondRoleWriteError(w, err)
+			return
+		}
+		if err := a.reloadRoleDefinitions(r.Context()); err != nil {
+			log.Printf("roles: reload failed: %v", err)
+		}
+		a.logAuditEventFromRequest(r, auditActionDelete, auditEntityRole, int64(roleID), existing.Name, roleAuditDetails(existing))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
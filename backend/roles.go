@@ -9,9 +9,16 @@ import (
 	"strings"
 )
 
+// Built-in roles. Custom roles defined through /api/admin/roles get ids from
+// customRoleIDStart upwards; users.role stores either.
 const (
-	roleEmployee = 1
-	roleAdmin    = 2
+	roleEmployee           = 1
+	roleAdmin              = 2
+	roleReceptionist       = 3
+	roleFacilityManager    = 4
+	roleAuditor            = 5
+	roleBookingCoordinator = 6
+	customRoleIDStart      = 100
 )
 
 type permission string
@@ -19,6 +26,20 @@ type permission string
 const (
 	permissionManageRoleAssignments   permission = "manage_role_assignments"
 	permissionViewAnyResponsibilities permission = "view_any_responsibilities"
+	permissionManageRoles             permission = "manage_roles"
+	permissionManageBuildings         permission = "manage_buildings"
+	permissionManageLayout            permission = "manage_layout"
+	permissionManageBookings          permission = "manage_bookings"
+	permissionViewUsers               permission = "view_users"
+	permissionViewAuditLogs           permission = "view_audit_logs"
+	permissionManageTrash             permission = "manage_trash"
+	permissionManageSessions          permission = "manage_sessions"
+	permissionManageServiceAccounts   permission = "manage_service_accounts"
+	permissionManageBackups           permission = "manage_backups"
+	permissionOffboardUsers           permission = "offboard_users"
+	permissionImpersonateUsers        permission = "impersonate_users"
+	permissionManagePersonalData      permission = "manage_personal_data"
+	permissionManageAuditSinks        permission = "manage_audit_sinks"
 )
 
 var errRequesterIdentityRequired = errors.New("requester identity is required")
@@ -26,18 +47,45 @@ var errRequesterIdentityRequired = errors.New("requester identity is required")
 const adminEmployeeIDsEnvKey = "OFFICE_ADMIN_EMPLOYEE_IDS"
 
 func isValidRole(role int) bool {
-	return role == roleEmployee || role == roleAdmin
+	_, ok := roleDefinitions.lookup(role)
+	return ok
 }
 
+// hasPermission reports whether role holds required in every building.
+// Grants scoped to a single building are checked with hasPermissionInBuilding.
 func hasPermission(role int, required permission) bool {
-	switch role {
-	case roleAdmin:
+	return roleDefinitions.allows(role, required, 0)
+}
+
+// hasPermissionInBuilding reports whether role holds required either
+// globally or through a grant scoped to buildingID.
+func hasPermissionInBuilding(role int, required permission, buildingID int64) bool {
+	return roleDefinitions.allows(role, required, buildingID)
+}
+
+// roleCovers reports whether actor holds every permission of target, so
+// acting as target or handing target out never widens what the actor may do.
+func roleCovers(actor, target int) bool {
+	if actor == roleAdmin {
 		return true
-	case roleEmployee:
-		return false
-	default:
+	}
+	if target == roleAdmin {
 		return false
 	}
+	return roleGrantsCovered(actor, roleDefinitions.grantsFor(target))
+}
+
+// roleGrantsCovered reports whether role holds every grant in grants.
+func roleGrantsCovered(role int, grants []rolePermissionGrant) bool {
+	if role == roleAdmin {
+		return true
+	}
+	for _, grant := range grants {
+		if !hasPermissionInBuilding(role, grant.Permission, grant.BuildingID) {
+			return false
+		}
+	}
+	return true
 }
 
 func isMutatingMethod(method string) bool {
@@ -93,26 +141,45 @@ func respondRoleResolutionError(w http.ResponseWriter, err error) {
 	respondError(w, http.StatusInternalServerError, "Failed to resolve requester role")
 }
 
-func ensureNotEmployeeRole(w http.ResponseWriter, r *http.Request, queryer rowQueryer) bool {
+// ensurePermission responds 403 unless the caller's role holds required
+// in every building.
+func ensurePermission(w http.ResponseWriter, r *http.Request, queryer rowQueryer, required permission) bool {
 	role, err := resolveRoleFromRequest(r, queryer)
 	if err != nil {
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role == roleEmployee {
+	if !hasRequestPermission(r, role, required) {
 		respondError(w, http.StatusForbidden, "Недостаточно прав")
 		return false
 	}
 	return true
 }
 
-func ensureNotEmployeeRoleFresh(w http.ResponseWriter, r *http.Request, queryer rowQueryer) bool {
+// ensurePermissionFresh is ensurePermission with the role always read from
+// the database, for operations where a stale token role is not acceptable.
+func ensurePermissionFresh(w http.ResponseWriter, r *http.Request, queryer rowQueryer, required permission) bool {
 	role, err := resolveRoleFromRequestFresh(r, queryer)
 	if err != nil {
 		respondRoleResolutionError(w, err)
 		return false
 	}
-	if role == roleEmployee {
+	if !hasRequestPermission(r, role, required) {
+		respondError(w, http.StatusForbidden, "Недостаточно прав")
+		return false
+	}
+	return true
+}
+
+// ensureAdminFresh admits only the admin role, read from the database, for
+// endpoints no permission of a custom role should open.
+func ensureAdminFresh(w http.ResponseWriter, r *http.Request, queryer rowQueryer) bool {
+	role, err := resolveRoleFromRequestFresh(r, queryer)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return false
+	}
+	if role != roleAdmin {
 		respondError(w, http.StatusForbidden, "Недостаточно прав")
 		return false
 	}
@@ -148,6 +215,9 @@ func getUserRoleByWbUserID(ctx context.Context, queryer rowQueryer, wbUserID str
 	if queryer == nil {
 		return roleEmployee, nil
 	}
+	if isServiceAccountPrincipal(normalizedID) {
+		return getServiceAccountRole(ctx, queryer, normalizedID)
+	}
 	row := queryer.QueryRowContext(ctx,
 		`SELECT COALESCE(role, $2),
 		        TRIM(COALESCE(employee_id, ''))
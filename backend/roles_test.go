@@ -32,6 +32,18 @@ func TestHasPermission(t *testing.T) {
 			required: permissionManageRoleAssignments,
 			want:     false,
 		},
+		{
+			name:     "admin can retry audit sinks",
+			role:     roleAdmin,
+			required: permissionManageAuditSinks,
+			want:     true,
+		},
+		{
+			name:     "auditor cannot retry audit sinks",
+			role:     roleAuditor,
+			required: permissionManageAuditSinks,
+			want:     false,
+		},
 		{
 			name:     "invalid role cannot view any responsibilities",
 			role:     999,
@@ -77,3 +89,123 @@ func TestGetUserRoleByWbUserID_AdminFromEnvWithoutDB(t *testing.T) {
 		t.Fatalf("getUserRoleByWbUserID role = %d, want %d", role, roleAdmin)
 	}
 }
+
+func TestRoleRegistryBuildingScopedGrants(t *testing.T) {
+	t.Parallel()
+
+	reg := newRoleRegistry(append(builtinRoleDefinitions(), roleDefinition{
+		ID:  customRoleIDStart,
+		Key: "north_reception",
+		Permissions: []rolePermissionGrant{
+			{Permission: permissionManageBookings, BuildingID: 7},
+			{Permission: permissionViewUsers},
+		},
+	}))
+
+	tests := []struct {
+		name       string
+		role       int
+		required   permission
+		buildingID int64
+		want       bool
+	}{
+		{"scoped grant applies in its building", customRoleIDStart, permissionManageBookings, 7, true},
+		{"scoped grant does not apply elsewhere", customRoleIDStart, permissionManageBookings, 8, false},
+		{"scoped grant is not global", customRoleIDStart, permissionManageBookings, 0, false},
+		{"global grant applies in any building", customRoleIDStart, permissionViewUsers, 8, true},
+		{"auditor reads audit logs", roleAuditor, permissionViewAuditLogs, 0, true},
+		{"auditor cannot edit layout", roleAuditor, permissionManageLayout, 3, false},
+		{"facility manager edits layout", roleFacilityManager, permissionManageLayout, 3, true},
+		{"admin holds everything", roleAdmin, permissionManageRoles, 0, true},
+		{"unknown role holds nothing", 999, permissionViewUsers, 0, false},
+	}
+	for _, tc := range tests {
+		if got := reg.allows(tc.role, tc.required, tc.buildingID); got != tc.want {
+			t.Errorf("%s: allows(%d, %q, %d) = %v, want %v", tc.name, tc.role, tc.required, tc.buildingID, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeRoleGrants(t *testing.T) {
+	t.Parallel()
+
+	grants, err := normalizeRoleGrants([]rolePermissionGrant{
+		{Permission: permissionViewUsers},
+		{Permission: permissionManageLayout, BuildingID: 2},
+		{Permission: permissionViewUsers},
+	})
+	if err != nil || len(grants) != 2 || grants[0].Permission != permissionManageLayout {
+		t.Fatalf("unexpected grants %v, %v", grants, err)
+	}
+	if _, err := normalizeRoleGrants([]rolePermissionGrant{{Permission: "fly"}}); err == nil {
+		t.Error("expected unknown permission to be rejected")
+	}
+	if _, err := normalizeRoleGrants([]rolePermissionGrant{{Permission: permissionViewAuditLogs, BuildingID: 2}}); err == nil {
+		t.Error("expected a global-only permission scoped to a building to be rejected")
+	}
+}
+
+func TestRoleCovers(t *testing.T) {
+	cases := []struct {
+		actor, target int
+		want          bool
+	}{
+		{roleAdmin, roleAdmin, true},
+		{roleAdmin, roleAuditor, true},
+		{roleFacilityManager, roleEmployee, true},
+		{roleFacilityManager, roleReceptionist, true},
+		{roleFacilityManager, roleAuditor, false},
+		{roleReceptionist, roleFacilityManager, false},
+		{roleAuditor, roleAdmin, false},
+	}
+	for _, tc := range cases {
+		if got := roleCovers(tc.actor, tc.target); got != tc.want {
+			t.Errorf("roleCovers(%d, %d) = %v, want %v", tc.actor, tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestCheckRoleAssignment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                     string
+		requester, current, next int
+		want                     error
+	}{
+		{name: "admin promotes to admin", requester: roleAdmin, current: roleEmployee, next: roleAdmin, want: nil},
+		{name: "admin demotes admin", requester: roleAdmin, current: roleAdmin, next: roleEmployee, want: nil},
+		{name: "promote to admin", requester: roleFacilityManager, current: roleEmployee, next: roleAdmin, want: errRoleAssignmentAdminOnly},
+		{name: "demote admin", requester: roleFacilityManager, current: roleAdmin, next: roleEmployee, want: errRoleAssignmentAdminOnly},
+		{name: "covered role", requester: roleFacilityManager, current: roleEmployee, next: roleReceptionist, want: nil},
+		{name: "role with foreign permissions", requester: roleFacilityManager, current: roleEmployee, next: roleAuditor, want: errRoleAssignmentNotCovered},
+		{name: "user with foreign permissions", requester: roleFacilityManager, current: roleAuditor, next: roleEmployee, want: errRoleAssignmentNotCovered},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := checkRoleAssignment(tc.requester, tc.current, tc.next); got != tc.want {
+				t.Fatalf("checkRoleAssignment(%d, %d, %d) = %v, want %v", tc.requester, tc.current, tc.next, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRoleGrantsCovered(t *testing.T) {
+	t.Parallel()
+
+	manageRoles := []rolePermissionGrant{{Permission: permissionManageRoles}, {Permission: permissionManageRoleAssignments}}
+	if !roleGrantsCovered(roleAdmin, manageRoles) {
+		t.Fatal("admin does not cover role management")
+	}
+	if roleGrantsCovered(roleFacilityManager, manageRoles) {
+		t.Fatal("facility manager can write role management into a role")
+	}
+	if !roleGrantsCovered(roleFacilityManager, roleDefinitions.grantsFor(roleFacilityManager)) {
+		t.Fatal("facility manager does not cover its own grants")
+	}
+	if !roleGrantsCovered(roleEmployee, nil) {
+		t.Fatal("empty grant set is not covered")
+	}
+}
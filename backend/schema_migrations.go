@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Versioned schema migrations. Every migration has a version and is recorded
+// in schema_migrations once applied, so startup runs only what is missing and
+// "api migrate status" shows where a database stands. Everything that existed
+// before versioning is migration 1 (migrateBaseline). Later changes are
+// appended to schemaMigrations with the next version and, where they can be
+// undone, a Down step.
+//
+// Replicas that start together serialize on a session advisory lock: the
+// first one applies pending migrations, the others wait and find nothing
+// left to do.
+const (
+	schemaMigrationsLockID        = 7_340_024
+	schemaMigrationsLockRetryWait = time.Second
+
+	schemaMigrationApplied = "applied"
+	schemaMigrationPending = "pending"
+	// schemaMigrationUnknown marks a version recorded in the database but
+	// missing from this build, i.e. applied by a newer release.
+	schemaMigrationUnknown = "unknown"
+)
+
+var errSchemaMigrationIrreversible = errors.New("migration cannot be reverted")
+
+type schemaMigration struct {
+	Version int64
+	Name    string
+	// Up and Down run in one transaction together with the schema_migrations
+	// update. A nil Down makes the migration irreversible.
+	Up   func(ctx context.Context, tx *sql.Tx) error
+	Down func(ctx context.Context, tx *sql.Tx) error
+	// upDB replaces Up for the baseline, whose steps take *sql.DB and manage
+	// their own transactions.
+	upDB func(db *sql.DB) error
+}
+
+var schemaMigrations = []schemaMigration{
+	{Version: 1, Name: "baseline", upDB: migrateBaseline},
+	{Version: 2, Name: "audit_checkpoint_keys", Up: migrateAuditCheckpointKeysUp, Down: migrateAuditCheckpointKeysDown},
+	{Version: 3, Name: "service_account_key_building", Up: migrateServiceAccountKeyBuildingUp, Down: migrateServiceAccountKeyBuildingDown},
+	{Version: 4, Name: "scim_name_parts", Up: migrateSCIMNamePartsUp, Down: migrateSCIMNamePartsDown},
+}
+
+type appliedSchemaMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+type schemaMigrationStatus struct {
+	Version    int64      `json:"version"`
+	Name       string     `json:"name"`
+	State      string     `json:"state"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	Reversible bool       `json:"reversible"`
+}
+
+// migrate brings the schema up to date. It runs on every server start.
+// Indexes that existing data or missing privileges may prevent are not
+// migrations: they are retried on every start until an administrator has
+// fixed the cause.
+func migrate(db *sql.DB) error {
+	applied, err := migrateSchemaUp(context.Background(), db, schemaMigrations, 0)
+	for _, m := range applied {
+		log.Printf("schema migration %d %s applied", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := ensureFloorLevelIndex(db); err != nil {
+		return err
+	}
+	return ensureAuditSearchTrigramIndex(db)
+}
+
+func validateSchemaMigrations(list []schemaMigration) error {
+	var prev int64
+	for _, m := range list {
+		if m.Version <= prev {
+			return fmt.Errorf("schema migration %d %s: versions must be positive and ascending", m.Version, m.Name)
+		}
+		if (m.Up == nil) == (m.upDB == nil) {
+			return fmt.Errorf("schema migration %d %s: exactly one up step is required", m.Version, m.Name)
+		}
+		prev = m.Version
+	}
+	return nil
+}
+
+func ensureSchemaMigrationsTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+// loadAppliedSchemaMigrations returns the recorded migrations. A database
+// without schema_migrations has none applied.
+func loadAppliedSchemaMigrations(ctx context.Context, db *sql.DB) (map[int64]appliedSchemaMigration, error) {
+	applied := make(map[int64]appliedSchemaMigration)
+	exists, err := tableExists(db, "schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m appliedSchemaMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[m.Version] = m
+	}
+	return applied, rows.Err()
+}
+
+// withSchemaMigrationLock runs fn while holding schemaMigrationsLockID on a
+// dedicated connection. The lock is polled rather than awaited so that a
+// long migration on another replica does not trip statement_timeout.
+func withSchemaMigrationLock(ctx context.Context, db *sql.DB, fn func() error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	waiting := false
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, schemaMigrationsLockID).Scan(&locked); err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if !waiting {
+			log.Println("schema migrations: waiting for another instance to finish")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(schemaMigrationsLockRetryWait):
+		}
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, schemaMigrationsLockID); err != nil {
+			log.Printf("schema migrations: release lock: %v", err)
+		}
+	}()
+	return fn()
+}
+
+// planSchemaMigrationsUp returns the migrations not yet applied, in order.
+// A positive target stops at that version.
+func planSchemaMigrationsUp(list []schemaMigration, applied map[int64]appliedSchemaMigration, target int64) []schemaMigration {
+	var plan []schemaMigration
+	for _, m := range list {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			plan = append(plan, m)
+		}
+	}
+	return plan
+}
+
+// planSchemaMigrationsDown returns the applied migrations above target,
+// newest first. A negative target reverts only the newest one. The plan is
+// refused as a whole if any step is irreversible or unknown to this build.
+func planSchemaMigrationsDown(list []schemaMigration, applied map[int64]appliedSchemaMigration, target int64) ([]schemaMigration, error) {
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if target < 0 {
+		target = 0
+		if len(versions) > 1 {
+			target = versions[1]
+		}
+	}
+
+	known := make(map[int64]schemaMigration, len(list))
+	for _, m := range list {
+		known[m.Version] = m
+	}
+	var plan []schemaMigration
+	for _, v := range versions {
+		if v <= target {
+			break
+		}
+		m, ok := known[v]
+		if !ok {
+			return nil, fmt.Errorf("schema migration %d %s was applied by a newer build", v, applied[v].Name)
+		}
+		if m.Down == nil {
+			return nil, fmt.Errorf("schema migration %d %s: %w", m.Version, m.Name, errSchemaMigrationIrreversible)
+		}
+		plan = append(plan, m)
+	}
+	return plan, nil
+}
+
+// schemaMigrationStatuses lists the migrations of this build followed by
+// versions only the database knows about.
+func schemaMigrationStatuses(list []schemaMigration, applied map[int64]appliedSchemaMigration) []schemaMigrationStatus {
+	statuses := make([]schemaMigrationStatus, 0, len(list))
+	known := make(map[int64]bool, len(list))
+	for _, m := range list {
+		known[m.Version] = true
+		status := schemaMigrationStatus{Version: m.Version, Name: m.Name, State: schemaMigrationPending, Reversible: m.Down != nil}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.AppliedAt
+			status.State = schemaMigrationApplied
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	var unknown []schemaMigrationStatus
+	for _, a := range applied {
+		if known[a.Version] {
+			continue
+		}
+		appliedAt := a.AppliedAt
+		unknown = append(unknown, schemaMigrationStatus{Version: a.Version, Name: a.Name, State: schemaMigrationUnknown, AppliedAt: &appliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+	return append(statuses, unknown...)
+}
+
+// migrateSchemaUp applies pending migrations up to target (all when zero)
+// and returns the ones it applied.
+func migrateSchemaUp(ctx context.Context, db *sql.DB, list []schemaMigration, target int64) ([]schemaMigration, error) {
+	if err := validateSchemaMigrations(list); err != nil {
+		return nil, err
+	}
+	var done []schemaMigration
+	err := withSchemaMigrationLock(ctx, db, func() error {
+		if err := ensureSchemaMigrationsTable(ctx, db); err != nil {
+			return err
+		}
+		applied, err := loadAppliedSchemaMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range schemaMigrationStatuses(list, applied) {
+			if s.State == schemaMigrationUnknown {
+				log.Printf("WARNING: schema migration %d %s is applied but unknown to this build", s.Version, s.Name)
+			}
+		}
+		for _, m := range planSchemaMigrationsUp(list, applied, target) {
+			if err := applySchemaMigration(ctx, db, m); err != nil {
+				return fmt.Errorf("schema migration %d %s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func applySchemaMigration(ctx context.Context, db *sql.DB, m schemaMigration) error {
+	const record = `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`
+	if m.upDB != nil {
+		if err := m.upDB(db); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx, record, m.Version, m.Name)
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := m.Up(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrateSchemaDown reverts applied migrations above target (see
+// planSchemaMigrationsDown) and returns the ones it reverted.
+func migrateSchemaDown(ctx context.Context, db *sql.DB, list []schemaMigration, target int64) ([]schemaMigration, error) {
+	if err := validateSchemaMigrations(list); err != nil {
+		return nil, err
+	}
+	var done []schemaMigration
+	err := withSchemaMigrationLock(ctx, db, func() error {
+		applied, err := loadAppliedSchemaMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		plan, err := planSchemaMigrationsDown(list, applied, target)
+		if err != nil {
+			return err
+		}
+		for _, m := range plan {
+			if err := revertSchemaMigration(ctx, db, m); err != nil {
+				return fmt.Errorf("schema migration %d %s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func revertSchemaMigration(ctx context.Context, db *sql.DB, m schemaMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := m.Down(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testSchemaMigrations() []schemaMigration {
+	noop := func(context.Context, *sql.Tx) error { return nil }
+	return []schemaMigration{
+		{Version: 1, Name: "baseline", upDB: func(*sql.DB) error { return nil }},
+		{Version: 2, Name: "desk_tags", Up: noop, Down: noop},
+		{Version: 3, Name: "drop_legacy_floors", Up: noop},
+		{Version: 4, Name: "booking_notes", Up: noop, Down: noop},
+	}
+}
+
+func appliedSchemaVersions(versions ...int64) map[int64]appliedSchemaMigration {
+	applied := make(map[int64]appliedSchemaMigration)
+	for _, v := range versions {
+		applied[v] = appliedSchemaMigration{Version: v, Name: "m", AppliedAt: time.Unix(1_700_000_000+v, 0)}
+	}
+	return applied
+}
+
+func schemaMigrationVersions(list []schemaMigration) []int64 {
+	versions := make([]int64, 0, len(list))
+	for _, m := range list {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func TestSchemaMigrationsRegistry(t *testing.T) {
+	if err := validateSchemaMigrations(schemaMigrations); err != nil {
+		t.Fatal(err)
+	}
+	if schemaMigrations[0].Version != 1 || schemaMigrations[0].upDB == nil {
+		t.Fatalf("migration 1 must be the baseline: %+v", schemaMigrations[0])
+	}
+
+	list := testSchemaMigrations()
+	list[2].Version = 2
+	if err := validateSchemaMigrations(list); err == nil {
+		t.Error("duplicate version accepted")
+	}
+	list = testSchemaMigrations()
+	list[1].upDB = func(*sql.DB) error { return nil }
+	if err := validateSchemaMigrations(list); err == nil {
+		t.Error("migration with two up steps accepted")
+	}
+}
+
+func TestPlanSchemaMigrationsUp(t *testing.T) {
+	list := testSchemaMigrations()
+	cases := []struct {
+		applied []int64
+		target  int64
+		want    []int64
+	}{
+		{nil, 0, []int64{1, 2, 3, 4}},
+		{[]int64{1, 2}, 0, []int64{3, 4}},
+		{[]int64{1}, 3, []int64{2, 3}},
+		{[]int64{1, 3}, -1, []int64{2, 4}},
+		{[]int64{1, 2, 3, 4}, 0, nil},
+	}
+	for _, tc := range cases {
+		got := schemaMigrationVersions(planSchemaMigrationsUp(list, appliedSchemaVersions(tc.applied...), tc.target))
+		if len(got) != len(tc.want) {
+			t.Errorf("applied %v target %d: got %v, want %v", tc.applied, tc.target, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("applied %v target %d: got %v, want %v", tc.applied, tc.target, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPlanSchemaMigrationsDown(t *testing.T) {
+	list := testSchemaMigrations()
+
+	plan, err := planSchemaMigrationsDown(list, appliedSchemaVersions(1, 2, 3, 4), -1)
+	if err != nil || len(plan) != 1 || plan[0].Version != 4 {
+		t.Fatalf("default down: plan %v, err %v", schemaMigrationVersions(plan), err)
+	}
+	if _, err := planSchemaMigrationsDown(list, appliedSchemaVersions(1, 2, 3, 4), 1); !errors.Is(err, errSchemaMigrationIrreversible) {
+		t.Fatalf("down past an irreversible migration: err %v", err)
+	}
+	plan, err = planSchemaMigrationsDown(list, appliedSchemaVersions(1, 2), 1)
+	if err != nil || len(plan) != 1 || plan[0].Version != 2 {
+		t.Fatalf("down to 1: plan %v, err %v", schemaMigrationVersions(plan), err)
+	}
+	if _, err := planSchemaMigrationsDown(list, appliedSchemaVersions(1), -1); !errors.Is(err, errSchemaMigrationIrreversible) {
+		t.Fatalf("baseline reverted: err %v", err)
+	}
+	if _, err := planSchemaMigrationsDown(list, appliedSchemaVersions(1, 2, 5), -1); err == nil {
+		t.Fatal("reverting a migration unknown to this build should fail")
+	}
+	plan, err = planSchemaMigrationsDown(list, appliedSchemaVersions(), -1)
+	if err != nil || len(plan) != 0 {
+		t.Fatalf("empty database: plan %v, err %v", schemaMigrationVersions(plan), err)
+	}
+}
+
+func TestSchemaMigrationStatuses(t *testing.T) {
+	statuses := schemaMigrationStatuses(testSchemaMigrations(), appliedSchemaVersions(1, 2, 7))
+	want := []struct {
+		version int64
+		state   string
+	}{
+		{1, schemaMigrationApplied},
+		{2, schemaMigrationApplied},
+		{3, schemaMigrationPending},
+		{4, schemaMigrationPending},
+		{7, schemaMigrationUnknown},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("got %d statuses, want %d", len(statuses), len(want))
+	}
+	for i, w := range want {
+		if statuses[i].Version != w.version || statuses[i].State != w.state {
+			t.Errorf("status %d = %d %s, want %d %s", i, statuses[i].Version, statuses[i].State, w.version, w.state)
+		}
+	}
+	if statuses[0].Reversible || !statuses[1].Reversible || statuses[0].AppliedAt == nil || statuses[2].AppliedAt != nil {
+		t.Errorf("unexpected details: %+v", statuses[:3])
+	}
+}
@@ -0,0 +1,1111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCIM 2.0 (RFC 7643/7644) lets an HR system push joiners and leavers into
+// the users directory instead of waiting for their first login. Requests are
+// authenticated with a service account API key that carries the
+// users:provision scope.
+//
+// A provisioned user gets the placeholder wb_user_id "scim:<hex>" until the
+// employee logs in for the first time; upsertUserInfo then claims the row by
+// employee_id. Deleting a user through SCIM only deactivates it: bookings
+// and audit entries keep pointing at the employee, the user disappears from
+// the directory, sessions are revoked and new logins are refused.
+const (
+	scimBasePath          = "/scim/v2"
+	scimUsersPath         = scimBasePath + "/Users"
+	scimPlaceholderPrefix = "scim:"
+
+	scimSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSchemaEnterprise   = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	scimSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSchemaSPConfig     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	scimContentType = "application/scim+json"
+
+	scimDefaultCount = 100
+	scimMaxCount     = 500
+
+	auditEntityUser        = "user"
+	auditActionDeactivate  = "deactivate"
+	scimAuditSourceDetails = "scim"
+)
+
+var errSCIMUserNotFound = errors.New("user not found")
+
+// scimError is a protocol error rendered as an RFC 7644 error response.
+type scimError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *scimError) Error() string { return e.Detail }
+
+func newSCIMError(status int, scimType, format string, args ...any) *scimError {
+	return &scimError{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func respondSCIM(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	if payload != nil {
+		_ = json.NewEncoder(w).Encode(payload)
+	}
+}
+
+func respondSCIMError(w http.ResponseWriter, err error) {
+	var se *scimError
+	if !errors.As(err, &se) {
+		log.Printf("internal error: %v", err)
+		se = &scimError{Status: http.StatusInternalServerError, Detail: "internal error"}
+	}
+	payload := map[string]any{
+		"schemas": []string{scimSchemaError},
+		"status":  strconv.Itoa(se.Status),
+		"detail":  se.Detail,
+	}
+	if se.ScimType != "" {
+		payload["scimType"] = se.ScimType
+	}
+	respondSCIM(w, se.Status, payload)
+}
+
+func ensureSCIMStorage(db *sql.DB) error {
+	columns := []struct{ name, definition string }{
+		{"scim_user_name", "TEXT NOT NULL DEFAULT ''"},
+		{"scim_external_id", "TEXT NOT NULL DEFAULT ''"},
+		{"subdivision_level_1", "TEXT NOT NULL DEFAULT ''"},
+		{"subdivision_level_2", "TEXT NOT NULL DEFAULT ''"},
+		{"active", "BOOLEAN NOT NULL DEFAULT TRUE"},
+		{"updated_at", "TIMESTAMPTZ NOT NULL DEFAULT now()"},
+	}
+	for _, column := range columns {
+		if err := ensureColumn(db, "users", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	stmts := []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS users_scim_user_name_uidx ON users (LOWER(scim_user_name)) WHERE scim_user_name <> ''`,
+		`CREATE INDEX IF NOT EXISTS users_scim_external_id_idx ON users (scim_external_id) WHERE scim_external_id <> ''`,
+		`CREATE INDEX IF NOT EXISTS users_employee_id_idx ON users (employee_id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateSCIMNamePartsUp(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx,
+		`ALTER TABLE users
+		   ADD COLUMN IF NOT EXISTS scim_given_name TEXT NOT NULL DEFAULT '',
+		   ADD COLUMN IF NOT EXISTS scim_family_name TEXT NOT NULL DEFAULT ''`,
+	)
+	return err
+}
+
+func migrateSCIMNamePartsDown(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx,
+		`ALTER TABLE users DROP COLUMN IF EXISTS scim_given_name, DROP COLUMN IF EXISTS scim_family_name`,
+	)
+	return err
+}
+
+func newSCIMPlaceholderWbUserID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return scimPlaceholderPrefix + hex.EncodeToString(buf), nil
+}
+
+// isEmployeeDeactivated reports whether the directory marks employeeID as
+// deactivated. Unknown employees are not deactivated.
+func isEmployeeDeactivated(ctx context.Context, queryer rowQueryer, employeeID string) (bool, error) {
+	employeeID = strings.TrimSpace(employeeID)
+	if employeeID == "" {
+		return false, nil
+	}
+	var deactivated bool
+	err := queryer.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE employee_id = $1 AND NOT active)`,
+		employeeID,
+	).Scan(&deactivated)
+	return deactivated, err
+}
+
+type scimUser struct {
+	ID         int64
+	UserName   string
+	ExternalID string
+	EmployeeID string
+	FullName   string
+	GivenName  string
+	FamilyName string
+	AvatarURL  string
+	Division   string
+	Department string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	// The request set the display name, or changed a name part; used by
+	// finish to decide whether the full name is composed from the parts.
+	fullNameIsSet    bool
+	namePartsChanged bool
+}
+
+func (u *scimUser) resource() map[string]any {
+	id := strconv.FormatInt(u.ID, 10)
+	resource := map[string]any{
+		"schemas":     []string{scimSchemaUser, scimSchemaEnterprise},
+		"id":          id,
+		"userName":    u.UserName,
+		"displayName": u.FullName,
+		"name":        u.nameResource(),
+		"active":      u.Active,
+		scimSchemaEnterprise: map[string]any{
+			"employeeNumber": u.EmployeeID,
+			"division":       u.Division,
+			"department":     u.Department,
+		},
+		"meta": map[string]any{
+			"resourceType": "User",
+			"created":      u.CreatedAt.UTC().Format(time.RFC3339),
+			"lastModified": u.UpdatedAt.UTC().Format(time.RFC3339),
+			"location":     scimUsersPath + "/" + id,
+		},
+	}
+	if u.ExternalID != "" {
+		resource["externalId"] = u.ExternalID
+	}
+	if u.AvatarURL != "" {
+		resource["photos"] = []map[string]any{{"value": u.AvatarURL, "type": "photo", "primary": true}}
+	}
+	return resource
+}
+
+func (u *scimUser) nameResource() map[string]any {
+	name := map[string]any{"formatted": u.FullName}
+	if u.GivenName != "" {
+		name["givenName"] = u.GivenName
+	}
+	if u.FamilyName != "" {
+		name["familyName"] = u.FamilyName
+	}
+	return name
+}
+
+// applyAttributes sets every attribute of a SCIM resource (or of a pathless
+// PATCH value) on u. Keys may be plain attribute names, dotted sub-attribute
+// paths or schema-qualified enterprise attributes.
+func (u *scimUser) applyAttributes(attrs map[string]any) error {
+	for key, value := range attrs {
+		if strings.EqualFold(key, "schemas") || strings.EqualFold(key, "meta") || strings.EqualFold(key, "id") {
+			continue
+		}
+		if err := u.setAttribute(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAttribute applies a single attribute path. A nil value clears it.
+func (u *scimUser) setAttribute(path string, value any) error {
+	path = strings.TrimSpace(path)
+	lower := strings.ToLower(path)
+	enterprisePrefix := strings.ToLower(scimSchemaEnterprise)
+	if lower == enterprisePrefix {
+		attrs, ok := value.(map[string]any)
+		if value != nil && !ok {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "%s must be an object", scimSchemaEnterprise)
+		}
+		for key, sub := range attrs {
+			if err := u.setEnterpriseAttribute(key, sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if strings.HasPrefix(lower, enterprisePrefix+":") {
+		return u.setEnterpriseAttribute(path[len(enterprisePrefix)+1:], value)
+	}
+	if strings.HasPrefix(lower, strings.ToLower(scimSchemaUser)+":") {
+		path = path[len(scimSchemaUser)+1:]
+		lower = strings.ToLower(path)
+	}
+
+	switch {
+	case lower == "username":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		u.UserName = s
+	case lower == "externalid":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		u.ExternalID = s
+	case lower == "displayname":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		u.FullName = s
+		u.fullNameIsSet = s != ""
+	case lower == "name":
+		attrs, ok := value.(map[string]any)
+		if value != nil && !ok {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "name must be an object")
+		}
+		for key, sub := range attrs {
+			if err := u.setAttribute("name."+key, sub); err != nil {
+				return err
+			}
+		}
+	case lower == "name.formatted":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		if !u.fullNameIsSet {
+			u.FullName = s
+		}
+	case lower == "name.givenname":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		u.GivenName = s
+		u.namePartsChanged = true
+	case lower == "name.familyname":
+		s, err := scimString(path, value)
+		if err != nil {
+			return err
+		}
+		u.FamilyName = s
+		u.namePartsChanged = true
+	case lower == "active":
+		switch v := value.(type) {
+		case bool:
+			u.Active = v
+		case string:
+			// Some clients send booleans as strings in PATCH values.
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "active must be a boolean")
+			}
+			u.Active = parsed
+		default:
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "active must be a boolean")
+		}
+	case lower == "photos" || strings.HasPrefix(lower, "photos[") || strings.HasPrefix(lower, "photos."):
+		avatar, err := scimPhotoValue(value)
+		if err != nil {
+			return err
+		}
+		u.AvatarURL = avatar
+	default:
+		// Attributes the directory does not store (emails, phone numbers,
+		// addresses, ...) are accepted and ignored.
+	}
+	return nil
+}
+
+func (u *scimUser) setEnterpriseAttribute(name string, value any) error {
+	var target *string
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "employeenumber":
+		target = &u.EmployeeID
+	case "division":
+		target = &u.Division
+	case "department":
+		target = &u.Department
+	default:
+		return nil
+	}
+	s, err := scimString(name, value)
+	if err != nil {
+		return err
+	}
+	*target = s
+	return nil
+}
+
+// finish composes the full name from its parts when the request changed a
+// part but gave no display name, and fills defaults required by the
+// directory. The parts are stored, so a PATCH of one part keeps the other;
+// a user provisioned before that has only one part and keeps the full name.
+func (u *scimUser) finish() error {
+	if u.namePartsChanged && !u.fullNameIsSet {
+		switch {
+		case u.GivenName != "" && u.FamilyName != "":
+			u.FullName = u.GivenName + " " + u.FamilyName
+		case u.FullName == "":
+			u.FullName = strings.TrimSpace(u.GivenName + " " + u.FamilyName)
+		}
+	}
+	u.UserName = strings.TrimSpace(u.UserName)
+	if u.UserName == "" {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+	if u.EmployeeID == "" {
+		u.EmployeeID = u.UserName
+	}
+	// Employee ids with this prefix name service account principals.
+	if isServiceAccountPrincipal(u.EmployeeID) {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "employeeNumber must not start with %q", serviceAccountPrincipalPrefix)
+	}
+	if u.FullName == "" {
+		u.FullName = u.UserName
+	}
+	return nil
+}
+
+func scimString(name string, value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", newSCIMError(http.StatusBadRequest, "invalidValue", "%s must be a string", name)
+	}
+}
+
+// scimPhotoValue picks the avatar from a photos value: the primary photo, or
+// the first one when none is marked primary.
+func scimPhotoValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return strings.TrimSpace(v), nil
+	case map[string]any:
+		return scimString("photos.value", v["value"])
+	case []any:
+		avatar := ""
+		for i, item := range v {
+			photo, ok := item.(map[string]any)
+			if !ok {
+				return "", newSCIMError(http.StatusBadRequest, "invalidValue", "photos must be a list of objects")
+			}
+			url, err := scimString("photos.value", photo["value"])
+			if err != nil {
+				return "", err
+			}
+			if primary, _ := photo["primary"].(bool); primary {
+				return url, nil
+			}
+			if i == 0 {
+				avatar = url
+			}
+		}
+		return avatar, nil
+	default:
+		return "", newSCIMError(http.StatusBadRequest, "invalidValue", "photos must be a list")
+	}
+}
+
+// scimFilterClause is one "attr op value" comparison of a filter; clauses
+// of a filter are joined with "and".
+type scimFilterClause struct {
+	Column string
+	Op     string
+	Value  any
+}
+
+var scimFilterColumns = map[string]string{
+	"username":       "scim_user_name",
+	"externalid":     "scim_external_id",
+	"displayname":    "full_name",
+	"employeenumber": "employee_id",
+	"active":         "active",
+	"id":             "id",
+}
+
+// parseSCIMFilter parses the subset of RFC 7644 filters used by HR
+// connectors: comparisons on userName, externalId, displayName,
+// employeeNumber, id and active joined with "and".
+func parseSCIMFilter(filter string) ([]scimFilterClause, error) {
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	clauses := make([]scimFilterClause, 0, 1)
+	for i := 0; i < len(tokens); {
+		if len(clauses) > 0 {
+			if !strings.EqualFold(tokens[i].text, "and") || tokens[i].quoted {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "only \"and\" is supported between comparisons")
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "incomplete filter")
+		}
+		attr := strings.ToLower(tokens[i].text)
+		attr = strings.TrimPrefix(attr, strings.ToLower(scimSchemaEnterprise)+":")
+		attr = strings.TrimPrefix(attr, strings.ToLower(scimSchemaUser)+":")
+		column, ok := scimFilterColumns[attr]
+		if !ok || tokens[i].quoted {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "unsupported filter attribute %q", tokens[i].text)
+		}
+		op := strings.ToLower(tokens[i+1].text)
+		if op == "pr" {
+			clauses = append(clauses, scimFilterClause{Column: column, Op: op})
+			i += 2
+			continue
+		}
+		if i+2 >= len(tokens) {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "missing value for %s", tokens[i].text)
+		}
+		switch op {
+		case "eq", "ne", "co", "sw", "ew":
+		default:
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "unsupported filter operator %q", tokens[i+1].text)
+		}
+		valueToken := tokens[i+2]
+		var value any = valueToken.text
+		switch column {
+		case "active":
+			if valueToken.quoted || (op != "eq" && op != "ne") {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "active only supports eq/ne with true or false")
+			}
+			parsed, err := strconv.ParseBool(valueToken.text)
+			if err != nil {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "active must be compared with true or false")
+			}
+			value = parsed
+		case "id":
+			if op != "eq" && op != "ne" {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "id only supports eq/ne")
+			}
+			parsed, err := strconv.ParseInt(valueToken.text, 10, 64)
+			if err != nil {
+				// No user has a non-numeric id.
+				parsed = 0
+			}
+			value = parsed
+		default:
+			if !valueToken.quoted {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "%s must be compared with a quoted string", tokens[i].text)
+			}
+		}
+		clauses = append(clauses, scimFilterClause{Column: column, Op: op, Value: value})
+		i += 3
+	}
+	return clauses, nil
+}
+
+type scimFilterToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeSCIMFilter(filter string) ([]scimFilterToken, error) {
+	var tokens []scimFilterToken
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "grouping is not supported")
+		case c == '"':
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(filter) {
+				if filter[i] == '\\' && i+1 < len(filter) {
+					b.WriteByte(filter[i+1])
+					i += 2
+					continue
+				}
+				if filter[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				b.WriteByte(filter[i])
+				i++
+			}
+			if !closed {
+				return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "unterminated string in filter")
+			}
+			tokens = append(tokens, scimFilterToken{text: b.String(), quoted: true})
+		default:
+			start := i
+			for i < len(filter) && filter[i] != ' ' && filter[i] != '\t' && filter[i] != '"' {
+				i++
+			}
+			tokens = append(tokens, scimFilterToken{text: filter[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+var scimLikeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// scimFilterSQL renders clauses as a WHERE condition whose placeholders
+// start at $firstArg. userName and displayName compare case-insensitively as
+// RFC 7643 defines them with caseExact=false.
+func scimFilterSQL(clauses []scimFilterClause, firstArg int) (string, []any) {
+	if len(clauses) == 0 {
+		return "TRUE", nil
+	}
+	conditions := make([]string, 0, len(clauses))
+	args := make([]any, 0, len(clauses))
+	for _, clause := range clauses {
+		column := clause.Column
+		if clause.Op == "pr" {
+			switch column {
+			case "active", "id":
+				conditions = append(conditions, "TRUE")
+			default:
+				conditions = append(conditions, fmt.Sprintf("COALESCE(%s, '') <> ''", column))
+			}
+			continue
+		}
+		placeholder := fmt.Sprintf("$%d", firstArg+len(args))
+		if column == "active" || column == "id" {
+			sqlOp := "="
+			if clause.Op == "ne" {
+				sqlOp = "<>"
+			}
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", column, sqlOp, placeholder))
+			args = append(args, clause.Value)
+			continue
+		}
+		value, _ := clause.Value.(string)
+		expr := fmt.Sprintf("COALESCE(%s, '')", column)
+		if column == "scim_user_name" || column == "full_name" {
+			expr = "LOWER(" + expr + ")"
+			placeholder = "LOWER(" + placeholder + ")"
+		}
+		switch clause.Op {
+		case "eq":
+			conditions = append(conditions, fmt.Sprintf("%s = %s", expr, placeholder))
+			args = append(args, value)
+		case "ne":
+			conditions = append(conditions, fmt.Sprintf("%s <> %s", expr, placeholder))
+			args = append(args, value)
+		case "co":
+			conditions = append(conditions, fmt.Sprintf("%s LIKE %s", expr, placeholder))
+			args = append(args, "%"+scimLikeEscaper.Replace(value)+"%")
+		case "sw":
+			conditions = append(conditions, fmt.Sprintf("%s LIKE %s", expr, placeholder))
+			args = append(args, scimLikeEscaper.Replace(value)+"%")
+		case "ew":
+			conditions = append(conditions, fmt.Sprintf("%s LIKE %s", expr, placeholder))
+			args = append(args, "%"+scimLikeEscaper.Replace(value))
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+const scimUserColumns = `id, COALESCE(scim_user_name, ''), COALESCE(scim_external_id, ''),
+	COALESCE(employee_id, ''), COALESCE(full_name, ''), scim_given_name, scim_family_name, COALESCE(avatar_url, ''),
+	COALESCE(subdivision_level_1, ''), COALESCE(subdivision_level_2, ''),
+	active, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSCIMUser(row rowScanner) (scimUser, error) {
+	var u scimUser
+	err := row.Scan(&u.ID, &u.UserName, &u.ExternalID, &u.EmployeeID, &u.FullName, &u.GivenName, &u.FamilyName, &u.AvatarURL,
+		&u.Division, &u.Department, &u.Active, &u.CreatedAt, &u.UpdatedAt)
+	if u.UserName == "" {
+		// Users that only ever logged in are addressed by their employee id.
+		u.UserName = u.EmployeeID
+	}
+	return u, err
+}
+
+func (a *app) getSCIMUser(ctx context.Context, queryer rowQueryer, id int64) (scimUser, error) {
+	u, err := scanSCIMUser(queryer.QueryRowContext(ctx,
+		`SELECT `+scimUserColumns+` FROM users WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return scimUser{}, newSCIMError(http.StatusNotFound, "", "%s", errSCIMUserNotFound.Error())
+	}
+	return u, err
+}
+
+// checkSCIMUniqueness rejects a userName or employee id that already
+// belongs to another user.
+func checkSCIMUniqueness(ctx context.Context, queryer rowQueryer, u scimUser) error {
+	var conflict string
+	err := queryer.QueryRowContext(ctx,
+		`SELECT CASE WHEN LOWER(scim_user_name) = LOWER($2) AND scim_user_name <> '' THEN 'userName' ELSE 'employeeNumber' END
+		   FROM users
+		  WHERE id <> $1
+		    AND ((scim_user_name <> '' AND LOWER(scim_user_name) = LOWER($2)) OR employee_id = $3)
+		  LIMIT 1`,
+		u.ID, u.UserName, u.EmployeeID,
+	).Scan(&conflict)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return newSCIMError(http.StatusConflict, "uniqueness", "%s is already used by another user", conflict)
+}
+
+func (a *app) saveSCIMUser(ctx context.Context, tx *sql.Tx, u *scimUser) error {
+	if err := checkSCIMUniqueness(ctx, tx, *u); err != nil {
+		return err
+	}
+	return tx.QueryRowContext(ctx,
+		`UPDATE users
+		    SET scim_user_name = $2,
+		        scim_external_id = $3,
+		        employee_id = $4,
+		        full_name = $5,
+		        scim_given_name = $6,
+		        scim_family_name = $7,
+		        avatar_url = $8,
+		        subdivision_level_1 = $9,
+		        subdivision_level_2 = $10,
+		        active = $11,
+		        updated_at = now()
+		  WHERE id = $1
+		  RETURNING updated_at`,
+		u.ID, u.UserName, u.ExternalID, u.EmployeeID, u.FullName, u.GivenName, u.FamilyName, u.AvatarURL,
+		u.Division, u.Department, u.Active,
+	).Scan(&u.UpdatedAt)
+}
+
+// authenticateSCIMRequest checks the bearer API key and returns the request
+// carrying the service account as actor.
+func (a *app) authenticateSCIMRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	key := extractBearerToken(r.Header.Get("Authorization"))
+	if !isAPIKeyToken(key) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		respondSCIMError(w, newSCIMError(http.StatusUnauthorized, "", "API key is required"))
+		return nil, false
+	}
+	principal, err := a.authenticateAPIKey(r.Context(), key, clientIP(r))
+	if err != nil {
+		if errors.Is(err, errAPIKeyInvalid) {
+			err = newSCIMError(http.StatusUnauthorized, "", "%s", err.Error())
+		}
+		respondSCIMError(w, err)
+		return nil, false
+	}
+	if !principal.hasScope(scopeUsersProvision) {
+		respondSCIMError(w, newSCIMError(http.StatusForbidden, "", "%s: %s is required", errAPIKeyScopeDenied.Error(), scopeUsersProvision))
+		return nil, false
+	}
+	r = r.WithContext(withServiceAccountPrincipal(r.Context(), principal))
+	group := rateLimitGroupRead
+	if isMutatingMethod(r.Method) {
+		group = rateLimitGroupWrite
+	}
+	if !a.allowRequest(w, r, group, rateLimitKey(r)) {
+		return nil, false
+	}
+	return r, true
+}
+
+func (a *app) handleSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondSCIMError(w, newSCIMError(http.StatusMethodNotAllowed, "", "method not allowed"))
+		return
+	}
+	if _, ok := a.authenticateSCIMRequest(w, r); !ok {
+		return
+	}
+	respondSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scimSchemaSPConfig},
+		"patch":          map[string]any{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]any{"supported": false},
+		"sort":           map[string]any{"supported": false},
+		"etag":           map[string]any{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "API key",
+			"description": "Service account API key with the " + scopeUsersProvision + " scope",
+		}},
+	})
+}
+
+func (a *app) handleSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	r, ok := a.authenticateSCIMRequest(w, r)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		a.listSCIMUsers(w, r)
+	case http.MethodPost:
+		a.createSCIMUser(w, r)
+	default:
+		respondSCIMError(w, newSCIMError(http.StatusMethodNotAllowed, "", "method not allowed"))
+	}
+}
+
+func (a *app) handleSCIMUserSubroutes(w http.ResponseWriter, r *http.Request) {
+	r, ok := a.authenticateSCIMRequest(w, r)
+	if !ok {
+		return
+	}
+	id, suffix, err := parseIDFromPath(r.URL.Path, scimUsersPath+"/")
+	if err != nil || suffix != "" {
+		respondSCIMError(w, newSCIMError(http.StatusNotFound, "", "%s", errSCIMUserNotFound.Error()))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		u, err := a.getSCIMUser(r.Context(), a.db, id)
+		if err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+		respondSCIM(w, http.StatusOK, u.resource())
+	case http.MethodPut:
+		a.replaceSCIMUser(w, r, id)
+	case http.MethodPatch:
+		a.patchSCIMUser(w, r, id)
+	case http.MethodDelete:
+		a.deactivateSCIMUser(w, r, id)
+	default:
+		respondSCIMError(w, newSCIMError(http.StatusMethodNotAllowed, "", "method not allowed"))
+	}
+}
+
+func (a *app) listSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	clauses, err := parseSCIMFilter(query.Get("filter"))
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	startIndex := 1
+	if raw := strings.TrimSpace(query.Get("startIndex")); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 1 {
+			startIndex = parsed
+		}
+	}
+	count := scimDefaultCount
+	if raw := strings.TrimSpace(query.Get("count")); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed >= 0 {
+			count = parsed
+		}
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	where, args := scimFilterSQL(clauses, 1)
+	var total int
+	if err := a.db.QueryRowContext(r.Context(),
+		`SELECT COUNT(*) FROM users WHERE `+where, args...,
+	).Scan(&total); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+
+	resources := make([]map[string]any, 0)
+	if count > 0 {
+		pageArgs := append(args, count, startIndex-1)
+		rows, err := a.db.QueryContext(r.Context(),
+			`SELECT `+scimUserColumns+`
+			   FROM users
+			  WHERE `+where+`
+			  ORDER BY id
+			  LIMIT $`+strconv.Itoa(len(args)+1)+` OFFSET $`+strconv.Itoa(len(args)+2),
+			pageArgs...,
+		)
+		if err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			u, err := scanSCIMUser(rows)
+			if err != nil {
+				respondSCIMError(w, err)
+				return
+			}
+			resources = append(resources, u.resource())
+		}
+		if err := rows.Err(); err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+	}
+	respondSCIM(w, http.StatusOK, map[string]any{
+		"schemas":      []string{scimSchemaListResponse},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+func decodeSCIMBody(r *http.Request) (map[string]any, error) {
+	var attrs map[string]any
+	if err := decodeJSON(r, &attrs); err != nil || attrs == nil {
+		return nil, newSCIMError(http.StatusBadRequest, "invalidSyntax", "request body must be a JSON object")
+	}
+	return attrs, nil
+}
+
+func (a *app) createSCIMUser(w http.ResponseWriter, r *http.Request) {
+	attrs, err := decodeSCIMBody(r)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	u := scimUser{Active: true}
+	if err := u.applyAttributes(attrs); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if err := u.finish(); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// An employee who already logged in has a row without SCIM data: adopt
+	// it instead of creating a duplicate.
+	var existingID int64
+	var existingUserName string
+	err = tx.QueryRowContext(r.Context(),
+		`SELECT id, COALESCE(scim_user_name, '') FROM users WHERE employee_id = $1 ORDER BY id LIMIT 1`,
+		u.EmployeeID,
+	).Scan(&existingID, &existingUserName)
+	switch {
+	case err == nil && existingUserName == "":
+		u.ID = existingID
+	case err == nil:
+		respondSCIMError(w, newSCIMError(http.StatusConflict, "uniqueness", "employeeNumber is already used by another user"))
+		return
+	case !errors.Is(err, sql.ErrNoRows):
+		respondSCIMError(w, err)
+		return
+	default:
+		wbUserID, err := newSCIMPlaceholderWbUserID()
+		if err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+		role, err := defaultRoleForNewUser(tx)
+		if err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+		if err := tx.QueryRowContext(r.Context(),
+			`INSERT INTO users (wb_user_id, employee_id, role) VALUES ($1, $2, $3) RETURNING id`,
+			wbUserID, u.EmployeeID, role,
+		).Scan(&u.ID); err != nil {
+			respondSCIMError(w, err)
+			return
+		}
+	}
+	if err := a.saveSCIMUser(r.Context(), tx, &u); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	created, err := a.getSCIMUser(r.Context(), a.db, u.ID)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	a.logAuditEventFromRequest(r, auditActionCreate, auditEntityUser, created.ID, created.FullName, map[string]any{
+		"source":      scimAuditSourceDetails,
+		"employee_id": created.EmployeeID,
+		"user_name":   created.UserName,
+	})
+	w.Header().Set("Location", scimUsersPath+"/"+strconv.FormatInt(created.ID, 10))
+	respondSCIM(w, http.StatusCreated, created.resource())
+}
+
+func (a *app) replaceSCIMUser(w http.ResponseWriter, r *http.Request, id int64) {
+	attrs, err := decodeSCIMBody(r)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	a.updateSCIMUser(w, r, id, func(current scimUser) (scimUser, error) {
+		next := scimUser{ID: current.ID, Active: true, CreatedAt: current.CreatedAt}
+		if err := next.applyAttributes(attrs); err != nil {
+			return scimUser{}, err
+		}
+		return next, nil
+	})
+}
+
+type scimPatchRequest struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	} `json:"Operations"`
+}
+
+func (a *app) patchSCIMUser(w http.ResponseWriter, r *http.Request, id int64) {
+	var req scimPatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		respondSCIMError(w, newSCIMError(http.StatusBadRequest, "invalidSyntax", "request body must be a PatchOp message"))
+		return
+	}
+	if len(req.Operations) == 0 {
+		respondSCIMError(w, newSCIMError(http.StatusBadRequest, "invalidValue", "Operations must not be empty"))
+		return
+	}
+	a.updateSCIMUser(w, r, id, func(current scimUser) (scimUser, error) {
+		next := current
+		for _, op := range req.Operations {
+			if err := next.applyPatchOperation(op.Op, op.Path, op.Value); err != nil {
+				return scimUser{}, err
+			}
+		}
+		return next, nil
+	})
+}
+
+func (u *scimUser) applyPatchOperation(op, path string, value any) error {
+	path = strings.TrimSpace(path)
+	switch strings.ToLower(strings.TrimSpace(op)) {
+	case "add", "replace":
+		if path == "" {
+			attrs, ok := value.(map[string]any)
+			if !ok {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+			}
+			return u.applyAttributes(attrs)
+		}
+		return u.setAttribute(path, value)
+	case "remove":
+		if path == "" {
+			return newSCIMError(http.StatusBadRequest, "noTarget", "path is required for remove")
+		}
+		switch strings.ToLower(path) {
+		case "username", "active":
+			return newSCIMError(http.StatusBadRequest, "mutability", "%s cannot be removed", path)
+		}
+		return u.setAttribute(path, nil)
+	default:
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "unsupported patch op %q", op)
+	}
+}
+
+// updateSCIMUser loads the user, lets mutate produce its new state, stores
+// it and revokes the sessions of a user that got deactivated.
+func (a *app) updateSCIMUser(w http.ResponseWriter, r *http.Request, id int64, mutate func(scimUser) (scimUser, error)) {
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	current, err := a.getSCIMUser(r.Context(), tx, id)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	next, err := mutate(current)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if err := next.finish(); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if err := a.saveSCIMUser(r.Context(), tx, &next); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	a.afterSCIMUserChange(r, current, next)
+	respondSCIM(w, http.StatusOK, next.resource())
+}
+
+func (a *app) deactivateSCIMUser(w http.ResponseWriter, r *http.Request, id int64) {
+	current, err := a.getSCIMUser(r.Context(), a.db, id)
+	if err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	if _, err := a.db.ExecContext(r.Context(),
+		`UPDATE users SET active = FALSE, updated_at = now() WHERE id = $1`, id,
+	); err != nil {
+		respondSCIMError(w, err)
+		return
+	}
+	next := current
+	next.Active = false
+	a.afterSCIMUserChange(r, current, next)
+	respondSCIM(w, http.StatusNoContent, nil)
+}
+
+// afterSCIMUserChange revokes sessions of deactivated users and records the
+// change in the audit log.
+func (a *app) afterSCIMUserChange(r *http.Request, before, after scimUser) {
+	action := auditActionUpdate
+	if before.Active && !after.Active {
+		action = auditActionDeactivate
+		if revoked, err := a.revokeOtherTokenFamilies(r.Context(), after.EmployeeID, ""); err != nil {
+			log.Printf("scim: failed to revoke sessions of %s: %v", after.EmployeeID, err)
+		} else if revoked > 0 {
+			log.Printf("scim: revoked %d sessions of deactivated employee %s", revoked, after.EmployeeID)
+		}
+	}
+	details := map[string]any{
+		"source":      scimAuditSourceDetails,
+		"employee_id": after.EmployeeID,
+		"user_name":   after.UserName,
+	}
+	if before.Active != after.Active {
+		details["active"] = after.Active
+	}
+	a.logAuditEventFromRequest(r, action, auditEntityUser, after.ID, after.FullName, details)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	t.Parallel()
+
+	clauses, err := parseSCIMFilter(`userName eq "Ivanov.I" and active eq true and urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber sw "12"`)
+	if err != nil {
+		t.Fatalf("parseSCIMFilter: %v", err)
+	}
+	want := []scimFilterClause{
+		{Column: "scim_user_name", Op: "eq", Value: "Ivanov.I"},
+		{Column: "active", Op: "eq", Value: true},
+		{Column: "employee_id", Op: "sw", Value: "12"},
+	}
+	if !reflect.DeepEqual(clauses, want) {
+		t.Fatalf("clauses = %#v; want %#v", clauses, want)
+	}
+
+	where, args := scimFilterSQL(clauses, 1)
+	wantWhere := "LOWER(COALESCE(scim_user_name, '')) = LOWER($1) AND active = $2 AND COALESCE(employee_id, '') LIKE $3"
+	if where != wantWhere {
+		t.Errorf("where = %q; want %q", where, wantWhere)
+	}
+	if !reflect.DeepEqual(args, []any{"Ivanov.I", true, "12%"}) {
+		t.Errorf("args = %#v", args)
+	}
+
+	for _, bad := range []string{
+		`userName eq "a" or userName eq "b"`,
+		`emails eq "a@example.com"`,
+		`userName gt "a"`,
+		`userName eq "unterminated`,
+		`active eq "true"`,
+		`(userName eq "a")`,
+	} {
+		if _, err := parseSCIMFilter(bad); err == nil {
+			t.Errorf("parseSCIMFilter(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestSCIMFilterSQLEscapesLikePatterns(t *testing.T) {
+	t.Parallel()
+
+	_, args := scimFilterSQL([]scimFilterClause{{Column: "full_name", Op: "co", Value: `50%_off\`}}, 3)
+	if want := `%50\%\_off\\%`; args[0] != want {
+		t.Errorf("pattern = %q; want %q", args[0], want)
+	}
+}
+
+func TestSCIMUserAttributeMapping(t *testing.T) {
+	t.Parallel()
+
+	var attrs map[string]any
+	body := `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "petrov.p",
+		"externalId": "hr-42",
+		"name": {"givenName": "Пётр", "familyName": "Петров"},
+		"active": true,
+		"photos": [{"value": "https://cdn/a.png"}, {"value": "https://cdn/b.png", "primary": true}],
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {
+			"employeeNumber": "100500",
+			"division": "Логистика",
+			"department": "Склад"
+		}
+	}`
+	if err := json.Unmarshal([]byte(body), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	u := scimUser{}
+	if err := u.applyAttributes(attrs); err != nil {
+		t.Fatalf("applyAttributes: %v", err)
+	}
+	if err := u.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if u.UserName != "petrov.p" || u.ExternalID != "hr-42" || u.EmployeeID != "100500" {
+		t.Errorf("identifiers = %q, %q, %q", u.UserName, u.ExternalID, u.EmployeeID)
+	}
+	if u.FullName != "Пётр Петров" {
+		t.Errorf("FullName = %q", u.FullName)
+	}
+	if u.AvatarURL != "https://cdn/b.png" {
+		t.Errorf("AvatarURL = %q; want the primary photo", u.AvatarURL)
+	}
+	if u.Division != "Логистика" || u.Department != "Склад" || !u.Active {
+		t.Errorf("Division = %q, Department = %q, Active = %v", u.Division, u.Department, u.Active)
+	}
+}
+
+func TestSCIMUserPatchOperations(t *testing.T) {
+	t.Parallel()
+
+	u := scimUser{UserName: "sidorov", EmployeeID: "7", FullName: "Сидоров", Division: "ИТ", Active: true}
+	ops := []struct {
+		op, path string
+		value    any
+	}{
+		{"Replace", "", map[string]any{"active": "False", "displayName": "Сидоров С."}},
+		{"add", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "Поддержка"},
+		{"remove", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:division", nil},
+	}
+	for _, op := range ops {
+		if err := u.applyPatchOperation(op.op, op.path, op.value); err != nil {
+			t.Fatalf("applyPatchOperation(%s %s): %v", op.op, op.path, err)
+		}
+	}
+	if err := u.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if u.Active || u.FullName != "Сидоров С." || u.Department != "Поддержка" || u.Division != "" {
+		t.Errorf("patched user = %+v", u)
+	}
+	if err := u.applyPatchOperation("remove", "userName", nil); err == nil {
+		t.Error("removing userName succeeded; want mutability error")
+	}
+}
+
+func TestSCIMUserNamePartPatch(t *testing.T) {
+	t.Parallel()
+
+	u := scimUser{UserName: "petrov.p", EmployeeID: "100500", FullName: "Пётр Петров", GivenName: "Пётр", FamilyName: "Петров", Active: true}
+	if err := u.applyPatchOperation("replace", "name.givenName", "Павел"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if u.FullName != "Павел Петров" {
+		t.Errorf("FullName = %q, want the stored family name kept", u.FullName)
+	}
+
+	// Provisioned before the parts were stored: only one part is known.
+	legacy := scimUser{UserName: "sidorov", EmployeeID: "7", FullName: "Сидор Сидоров", Active: true}
+	if err := legacy.applyPatchOperation("replace", "name.givenName", "Семён"); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.FullName != "Сидор Сидоров" {
+		t.Errorf("FullName = %q, want it unchanged", legacy.FullName)
+	}
+}
+
+func TestSCIMUserRejectsServiceAccountEmployeeNumber(t *testing.T) {
+	t.Parallel()
+
+	for _, u := range []scimUser{
+		{UserName: "bot", EmployeeID: "svc:1"},
+		{UserName: "svc:2"},
+	} {
+		if err := u.finish(); err == nil {
+			t.Errorf("finish(%q, %q) succeeded; want invalidValue", u.UserName, u.EmployeeID)
+		}
+	}
+}
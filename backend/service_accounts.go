@@ -0,0 +1,778 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Service accounts let scripts and BI jobs call the API with an
+// "Authorization: Bearer ofk_..." header. A service account acts as the
+// principal "svc:<id>": its role comes from service_accounts.role and the
+// existing permission checks (roles, building/floor responsibility) apply to
+// it like to any employee. Key scopes additionally limit which endpoints a
+// key may call at all; anything not covered by a scope is rejected. A key
+// may also be limited to one building: it then holds no permission checked
+// without a building and acts only on objects inside that building.
+const (
+	serviceAccountPrincipalPrefix = "svc:"
+	apiKeyPrefix                  = "ofk_"
+	adminServiceAccountsPath      = "/api/admin/service-accounts"
+
+	auditEntityServiceAccount = "service_account"
+
+	defaultAPIKeyTTLDays = 90
+	maxAPIKeyTTLDays     = 730
+)
+
+const (
+	scopeDirectoryRead   = "directory:read"
+	scopeDirectoryManage = "directory:manage"
+	scopeDesksManage     = "desks:manage"
+	scopeBookingsRead    = "bookings:read"
+	scopeBookingsWrite   = "bookings:write"
+	scopeUsersRead       = "users:read"
+	scopeAuditRead       = "audit:read"
+	scopeUsersProvision  = "users:provision"
+)
+
+var apiKeyScopes = map[string]string{
+	scopeDirectoryRead:   "Чтение зданий, этажей, пространств и ресурсов",
+	scopeDirectoryManage: "Изменение зданий, этажей, пространств, зон и ресурсов",
+	scopeDesksManage:     "Изменение столов",
+	scopeBookingsRead:    "Чтение бронирований",
+	scopeBookingsWrite:   "Создание и отмена бронирований",
+	scopeUsersRead:       "Чтение сотрудников и ответственных",
+	scopeAuditRead:       "Чтение журнала аудита",
+	scopeUsersProvision:  "Провижининг сотрудников через SCIM (/scim/v2)",
+}
+
+var (
+	errAPIKeyInvalid          = errors.New("invalid or expired API key")
+	errAPIKeyScopeDenied      = errors.New("API key scope does not allow this request")
+	errServiceAccountNotFound = errors.New("service account not found")
+)
+
+const serviceAccountCtxKey contextKey = "serviceAccount"
+
+type serviceAccountPrincipal struct {
+	AccountID int64
+	KeyID     int64
+	Name      string
+	Scopes    []string
+	// BuildingID is the building the key is limited to, 0 for none.
+	BuildingID int64
+}
+
+func serviceAccountFromContext(ctx context.Context) *serviceAccountPrincipal {
+	if principal, ok := ctx.Value(serviceAccountCtxKey).(*serviceAccountPrincipal); ok {
+		return principal
+	}
+	return nil
+}
+
+func serviceAccountPrincipalID(accountID int64) string {
+	return fmt.Sprintf("%s%d", serviceAccountPrincipalPrefix, accountID)
+}
+
+func isServiceAccountPrincipal(employeeID string) bool {
+	return strings.HasPrefix(strings.TrimSpace(employeeID), serviceAccountPrincipalPrefix)
+}
+
+type serviceAccount struct {
+	ID          int64           `json:"id"`
+	Principal   string          `json:"principal"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Role        int             `json:"role"`
+	CreatedBy   string          `json:"created_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DisabledAt  *time.Time      `json:"disabled_at,omitempty"`
+	Keys        []serviceAPIKey `json:"keys"`
+}
+
+type serviceAPIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	BuildingID *int64     `json:"building_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+func ensureServiceAccountsStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS service_accounts (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			role INTEGER NOT NULL DEFAULT 1,
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			disabled_at TIMESTAMPTZ
+		);`,
+		`CREATE TABLE IF NOT EXISTS service_account_keys (
+			id BIGSERIAL PRIMARY KEY,
+			service_account_id BIGINT NOT NULL REFERENCES service_accounts(id) ON DELETE CASCADE,
+			name TEXT NOT NULL DEFAULT '',
+			key_prefix TEXT NOT NULL UNIQUE,
+			key_hash TEXT NOT NULL,
+			scopes JSONB NOT NULL DEFAULT '[]'::jsonb,
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			last_used_at TIMESTAMPTZ,
+			last_used_ip TEXT NOT NULL DEFAULT '',
+			revoked_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS service_account_keys_account_idx ON service_account_keys (service_account_id);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateServiceAccountKeyBuildingUp(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx,
+		`ALTER TABLE service_account_keys ADD COLUMN IF NOT EXISTS building_id BIGINT REFERENCES office_buildings(id) ON DELETE CASCADE`,
+	)
+	return err
+}
+
+func migrateServiceAccountKeyBuildingDown(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE service_account_keys DROP COLUMN IF EXISTS building_id`)
+	return err
+}
+
+// normalizeAPIKeyScopes validates, de-duplicates and sorts scopes.
+func normalizeAPIKeyScopes(raw []string) ([]string, error) {
+	seen := make(map[string]bool, len(raw))
+	scopes := make([]string, 0, len(raw))
+	for _, scope := range raw {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if _, ok := apiKeyScopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		seen[scope] = true
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
+
+func hasAPIPathPrefix(path, base string) bool {
+	return path == base || strings.HasPrefix(path, base+"/")
+}
+
+// requiredAPIKeyScope maps a request to the scope an API key needs for it.
+// ok is false for endpoints that API keys may never call: authentication,
+// sessions, role assignment and the admin area apart from the audit log.
+func requiredAPIKeyScope(method, path string) (scope string, ok bool) {
+	read := method == http.MethodGet || method == http.MethodHead
+	switch {
+	case hasAPIPathPrefix(path, "/api/bookings"),
+		hasAPIPathPrefix(path, "/api/meeting-room-bookings"),
+		hasAPIPathPrefix(path, "/api/resource-bookings"),
+		strings.HasPrefix(path, "/api/resources/") && strings.HasSuffix(path, "/bookings"):
+		if read {
+			return scopeBookingsRead, true
+		}
+		return scopeBookingsWrite, true
+	case hasAPIPathPrefix(path, "/api/desks"):
+		if read {
+			return scopeDirectoryRead, true
+		}
+		return scopeDesksManage, true
+	case hasAPIPathPrefix(path, "/api/buildings"),
+		hasAPIPathPrefix(path, "/api/floors"),
+		hasAPIPathPrefix(path, "/api/spaces"),
+		hasAPIPathPrefix(path, "/api/zones"),
+		hasAPIPathPrefix(path, "/api/resources"),
+		hasAPIPathPrefix(path, "/api/meeting-rooms"):
+		if read {
+			return scopeDirectoryRead, true
+		}
+		return scopeDirectoryManage, true
+	case (path == "/api/users" || path == "/api/responsibilities") && read:
+		return scopeUsersRead, true
+	case path == "/api/admin/logs" && read:
+		return scopeAuditRead, true
+	}
+	return "", false
+}
+
+func isAPIKeyToken(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// generateAPIKey returns a new key "ofk_<prefix>_<secret>" and its prefix.
+// The prefix is stored in clear for lookup and display; the key itself only
+// as a peppered hash.
+func generateAPIKey() (key, prefix string, err error) {
+	prefixBytes := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(prefixBytes)
+	return apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// authenticateAPIKey resolves an API key to its service account and records
+// when and from where it was last used.
+func (a *app) authenticateAPIKey(ctx context.Context, key, ip string) (*serviceAccountPrincipal, error) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return nil, errAPIKeyInvalid
+	}
+	var (
+		principal  serviceAccountPrincipal
+		storedHash string
+		scopesJSON []byte
+	)
+	err := a.db.QueryRowContext(ctx,
+		`SELECT k.id, k.key_hash, k.scopes, COALESCE(k.building_id, 0), a.id, a.name
+		   FROM service_account_keys k
+		   JOIN service_accounts a ON a.id = k.service_account_id
+		  WHERE k.key_prefix = $1
+		    AND k.revoked_at IS NULL
+		    AND k.expires_at > now()
+		    AND a.disabled_at IS NULL`,
+		prefix,
+	).Scan(&principal.KeyID, &storedHash, &scopesJSON, &principal.BuildingID, &principal.AccountID, &principal.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errAPIKeyInvalid
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashTokenID(key, a.refreshTokenPepper)), []byte(storedHash)) != 1 {
+		return nil, errAPIKeyInvalid
+	}
+	if err := json.Unmarshal(scopesJSON, &principal.Scopes); err != nil {
+		return nil, err
+	}
+	// Throttled so that busy jobs do not turn every request into a write.
+	if _, err := a.db.ExecContext(ctx,
+		`UPDATE service_account_keys
+		    SET last_used_at = now(), last_used_ip = $2
+		  WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute' OR last_used_ip <> $2)`,
+		principal.KeyID, ip,
+	); err != nil {
+		log.Printf("api key: failed to record last use of key %d: %v", principal.KeyID, err)
+	}
+	return &principal, nil
+}
+
+func (p *serviceAccountPrincipal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// serveAPIKeyRequest authenticates a request carrying an API key and passes
+// it on as the service account principal.
+func (a *app) serveAPIKeyRequest(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	scope, allowed := requiredAPIKeyScope(r.Method, r.URL.Path)
+	if !allowed {
+		respondError(w, http.StatusForbidden, errAPIKeyScopeDenied.Error())
+		return
+	}
+	principal, err := a.authenticateAPIKey(r.Context(), key, clientIP(r))
+	if err != nil {
+		if !errors.Is(err, errAPIKeyInvalid) {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if !principal.hasScope(scope) {
+		respondError(w, http.StatusForbidden, fmt.Sprintf("%s: %s is required", errAPIKeyScopeDenied.Error(), scope))
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(withServiceAccountPrincipal(r.Context(), principal)))
+}
+
+// apiKeyBuildingLimit returns the building the request's API key is limited
+// to, or 0 when the request does not come with a building-limited key.
+func apiKeyBuildingLimit(r *http.Request) int64 {
+	if r == nil {
+		return 0
+	}
+	if principal := serviceAccountFromContext(r.Context()); principal != nil {
+		return principal.BuildingID
+	}
+	return 0
+}
+
+// hasRequestPermission is hasPermission for the caller of r. A key limited
+// to one building holds no permission that is checked without a building,
+// whatever the role of its service account.
+func hasRequestPermission(r *http.Request, role int, required permission) bool {
+	return apiKeyBuildingLimit(r) == 0 && hasPermission(role, required)
+}
+
+// canRequestActInBuilding is canActInBuilding for the caller of r; a
+// building-limited key acts only inside its own building.
+func canRequestActInBuilding(r *http.Request, role int, required permission, eid string, buildingID int64, responsibleIDs ...string) bool {
+	if limit := apiKeyBuildingLimit(r); limit != 0 && limit != buildingID {
+		return false
+	}
+	return canActInBuilding(role, required, eid, buildingID, responsibleIDs...)
+}
+
+// ensureServiceAccountRole applies the role assignment rule to service
+// accounts: a key acts with the role of its account, so creating, changing
+// or minting keys for an account is as good as holding that role.
+func ensureServiceAccountRole(w http.ResponseWriter, r *http.Request, queryer rowQueryer, current, next int) bool {
+	requesterRole, err := resolveRoleFromRequestFresh(r, queryer)
+	if err != nil {
+		respondRoleResolutionError(w, err)
+		return false
+	}
+	if err := checkRoleAssignment(requesterRole, current, next); err != nil {
+		respondError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// withServiceAccountPrincipal makes the service account the request actor.
+func withServiceAccountPrincipal(ctx context.Context, principal *serviceAccountPrincipal) context.Context {
+	principalID := serviceAccountPrincipalID(principal.AccountID)
+	ctx = context.WithValue(ctx, authClaimsCtxKey, authClaims{
+		WbUserID:   principalID,
+		EmployeeID: principalID,
+		UserName:   principal.Name,
+	})
+	return context.WithValue(ctx, serviceAccountCtxKey, principal)
+}
+
+// getServiceAccountRole returns the role a service account principal acts with.
+func getServiceAccountRole(ctx context.Context, queryer rowQueryer, principal string) (int, error) {
+	var accountID int64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(principal, serviceAccountPrincipalPrefix), "%d", &accountID); err != nil {
+		return roleEmployee, nil
+	}
+	var role int
+	err := queryer.QueryRowContext(ctx,
+		`SELECT role FROM service_accounts WHERE id = $1 AND disabled_at IS NULL`,
+		accountID,
+	).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return roleEmployee, nil
+		}
+		return roleEmployee, err
+	}
+	if !isValidRole(role) {
+		return roleEmployee, nil
+	}
+	return role, nil
+}
+
+func (a *app) listServiceAccounts(ctx context.Context, onlyID int64) ([]serviceAccount, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id, name, description, role, created_by, created_at, disabled_at
+		   FROM service_accounts
+		  WHERE $1 = 0 OR id = $1
+		  ORDER BY name, id`,
+		onlyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accounts := make([]serviceAccount, 0)
+	index := make(map[int64]int)
+	for rows.Next() {
+		var (
+			account    serviceAccount
+			disabledAt sql.NullTime
+		)
+		if err := rows.Scan(&account.ID, &account.Name, &account.Description, &account.Role, &account.CreatedBy, &account.CreatedAt, &disabledAt); err != nil {
+			return nil, err
+		}
+		if disabledAt.Valid {
+			account.DisabledAt = &disabledAt.Time
+		}
+		account.Principal = serviceAccountPrincipalID(account.ID)
+		account.Keys = make([]serviceAPIKey, 0)
+		index[account.ID] = len(accounts)
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keyRows, err := a.db.QueryContext(ctx,
+		`SELECT id, service_account_id, name, key_prefix, scopes, building_id, created_at, expires_at, last_used_at, last_used_ip, revoked_at
+		   FROM service_account_keys
+		  WHERE $1 = 0 OR service_account_id = $1
+		  ORDER BY created_at DESC`,
+		onlyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer keyRows.Close()
+	for keyRows.Next() {
+		var (
+			key        serviceAPIKey
+			accountID  int64
+			scopesJSON []byte
+			buildingID sql.NullInt64
+			lastUsedAt sql.NullTime
+			revokedAt  sql.NullTime
+		)
+		if err := keyRows.Scan(&key.ID, &accountID, &key.Name, &key.Prefix, &scopesJSON, &buildingID, &key.CreatedAt, &key.ExpiresAt, &lastUsedAt, &key.LastUsedIP, &revokedAt); err != nil {
+			return nil, err
+		}
+		if buildingID.Valid {
+			key.BuildingID = &buildingID.Int64
+		}
+		if err := json.Unmarshal(scopesJSON, &key.Scopes); err != nil {
+			return nil, err
+		}
+		if lastUsedAt.Valid {
+			key.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		if i, ok := index[accountID]; ok {
+			accounts[i].Keys = append(accounts[i].Keys, key)
+		}
+	}
+	return accounts, keyRows.Err()
+}
+
+func (a *app) getServiceAccount(ctx context.Context, id int64) (serviceAccount, error) {
+	accounts, err := a.listServiceAccounts(ctx, id)
+	if err != nil {
+		return serviceAccount{}, err
+	}
+	if len(accounts) == 0 {
+		return serviceAccount{}, errServiceAccountNotFound
+	}
+	return accounts[0], nil
+}
+
+type serviceAccountPayload struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Role        *int    `json:"role"`
+	Disabled    *bool   `json:"disabled"`
+}
+
+// handleAdminServiceAccounts lists and creates service accounts.
+// GET  /api/admin/service-accounts
+// POST /api/admin/service-accounts  {"name", "description", "role"}
+func (a *app) handleAdminServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermission(w, r, a.db, permissionManageServiceAccounts) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		accounts, err := a.listServiceAccounts(r.Context(), 0)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"service_accounts": accounts, "scopes": apiKeyScopes})
+	case http.MethodPost:
+		var payload serviceAccountPayload
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		name := ""
+		if payload.Name != nil {
+			name = strings.TrimSpace(*payload.Name)
+		}
+		if name == "" {
+			respondError(w, http.StatusBadRequest, "name is required")
+			return
+		}
+		role := roleEmployee
+		if payload.Role != nil {
+			role = *payload.Role
+		}
+		if !isValidRole(role) {
+			respondError(w, http.StatusBadRequest, "invalid role")
+			return
+		}
+		if !ensureServiceAccountRole(w, r, a.db, role, role) {
+			return
+		}
+		description := ""
+		if payload.Description != nil {
+			description = strings.TrimSpace(*payload.Description)
+		}
+		var id int64
+		if err := a.db.QueryRowContext(r.Context(),
+			`INSERT INTO service_accounts (name, description, role, created_by) VALUES ($1, $2, $3, $4) RETURNING id`,
+			name, description, role, a.requestActorEmployeeID(r),
+		).Scan(&id); err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		account, err := a.getServiceAccount(r.Context(), id)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		a.logAuditEventFromRequest(r, auditActionCreate, auditEntityServiceAccount, id, name, map[string]any{
+			"role":        role,
+			"entity_path": "Сервисные аккаунты · " + name,
+		})
+		respondJSON(w, http.StatusCreated, account)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminServiceAccountSubroutes manages one service account and its keys.
+// GET/PUT/DELETE /api/admin/service-accounts/{id}
+// POST           /api/admin/service-accounts/{id}/keys  {"name", "scopes", "building_id", "expires_in_days"}
+// DELETE         /api/admin/service-accounts/{id}/keys/{key_id}
+func (a *app) handleAdminServiceAccountSubroutes(w http.ResponseWriter, r *http.Request) {
+	id, suffix, err := parseIDFromPath(r.URL.Path, adminServiceAccountsPath+"/")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionManageServiceAccounts) {
+		return
+	}
+	account, err := a.getServiceAccount(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, errServiceAccountNotFound) {
+			respondError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	auditPath := "Сервисные аккаунты · " + account.Name
+	if r.Method != http.MethodGet && !ensureServiceAccountRole(w, r, a.db, account.Role, account.Role) {
+		return
+	}
+
+	switch {
+	case suffix == "" && r.Method == http.MethodGet:
+		respondJSON(w, http.StatusOK, account)
+	case suffix == "" && r.Method == http.MethodPut:
+		var payload serviceAccountPayload
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		changes := map[string]any{}
+		if payload.Name != nil {
+			name := strings.TrimSpace(*payload.Name)
+			if name == "" {
+				respondError(w, http.StatusBadRequest, "name is required")
+				return
+			}
+			account.Name = name
+			changes["name"] = name
+		}
+		if payload.Description != nil {
+			account.Description = strings.TrimSpace(*payload.Description)
+			changes["description"] = account.Description
+		}
+		if payload.Role != nil {
+			if !isValidRole(*payload.Role) {
+				respondError(w, http.StatusBadRequest, "invalid role")
+				return
+			}
+			if !ensureServiceAccountRole(w, r, a.db, account.Role, *payload.Role) {
+				return
+			}
+			account.Role = *payload.Role
+			changes["role"] = account.Role
+		}
+		if payload.Disabled != nil {
+			changes["disabled"] = *payload.Disabled
+		}
+		if _, err := a.db.ExecContext(r.Context(),
+			`UPDATE service_accounts
+			    SET name = $2, description = $3, role = $4,
+			        disabled_at = CASE WHEN $5::boolean IS NULL THEN disabled_at
+			                           WHEN $5::boolean THEN COALESCE(disabled_at, now())
+			                           ELSE NULL END
+			  WHERE id = $1`,
+			id, account.Name, account.Description, account.Role, payload.Disabled,
+		); err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		updated, err := a.getServiceAccount(r.Context(), id)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		changes["entity_path"] = "Сервисные аккаунты · " + updated.Name
+		a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityServiceAccount, id, updated.Name, changes)
+		respondJSON(w, http.StatusOK, updated)
+	case suffix == "" && r.Method == http.MethodDelete:
+		if _, err := a.db.ExecContext(r.Context(), `DELETE FROM service_accounts WHERE id = $1`, id); err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		a.logAuditEventFromRequest(r, auditActionDelete, auditEntityServiceAccount, id, account.Name, map[string]any{
+			"entity_path": auditPath,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	case suffix == "/keys" && r.Method == http.MethodPost:
+		var payload struct {
+			Name          string   `json:"name"`
+			Scopes        []string `json:"scopes"`
+			BuildingID    *int64   `json:"building_id"`
+			ExpiresInDays *int     `json:"expires_in_days"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		scopes, err := normalizeAPIKeyScopes(payload.Scopes)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if payload.BuildingID != nil && *payload.BuildingID <= 0 {
+			payload.BuildingID = nil
+		}
+		if payload.BuildingID != nil {
+			if _, err := a.getBuilding(*payload.BuildingID); err != nil {
+				if errors.Is(err, errNotFound) {
+					respondError(w, http.StatusBadRequest, "building not found")
+					return
+				}
+				log.Printf("internal error: %v", err)
+				respondError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+		}
+		days := defaultAPIKeyTTLDays
+		if payload.ExpiresInDays != nil {
+			days = *payload.ExpiresInDays
+		}
+		if days < 1 || days > maxAPIKeyTTLDays {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_days must be between 1 and %d", maxAPIKeyTTLDays))
+			return
+		}
+		key, prefix, err := generateAPIKey()
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		scopesJSON, _ := json.Marshal(scopes)
+		var created serviceAPIKey
+		if err := a.db.QueryRowContext(r.Context(),
+			`INSERT INTO service_account_keys (service_account_id, name, key_prefix, key_hash, scopes, building_id, created_by, expires_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, now() + make_interval(days => $8))
+			 RETURNING id, name, key_prefix, created_at, expires_at`,
+			id, strings.TrimSpace(payload.Name), prefix, hashTokenID(key, a.refreshTokenPepper), string(scopesJSON), payload.BuildingID, a.requestActorEmployeeID(r), days,
+		).Scan(&created.ID, &created.Name, &created.Prefix, &created.CreatedAt, &created.ExpiresAt); err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		created.Scopes = scopes
+		created.BuildingID = payload.BuildingID
+		a.logAuditEventFromRequest(r, auditActionCreate, auditEntityServiceAccount, id, account.Name, map[string]any{
+			"api_key_id":     created.ID,
+			"api_key_prefix": prefix,
+			"scopes":         scopes,
+			"building_id":    formatOptionalID(payload.BuildingID),
+			"expires_at":     created.ExpiresAt,
+			"entity_path":    auditPath,
+		})
+		// The key is shown exactly once; only its hash is stored.
+		respondJSON(w, http.StatusCreated, map[string]any{"key": key, "api_key": created})
+	case strings.HasPrefix(suffix, "/keys/") && r.Method == http.MethodDelete:
+		keyID, _, err := parseIDFromPath(suffix, "/keys/")
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		result, err := a.db.ExecContext(r.Context(),
+			`UPDATE service_account_keys SET revoked_at = now()
+			  WHERE id = $1 AND service_account_id = $2 AND revoked_at IS NULL`,
+			keyID, id,
+		)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondError(w, http.StatusNotFound, "api key not found")
+			return
+		}
+		a.logAuditEventFromRequest(r, auditActionRevoke, auditEntityServiceAccount, id, account.Name, map[string]any{
+			"api_key_id":  keyID,
+			"entity_path": auditPath,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
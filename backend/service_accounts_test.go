@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiredAPIKeyScope(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		method, path string
+		scope        string
+		ok           bool
+	}{
+		{http.MethodGet, "/api/bookings", scopeBookingsRead, true},
+		{http.MethodPost, "/api/bookings", scopeBookingsWrite, true},
+		{http.MethodDelete, "/api/meeting-room-bookings/5", scopeBookingsWrite, true},
+		{http.MethodGet, "/api/resources/3/bookings", scopeBookingsRead, true},
+		{http.MethodPost, "/api/resources/3/bookings", scopeBookingsWrite, true},
+		{http.MethodPut, "/api/resources/3", scopeDirectoryManage, true},
+		{http.MethodGet, "/api/desks/7", scopeDirectoryRead, true},
+		{http.MethodPut, "/api/desks/7", scopeDesksManage, true},
+		{http.MethodPost, "/api/buildings", scopeDirectoryManage, true},
+		{http.MethodGet, "/api/users", scopeUsersRead, true},
+		{http.MethodGet, "/api/admin/logs", scopeAuditRead, true},
+		{http.MethodPut, "/api/users/role", "", false},
+		{http.MethodGet, adminServiceAccountsPath, "", false},
+		{http.MethodGet, adminSessionsPath, "", false},
+		{http.MethodGet, "/api/buildingsX", "", false},
+	}
+	for _, tc := range cases {
+		scope, ok := requiredAPIKeyScope(tc.method, tc.path)
+		if scope != tc.scope || ok != tc.ok {
+			t.Errorf("requiredAPIKeyScope(%s %s) = %q, %v; want %q, %v", tc.method, tc.path, scope, ok, tc.scope, tc.ok)
+		}
+	}
+}
+
+func TestAPIKeyFormat(t *testing.T) {
+	t.Parallel()
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey: %v", err)
+	}
+	if !isAPIKeyToken(key) {
+		t.Fatalf("expected %q to look like an API key", key)
+	}
+	if got, ok := parseAPIKeyPrefix(key); !ok || got != prefix {
+		t.Fatalf("parseAPIKeyPrefix(%q) = %q, %v; want %q", key, got, ok, prefix)
+	}
+	for _, bad := range []string{"ofk_", "ofk_zzzzzzzzzzzz_secret", "ofk_0123456789ab_", "eyJhbGciOi.x.y"} {
+		if _, ok := parseAPIKeyPrefix(bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := normalizeAPIKeyScopes([]string{"bookings:read", "everything"}); err == nil {
+		t.Error("expected unknown scope to be rejected")
+	}
+	scopes, err := normalizeAPIKeyScopes([]string{" Bookings:Write", "bookings:read", "bookings:read"})
+	if err != nil || len(scopes) != 2 || scopes[0] != scopeBookingsRead {
+		t.Errorf("unexpected normalized scopes %v, %v", scopes, err)
+	}
+}
+
+func TestAPIKeyCannotReachUnscopedEndpoints(t *testing.T) {
+	t.Parallel()
+
+	a := &app{officeTokenKeys: newLegacyHS256KeyManager([]byte("secret"))}
+	handler := a.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	key, _, err := generateAPIKey()
+	if err != nil {
+		t.Fatalf("generateAPIKey: %v", err)
+	}
+	// Rejected before any key lookup, so no database is needed.
+	req := httptest.NewRequest(http.MethodGet, adminServiceAccountsPath, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an API key on an admin endpoint, got %d", rec.Code)
+	}
+}
+
+func TestAPIKeyBuildingLimit(t *testing.T) {
+	t.Parallel()
+
+	request := func(buildingID int64) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, "/api/desks/1", nil)
+		if buildingID < 0 {
+			return req
+		}
+		ctx := withServiceAccountPrincipal(context.Background(), &serviceAccountPrincipal{AccountID: 1, BuildingID: buildingID})
+		return req.WithContext(ctx)
+	}
+	tests := []struct {
+		name         string
+		limit        int64
+		wantGlobal   bool
+		wantBuilding bool
+		wantOther    bool
+	}{
+		{name: "employee session", limit: -1, wantGlobal: true, wantBuilding: true, wantOther: true},
+		{name: "unlimited key", limit: 0, wantGlobal: true, wantBuilding: true, wantOther: true},
+		{name: "building key", limit: 7, wantGlobal: false, wantBuilding: true, wantOther: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := request(tt.limit)
+			if got := hasRequestPermission(r, roleAdmin, permissionManageLayout); got != tt.wantGlobal {
+				t.Errorf("hasRequestPermission = %v, want %v", got, tt.wantGlobal)
+			}
+			if got := canRequestActInBuilding(r, roleAdmin, permissionManageLayout, "svc:1", 7); got != tt.wantBuilding {
+				t.Errorf("canRequestActInBuilding(own building) = %v, want %v", got, tt.wantBuilding)
+			}
+			if got := canRequestActInBuilding(r, roleAdmin, permissionManageLayout, "svc:1", 8); got != tt.wantOther {
+				t.Errorf("canRequestActInBuilding(other building) = %v, want %v", got, tt.wantOther)
+			}
+		})
+	}
+}
+
+func TestBuildingLimitedKeyDirectoryAccess(t *testing.T) {
+	t.Parallel()
+
+	a := &app{}
+	request := func(target string) *http.Request {
+		ctx := withServiceAccountPrincipal(context.Background(), &serviceAccountPrincipal{AccountID: 1, BuildingID: 7, Scopes: []string{"audit:read", "users:read"}})
+		ctx = context.WithValue(ctx, officeAccessTokenClaimsCtxKey, &OfficeAccessTokenClaims{EmployeeID: "svc:1", Role: roleAdmin})
+		return httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	}
+	tests := []struct {
+		name    string
+		target  string
+		handler func(http.ResponseWriter, *http.Request)
+	}{
+		{name: "audit log", target: "/api/admin/logs", handler: a.handleAdminAuditLogs},
+		// Without view_users the directory needs a building the requester
+		// is responsible for; an admin key limited to a building has none.
+		{name: "user directory", target: "/api/users", handler: a.handleUsers},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			rec := httptest.NewRecorder()
+			tt.handler(rec, request(tt.target))
+			if rec.Code != http.StatusForbidden {
+				t.Fatalf("GET %s with a building-limited key = %d, want %d", tt.target, rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
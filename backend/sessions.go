@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// A session is one refresh token family: it starts at login and survives
+// every rotation. Revoking a session revokes its family, so the device can no
+// longer refresh; its current access token still works until it expires
+// (OFFICE_ACCESS_TTL_MINUTES).
+const (
+	authSessionsPath  = "/api/auth/sessions"
+	adminSessionsPath = "/api/admin/sessions"
+
+	auditEntitySession = "session"
+	auditActionRevoke  = "revoke"
+)
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+type sessionInfo struct {
+	ID           string    `json:"id"`
+	DeviceID     string    `json:"device_id"`
+	Device       string    `json:"device"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	StartedAt    time.Time `json:"started_at"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Current      bool      `json:"current"`
+}
+
+// listActiveSessions returns the employee's token families that still have
+// a usable refresh token, most recently active first.
+func (a *app) listActiveSessions(ctx context.Context, employeeID, currentFamilyID string) ([]sessionInfo, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT t.family_id, t.device_id, t.ip_address, t.user_agent, f.started_at,
+		        GREATEST(t.created_at, COALESCE(t.last_used_at, t.created_at)) AS last_active_at,
+		        t.expires_at
+		   FROM office_refresh_tokens t
+		   JOIN (SELECT family_id, MIN(created_at) AS started_at
+		           FROM office_refresh_tokens
+		          WHERE employee_id = $1
+		          GROUP BY family_id) f ON f.family_id = t.family_id
+		  WHERE t.employee_id = $1
+		    AND t.family_id <> ''
+		    AND t.revoked_at IS NULL
+		    AND t.expires_at > now()
+		  ORDER BY last_active_at DESC`,
+		employeeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]sessionInfo, 0)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var s sessionInfo
+		if err := rows.Scan(&s.ID, &s.DeviceID, &s.IPAddress, &s.UserAgent, &s.StartedAt, &s.LastActiveAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if seen[s.ID] {
+			continue
+		}
+		seen[s.ID] = true
+		s.Device = describeUserAgent(s.UserAgent)
+		s.Current = currentFamilyID != "" && s.ID == currentFamilyID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// sessionOwner returns the employee a token family belongs to.
+func (a *app) sessionOwner(ctx context.Context, familyID string) (string, error) {
+	var employeeID string
+	err := a.db.QueryRowContext(ctx,
+		`SELECT employee_id FROM office_refresh_tokens WHERE family_id = $1 LIMIT 1`,
+		familyID,
+	).Scan(&employeeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errNotFound
+	}
+	return employeeID, err
+}
+
+// revokeOtherTokenFamilies revokes every session of the employee except
+// keepFamilyID (all of them when it is empty) and returns how many families
+// were revoked.
+func (a *app) revokeOtherTokenFamilies(ctx context.Context, employeeID, keepFamilyID string) (int64, error) {
+	var count int64
+	err := a.db.QueryRowContext(ctx,
+		`WITH revoked AS (
+			UPDATE office_refresh_tokens
+			   SET revoked_at = now()
+			 WHERE employee_id = $1 AND ($2 = '' OR family_id <> $2) AND revoked_at IS NULL
+			RETURNING family_id
+		)
+		SELECT COUNT(DISTINCT family_id) FROM revoked`,
+		employeeID, keepFamilyID,
+	).Scan(&count)
+	return count, err
+}
+
+// currentSessionFamily reads the token family of the caller's own session
+// from the refresh cookie, which is scoped to /api/auth/.
+func (a *app) currentSessionFamily(r *http.Request) string {
+	if a.officeTokenKeys == nil {
+		return ""
+	}
+	c, err := r.Cookie(refreshTokenCookieName)
+	if err != nil || strings.TrimSpace(c.Value) == "" {
+		return ""
+	}
+	claims, err := VerifyOfficeRefreshTokenWithKeyManager(c.Value, a.officeTokenKeys)
+	if err != nil {
+		return ""
+	}
+	return claims.FamilyID
+}
+
+func (a *app) auditSessionRevocation(r *http.Request, employeeID, scope string, revoked int64, session *sessionInfo) {
+	details := map[string]any{
+		"employee_id":      employeeID,
+		"scope":            scope,
+		"revoked_sessions": revoked,
+		"entity_path":      "Сессии · " + employeeID,
+	}
+	if session != nil {
+		details["ip_address"] = session.IPAddress
+		details["device"] = session.Device
+	}
+	name := employeeID
+	if fullName, err := getUserNameByEmployeeID(r.Context(), a.db, employeeID); err == nil && strings.TrimSpace(fullName) != "" {
+		name = strings.TrimSpace(fullName)
+	}
+	a.logAuditEventFromRequest(r, auditActionRevoke, auditEntitySession, 0, name, details)
+}
+
+// findSession returns the active session familyID of employeeID, if any.
+func (a *app) findSession(ctx context.Context, employeeID, familyID string) (*sessionInfo, error) {
+	sessions, err := a.listActiveSessions(ctx, employeeID, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		if sessions[i].ID == familyID {
+			return &sessions[i], nil
+		}
+	}
+	return nil, errNotFound
+}
+
+// revokeSession revokes one session of employeeID and records it.
+func (a *app) revokeSession(w http.ResponseWriter, r *http.Request, employeeID, familyID string) bool {
+	session, err := a.findSession(r.Context(), employeeID, familyID)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			respondError(w, http.StatusNotFound, "session not found")
+			return false
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	if err := a.revokeTokenFamily(r.Context(), familyID); err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	a.auditSessionRevocation(r, employeeID, "one", 1, session)
+	return true
+}
+
+// handleAuthSessions lets employees see and end their own sessions.
+// GET    /api/auth/sessions
+// POST   /api/auth/sessions/revoke-others
+// DELETE /api/auth/sessions/{id}
+func (a *app) handleAuthSessions(w http.ResponseWriter, r *http.Request) {
+	employeeID := strings.TrimSpace(authClaimsFromContext(r.Context()).EmployeeID)
+	if employeeID == "" {
+		respondError(w, http.StatusUnauthorized, "Office-Access-Token is required")
+		return
+	}
+	current := a.currentSessionFamily(r)
+	suffix := strings.TrimPrefix(r.URL.Path, authSessionsPath)
+
+	switch {
+	case (suffix == "" || suffix == "/") && r.Method == http.MethodGet:
+		sessions, err := a.listActiveSessions(r.Context(), employeeID, current)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"sessions": sessions})
+	case suffix == "/revoke-others" && r.Method == http.MethodPost:
+		if current == "" {
+			respondError(w, http.StatusBadRequest, "current session is unknown; sign in again")
+			return
+		}
+		revoked, err := a.revokeOtherTokenFamilies(r.Context(), employeeID, current)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		a.auditSessionRevocation(r, employeeID, "others", revoked, nil)
+		respondJSON(w, http.StatusOK, map[string]any{"revoked": revoked})
+	case suffix != "" && r.Method == http.MethodDelete:
+		familyID := strings.TrimPrefix(suffix, "/")
+		if !sessionIDPattern.MatchString(familyID) {
+			respondError(w, http.StatusBadRequest, "invalid session id")
+			return
+		}
+		if !a.revokeSession(w, r, employeeID, familyID) {
+			return
+		}
+		if familyID == current {
+			a.clearTokenCookies(w, r)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminSessions gives admins the same view for any employee.
+// GET    /api/admin/sessions?employee_id=...
+// POST   /api/admin/sessions/revoke-all   {"employee_id": "..."}
+// DELETE /api/admin/sessions/{id}
+func (a *app) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if !ensurePermission(w, r, a.db, permissionManageSessions) {
+		return
+	}
+	suffix := strings.TrimPrefix(r.URL.Path, adminSessionsPath)
+
+	switch {
+	case (suffix == "" || suffix == "/") && r.Method == http.MethodGet:
+		employeeID := strings.TrimSpace(r.URL.Query().Get("employee_id"))
+		if employeeID == "" {
+			respondError(w, http.StatusBadRequest, "employee_id is required")
+			return
+		}
+		sessions, err := a.listActiveSessions(r.Context(), employeeID, "")
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"employee_id": employeeID, "sessions": sessions})
+	case suffix == "/revoke-all" && r.Method == http.MethodPost:
+		var payload struct {
+			EmployeeID string `json:"employee_id"`
+		}
+		if err := decodeJSON(r, &payload); err != nil {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		employeeID := strings.TrimSpace(payload.EmployeeID)
+		if employeeID == "" {
+			respondError(w, http.StatusBadRequest, "employee_id is required")
+			return
+		}
+		revoked, err := a.revokeOtherTokenFamilies(r.Context(), employeeID, "")
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		a.auditSessionRevocation(r, employeeID, "all", revoked, nil)
+		respondJSON(w, http.StatusOK, map[string]any{"revoked": revoked})
+	case suffix != "" && r.Method == http.MethodDelete:
+		familyID := strings.TrimPrefix(suffix, "/")
+		if !sessionIDPattern.MatchString(familyID) {
+			respondError(w, http.StatusBadRequest, "invalid session id")
+			return
+		}
+		employeeID, err := a.sessionOwner(r.Context(), familyID)
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				respondError(w, http.StatusNotFound, "session not found")
+				return
+			}
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if !a.revokeSession(w, r, employeeID, familyID) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// describeUserAgent turns a User-Agent into a short "Browser, OS" label.
+func describeUserAgent(ua string) string {
+	browser := ""
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "YaBrowser/"):
+		browser = "Яндекс Браузер"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/") || strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	}
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad"):
+		platform = "iOS"
+	case strings.Contains(ua, "Android"):
+		platform = "Android"
+	case strings.Contains(ua, "Windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "Mac OS X") || strings.Contains(ua, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "Linux"):
+		platform = "Linux"
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + ", " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Неизвестное устройство"
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	storageBackendLocal = "local"
+	storageBackendS3    = "s3"
+
+	storageOperationTimeout = 30 * time.Second
+)
+
+var errInvalidObjectKey = errors.New("invalid object key")
+
+// objectStorage stores uploaded files under slash-separated keys such as
+// "buildings/building-1-...-large.jpg". Public URLs are always
+// "/uploads/<key>" regardless of the backend, so switching backends does not
+// require rewriting stored URLs.
+type objectStorage interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, objectInfo, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Name() string
+}
+
+type objectInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	ETag        string
+}
+
+func uploadURLForKey(key string) string {
+	return "/" + uploadDirName + "/" + key
+}
+
+// objectKeyFromUploadURL returns the storage key for a "/uploads/..." URL.
+// ok is false for URLs that do not point to uploaded files.
+func objectKeyFromUploadURL(imageURL string) (string, bool) {
+	prefix := "/" + uploadDirName + "/"
+	if !strings.HasPrefix(imageURL, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(imageURL, prefix), true
+}
+
+// normalizeObjectKey rejects keys that could escape the storage root.
+func normalizeObjectKey(key string) (string, error) {
+	key = strings.TrimPrefix(strings.TrimSpace(key), "/")
+	if key == "" || strings.Contains(key, "\\") {
+		return "", errInvalidObjectKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned != key || cleaned == "." || strings.HasPrefix(cleaned, "../") || cleaned == ".." {
+		return "", errInvalidObjectKey
+	}
+	return cleaned, nil
+}
+
+func contentTypeForKey(key string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func loadObjectStorageFromEnv(uploadDir string) (objectStorage, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("OFFICE_STORAGE_BACKEND")))
+	switch backend {
+	case "", storageBackendLocal:
+		return newLocalObjectStorage(uploadDir)
+	case storageBackendS3:
+		cfg, err := loadS3ConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return newS3ObjectStorage(cfg, nil)
+	default:
+		return nil, fmt.Errorf("unknown OFFICE_STORAGE_BACKEND %q", backend)
+	}
+}
+
+// localObjectStorage keeps objects as plain files below root.
+type localObjectStorage struct {
+	root string
+}
+
+func newLocalObjectStorage(root string) (*localObjectStorage, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &localObjectStorage{root: root}, nil
+}
+
+func (s *localObjectStorage) Name() string {
+	return storageBackendLocal + ":" + s.root
+}
+
+func (s *localObjectStorage) pathForKey(key string) (string, error) {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return "", err
+	}
+	targetPath := filepath.Clean(filepath.Join(s.root, filepath.FromSlash(key)))
+	// Prevent path traversal: ensure the resolved path stays within root.
+	if !strings.HasPrefix(targetPath, s.root+string(filepath.Separator)) {
+		return "", errInvalidObjectKey
+	}
+	return targetPath, nil
+}
+
+func (s *localObjectStorage) Put(_ context.Context, key string, body []byte, _ string) error {
+	targetPath, err := s.pathForKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(targetPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err := tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, targetPath)
+}
+
+func (s *localObjectStorage) Get(_ context.Context, key string) (io.ReadCloser, objectInfo, error) {
+	targetPath, err := s.pathForKey(key)
+	if err != nil {
+		return nil, objectInfo{}, errNotFound
+	}
+	file, err := os.Open(targetPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, objectInfo{}, errNotFound
+		}
+		return nil, objectInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, objectInfo{}, err
+	}
+	if stat.IsDir() {
+		_ = file.Close()
+		return nil, objectInfo{}, errNotFound
+	}
+	return file, objectInfo{
+		Size:        stat.Size(),
+		ContentType: contentTypeForKey(key),
+		ModTime:     stat.ModTime(),
+	}, nil
+}
+
+func (s *localObjectStorage) Exists(_ context.Context, key string) (bool, error) {
+	targetPath, err := s.pathForKey(key)
+	if err != nil {
+		return false, err
+	}
+	stat, err := os.Stat(targetPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !stat.IsDir(), nil
+}
+
+func (s *localObjectStorage) Delete(_ context.Context, key string) error {
+	targetPath, err := s.pathForKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(targetPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localObjectStorage) List(_ context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := filepath.WalkDir(s.root, func(current string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		relative, err := filepath.Rel(s.root, current)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+type s3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Prefix          string
+	PathStyle       bool
+}
+
+func loadS3ConfigFromEnv() (s3Config, error) {
+	cfg := s3Config{
+		Endpoint:        strings.TrimRight(strings.TrimSpace(os.Getenv("OFFICE_S3_ENDPOINT")), "/"),
+		Region:          strings.TrimSpace(os.Getenv("OFFICE_S3_REGION")),
+		Bucket:          strings.TrimSpace(os.Getenv("OFFICE_S3_BUCKET")),
+		AccessKeyID:     strings.TrimSpace(os.Getenv("OFFICE_S3_ACCESS_KEY_ID")),
+		SecretAccessKey: strings.TrimSpace(os.Getenv("OFFICE_S3_SECRET_ACCESS_KEY")),
+		Prefix:          strings.Trim(strings.TrimSpace(os.Getenv("OFFICE_S3_PREFIX")), "/"),
+		PathStyle:       true,
+	}
+	if value := strings.TrimSpace(os.Getenv("OFFICE_S3_PATH_STYLE")); value != "" {
+		cfg.PathStyle = isTrueEnv("OFFICE_S3_PATH_STYLE")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Bucket == "" {
+		return s3Config{}, errors.New("OFFICE_S3_BUCKET is required for s3 storage")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return s3Config{}, errors.New("OFFICE_S3_ACCESS_KEY_ID and OFFICE_S3_SECRET_ACCESS_KEY are required for s3 storage")
+	}
+	return cfg, nil
+}
+
+// s3ObjectStorage talks to any S3-compatible service (AWS S3, MinIO, Ceph)
+// using the REST API with Signature Version 4.
+type s3ObjectStorage struct {
+	cfg      s3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func newS3ObjectStorage(cfg s3Config, client *http.Client) (*s3ObjectStorage, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid OFFICE_S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: storageOperationTimeout}
+	}
+	return &s3ObjectStorage{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+func (s *s3ObjectStorage) Name() string {
+	return storageBackendS3 + ":" + s.cfg.Bucket
+}
+
+func (s *s3ObjectStorage) objectName(key string) string {
+	if s.cfg.Prefix == "" {
+		return key
+	}
+	return s.cfg.Prefix + "/" + key
+}
+
+func (s *s3ObjectStorage) requestURL(objectName string, query url.Values) *url.URL {
+	target := *s.endpoint
+	basePath := strings.TrimRight(target.Path, "/")
+	if s.cfg.PathStyle {
+		target.Path = basePath + "/" + s.cfg.Bucket
+	} else {
+		target.Host = s.cfg.Bucket + "." + target.Host
+		target.Path = basePath
+	}
+	if objectName != "" {
+		target.Path += "/" + objectName
+	}
+	if target.Path == "" {
+		target.Path = "/"
+	}
+	target.RawPath = s3EncodePath(target.Path)
+	target.RawQuery = s3CanonicalQuery(query)
+	return &target
+}
+
+func (s *s3ObjectStorage) do(ctx context.Context, method, objectName string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	target := s.requestURL(objectName, query)
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body)
+	return s.client.Do(req)
+}
+
+func (s *s3ObjectStorage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = contentTypeForKey(key)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectName(key), nil, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(resp)
+	}
+	return nil
+}
+
+func (s *s3ObjectStorage) Get(ctx context.Context, key string) (io.ReadCloser, objectInfo, error) {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return nil, objectInfo{}, errNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectName(key), nil, nil, "")
+	if err != nil {
+		return nil, objectInfo{}, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, objectInfo{}, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, objectInfo{}, s3ResponseError(resp)
+	}
+	info := objectInfo{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if info.ContentType == "" {
+		info.ContentType = contentTypeForKey(key)
+	}
+	if modified, parseErr := http.ParseTime(resp.Header.Get("Last-Modified")); parseErr == nil {
+		info.ModTime = modified
+	}
+	return resp.Body, info, nil
+}
+
+func (s *s3ObjectStorage) Exists(ctx context.Context, key string) (bool, error) {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return false, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectName(key), nil, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s3ResponseError(resp)
+	}
+}
+
+func (s *s3ObjectStorage) Delete(ctx context.Context, key string) error {
+	key, err := normalizeObjectKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectName(key), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3ResponseError(resp)
+	}
+	return nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3ObjectStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	fullPrefix := s.objectName(prefix)
+	keys := make([]string, 0)
+	continuation := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", fullPrefix)
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3ResponseError(resp)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result s3ListBucketResult
+		decodeErr := xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		for _, item := range result.Contents {
+			key := item.Key
+			if s.cfg.Prefix != "" {
+				key = strings.TrimPrefix(key, s.cfg.Prefix+"/")
+			}
+			keys = append(keys, key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		continuation = result.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func s3ResponseError(resp *http.Response) error {
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(payload, &body) == nil && body.Code != "" {
+		return fmt.Errorf("s3 %s: %s: %s", resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("s3 %s", resp.Status)
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *s3ObjectStorage) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headerNames := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if req.Header.Get("Content-Type") != "" {
+		headerNames = append(headerNames, "content-type")
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := dateStamp + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+	signingKey := sigV4SigningKey(s.cfg.SecretAccessKey, dateStamp, s.cfg.Region, "s3")
+	signature := hex.EncodeToString(hmacSHA256([]byte(stringToSign), signingKey))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func sigV4SigningKey(secret, dateStamp, region, service string) []byte {
+	key := hmacSHA256([]byte(dateStamp), []byte("AWS4"+secret))
+	key = hmacSHA256([]byte(region), key)
+	key = hmacSHA256([]byte(service), key)
+	return hmacSHA256([]byte("aws4_request"), key)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3EncodePath percent-encodes every byte outside the RFC 3986 unreserved set,
+// keeping "/" separators, as required for SigV4 canonical URIs.
+func s3EncodePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || isS3Unreserved(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3EncodeQueryComponent(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isS3Unreserved(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3EncodeQueryComponent(key)+"="+s3EncodeQueryComponent(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func isS3Unreserved(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+// handleUploads serves stored files at /uploads/<key> from the configured
+// object storage.
+func (a *app) handleUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	key, ok := objectKeyFromUploadURL(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	body, info, err := a.storage.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("uploads: get %s: %v", key, err)
+		respondError(w, http.StatusBadGateway, "storage unavailable")
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), info.ModTime, seeker)
+		return
+	}
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("uploads: stream %s: %v", key, err)
+	}
+}
+
+// migrateObjectStorage copies every object from src to dst, skipping keys
+// that already exist in dst. With deleteSource the copied objects are removed
+// from src afterwards.
+func migrateObjectStorage(ctx context.Context, src, dst objectStorage, deleteSource, dryRun bool, out io.Writer) (copied, skipped int, err error) {
+	keys, err := src.List(ctx, "")
+	if err != nil {
+		return 0, 0, fmt.Errorf("list %s: %w", src.Name(), err)
+	}
+	for _, key := range keys {
+		exists, existsErr := dst.Exists(ctx, key)
+		if existsErr != nil {
+			return copied, skipped, fmt.Errorf("check %s in %s: %w", key, dst.Name(), existsErr)
+		}
+		if exists {
+			skipped++
+			fmt.Fprintf(out, "skip   %s (already exists)\n", key)
+			continue
+		}
+		if dryRun {
+			copied++
+			fmt.Fprintf(out, "copy   %s (dry run)\n", key)
+			continue
+		}
+		body, info, getErr := src.Get(ctx, key)
+		if getErr != nil {
+			return copied, skipped, fmt.Errorf("read %s: %w", key, getErr)
+		}
+		payload, readErr := io.ReadAll(body)
+		body.Close()
+		if readErr != nil {
+			return copied, skipped, fmt.Errorf("read %s: %w", key, readErr)
+		}
+		if putErr := dst.Put(ctx, key, payload, info.ContentType); putErr != nil {
+			return copied, skipped, fmt.Errorf("write %s: %w", key, putErr)
+		}
+		copied++
+		fmt.Fprintf(out, "copy   %s\n", key)
+		if deleteSource {
+			if delErr := src.Delete(ctx, key); delErr != nil {
+				return copied, skipped, fmt.Errorf("delete %s from source: %w", key, delErr)
+			}
+		}
+	}
+	return copied, skipped, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalObjectStorageRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage, err := newLocalObjectStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("newLocalObjectStorage() error = %v", err)
+	}
+
+	key := "buildings/building-1-large.jpg"
+	if err := storage.Put(ctx, key, []byte("payload"), "image/jpeg"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	body, info, err := storage.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	payload, _ := io.ReadAll(body)
+	body.Close()
+	if string(payload) != "payload" || info.ContentType != "image/jpeg" {
+		t.Fatalf("Get() = %q (%s), want payload (image/jpeg)", payload, info.ContentType)
+	}
+
+	keys, err := storage.List(ctx, "buildings/")
+	if err != nil || len(keys) != 1 || keys[0] != key {
+		t.Fatalf("List() = %v, %v; want [%s]", keys, err, key)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, _, err := storage.Get(ctx, key); !errors.Is(err, errNotFound) {
+		t.Fatalf("Get() after delete error = %v, want errNotFound", err)
+	}
+}
+
+func TestLocalObjectStorageRejectsTraversal(t *testing.T) {
+	t.Parallel()
+
+	storage, err := newLocalObjectStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("newLocalObjectStorage() error = %v", err)
+	}
+	for _, key := range []string{"../secret", "buildings/../../secret", "..", ""} {
+		if err := storage.Put(context.Background(), key, []byte("x"), ""); !errors.Is(err, errInvalidObjectKey) {
+			t.Fatalf("Put(%q) error = %v, want errInvalidObjectKey", key, err)
+		}
+	}
+}
+
+func TestSigV4SigningKey(t *testing.T) {
+	t.Parallel()
+
+	// Example from the AWS Signature Version 4 documentation.
+	got := hex.EncodeToString(sigV4SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam"))
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got != want {
+		t.Fatalf("sigV4SigningKey() = %s, want %s", got, want)
+	}
+}
+
+func TestS3ObjectStorageSignsPathStyleRequests(t *testing.T) {
+	t.Parallel()
+
+	var gotPath, gotAuth, gotHash string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		gotHash = r.Header.Get("X-Amz-Content-Sha256")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	storage, err := newS3ObjectStorage(s3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "office",
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio-secret",
+		Prefix:          "uploads",
+		PathStyle:       true,
+	}, server.Client())
+	if err != nil {
+		t.Fatalf("newS3ObjectStorage() error = %v", err)
+	}
+	storage.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	if err := storage.Put(context.Background(), "buildings/a b.jpg", []byte("data"), "image/jpeg"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if gotPath != "/office/uploads/buildings/a%20b.jpg" {
+		t.Fatalf("request path = %s", gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=minio/20260102/us-east-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Fatalf("Authorization = %s", gotAuth)
+	}
+	if gotHash != sha256Hex([]byte("data")) {
+		t.Fatalf("X-Amz-Content-Sha256 = %s", gotHash)
+	}
+}
@@ -0,0 +1,864 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Deleting a building, floor, space or resource does not remove rows any
+// more. The entity and every descendant that is not already in the trash get
+// deleted_at/trash_item_id set, and one trash_items row records the
+// operation. Restoring clears the marks by trash_item_id; the purge job hard
+// deletes items whose retention period has expired.
+
+const (
+	defaultTrashRetentionDays = 30
+	trashPurgeInterval        = time.Hour
+)
+
+var errTrashParentDeleted = errors.New("parent entity is in trash; restore it first")
+
+type trashItem struct {
+	ID                  int64          `json:"id"`
+	EntityType          string         `json:"entity_type"`
+	EntityID            int64          `json:"entity_id"`
+	EntityName          string         `json:"entity_name"`
+	BuildingID          int64          `json:"building_id,omitempty"`
+	FloorID             int64          `json:"floor_id,omitempty"`
+	DeletedByEmployeeID string         `json:"deleted_by_employee_id"`
+	DeletedByName       string         `json:"deleted_by_name"`
+	Summary             map[string]any `json:"summary"`
+	DeletedAt           time.Time      `json:"deleted_at"`
+	PurgeAfter          time.Time      `json:"purge_after"`
+}
+
+func ensureTrashStorage(db *sql.DB) error {
+	if _, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS trash_items (
+			id BIGSERIAL PRIMARY KEY,
+			entity_type TEXT NOT NULL,
+			entity_id BIGINT NOT NULL,
+			entity_name TEXT NOT NULL DEFAULT '',
+			building_id BIGINT NOT NULL DEFAULT 0,
+			floor_id BIGINT NOT NULL DEFAULT 0,
+			deleted_by_employee_id TEXT NOT NULL DEFAULT '',
+			summary_json JSONB NOT NULL DEFAULT '{}'::jsonb,
+			deleted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			purge_after TIMESTAMPTZ NOT NULL
+		);`,
+	); err != nil {
+		return err
+	}
+	for _, table := range []string{"office_buildings", "floors", "coworkings", "meeting_rooms"} {
+		if err := ensureColumn(db, table, "deleted_at", "TIMESTAMPTZ"); err != nil {
+			return err
+		}
+		if err := ensureColumn(db, table, "trash_item_id", "BIGINT"); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %s_trash_item_id_idx ON %s (trash_item_id) WHERE trash_item_id IS NOT NULL`,
+			table, table,
+		)); err != nil {
+			return err
+		}
+	}
+	stmts := []string{
+		`CREATE INDEX IF NOT EXISTS trash_items_purge_after_idx ON trash_items (purge_after);`,
+		`CREATE INDEX IF NOT EXISTS trash_items_entity_idx ON trash_items (entity_type, entity_id);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *app) createTrashItemInTx(tx *sql.Tx, entityType string, entityID int64, entityName string, buildingID, floorID int64, actorEmployeeID string, summary map[string]any) (int64, error) {
+	summaryJSON := []byte("{}")
+	if len(summary) > 0 {
+		raw, err := json.Marshal(summary)
+		if err != nil {
+			return 0, err
+		}
+		summaryJSON = raw
+	}
+	retention := a.trashRetention
+	if retention <= 0 {
+		retention = defaultTrashRetentionDays * 24 * time.Hour
+	}
+	var id int64
+	err := tx.QueryRow(
+		`INSERT INTO trash_items (entity_type, entity_id, entity_name, building_id, floor_id, deleted_by_employee_id, summary_json, purge_after)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, now() + $8::bigint * interval '1 second')
+		 RETURNING id`,
+		entityType,
+		entityID,
+		entityName,
+		buildingID,
+		floorID,
+		strings.TrimSpace(actorEmployeeID),
+		string(summaryJSON),
+		int64(retention/time.Second),
+	).Scan(&id)
+	return id, err
+}
+
+// trashSummaryInTx counts what disappears together with the entity so the
+// trash listing can show the blast radius of a restore or purge.
+func trashSummaryInTx(tx *sql.Tx, coworkingFilter, meetingRoomFilter string, args ...any) (map[string]any, error) {
+	var coworkings, meetingRooms, desks, deskBookings, meetingBookings int64
+	queries := []struct {
+		query string
+		dest  *int64
+	}{
+		{fmt.Sprintf(`SELECT COUNT(*) FROM coworkings c WHERE c.deleted_at IS NULL AND %s`, coworkingFilter), &coworkings},
+		{fmt.Sprintf(`SELECT COUNT(*) FROM meeting_rooms m WHERE m.deleted_at IS NULL AND %s`, meetingRoomFilter), &meetingRooms},
+		{fmt.Sprintf(
+			`SELECT COUNT(*) FROM workplaces w JOIN coworkings c ON c.id = w.coworking_id
+			  WHERE c.deleted_at IS NULL AND %s`, coworkingFilter), &desks},
+		{fmt.Sprintf(
+			`SELECT COUNT(*) FROM workplace_bookings b
+			   JOIN workplaces w ON w.id = b.workplace_id
+			   JOIN coworkings c ON c.id = w.coworking_id
+			  WHERE c.deleted_at IS NULL AND b.cancelled_at IS NULL AND %s`, coworkingFilter), &deskBookings},
+		{fmt.Sprintf(
+			`SELECT COUNT(*) FROM meeting_room_bookings b
+			   JOIN meeting_rooms m ON m.id = b.meeting_room_id
+			  WHERE m.deleted_at IS NULL AND b.cancelled_at IS NULL AND %s`, meetingRoomFilter), &meetingBookings},
+	}
+	for _, q := range queries {
+		if err := tx.QueryRow(q.query, args...).Scan(q.dest); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]any{
+		"coworkings":       coworkings,
+		"meeting_rooms":    meetingRooms,
+		"desks":            desks,
+		"desk_bookings":    deskBookings,
+		"meeting_bookings": meetingBookings,
+	}, nil
+}
+
+func (a *app) deleteBuilding(id int64, actorEmployeeID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	if err := tx.QueryRow(
+		`SELECT name FROM office_buildings WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	}
+	summary, err := trashSummaryInTx(tx,
+		`c.floor_id IN (SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL)`,
+		`m.floor_id IN (SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL)`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	var floors int64
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM floors WHERE building_id = $1 AND deleted_at IS NULL`, id).Scan(&floors); err != nil {
+		return err
+	}
+	summary["floors"] = floors
+
+	trashID, err := a.createTrashItemInTx(tx, auditEntityBuilding, id, name, id, 0, actorEmployeeID, summary)
+	if err != nil {
+		return err
+	}
+	stmts := []string{
+		`UPDATE coworkings SET deleted_at = now(), trash_item_id = $2
+		  WHERE deleted_at IS NULL
+		    AND floor_id IN (SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL)`,
+		`UPDATE meeting_rooms SET deleted_at = now(), trash_item_id = $2
+		  WHERE deleted_at IS NULL
+		    AND floor_id IN (SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL)`,
+		`UPDATE resources SET deleted_at = now(), trash_item_id = $2
+		  WHERE deleted_at IS NULL
+		    AND floor_id IN (SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL)`,
+		`UPDATE floors SET deleted_at = now(), trash_item_id = $2 WHERE building_id = $1 AND deleted_at IS NULL`,
+		`UPDATE office_buildings SET deleted_at = now(), trash_item_id = $2 WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id, trashID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteFloor moves the floor to the trash. Levels of the remaining floors
+// are left untouched: they are explicit and only change through
+// reorderFloors, so a restore can put the floor back on its old level.
+func (a *app) deleteFloor(id int64, actorEmployeeID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.deleteFloorInTx(tx, id, actorEmployeeID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteFloorInTx is deleteFloor for callers that already hold a
+// transaction, such as shrinking the floor count of a building.
+func (a *app) deleteFloorInTx(tx *sql.Tx, id int64, actorEmployeeID string) error {
+	var buildingID int64
+	var level int
+	var name string
+	if err := tx.QueryRow(
+		`SELECT building_id, level, name FROM floors WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&buildingID, &level, &name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	}
+	summary, err := trashSummaryInTx(tx, `c.floor_id = $1`, `m.floor_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	summary["level"] = level
+
+	trashID, err := a.createTrashItemInTx(tx, auditEntityFloor, id, name, buildingID, id, actorEmployeeID, summary)
+	if err != nil {
+		return err
+	}
+	stmts := []string{
+		`UPDATE coworkings SET deleted_at = now(), trash_item_id = $2 WHERE floor_id = $1 AND deleted_at IS NULL`,
+		`UPDATE meeting_rooms SET deleted_at = now(), trash_item_id = $2 WHERE floor_id = $1 AND deleted_at IS NULL`,
+		`UPDATE resources SET deleted_at = now(), trash_item_id = $2 WHERE floor_id = $1 AND deleted_at IS NULL`,
+		`UPDATE floors SET deleted_at = now(), trash_item_id = $2 WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id, trashID); err != nil {
+			return err
+		}
+	}
+	return syncBuildingFloorIDsInTx(tx, buildingID)
+}
+
+func (a *app) deleteSpace(id int64, actorEmployeeID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := a.deleteSpaceInTx(tx, id, actorEmployeeID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteSpaceInTx is deleteSpace for callers that already hold a
+// transaction, such as clearing the plan of a floor.
+func (a *app) deleteSpaceInTx(tx *sql.Tx, id int64, actorEmployeeID string) error {
+	entityType := auditEntityCoworking
+	table := "coworkings"
+	var name string
+	var floorID int64
+	err := tx.QueryRow(
+		`SELECT name, floor_id FROM coworkings WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+		id,
+	).Scan(&name, &floorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		entityType = auditEntityMeetingRoom
+		table = "meeting_rooms"
+		err = tx.QueryRow(
+			`SELECT name, floor_id FROM meeting_rooms WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			id,
+		).Scan(&name, &floorID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errNotFound
+		}
+		return err
+	}
+	var buildingID int64
+	if err := tx.QueryRow(`SELECT building_id FROM floors WHERE id = $1`, floorID).Scan(&buildingID); err != nil {
+		return err
+	}
+	summary, err := trashSummaryInTx(tx, `c.id = $1`, `m.id = $1`, id)
+	if err != nil {
+		return err
+	}
+	trashID, err := a.createTrashItemInTx(tx, entityType, id, name, buildingID, floorID, actorEmployeeID, summary)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		fmt.Sprintf(`UPDATE %s SET deleted_at = now(), trash_item_id = $2 WHERE id = $1`, table),
+		id,
+		trashID,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+// activeFloorSpaceIDsInTx lists the coworkings and meeting rooms of the
+// floor that are not in the trash.
+func activeFloorSpaceIDsInTx(tx *sql.Tx, floorID int64) ([]int64, error) {
+	rows, err := tx.Query(
+		`SELECT id FROM coworkings WHERE floor_id = $1 AND deleted_at IS NULL
+		 UNION ALL
+		 SELECT id FROM meeting_rooms WHERE floor_id = $1 AND deleted_at IS NULL
+		 ORDER BY id`,
+		floorID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// syncBuildingFloorIDsInTx rewrites office_buildings.floors from the active
+// floors of the building, bottom to top.
+func syncBuildingFloorIDsInTx(tx *sql.Tx, buildingID int64) error {
+	rows, err := tx.Query(
+		`SELECT id FROM floors WHERE building_id = $1 AND deleted_at IS NULL ORDER BY level, id`,
+		buildingID,
+	)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var floorID int64
+		if err := rows.Scan(&floorID); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, floorID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+	floorsJSON, err := encodeFloorIDs(ids)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE office_buildings SET floors = $1 WHERE id = $2`, floorsJSON, buildingID)
+	return err
+}
+
+func (a *app) getTrashItem(ctx context.Context, id int64) (trashItem, error) {
+	items, err := a.queryTrashItems(ctx, `t.id = $1`, []any{id}, 1, 0)
+	if err != nil {
+		return trashItem{}, err
+	}
+	if len(items) == 0 {
+		return trashItem{}, errNotFound
+	}
+	return items[0], nil
+}
+
+func (a *app) queryTrashItems(ctx context.Context, where string, args []any, limit, offset int) ([]trashItem, error) {
+	if where == "" {
+		where = "TRUE"
+	}
+	args = append(args, limit, offset)
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT t.id, t.entity_type, t.entity_id, t.entity_name, t.building_id, t.floor_id,
+		        t.deleted_by_employee_id, COALESCE(NULLIF(u.full_name, ''), ''),
+		        COALESCE(t.summary_json, '{}'::jsonb)::text, t.deleted_at, t.purge_after
+		   FROM trash_items t
+		   LEFT JOIN users u ON u.employee_id = t.deleted_by_employee_id AND t.deleted_by_employee_id <> ''
+		  WHERE %s
+		  ORDER BY t.deleted_at DESC, t.id DESC
+		  LIMIT $%d OFFSET $%d`,
+		where, len(args)-1, len(args),
+	), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]trashItem, 0)
+	for rows.Next() {
+		var item trashItem
+		var summaryJSON string
+		if err := rows.Scan(
+			&item.ID,
+			&item.EntityType,
+			&item.EntityID,
+			&item.EntityName,
+			&item.BuildingID,
+			&item.FloorID,
+			&item.DeletedByEmployeeID,
+			&item.DeletedByName,
+			&summaryJSON,
+			&item.DeletedAt,
+			&item.PurgeAfter,
+		); err != nil {
+			return nil, err
+		}
+		item.Summary = map[string]any{}
+		if err := json.Unmarshal([]byte(summaryJSON), &item.Summary); err != nil {
+			item.Summary = map[string]any{}
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// trashRestoreState is what restoreTrashItem checks before it clears the
+// trash marks: whether the parents are still in the trash and whether
+// another floor took the level meanwhile.
+type trashRestoreState struct {
+	BuildingDeleted bool
+	FloorDeleted    bool
+	FloorLevelTaken bool
+}
+
+func (s trashRestoreState) conflict(entityType string) error {
+	switch entityType {
+	case auditEntityFloor:
+		if s.BuildingDeleted {
+			return errTrashParentDeleted
+		}
+		if s.FloorLevelTaken {
+			return errFloorLevelTaken
+		}
+	case auditEntityCoworking, auditEntityMeetingRoom, auditEntityResource:
+		if s.FloorDeleted {
+			return errTrashParentDeleted
+		}
+	}
+	return nil
+}
+
+// restoreTrashItem undoes a delete: every row marked with the trash item is
+// made visible again. Children that were trashed separately before the parent
+// keep their own trash entries.
+func (a *app) restoreTrashItem(ctx context.Context, id int64) (trashItem, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return trashItem{}, err
+	}
+	defer tx.Rollback()
+
+	var item trashItem
+	if err := tx.QueryRowContext(ctx,
+		`SELECT id, entity_type, entity_id, entity_name, building_id, floor_id
+		   FROM trash_items WHERE id = $1 FOR UPDATE`,
+		id,
+	).Scan(&item.ID, &item.EntityType, &item.EntityID, &item.EntityName, &item.BuildingID, &item.FloorID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return trashItem{}, errNotFound
+		}
+		return trashItem{}, err
+	}
+
+	var state trashRestoreState
+	switch item.EntityType {
+	case auditEntityFloor:
+		if err := tx.QueryRowContext(ctx,
+			`SELECT deleted_at IS NOT NULL FROM office_buildings WHERE id = $1`,
+			item.BuildingID,
+		).Scan(&state.BuildingDeleted); err != nil {
+			return trashItem{}, err
+		}
+		var level int
+		if err := tx.QueryRowContext(ctx, `SELECT level FROM floors WHERE id = $1`, item.EntityID).Scan(&level); err != nil {
+			return trashItem{}, err
+		}
+		if state.FloorLevelTaken, err = floorLevelTakenInTx(ctx, tx, item.BuildingID, level, item.EntityID); err != nil {
+			return trashItem{}, err
+		}
+	case auditEntityCoworking, auditEntityMeetingRoom, auditEntityResource:
+		if err := tx.QueryRowContext(ctx,
+			`SELECT deleted_at IS NOT NULL FROM floors WHERE id = $1`,
+			item.FloorID,
+		).Scan(&state.FloorDeleted); err != nil {
+			return trashItem{}, err
+		}
+	}
+	if err := state.conflict(item.EntityType); err != nil {
+		return trashItem{}, err
+	}
+
+	for _, table := range []string{"office_buildings", "floors", "coworkings", "meeting_rooms", "resources"} {
+		if _, err := tx.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE %s SET deleted_at = NULL, trash_item_id = NULL WHERE trash_item_id = $1`, table),
+			item.ID,
+		); err != nil {
+			return trashItem{}, err
+		}
+	}
+	if item.EntityType == auditEntityFloor || item.EntityType == auditEntityBuilding {
+		if err := syncBuildingFloorIDsInTx(tx, item.BuildingID); err != nil {
+			return trashItem{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash_items WHERE id = $1`, item.ID); err != nil {
+		return trashItem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return trashItem{}, err
+	}
+	return item, nil
+}
+
+// purgeTrashItem permanently deletes the entity of a trash item together with
+// all of its children and bookings.
+func (a *app) purgeTrashItem(ctx context.Context, id int64) (trashItem, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return trashItem{}, err
+	}
+	defer tx.Rollback()
+
+	var item trashItem
+	if err := tx.QueryRowContext(ctx,
+		`SELECT id, entity_type, entity_id, entity_name, building_id, floor_id
+		   FROM trash_items WHERE id = $1 FOR UPDATE`,
+		id,
+	).Scan(&item.ID, &item.EntityType, &item.EntityID, &item.EntityName, &item.BuildingID, &item.FloorID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return trashItem{}, errNotFound
+		}
+		return trashItem{}, err
+	}
+
+	var imageURLs []string
+	switch item.EntityType {
+	case auditEntityBuilding:
+		var imageURL, variantsJSON string
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(image_url, ''), COALESCE(image_variants, '{}') FROM office_buildings WHERE id = $1`,
+			item.EntityID,
+		).Scan(&imageURL, &variantsJSON); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return trashItem{}, err
+		}
+		imageURLs = append([]string{imageURL}, variantURLs(decodeImageVariants(variantsJSON))...)
+		err = purgeBuildingInTx(ctx, tx, item.EntityID)
+	case auditEntityFloor:
+		err = purgeFloorInTx(ctx, tx, item.EntityID)
+	case auditEntityCoworking:
+		err = purgeCoworkingInTx(ctx, tx, item.EntityID)
+	case auditEntityMeetingRoom:
+		err = purgeMeetingRoomInTx(ctx, tx, item.EntityID)
+	case auditEntityResource:
+		err = purgeResourceInTx(ctx, tx, item.EntityID)
+	default:
+		err = fmt.Errorf("unknown trash entity type %q", item.EntityType)
+	}
+	if err != nil {
+		return trashItem{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash_items WHERE id = $1`, item.ID); err != nil {
+		return trashItem{}, err
+	}
+	if err := deleteOrphanTrashItemsInTx(ctx, tx); err != nil {
+		return trashItem{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return trashItem{}, err
+	}
+	for _, url := range imageURLs {
+		if strings.TrimSpace(url) == "" {
+			continue
+		}
+		if err := a.removeUploadedFile(url); err != nil {
+			log.Printf("trash purge: failed to remove %s: %v", url, err)
+		}
+	}
+	return item, nil
+}
+
+func purgeBuildingInTx(ctx context.Context, tx *sql.Tx, buildingID int64) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM floors WHERE building_id = $1`, buildingID)
+	if err != nil {
+		return err
+	}
+	var floorIDs []int64
+	for rows.Next() {
+		var floorID int64
+		if err := rows.Scan(&floorID); err != nil {
+			rows.Close()
+			return err
+		}
+		floorIDs = append(floorIDs, floorID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+	for _, floorID := range floorIDs {
+		if err := purgeFloorInTx(ctx, tx, floorID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM office_buildings WHERE id = $1`, buildingID)
+	return err
+}
+
+func purgeFloorInTx(ctx context.Context, tx *sql.Tx, floorID int64) error {
+	stmts := []string{
+		`DELETE FROM workplace_bookings
+		  WHERE workplace_id IN (
+		    SELECT w.id FROM workplaces w
+		    JOIN coworkings c ON c.id = w.coworking_id
+		    WHERE c.floor_id = $1
+		  )`,
+		`DELETE FROM workplaces
+		  WHERE coworking_id IN (SELECT id FROM coworkings WHERE floor_id = $1)`,
+		`DELETE FROM coworkings WHERE floor_id = $1`,
+		`DELETE FROM meeting_room_bookings
+		  WHERE meeting_room_id IN (SELECT id FROM meeting_rooms WHERE floor_id = $1)`,
+		`DELETE FROM meeting_rooms WHERE floor_id = $1`,
+		`DELETE FROM resource_bookings
+		  WHERE resource_id IN (SELECT id FROM resources WHERE floor_id = $1)`,
+		`DELETE FROM resources WHERE floor_id = $1`,
+		`DELETE FROM floors WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, floorID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func purgeCoworkingInTx(ctx context.Context, tx *sql.Tx, coworkingID int64) error {
+	stmts := []string{
+		`DELETE FROM workplace_bookings
+		  WHERE workplace_id IN (SELECT id FROM workplaces WHERE coworking_id = $1)`,
+		`DELETE FROM workplaces WHERE coworking_id = $1`,
+		`DELETE FROM coworkings WHERE id = $1`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, coworkingID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func purgeMeetingRoomInTx(ctx context.Context, tx *sql.Tx, meetingRoomID int64) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM meeting_room_bookings WHERE meeting_room_id = $1`, meetingRoomID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM meeting_rooms WHERE id = $1`, meetingRoomID)
+	return err
+}
+
+// deleteOrphanTrashItemsInTx drops trash entries whose entity was removed as
+// part of purging an ancestor.
+func deleteOrphanTrashItemsInTx(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx,
+		`DELETE FROM trash_items t
+		  WHERE (t.entity_type = 'building' AND NOT EXISTS (SELECT 1 FROM office_buildings b WHERE b.id = t.entity_id))
+		     OR (t.entity_type = 'floor' AND NOT EXISTS (SELECT 1 FROM floors f WHERE f.id = t.entity_id))
+		     OR (t.entity_type = 'coworking' AND NOT EXISTS (SELECT 1 FROM coworkings c WHERE c.id = t.entity_id))
+		     OR (t.entity_type = 'meeting_room' AND NOT EXISTS (SELECT 1 FROM meeting_rooms m WHERE m.id = t.entity_id))
+		     OR (t.entity_type = 'resource' AND NOT EXISTS (SELECT 1 FROM resources r WHERE r.id = t.entity_id))`,
+	)
+	return err
+}
+
+func (a *app) purgeExpiredTrash(ctx context.Context) (int, error) {
+	rows, err := a.db.QueryContext(ctx,
+		`SELECT id FROM trash_items WHERE purge_after <= now() ORDER BY purge_after ASC LIMIT 100`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	purged := 0
+	for _, id := range ids {
+		item, err := a.purgeTrashItem(ctx, id)
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			return purged, err
+		}
+		purged++
+		a.logAuditEvent(ctx, auditLogWriteInput{
+			ActionType: auditActionPurge,
+			EntityType: item.EntityType,
+			EntityID:   item.EntityID,
+			EntityName: item.EntityName,
+			Details: map[string]any{
+				"trash_item_id": item.ID,
+				"reason":        "retention_expired",
+			},
+		})
+	}
+	return purged, nil
+}
+
+// runTrashPurgeLoop purges expired trash items until ctx is cancelled.
+func (a *app) runTrashPurgeLoop(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := a.purgeExpiredTrash(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("trash purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("trash purge: removed %d expired item(s)", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *app) handleAdminTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionManageTrash) {
+		return
+	}
+
+	limit := parseAuditLogsInt(r.URL.Query().Get("limit"), 100)
+	if limit > 500 {
+		limit = 500
+	}
+	offset := parseAuditLogsInt(r.URL.Query().Get("offset"), 0)
+	if offset < 0 {
+		offset = 0
+	}
+	var (
+		whereParts []string
+		args       []any
+	)
+	if entityType := strings.TrimSpace(r.URL.Query().Get("entity_type")); entityType != "" && entityType != "all" {
+		args = append(args, entityType)
+		whereParts = append(whereParts, fmt.Sprintf("t.entity_type = $%d", len(args)))
+	}
+	if buildingID := parseAuditLogsInt(r.URL.Query().Get("building_id"), 0); buildingID > 0 {
+		args = append(args, buildingID)
+		whereParts = append(whereParts, fmt.Sprintf("t.building_id = $%d", len(args)))
+	}
+	items, err := a.queryTrashItems(r.Context(), strings.Join(whereParts, " AND "), args, limit, offset)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]any{"items": items, "limit": limit, "offset": offset})
+}
+
+func (a *app) handleAdminTrashSubroutes(w http.ResponseWriter, r *http.Request) {
+	id, suffix, err := parseIDFromPath(r.URL.Path, "/api/admin/trash/")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !ensurePermission(w, r, a.db, permissionManageTrash) {
+		return
+	}
+
+	switch {
+	case suffix == "" && r.Method == http.MethodGet:
+		item, err := a.getTrashItem(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				respondError(w, http.StatusNotFound, "trash item not found")
+				return
+			}
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, item)
+	case suffix == "" && r.Method == http.MethodDelete:
+		item, err := a.purgeTrashItem(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				respondError(w, http.StatusNotFound, "trash item not found")
+				return
+			}
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		a.logAuditEventFromRequest(r, auditActionPurge, item.EntityType, item.EntityID, item.EntityName, map[string]any{
+			"trash_item_id": item.ID,
+			"reason":        "manual",
+		})
+		w.WriteHeader(http.StatusNoContent)
+	case suffix == "/restore" && r.Method == http.MethodPost:
+		item, err := a.restoreTrashItem(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, errNotFound):
+				respondError(w, http.StatusNotFound, "trash item not found")
+			case errors.Is(err, errTrashParentDeleted), errors.Is(err, errFloorLevelTaken):
+				respondError(w, http.StatusConflict, err.Error())
+			default:
+				log.Printf("internal error: %v", err)
+				respondError(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		a.logAuditEventFromRequest(r, auditActionRestore, item.EntityType, item.EntityID, item.EntityName, map[string]any{
+			"trash_item_id": item.ID,
+			"building_id":   item.BuildingID,
+			"floor_id":      item.FloorID,
+		})
+		respondJSON(w, http.StatusOK, item)
+	case suffix == "" || suffix == "/restore":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTrashRestoreConflict(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		entityType string
+		state      trashRestoreState
+		want       error
+	}{
+		{name: "building", entityType: auditEntityBuilding, state: trashRestoreState{BuildingDeleted: true}, want: nil},
+		{name: "floor of active building", entityType: auditEntityFloor, want: nil},
+		{name: "floor of trashed building", entityType: auditEntityFloor, state: trashRestoreState{BuildingDeleted: true, FloorLevelTaken: true}, want: errTrashParentDeleted},
+		{name: "floor level taken", entityType: auditEntityFloor, state: trashRestoreState{FloorLevelTaken: true}, want: errFloorLevelTaken},
+		{name: "coworking of trashed floor", entityType: auditEntityCoworking, state: trashRestoreState{FloorDeleted: true}, want: errTrashParentDeleted},
+		{name: "meeting room of trashed floor", entityType: auditEntityMeetingRoom, state: trashRestoreState{FloorDeleted: true}, want: errTrashParentDeleted},
+		{name: "resource of trashed floor", entityType: auditEntityResource, state: trashRestoreState{FloorDeleted: true}, want: errTrashParentDeleted},
+		{name: "coworking ignores floor level", entityType: auditEntityCoworking, state: trashRestoreState{FloorLevelTaken: true}, want: nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := tt.state.conflict(tt.entityType); !errors.Is(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Fatalf("conflict(%q) = %v, want %v", tt.entityType, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTrashLifecycle deletes, restores and purges a building tree against
+// the database in OFFICE_TEST_DATABASE_URL.
+func TestTrashLifecycle(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+	ctx := context.Background()
+
+	b, err := a.createBuildingWithFloors("Trash test", "Test street 1", defaultBuildingTimezone, "", 0, 2, "")
+	if err != nil {
+		t.Fatalf("create building: %v", err)
+	}
+	floorID := b.Floors[1]
+	sp, err := a.createSpace(floorID, "Coworking", "coworking", 0, "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("create space: %v", err)
+	}
+	d, err := a.createDesk(sp.ID, "D1", 0, 0, 100, 100, 0)
+	if err != nil {
+		t.Fatalf("create desk: %v", err)
+	}
+	if _, err := db.Exec(
+		`INSERT INTO workplace_bookings (workplace_id, applier_employee_id, date) VALUES ($1, 'trash-test', '2030-01-01')`,
+		d.ID,
+	); err != nil {
+		t.Fatalf("create booking: %v", err)
+	}
+	trashItemID := func(entityType string, entityID int64) int64 {
+		t.Helper()
+		items, err := a.queryTrashItems(ctx, `t.entity_type = $1 AND t.entity_id = $2`, []any{entityType, entityID}, 1, 0)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("trash item for %s %d: %v %v", entityType, entityID, items, err)
+		}
+		return items[0].ID
+	}
+	spaceListed := func() bool {
+		t.Helper()
+		spaces, err := a.listSpacesByFloor(floorID, 0)
+		if err != nil {
+			t.Fatalf("list spaces: %v", err)
+		}
+		for _, s := range spaces {
+			if s.ID == sp.ID {
+				return true
+			}
+		}
+		return false
+	}
+	floorListed := func(id int64) bool {
+		t.Helper()
+		floors, err := a.listFloorsByBuilding(b.ID)
+		if err != nil {
+			t.Fatalf("list floors: %v", err)
+		}
+		for _, f := range floors {
+			if f.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := a.deleteSpace(sp.ID, "trash-test"); err != nil {
+		t.Fatalf("delete space: %v", err)
+	}
+	if spaceListed() {
+		t.Fatal("trashed space is still listed")
+	}
+	spaceItem := trashItemID(auditEntityCoworking, sp.ID)
+
+	if err := a.deleteFloor(floorID, "trash-test"); err != nil {
+		t.Fatalf("delete floor: %v", err)
+	}
+	if floorListed(floorID) {
+		t.Fatal("trashed floor is still listed")
+	}
+	floorItem := trashItemID(auditEntityFloor, floorID)
+	if _, err := a.restoreTrashItem(ctx, spaceItem); !errors.Is(err, errTrashParentDeleted) {
+		t.Fatalf("restore space of trashed floor: %v, want %v", err, errTrashParentDeleted)
+	}
+
+	replacement, err := a.createFloor(b.ID, "", 2, "")
+	if err != nil {
+		t.Fatalf("create replacement floor: %v", err)
+	}
+	if _, err := a.restoreTrashItem(ctx, floorItem); !errors.Is(err, errFloorLevelTaken) {
+		t.Fatalf("restore floor onto a taken level: %v, want %v", err, errFloorLevelTaken)
+	}
+	if err := a.deleteFloor(replacement.ID, "trash-test"); err != nil {
+		t.Fatalf("delete replacement floor: %v", err)
+	}
+	replacementItem := trashItemID(auditEntityFloor, replacement.ID)
+	if _, err := a.restoreTrashItem(ctx, floorItem); err != nil {
+		t.Fatalf("restore floor: %v", err)
+	}
+	if !floorListed(floorID) {
+		t.Fatal("restored floor is not listed")
+	}
+	if _, err := a.restoreTrashItem(ctx, spaceItem); err != nil {
+		t.Fatalf("restore space: %v", err)
+	}
+	if !spaceListed() {
+		t.Fatal("restored space is not listed")
+	}
+
+	if err := a.deleteBuilding(b.ID, "trash-test"); err != nil {
+		t.Fatalf("delete building: %v", err)
+	}
+	if _, err := a.getBuilding(b.ID); !errors.Is(err, errNotFound) {
+		t.Fatalf("get trashed building: %v, want %v", err, errNotFound)
+	}
+	if _, err := a.restoreTrashItem(ctx, replacementItem); !errors.Is(err, errTrashParentDeleted) {
+		t.Fatalf("restore floor of trashed building: %v, want %v", err, errTrashParentDeleted)
+	}
+
+	if _, err := a.purgeTrashItem(ctx, trashItemID(auditEntityBuilding, b.ID)); err != nil {
+		t.Fatalf("purge building: %v", err)
+	}
+	var left int
+	if err := db.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM floors WHERE building_id = $1)
+		      + (SELECT COUNT(*) FROM coworkings WHERE id = $2)
+		      + (SELECT COUNT(*) FROM workplaces WHERE id = $3)
+		      + (SELECT COUNT(*) FROM workplace_bookings WHERE workplace_id = $3)
+		      + (SELECT COUNT(*) FROM trash_items WHERE id = $4)`,
+		b.ID, sp.ID, d.ID, replacementItem,
+	).Scan(&left); err != nil {
+		t.Fatalf("count leftovers: %v", err)
+	}
+	if left != 0 {
+		t.Fatalf("purge left %d row(s) behind", left)
+	}
+}
+
+// TestTrashFloorShrinkAndPlanClear checks that shrinking the floor count and
+// clearing a floor plan trash the removed floors and spaces instead of
+// deleting them.
+func TestTrashFloorShrinkAndPlanClear(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+	ctx := context.Background()
+
+	b, err := a.createBuildingWithFloors("Trash shrink test", "Test street 2", defaultBuildingTimezone, "", 0, 2, "")
+	if err != nil {
+		t.Fatalf("create building: %v", err)
+	}
+	defer a.deleteBuilding(b.ID, "trash-test")
+	lower, upper := b.Floors[0], b.Floors[1]
+	upperSpace, err := a.createSpace(upper, "Upper", "coworking", 0, "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("create upper space: %v", err)
+	}
+	lowerSpace, err := a.createSpace(lower, "Lower", "meeting", 4, "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("create lower space: %v", err)
+	}
+
+	one := 1
+	updated, err := a.updateBuilding(b.ID, b.Name, b.Address, b.Timezone, nil, nil, &one, "trash-test")
+	if err != nil {
+		t.Fatalf("shrink building: %v", err)
+	}
+	if len(updated.Floors) != 1 || updated.Floors[0] != lower {
+		t.Fatalf("floors after shrink = %v, want [%d]", updated.Floors, lower)
+	}
+	items, err := a.queryTrashItems(ctx, `t.entity_type = $1 AND t.entity_id = $2`, []any{auditEntityFloor, upper}, 1, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("trash item for removed floor: %v %v", items, err)
+	}
+	if _, err := a.restoreTrashItem(ctx, items[0].ID); err != nil {
+		t.Fatalf("restore removed floor: %v", err)
+	}
+	if _, err := a.getSpace(upperSpace.ID); err != nil {
+		t.Fatalf("space of restored floor: %v", err)
+	}
+
+	if _, err := a.updateFloorPlan(lower, "", "trash-test"); err != nil {
+		t.Fatalf("clear floor plan: %v", err)
+	}
+	items, err = a.queryTrashItems(ctx, `t.entity_type = $1 AND t.entity_id = $2`, []any{auditEntityMeetingRoom, lowerSpace.ID}, 1, 0)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("trash item for space of cleared plan: %v %v", items, err)
+	}
+	if _, err := a.restoreTrashItem(ctx, items[0].ID); err != nil {
+		t.Fatalf("restore space of cleared plan: %v", err)
+	}
+}
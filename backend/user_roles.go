@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -33,7 +35,7 @@ func (a *app) handleUserRole(w http.ResponseWriter, r *http.Request) {
 		respondRoleResolutionError(w, err)
 		return
 	}
-	if !hasPermission(requesterRole, permissionManageRoleAssignments) {
+	if !hasRequestPermission(r, requesterRole, permissionManageRoleAssignments) {
 		respondError(w, http.StatusForbidden, "Admin role is required")
 		return
 	}
@@ -56,23 +58,76 @@ func (a *app) handleUserRole(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "Invalid role")
 		return
 	}
+	tx, err := a.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(r.Context(),
+		`SELECT id, TRIM(COALESCE(employee_id, '')), COALESCE(full_name, ''), role
+		   FROM users
+		  WHERE wb_user_id = $1 OR wb_team_profile_id = $1 OR employee_id = $1
+		  FOR UPDATE`,
+		targetID,
+	)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	var targets []roleAssignmentTarget
+	for rows.Next() {
+		var t roleAssignmentTarget
+		if err := rows.Scan(&t.ID, &t.EmployeeID, &t.FullName, &t.Role); err != nil {
+			rows.Close()
+			respondError(w, http.StatusInternalServerError, "Failed to update role")
+			return
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		respondError(w, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+	rows.Close()
+	if len(targets) == 0 {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	for _, t := range targets {
+		if err := checkRoleAssignment(requesterRole, t.Role, payload.Role); err != nil {
+			respondError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
 
-	result, err := a.db.Exec(
+	if _, err := tx.ExecContext(r.Context(),
 		`UPDATE users
 		    SET role = $1
 		  WHERE wb_user_id = $2 OR wb_team_profile_id = $2 OR employee_id = $2`,
 		payload.Role,
 		targetID,
-	)
-	if err != nil {
+	); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update role")
 		return
 	}
-	affected, _ := result.RowsAffected()
-	if affected == 0 {
-		respondError(w, http.StatusNotFound, "User not found")
+	if err := tx.Commit(); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update role")
 		return
 	}
+	for _, t := range targets {
+		if t.Role == payload.Role {
+			continue
+		}
+		a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityUser, t.ID, t.FullName, map[string]any{
+			"employee_id":      t.EmployeeID,
+			"before_role":      t.Role,
+			"before_role_name": roleDisplayName(t.Role),
+			"after_role":       payload.Role,
+			"after_role_name":  roleDisplayName(payload.Role),
+		})
+	}
 
 	respondJSON(w, http.StatusOK, map[string]any{
 		"success": true,
@@ -80,3 +135,41 @@ func (a *app) handleUserRole(w http.ResponseWriter, r *http.Request) {
 		"role":    payload.Role,
 	})
 }
+
+type roleAssignmentTarget struct {
+	ID         int64
+	EmployeeID string
+	FullName   string
+	Role       int
+}
+
+var (
+	errRoleAssignmentAdminOnly  = errors.New("admin role is required")
+	errRoleAssignmentNotCovered = errors.New("role grants permissions you do not hold")
+)
+
+// checkRoleAssignment decides whether requester may move a user from
+// current to next. Only admins touch admins, and nobody hands out or takes
+// away a role with permissions they do not hold themselves; otherwise
+// manage_role_assignments would be a path to any permission.
+func checkRoleAssignment(requester, current, next int) error {
+	if requester == roleAdmin {
+		return nil
+	}
+	if current == roleAdmin || next == roleAdmin {
+		return errRoleAssignmentAdminOnly
+	}
+	if !roleCovers(requester, current) || !roleCovers(requester, next) {
+		return errRoleAssignmentNotCovered
+	}
+	return nil
+}
+
+// roleDisplayName is the role name for audit details, falling back to the
+// number for roles deleted since.
+func roleDisplayName(role int) string {
+	if def, ok := roleDefinitions.lookup(role); ok {
+		return def.Name
+	}
+	return strconv.Itoa(role)
+}
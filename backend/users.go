@@ -28,7 +28,7 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 	// scope=booking — any authenticated user may list all users (for booking on behalf).
 	scope := strings.TrimSpace(r.URL.Query().Get("scope"))
 	if scope == "booking" {
-		if role != roleAdmin && role != roleEmployee {
+		if !isValidRole(role) {
 			respondError(w, http.StatusForbidden, "Недостаточно прав")
 			return
 		}
@@ -39,6 +39,7 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 			        COALESCE(wb_user_id, '')
 			   FROM users
 			  WHERE TRIM(COALESCE(employee_id, '')) <> ''
+			    AND active
 			  ORDER BY full_name, employee_id`,
 		)
 		if err != nil {
@@ -70,9 +71,12 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Without view_users the list is limited to the responsibles of one
+	// building the requester is responsible for.
+	viewAll := hasRequestPermission(r, role, permissionViewUsers)
 	var scopedBuildingID int64
 	var scopedRequesterEmployeeID string
-	if role == roleEmployee {
+	if !viewAll {
 		buildingIDRaw := strings.TrimSpace(r.URL.Query().Get("building_id"))
 		if buildingIDRaw == "" {
 			respondError(w, http.StatusForbidden, "Недостаточно прав")
@@ -153,13 +157,10 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusForbidden, "Недостаточно прав")
 			return
 		}
-	} else if role != roleAdmin {
-		respondError(w, http.StatusForbidden, "Недостаточно прав")
-		return
 	}
 
 	var rows *sql.Rows
-	if role == roleEmployee {
+	if !viewAll {
 		rows, err = a.db.QueryContext(r.Context(),
 			`WITH allowed_ids AS (
 			     SELECT TRIM(COALESCE(responsible_employee_id, '')) AS employee_id
@@ -186,6 +187,7 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 			     FROM users u
 			     JOIN allowed_ids a ON a.employee_id = TRIM(COALESCE(u.employee_id, ''))
 			    WHERE TRIM(COALESCE(u.employee_id, '')) <> ''
+			      AND u.active
 			    ORDER BY u.full_name, u.employee_id`,
 			scopedBuildingID,
 			scopedRequesterEmployeeID,
@@ -197,6 +199,7 @@ func (a *app) handleUsers(w http.ResponseWriter, r *http.Request) {
 			        COALESCE(wb_user_id, '')
 			   FROM users
 			  WHERE TRIM(COALESCE(employee_id, '')) <> ''
+			    AND active
 			  ORDER BY full_name, employee_id`,
 		)
 	}
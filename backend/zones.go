@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const auditEntityZone = "zone"
+
+var errZoneFloorMismatch = errors.New("zone belongs to another floor")
+
+// zone is a named area of a floor ("North wing", "Quiet zone") that groups
+// coworkings and meeting rooms. It sits between the floor and its spaces in
+// the hierarchy: a zone responsible can manage every space of the zone.
+type zone struct {
+	ID                    int64     `json:"id"`
+	FloorID               int64     `json:"floor_id"`
+	Name                  string    `json:"name"`
+	Color                 string    `json:"color,omitempty"`
+	SubdivisionL1         string    `json:"subdivision_level_1"`
+	SubdivisionL2         string    `json:"subdivision_level_2"`
+	ResponsibleEmployeeID string    `json:"responsible_employee_id,omitempty"`
+	Points                []point   `json:"points"`
+	SpacesCount           int       `json:"spaces_count"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+type zoneInput struct {
+	Name                  *string  `json:"name"`
+	Color                 *string  `json:"color"`
+	SubdivisionLevel1     *string  `json:"subdivision_level_1"`
+	SubdivisionLevel2     *string  `json:"subdivision_level_2"`
+	ResponsibleEmployeeID *string  `json:"responsible_employee_id"`
+	Points                *[]point `json:"points"`
+}
+
+type zoneOccupancy struct {
+	ZoneID          int64   `json:"zone_id"`
+	Date            string  `json:"date"`
+	Coworkings      int     `json:"coworkings"`
+	MeetingRooms    int     `json:"meeting_rooms"`
+	DesksTotal      int     `json:"desks_total"`
+	DesksBooked     int     `json:"desks_booked"`
+	DeskUtilization float64 `json:"desk_utilization"`
+	MeetingBookings int     `json:"meeting_bookings"`
+}
+
+func ensureZonesStorage(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS zones (
+			id BIGSERIAL PRIMARY KEY,
+			floor_id BIGINT NOT NULL,
+			name TEXT NOT NULL,
+			color TEXT NOT NULL DEFAULT '',
+			subdivision_level_1 TEXT NOT NULL DEFAULT '',
+			subdivision_level_2 TEXT NOT NULL DEFAULT '',
+			responsible_employee_id TEXT NOT NULL DEFAULT '',
+			points_json JSONB NOT NULL DEFAULT '[]'::jsonb,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			FOREIGN KEY(floor_id) REFERENCES floors(id) ON DELETE CASCADE
+		);`,
+		`CREATE INDEX IF NOT EXISTS zones_floor_id_idx ON zones (floor_id);`,
+		`CREATE INDEX IF NOT EXISTS zones_responsible_employee_id_idx ON zones (responsible_employee_id);`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"coworkings", "meeting_rooms"} {
+		if err := ensureColumn(db, table, "zone_id", "BIGINT REFERENCES zones(id) ON DELETE SET NULL"); err != nil {
+			return err
+		}
+		if _, err := db.Exec(fmt.Sprintf(
+			`CREATE INDEX IF NOT EXISTS %s_zone_id_idx ON %s (zone_id) WHERE zone_id IS NOT NULL`,
+			table,
+			table,
+		)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const zoneSelectColumns = `z.id,
+		        z.floor_id,
+		        z.name,
+		        COALESCE(z.color, ''),
+		        COALESCE(z.subdivision_level_1, ''),
+		        COALESCE(z.subdivision_level_2, ''),
+		        COALESCE(z.responsible_employee_id, ''),
+		        COALESCE(z.points_json, '[]'),
+		        z.created_at,
+		        (SELECT COUNT(*) FROM coworkings c WHERE c.zone_id = z.id AND c.deleted_at IS NULL)
+		        + (SELECT COUNT(*) FROM meeting_rooms m WHERE m.zone_id = z.id AND m.deleted_at IS NULL)`
+
+func scanZone(row interface{ Scan(...any) error }) (zone, error) {
+	var z zone
+	var pointsJSON string
+	if err := row.Scan(
+		&z.ID,
+		&z.FloorID,
+		&z.Name,
+		&z.Color,
+		&z.SubdivisionL1,
+		&z.SubdivisionL2,
+		&z.ResponsibleEmployeeID,
+		&pointsJSON,
+		&z.CreatedAt,
+		&z.SpacesCount,
+	); err != nil {
+		return zone{}, err
+	}
+	z.Points = decodePoints(pointsJSON)
+	return z, nil
+}
+
+func (a *app) listZonesByFloor(floorID int64) ([]zone, error) {
+	rows, err := a.db.Query(
+		`SELECT `+zoneSelectColumns+`
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		  WHERE z.floor_id = $1 AND f.deleted_at IS NULL
+		  ORDER BY z.name, z.id`,
+		floorID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]zone, 0)
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, z)
+	}
+	return items, rows.Err()
+}
+
+func (a *app) getZone(id int64) (zone, error) {
+	z, err := scanZone(a.db.QueryRow(
+		`SELECT `+zoneSelectColumns+`
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		  WHERE z.id = $1 AND f.deleted_at IS NULL`,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return zone{}, errNotFound
+		}
+		return zone{}, err
+	}
+	return z, nil
+}
+
+func (a *app) getZoneFloorID(zoneID int64) (int64, error) {
+	var floorID int64
+	err := a.db.QueryRow(
+		`SELECT z.floor_id
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		  WHERE z.id = $1 AND f.deleted_at IS NULL`,
+		zoneID,
+	).Scan(&floorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errNotFound
+		}
+		return 0, err
+	}
+	return floorID, nil
+}
+
+func (a *app) createZone(floorID int64, name, color, subdivisionLevel1, subdivisionLevel2, responsibleEmployeeID string, points []point) (zone, error) {
+	pointsJSON, err := encodePoints(points)
+	if err != nil {
+		return zone{}, err
+	}
+	var id int64
+	if err := a.db.QueryRow(
+		`INSERT INTO zones (floor_id, name, color, subdivision_level_1, subdivision_level_2, responsible_employee_id, points_json)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING id`,
+		floorID,
+		name,
+		color,
+		subdivisionLevel1,
+		subdivisionLevel2,
+		strings.TrimSpace(responsibleEmployeeID),
+		pointsJSON,
+	).Scan(&id); err != nil {
+		return zone{}, err
+	}
+	return a.getZone(id)
+}
+
+func (a *app) updateZone(id int64, input zoneInput) (zone, error) {
+	current, err := a.getZone(id)
+	if err != nil {
+		return zone{}, err
+	}
+	next := current
+	if input.Name != nil {
+		next.Name = strings.TrimSpace(*input.Name)
+		if next.Name == "" {
+			return zone{}, errNameRequired
+		}
+	}
+	if input.Color != nil {
+		next.Color = strings.TrimSpace(*input.Color)
+	}
+	if input.SubdivisionLevel1 != nil {
+		next.SubdivisionL1 = strings.TrimSpace(*input.SubdivisionLevel1)
+	}
+	if input.SubdivisionLevel2 != nil {
+		next.SubdivisionL2 = strings.TrimSpace(*input.SubdivisionLevel2)
+	}
+	if input.ResponsibleEmployeeID != nil {
+		next.ResponsibleEmployeeID = strings.TrimSpace(*input.ResponsibleEmployeeID)
+	}
+	if input.Points != nil {
+		next.Points = *input.Points
+	}
+	pointsJSON, err := encodePoints(next.Points)
+	if err != nil {
+		return zone{}, err
+	}
+	result, err := a.db.Exec(
+		`UPDATE zones
+		    SET name = $1,
+		        color = $2,
+		        subdivision_level_1 = $3,
+		        subdivision_level_2 = $4,
+		        responsible_employee_id = $5,
+		        points_json = $6
+		  WHERE id = $7`,
+		next.Name,
+		next.Color,
+		next.SubdivisionL1,
+		next.SubdivisionL2,
+		next.ResponsibleEmployeeID,
+		pointsJSON,
+		id,
+	)
+	if err != nil {
+		return zone{}, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return zone{}, err
+	}
+	if rows == 0 {
+		return zone{}, errNotFound
+	}
+	return a.getZone(id)
+}
+
+// deleteZone removes the zone itself. Its spaces stay on the floor and simply
+// lose their zone (zone_id is ON DELETE SET NULL), so nothing needs to go to
+// the trash.
+func (a *app) deleteZone(id int64) error {
+	result, err := a.db.Exec(`DELETE FROM zones WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+// setSpaceZone assigns a coworking or meeting room to a zone of the same
+// floor, or detaches it when zoneID is nil.
+func (a *app) setSpaceZone(spaceID int64, zoneID *int64) (space, error) {
+	current, err := a.getSpace(spaceID)
+	if err != nil {
+		return space{}, err
+	}
+	if zoneID != nil {
+		zoneFloorID, err := a.getZoneFloorID(*zoneID)
+		if err != nil {
+			return space{}, err
+		}
+		if zoneFloorID != current.FloorID {
+			return space{}, errZoneFloorMismatch
+		}
+	}
+	table := "coworkings"
+	if current.Kind == "meeting" {
+		table = "meeting_rooms"
+	}
+	if _, err := a.db.Exec(
+		fmt.Sprintf(`UPDATE %s SET zone_id = $1 WHERE id = $2 AND deleted_at IS NULL`, table),
+		zoneID,
+		spaceID,
+	); err != nil {
+		return space{}, err
+	}
+	return a.getSpace(spaceID)
+}
+
+func (a *app) zoneOccupancyForDate(ctx context.Context, zoneID int64, date string) (zoneOccupancy, error) {
+	result := zoneOccupancy{ZoneID: zoneID, Date: date}
+	var timezone string
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT COALESCE(NULLIF(TRIM(b.timezone), ''), $2)
+		   FROM zones z
+		   JOIN floors f ON f.id = z.floor_id
+		   JOIN office_buildings b ON b.id = f.building_id
+		  WHERE z.id = $1 AND f.deleted_at IS NULL`,
+		zoneID,
+		defaultBuildingTimezone,
+	).Scan(&timezone); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return zoneOccupancy{}, errNotFound
+		}
+		return zoneOccupancy{}, err
+	}
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM coworkings WHERE zone_id = $1 AND deleted_at IS NULL),
+		        (SELECT COUNT(*) FROM meeting_rooms WHERE zone_id = $1 AND deleted_at IS NULL),
+		        (SELECT COUNT(*)
+		           FROM workplaces w
+		           JOIN coworkings c ON c.id = w.coworking_id
+		          WHERE c.zone_id = $1 AND c.deleted_at IS NULL),
+		        (SELECT COUNT(DISTINCT wb.workplace_id)
+		           FROM workplace_bookings wb
+		           JOIN workplaces w ON w.id = wb.workplace_id
+		           JOIN coworkings c ON c.id = w.coworking_id
+		          WHERE c.zone_id = $1 AND c.deleted_at IS NULL
+		            AND wb.date = $2 AND wb.cancelled_at IS NULL)`,
+		zoneID,
+		date,
+	).Scan(&result.Coworkings, &result.MeetingRooms, &result.DesksTotal, &result.DesksBooked); err != nil {
+		return zoneOccupancy{}, err
+	}
+	dayStart, dayEnd, err := getBookingDayBounds(date, timezone)
+	if err != nil {
+		return zoneOccupancy{}, err
+	}
+	if err := a.db.QueryRowContext(ctx,
+		`SELECT COUNT(*)
+		   FROM meeting_room_bookings mb
+		   JOIN meeting_rooms m ON m.id = mb.meeting_room_id
+		  WHERE m.zone_id = $1 AND m.deleted_at IS NULL
+		    AND mb.cancelled_at IS NULL
+		    AND mb.start_at < $3 AND mb.end_at > $2`,
+		zoneID,
+		dayStart,
+		dayEnd,
+	).Scan(&result.MeetingBookings); err != nil {
+		return zoneOccupancy{}, err
+	}
+	if result.DesksTotal > 0 {
+		result.DeskUtilization = float64(result.DesksBooked) / float64(result.DesksTotal)
+	}
+	return result, nil
+}
+
+func (a *app) handleZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		FloorID               int64   `json:"floor_id"`
+		Name                  string  `json:"name"`
+		Color                 string  `json:"color"`
+		SubdivisionLevel1     string  `json:"subdivision_level_1"`
+		SubdivisionLevel2     string  `json:"subdivision_level_2"`
+		ResponsibleEmployeeID string  `json:"responsible_employee_id"`
+		Points                []point `json:"points"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.FloorID == 0 || payload.Name == "" {
+		respondError(w, http.StatusBadRequest, "floor_id and name are required")
+		return
+	}
+	if len(payload.Points) > 0 && len(payload.Points) < 3 {
+		respondError(w, http.StatusBadRequest, "points must describe a polygon")
+		return
+	}
+	if !a.ensureCanManageFloor(w, r, payload.FloorID) {
+		return
+	}
+	if _, err := a.getFloor(payload.FloorID); err != nil {
+		if errors.Is(err, errNotFound) {
+			respondError(w, http.StatusNotFound, "floor not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	result, err := a.createZone(
+		payload.FloorID,
+		payload.Name,
+		strings.TrimSpace(payload.Color),
+		strings.TrimSpace(payload.SubdivisionLevel1),
+		strings.TrimSpace(payload.SubdivisionLevel2),
+		payload.ResponsibleEmployeeID,
+		payload.Points,
+	)
+	if err != nil {
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if result.ResponsibleEmployeeID != "" {
+		a.auditResponsibilityChange(r.Context(), auditEntityZone, result.ID, "", result.ResponsibleEmployeeID, a.requestActorEmployeeID(r))
+	}
+	a.logAuditEventFromRequest(r, auditActionCreate, auditEntityZone, result.ID, result.Name, zoneAuditDetails(result))
+	respondJSON(w, http.StatusCreated, result)
+}
+
+func (a *app) handleZoneSubroutes(w http.ResponseWriter, r *http.Request) {
+	id, suffix, err := parseIDFromPath(r.URL.Path, "/api/zones/")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch suffix {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			item, err := a.getZone(id)
+			if err != nil {
+				respondZoneError(w, err)
+				return
+			}
+			respondJSON(w, http.StatusOK, item)
+		case http.MethodPut:
+			var payload zoneInput
+			if err := decodeJSON(r, &payload); err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if payload.Points != nil && len(*payload.Points) > 0 && len(*payload.Points) < 3 {
+				respondError(w, http.StatusBadRequest, "points must describe a polygon")
+				return
+			}
+			if !a.ensureCanManageZone(w, r, id) {
+				return
+			}
+			// Handing the zone to someone else is a floor-level decision,
+			// the current zone responsible cannot do it alone.
+			if payload.ResponsibleEmployeeID != nil {
+				floorID, err := a.getZoneFloorID(id)
+				if err != nil {
+					respondZoneError(w, err)
+					return
+				}
+				if !a.ensureCanManageFloor(w, r, floorID) {
+					return
+				}
+			}
+			existing, err := a.getZone(id)
+			if err != nil {
+				respondZoneError(w, err)
+				return
+			}
+			updated, err := a.updateZone(id, payload)
+			if err != nil {
+				if errors.Is(err, errNameRequired) {
+					respondError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				respondZoneError(w, err)
+				return
+			}
+			if existing.ResponsibleEmployeeID != updated.ResponsibleEmployeeID {
+				a.auditResponsibilityChange(r.Context(), auditEntityZone, id, existing.ResponsibleEmployeeID, updated.ResponsibleEmployeeID, a.requestActorEmployeeID(r))
+			}
+			details := zoneAuditDetails(updated)
+			details["changes"] = describeZoneAuditChanges(existing, updated)
+			details["before_name"] = existing.Name
+			details["after_name"] = updated.Name
+			details["before_responsible"] = existing.ResponsibleEmployeeID
+			details["after_responsible"] = updated.ResponsibleEmployeeID
+			details["before_polygon_points"] = formatPointsForAudit(existing.Points)
+			details["after_polygon_points"] = formatPointsForAudit(updated.Points)
+			a.logAuditEventFromRequest(r, auditActionUpdate, auditEntityZone, updated.ID, updated.Name, details)
+			respondJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			floorID, err := a.getZoneFloorID(id)
+			if err != nil {
+				respondZoneError(w, err)
+				return
+			}
+			if !a.ensureCanManageFloor(w, r, floorID) {
+				return
+			}
+			existing, err := a.getZone(id)
+			if err != nil {
+				respondZoneError(w, err)
+				return
+			}
+			// Resolve the path before the row is gone.
+			details := a.enrichAuditLogDetails(r.Context(), auditEntityZone, id, zoneAuditDetails(existing))
+			if err := a.deleteZone(id); err != nil {
+				respondZoneError(w, err)
+				return
+			}
+			a.logAuditEventFromRequest(r, auditActionDelete, auditEntityZone, existing.ID, existing.Name, details)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "/spaces":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		floorID, err := a.getZoneFloorID(id)
+		if err != nil {
+			respondZoneError(w, err)
+			return
+		}
+		items, err := a.listSpacesByFloor(floorID, id)
+		if err != nil {
+			log.Printf("internal error: %v", err)
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]any{"items": items})
+	case "/occupancy":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		date := time.Now().Format("2006-01-02")
+		if dateRaw := strings.TrimSpace(r.URL.Query().Get("date")); dateRaw != "" {
+			normalized, err := normalizeBookingDate(dateRaw)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			date = normalized
+		}
+		result, err := a.zoneOccupancyForDate(r.Context(), id, date)
+		if err != nil {
+			respondZoneError(w, err)
+			return
+		}
+		respondJSON(w, http.StatusOK, result)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleSpaceZone handles PUT /api/spaces/{id}/zone. Moving a space between
+// zones changes who can manage it, so it needs floor-level rights.
+func (a *app) handleSpaceZone(w http.ResponseWriter, r *http.Request, spaceID int64) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var payload struct {
+		ZoneID *int64 `json:"zone_id"`
+	}
+	if err := decodeJSON(r, &payload); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if payload.ZoneID != nil && *payload.ZoneID <= 0 {
+		payload.ZoneID = nil
+	}
+	if !a.ensureCanManageFloorBySpace(w, r, spaceID) {
+		return
+	}
+	existing, err := a.getSpace(spaceID)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			respondError(w, http.StatusNotFound, "space not found")
+			return
+		}
+		log.Printf("internal error: %v", err)
+		respondError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	updated, err := a.setSpaceZone(spaceID, payload.ZoneID)
+	if err != nil {
+		if errors.Is(err, errZoneFloorMismatch) {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondZoneError(w, err)
+		return
+	}
+	entityType := auditEntityCoworking
+	if updated.Kind == "meeting" {
+		entityType = auditEntityMeetingRoom
+	}
+	a.logAuditEventFromRequest(r, auditActionUpdate, entityType, updated.ID, updated.Name, map[string]any{
+		"space_id":       updated.ID,
+		"space_name":     updated.Name,
+		"space_kind":     updated.Kind,
+		"floor_id":       updated.FloorID,
+		"zone_id":        formatOptionalID(updated.ZoneID),
+		"changes":        []string{fmt.Sprintf("Зона: %s -> %s", formatOptionalID(existing.ZoneID), formatOptionalID(updated.ZoneID))},
+		"before_zone_id": formatOptionalID(existing.ZoneID),
+		"after_zone_id":  formatOptionalID(updated.ZoneID),
+	})
+	respondJSON(w, http.StatusOK, updated)
+}
+
+func respondZoneError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		respondError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	log.Printf("internal error: %v", err)
+	respondError(w, http.StatusInternalServerError, "internal error")
+}
+
+func zoneAuditDetails(z zone) map[string]any {
+	return map[string]any{
+		"zone_id":              strconv.FormatInt(z.ID, 10),
+		"zone_name":            z.Name,
+		"floor_id":             z.FloorID,
+		"subdivision_level_1":  z.SubdivisionL1,
+		"subdivision_level_2":  z.SubdivisionL2,
+		"responsible_employee": z.ResponsibleEmployeeID,
+		"polygon_points":       formatPointsForAudit(z.Points),
+	}
+}
+
+func describeZoneAuditChanges(before, after zone) []string {
+	changes := make([]string, 0)
+	if before.Name != after.Name {
+		changes = append(changes, fmt.Sprintf("Название: %q -> %q", before.Name, after.Name))
+	}
+	if before.Color != after.Color {
+		changes = append(changes, fmt.Sprintf("Цвет: %q -> %q", before.Color, after.Color))
+	}
+	if before.SubdivisionL1 != after.SubdivisionL1 {
+		changes = append(changes, fmt.Sprintf("Подразделение 1: %q -> %q", before.SubdivisionL1, after.SubdivisionL1))
+	}
+	if before.SubdivisionL2 != after.SubdivisionL2 {
+		changes = append(changes, fmt.Sprintf("Подразделение 2: %q -> %q", before.SubdivisionL2, after.SubdivisionL2))
+	}
+	if before.ResponsibleEmployeeID != after.ResponsibleEmployeeID {
+		changes = append(changes, fmt.Sprintf("Ответственный: %q -> %q", before.ResponsibleEmployeeID, after.ResponsibleEmployeeID))
+	}
+	if formatPointsForAudit(before.Points) != formatPointsForAudit(after.Points) {
+		changes = append(changes, "Полигон: изменен")
+	}
+	return changes
+}
+
+// formatOptionalID renders a nullable id for audit details; an empty string
+// means "no zone".
+func formatOptionalID(id *int64) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatInt(*id, 10)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDescribeZoneAuditChanges(t *testing.T) {
+	t.Parallel()
+
+	base := zone{
+		ID:                    7,
+		FloorID:               3,
+		Name:                  "Север",
+		Color:                 "#ff0000",
+		SubdivisionL1:         "ИТ",
+		ResponsibleEmployeeID: "100",
+		Points:                []point{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}},
+	}
+	tests := []struct {
+		name   string
+		modify func(z *zone)
+		want   []string
+	}{
+		{name: "no changes", modify: func(z *zone) {}, want: nil},
+		{name: "rename", modify: func(z *zone) { z.Name = "Юг" }, want: []string{`Название: "Север" -> "Юг"`}},
+		{name: "subdivisions", modify: func(z *zone) { z.SubdivisionL1 = ""; z.SubdivisionL2 = "Платформа" }, want: []string{
+			`Подразделение 1: "ИТ" -> ""`,
+			`Подразделение 2: "" -> "Платформа"`,
+		}},
+		{name: "responsible", modify: func(z *zone) { z.ResponsibleEmployeeID = "" }, want: []string{`Ответственный: "100" -> ""`}},
+		{name: "polygon", modify: func(z *zone) { z.Points = []point{{X: 1, Y: 1}} }, want: []string{"Полигон: изменен"}},
+		{name: "spaces count is not audited", modify: func(z *zone) { z.SpacesCount = 5 }, want: nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			after := base
+			after.Points = append([]point(nil), base.Points...)
+			tt.modify(&after)
+			got := describeZoneAuditChanges(base, after)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("describeZoneAuditChanges() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneAuditDetails(t *testing.T) {
+	t.Parallel()
+
+	details := zoneAuditDetails(zone{ID: 12, FloorID: 3, Name: "Тихая зона", ResponsibleEmployeeID: "100"})
+	if details["zone_id"] != "12" || details["zone_name"] != "Тихая зона" || details["floor_id"] != int64(3) || details["responsible_employee"] != "100" {
+		t.Fatalf("unexpected details %v", details)
+	}
+	zoneID := int64(12)
+	if got := formatOptionalID(&zoneID); got != "12" {
+		t.Fatalf("formatOptionalID(12) = %q", got)
+	}
+	if got := formatOptionalID(nil); got != "" {
+		t.Fatalf("formatOptionalID(nil) = %q, want empty", got)
+	}
+}
+
+func TestCanActInBuildingZoneResponsible(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		role int
+		eid  string
+		want bool
+	}{
+		{name: "zone responsible", role: roleEmployee, eid: "300", want: true},
+		{name: "other employee", role: roleEmployee, eid: "400", want: false},
+		{name: "anonymous", role: roleEmployee, eid: "", want: false},
+		{name: "admin", role: roleAdmin, eid: "400", want: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			// building → floor → zone → coworking responsibles, no delegation.
+			got := canActInBuilding(tt.role, permissionManageLayout, tt.eid, 1, "100", "200", "300", "", "")
+			if got != tt.want {
+				t.Fatalf("canActInBuilding(%d, %q) = %v, want %v", tt.role, tt.eid, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestZoneSpaceAssignment checks setSpaceZone and the zone responsible's
+// access to the zone's coworkings against OFFICE_TEST_DATABASE_URL.
+func TestZoneSpaceAssignment(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+
+	b, err := a.createBuildingWithFloors("Zone test", "Test street 2", defaultBuildingTimezone, "", 0, 2, "")
+	if err != nil {
+		t.Fatalf("create building: %v", err)
+	}
+	sp, err := a.createSpace(b.Floors[0], "Coworking", "coworking", 0, "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("create space: %v", err)
+	}
+	ownZone, err := a.createZone(b.Floors[0], "Север", "", "", "", "zone-test-resp", nil)
+	if err != nil {
+		t.Fatalf("create zone: %v", err)
+	}
+	otherZone, err := a.createZone(b.Floors[1], "Юг", "", "", "", "", nil)
+	if err != nil {
+		t.Fatalf("create zone: %v", err)
+	}
+
+	if _, err := a.setSpaceZone(sp.ID, &otherZone.ID); !errors.Is(err, errZoneFloorMismatch) {
+		t.Fatalf("assign zone of another floor: %v, want %v", err, errZoneFloorMismatch)
+	}
+	updated, err := a.setSpaceZone(sp.ID, &ownZone.ID)
+	if err != nil {
+		t.Fatalf("assign zone: %v", err)
+	}
+	if updated.ZoneID == nil || *updated.ZoneID != ownZone.ID {
+		t.Fatalf("zone_id = %v, want %d", updated.ZoneID, ownZone.ID)
+	}
+
+	canManage := func(employeeID string) bool {
+		ctx := context.WithValue(context.Background(), authClaimsCtxKey, authClaims{EmployeeID: employeeID})
+		ctx = context.WithValue(ctx, officeAccessTokenClaimsCtxKey, &OfficeAccessTokenClaims{EmployeeID: employeeID, Role: roleEmployee})
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		return a.ensureCanManageCoworking(httptest.NewRecorder(), req, sp.ID)
+	}
+	if !canManage("zone-test-resp") {
+		t.Fatal("zone responsible cannot manage a coworking of the zone")
+	}
+	if canManage("zone-test-other") {
+		t.Fatal("unrelated employee can manage the coworking")
+	}
+
+	if updated, err = a.setSpaceZone(sp.ID, nil); err != nil || updated.ZoneID != nil {
+		t.Fatalf("detach zone: %v %v", updated.ZoneID, err)
+	}
+	if canManage("zone-test-resp") {
+		t.Fatal("zone responsible keeps access after the coworking left the zone")
+	}
+}
+
+// TestZoneSurvivesSpaceKindChange converts a zoned space to a meeting room
+// and back against OFFICE_TEST_DATABASE_URL; the space must stay in its zone.
+func TestZoneSurvivesSpaceKindChange(t *testing.T) {
+	db := openTestDatabase(t)
+	a := &app{db: db}
+
+	b, err := a.createBuildingWithFloors("Zone kind test", "Test street 3", defaultBuildingTimezone, "", 0, 1, "")
+	if err != nil {
+		t.Fatalf("create building: %v", err)
+	}
+	sp, err := a.createSpace(b.Floors[0], "Coworking", "coworking", 0, "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("create space: %v", err)
+	}
+	z, err := a.createZone(b.Floors[0], "Центр", "", "", "", "", nil)
+	if err != nil {
+		t.Fatalf("create zone: %v", err)
+	}
+	if _, err := a.setSpaceZone(sp.ID, &z.ID); err != nil {
+		t.Fatalf("assign zone: %v", err)
+	}
+
+	for _, kind := range []string{"meeting", "coworking"} {
+		updated, err := a.updateSpaceDetails(sp.ID, sp.Name, kind, 6, true, "", "", "", nil)
+		if err != nil {
+			t.Fatalf("convert to %s: %v", kind, err)
+		}
+		if updated.Kind != kind {
+			t.Fatalf("kind = %q, want %q", updated.Kind, kind)
+		}
+		if updated.ZoneID == nil || *updated.ZoneID != z.ID {
+			t.Fatalf("zone_id after converting to %s = %v, want %d", kind, updated.ZoneID, z.ID)
+		}
+	}
+}
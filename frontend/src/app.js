@@ -69,6 +69,8 @@ const authStatus = document.getElementById("authStatus");
 const requestCodeBtn = document.getElementById("requestCodeBtn");
 const confirmCodeBtn = document.getElementById("confirmCodeBtn");
 const backToPhoneBtn = document.getElementById("backToPhoneBtn");
+const authRedirectLogin = document.getElementById("authRedirectLogin");
+const authRedirectBtn = document.getElementById("authRedirectBtn");
 const authUserBlock = document.getElementById("authUserBlock");
 const authUserName = document.getElementById("authUserName");
 const breadcrumbProfiles = document.querySelectorAll('[data-role="breadcrumb-profile"]');
@@ -1027,7 +1029,13 @@ const getRoleIdFromUser = (user) => {
 
 const isAdminRole = (user) => getRoleIdFromUser(user) === 2;
 const isEmployeeRole = (user) => !isAdminRole(user);
-const canManageOfficeResources = (user) => isAdminRole(user);
+// Global (not building-scoped) permission from the role matrix.
+const hasGlobalPermission = (user, permission) =>
+  isAdminRole(user) ||
+  (getSessionClaims()?.permissions || []).some(
+    (grant) => grant.permission === permission && !grant.building_id,
+  );
+const canManageOfficeResources = (user) => hasGlobalPermission(user, "manage_layout");
 
 const toggleHidden = (node, hidden) => {
   if (!node) {
@@ -1040,6 +1048,10 @@ const applyRoleRestrictions = (user) => {
   const restricted = !canManageOfficeResources(user);
   document.body.classList.toggle("role-employee", restricted);
   if (!restricted) {
+    if (!hasGlobalPermission(user, "manage_buildings")) {
+      toggleHidden(openAddModalBtn, true);
+      toggleHidden(deleteBuildingBtn, true);
+    }
     return;
   }
   const isBuildingPageActive = Boolean(buildingPage && !buildingPage.classList.contains("is-hidden"));
@@ -1064,6 +1076,14 @@ const getRoleLabelFromUser = (user) => {
       return "Сотрудник";
     case 2:
       return "Администратор";
+    case 3:
+      return "Ресепшн";
+    case 4:
+      return "Менеджер офиса";
+    case 5:
+      return "Аудитор";
+    case 6:
+      return "Координатор бронирований";
     default:
       return "";
   }
@@ -1125,8 +1145,8 @@ const updateBreadcrumbProfile = (user) => {
     }
     node.classList.remove("is-hidden");
   });
-  setDbDumpMenuVisibility(isAdminRole(user));
-  setAuditLogsMenuVisibility(isAdminRole(user));
+  setDbDumpMenuVisibility(hasGlobalPermission(user, "manage_backups"));
+  setAuditLogsMenuVisibility(hasGlobalPermission(user, "view_audit_logs"));
   applyRoleRestrictions(user);
 };
 
@@ -1151,10 +1171,57 @@ const updateAuthUserBlock = (user) => {
   // Note: fetchResponsibilitiesForUser() is called explicitly after Office-Access-Token is obtained
 };
 
+// Login flow of the configured identity provider: "code" (phone + one-time
+// code) or "redirect" (OpenID Connect via /api/auth/oidc/login).
+let authLoginMode = "code";
+let authLoginURL = "";
+
+const loadAuthProvider = async () => {
+  try {
+    const response = await fetch("/api/auth/provider", { credentials: "include" });
+    if (!response.ok) {
+      return;
+    }
+    const data = await response.json().catch(() => null);
+    if (data?.login === "redirect" && data?.login_url) {
+      authLoginMode = "redirect";
+      authLoginURL = String(data.login_url);
+    }
+  } catch {
+    // Keep the code flow when the provider cannot be determined.
+  }
+};
+
+// consumeAuthRedirectError reads the ?auth_error= marker left by a failed
+// redirect login and removes it from the address bar.
+const consumeAuthRedirectError = () => {
+  const params = new URLSearchParams(window.location.search);
+  const reason = params.get("auth_error");
+  if (!reason) {
+    return "";
+  }
+  params.delete("auth_error");
+  const query = params.toString();
+  window.history.replaceState(
+    null,
+    "",
+    `${window.location.pathname}${query ? `?${query}` : ""}${window.location.hash}`
+  );
+  return reason;
+};
+
 const setAuthStep = (step) => {
   if (!authPhoneForm || !authCodeForm || !authSubtitle) {
     return;
   }
+  if (authLoginMode === "redirect") {
+    authPhoneForm.classList.add("is-hidden");
+    authCodeForm.classList.add("is-hidden");
+    authRedirectLogin?.classList.remove("is-hidden");
+    authSubtitle.textContent = "Войдите с корпоративной учетной записью.";
+    setAuthStatus("");
+    return;
+  }
   if (step === "code") {
     authPhoneForm.classList.add("is-hidden");
     authCodeForm.classList.remove("is-hidden");
@@ -1756,11 +1823,13 @@ const initializeAuth = async () => {
   const token = getAuthToken();
   const cachedUser = getUserInfo();
   const skipSessionRestore = consumeLogoutRedirectMark();
+  const redirectError = consumeAuthRedirectError();
   if (cachedUser) {
     updateAuthUserBlock(cachedUser);
   } else {
     showAuthBoot("Загрузка...");
   }
+  await loadAuthProvider();
 
   // Restore in-memory session from HttpOnly cookies via backend.
   // On page reload, JS has no access to the cookie values, so we ask
@@ -1793,6 +1862,9 @@ const initializeAuth = async () => {
     if (authGate) {
       showAuthLogin();
       setAuthStep("phone");
+      if (redirectError) {
+        setAuthStatus("Не удалось войти через SSO. Попробуйте снова.", "error");
+      }
       return;
     }
     await runAppInit();
@@ -2954,11 +3026,19 @@ const closeDbDumpModal = () => {
 const auditEntityLabels = {
   building: "Здание",
   floor: "Этаж",
+  zone: "Зона",
   coworking: "Коворкинг",
   meeting_room: "Переговорка",
   desk: "Стол",
   desk_booking: "Бронирование стола",
   meeting_booking: "Бронирование переговорки",
+  resource: "Ресурс",
+  resource_booking: "Бронирование ресурса",
+  session: "Сессия",
+  service_account: "Сервисный аккаунт",
+  role: "Роль",
+  user: "Сотрудник",
+  impersonation: "Вход от имени сотрудника",
 };
 
 const auditActionLabels = {
@@ -2967,6 +3047,18 @@ const auditActionLabels = {
   delete: "Удаление",
   book: "Бронирование",
   cancel: "Отмена бронирования",
+  restore: "Восстановление",
+  purge: "Окончательное удаление",
+  revoke: "Отзыв",
+  deactivate: "Деактивация",
+  offboard: "Увольнение",
+  change_responsible: "Смена ответственного",
+  delegate: "Делегирование",
+  impersonate: "Вход от имени",
+  impersonated_request: "Запрос от имени",
+  end_impersonation: "Завершение входа от имени",
+  export_personal_data: "Выгрузка персональных данных",
+  erase_personal_data: "Удаление персональных данных",
 };
 
 const getAuditEntityLabel = (value) => {
@@ -2987,6 +3079,9 @@ const getAuditPrimaryEntityLabel = (entityType) => {
   if (key === "meeting_booking") {
     return "Переговорка";
   }
+  if (key === "resource_booking") {
+    return "Ресурс";
+  }
   return getAuditEntityLabel(key);
 };
 
@@ -15543,6 +15638,16 @@ const handleAuthSuccess = async (token, user) => {
   await runAppInit();
 };
 
+if (authRedirectBtn) {
+  authRedirectBtn.addEventListener("click", () => {
+    if (!authLoginURL) {
+      return;
+    }
+    const returnTo = `${window.location.pathname}${window.location.search}`;
+    window.location.assign(`${authLoginURL}?return_to=${encodeURIComponent(returnTo)}`);
+  });
+}
+
 if (authPhoneInput) {
   authPhoneInput.addEventListener("input", (event) => {
     authPhoneInput.value = formatPhoneNumber(event.target.value);
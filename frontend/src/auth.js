@@ -35,6 +35,7 @@ export const AVATAR_CACHE_MAX_ENTRIES = 40;
  * @property {string}  [user_name]
  * @property {number}  role
  * @property {Object}  [responsibilities]
+ * @property {Array<{permission: string, building_id?: number}>} [permissions]
  * @property {number}  access_exp   – Unix epoch seconds
  * @property {number}  [refresh_exp] – Unix epoch seconds
  */